@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"os/signal"
 
-	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/client"
+	"github.com/vipnode/vipnode/internal/pretty"
 	"github.com/vipnode/vipnode/jsonrpc2"
 	"github.com/vipnode/vipnode/pool"
 
@@ -37,6 +38,12 @@ func runClient(options Options) error {
 
 	errChan := make(chan error)
 	c := client.New(remoteNode)
+	c.PreferredHosts = options.Client.PreferHost
+	c.Distribution = options.Client.Distribution
+	c.ConnectTimeout = options.Client.ConnectTimeout
+	if c.CreditUnit, err = pretty.ParseUnit(options.Client.Units); err != nil {
+		return ErrExplain{err, "Invalid --units. Use wei, gwei, eth, or a custom unit like credit:12."}
+	}
 	c.PoolMessageCallback = func(msg string) {
 		logger.Alertf("Message from pool: %s", msg)
 	}
@@ -54,21 +61,10 @@ func runClient(options Options) error {
 		return c.Wait()
 	}
 
-	// Node key is required for signing requests from the NodeID to avoid forgery.
-	privkey, err := findNodeKey(options.Client.NodeKey)
-	if err != nil {
-		return ErrExplain{err, "Failed to find node private key. Use --nodekey to specify the correct path."}
-	}
-
-	// Confirm that nodeID matches the private key
-	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
-	remoteEnode, err := remoteNode.Enode(context.Background())
+	keys, err := loadSigner(remoteNode, options.Client.NodeKey, options.Client.SigningKey)
 	if err != nil {
 		return err
 	}
-	if err := matchEnode(remoteEnode, nodeID); err != nil {
-		return err
-	}
 
 	logger.Infof("Connecting to pool: %s", poolURI)
 
@@ -77,12 +73,26 @@ func runClient(options Options) error {
 	// Especially if the client could be a mobile device, it's probably more battery-friendly.
 	var rpcPool jsonrpc2.Service
 	if uri.Scheme == "ws" || uri.Scheme == "wss" {
+		dialer := &ws.Dialer{}
+		for _, pin := range options.Client.PinCert {
+			fingerprint, err := ws.ParseFingerprint(pin)
+			if err != nil {
+				return err
+			}
+			dialer.PinnedFingerprints = append(dialer.PinnedFingerprints, fingerprint)
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
-		poolCodec, err := ws.WebSocketDial(ctx, uri.String())
+		poolCodec, err := dialer.Dial(ctx, uri.String())
 		cancel()
+		if errors.Is(err, ws.ErrCertificateNotPinned) {
+			return ErrExplain{err, "The pool's TLS certificate does not match any --pin-cert fingerprint. The pool may have rotated its certificate, or the connection is being intercepted."}
+		}
 		if err != nil {
 			return ErrExplain{err, "Failed to connect to the pool RPC API."}
 		}
+		if options.DebugRPC {
+			poolCodec = jsonrpc2.DebugCodec(uri.String(), poolCodec)
+		}
 		remote := &jsonrpc2.Remote{
 			Codec: poolCodec,
 		}
@@ -90,6 +100,8 @@ func runClient(options Options) error {
 			errChan <- remote.Serve()
 		}()
 		rpcPool = remote
+	} else if len(options.Client.PinCert) > 0 {
+		return ErrExplain{errors.New("certificate pinning is not supported for HTTP pools"), "Use a wss:// pool URL with --pin-cert."}
 	} else {
 		// Assume HTTP by default
 		rpcPool = &jsonrpc2.HTTPService{
@@ -97,7 +109,10 @@ func runClient(options Options) error {
 		}
 	}
 
-	p := pool.Remote(rpcPool, privkey)
+	if err := keys.Bind(context.Background(), rpcPool); err != nil {
+		return err
+	}
+	p := keys.Remote(rpcPool)
 
 	// Send the vipnode_client handshake and start sending regular updates.
 	if err := c.Start(p); err != nil {
@@ -3,17 +3,42 @@ package client
 import (
 	"context"
 	"errors"
-	"math/big"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/vipnode/vipnode/ethnode"
+	"github.com/vipnode/vipnode/internal/pretty"
+	"github.com/vipnode/vipnode/jsonrpc2"
 	"github.com/vipnode/vipnode/pool"
+	"github.com/vipnode/vipnode/pool/balance"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
 // ErrAlreadyConnected is returned on Connect() if the client is already connected.
 var ErrAlreadyConnected = errors.New("client already connected")
 
+// connectPollInterval is how often the client checks whether a host it's
+// dialing has shown up as a peer.
+var connectPollInterval = time.Second
+
+// updateInterval is how often the client sends its peers to the pool, and
+// replaces hosts that it lost.
+var updateInterval = store.KeepaliveInterval
+
+// ConnectTimeoutError is returned when the client's node accepted a
+// connection request to a host, but the host did not show up as a peer
+// within the client's ConnectTimeout.
+type ConnectTimeoutError struct {
+	HostID  string
+	URI     string
+	Timeout time.Duration
+}
+
+func (err ConnectTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for host %q to connect via %s", err.Timeout, err.HostID, err.URI)
+}
+
 func New(node ethnode.EthNode) *Client {
 	return &Client{
 		EthNode: node,
@@ -37,9 +62,32 @@ type Client struct {
 	// displayed to the client.
 	PoolMessageCallback func(string)
 
-	connectedHosts []store.Node
-	stopCh         chan struct{}
-	waitCh         chan error
+	// PreferredHosts is a list of host node IDs that the client asks the
+	// pool to prioritize, if they're available.
+	PreferredHosts []string
+
+	// Distribution is how the client would like to be spread across hosts,
+	// such as pool.DistributeSpread. The pool's default is used if empty.
+	Distribution string
+
+	// CreditUnit is the unit that balances are displayed in. Defaults to
+	// wei.
+	CreditUnit pretty.Unit
+
+	// ConnectTimeout is how long to wait for a host to show up as a peer
+	// after asking the node to connect to it. Nodes like geth only start
+	// dialing when asked, so without a timeout the client assumes the host
+	// is connected as soon as the node accepts the request.
+	ConnectTimeout time.Duration
+
+	// MinHosts is how many hosts the client keeps connected to. When fewer
+	// of its hosts are still peers on an update, the client asks the pool
+	// for replacements. If zero, then it keeps as many hosts as it connected
+	// to in Start.
+	MinHosts int
+
+	stopCh chan struct{}
+	waitCh chan error
 }
 
 // Wait blocks until the client is stopped.
@@ -54,7 +102,7 @@ func (c *Client) Start(p pool.Pool) error {
 	logger.Printf("Requesting host candidates...")
 	starCtx := context.Background()
 	kind := c.EthNode.Kind().String()
-	resp, err := p.Client(starCtx, pool.ClientRequest{Kind: kind})
+	resp, err := p.Client(starCtx, pool.ClientRequest{Kind: kind, PreferredHosts: c.PreferredHosts, Distribution: c.Distribution})
 	if err != nil {
 		return err
 	}
@@ -66,63 +114,190 @@ func (c *Client) Start(p pool.Pool) error {
 		return pool.NoHostNodesError{}
 	}
 	logger.Printf("Received %d host candidates from pool (version %s), connecting...", len(nodes), resp.PoolVersion)
+	if len(resp.Errors) > 0 {
+		logger.Printf("Pool tried %d hosts, %d failed to whitelist us: %s", resp.Tried, len(resp.Errors), strings.Join(resp.Errors, "; "))
+	}
 	for _, node := range nodes {
-		if err := c.EthNode.ConnectPeer(starCtx, node.URI); err != nil {
+		if err := c.connectHost(starCtx, node); err != nil {
 			return err
 		}
 	}
-	if err := c.updatePeers(context.Background(), p); err != nil {
+	if _, err := c.updatePeers(context.Background(), p); err != nil {
 		return err
 	}
 
+	minHosts := c.MinHosts
+	if minHosts <= 0 {
+		minHosts = len(nodes)
+	}
 	go func() {
-		c.waitCh <- c.serveUpdates(p, nodes)
+		c.waitCh <- c.serveUpdates(p, nodes, minHosts)
 	}()
 
 	return nil
 }
 
-func (c *Client) serveUpdates(p pool.Pool, connectedHosts []store.Node) error {
-	ticker := time.Tick(store.KeepaliveInterval)
+// connectHost tries each of the host's URIs in order until one connects.
+func (c *Client) connectHost(ctx context.Context, node store.Node) error {
+	var err error
+	for _, uri := range node.URIs() {
+		if err = c.EthNode.ConnectPeer(ctx, uri); err == nil {
+			if err = c.waitConnected(ctx, node, uri); err == nil {
+				return nil
+			}
+			// Stop dialing the unresponsive URI before trying the next one.
+			if err := c.EthNode.DisconnectPeer(ctx, uri); err != nil {
+				logger.Printf("Failed to cancel dialing host %q via %s: %s", node.ID, uri, err)
+			}
+		}
+		logger.Printf("Failed to connect to host %q via %s: %s", node.ID, uri, err)
+	}
+	return err
+}
+
+// waitConnected polls the node's peers until the host shows up, or returns
+// ConnectTimeoutError once ConnectTimeout elapses. It returns immediately if
+// ConnectTimeout is not set.
+func (c *Client) waitConnected(ctx context.Context, node store.Node, uri string) error {
+	if c.ConnectTimeout <= 0 {
+		return nil
+	}
+	logger.Printf("Dialing host %q via %s...", node.ID, uri)
+	ctx, cancel := context.WithTimeout(ctx, c.ConnectTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(connectPollInterval)
+	defer ticker.Stop()
+	for {
+		peers, err := c.EthNode.Peers(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		for _, peer := range peers {
+			if peer.ID == string(node.ID) {
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ConnectTimeoutError{HostID: string(node.ID), URI: uri, Timeout: c.ConnectTimeout}
+		}
+	}
+}
+
+func (c *Client) serveUpdates(p pool.Pool, connectedHosts []store.Node, minHosts int) error {
+	ticker := time.Tick(updateInterval)
 	for {
 		select {
 		case <-ticker:
-			if err := c.updatePeers(context.Background(), p); err != nil {
-				return err
+			peerIDs, err := c.updatePeers(context.Background(), p)
+			if err == nil {
+				connectedHosts = c.replaceHosts(context.Background(), p, connectedHosts, peerIDs, minHosts)
+				continue
 			}
-		case <-c.stopCh:
-			closeCtx := context.Background()
-			for _, node := range connectedHosts {
-				if err := c.EthNode.DisconnectPeer(closeCtx, node.URI); err != nil {
-					return err
+			if jsonrpc2.IsErrorCode(err, balance.ErrCodeBalanceExhausted) {
+				// The pool dropped us, so don't wait for the hosts to kick us.
+				logger.Printf("Pool balance exhausted, disconnecting from %d hosts.", len(connectedHosts))
+				if err := c.disconnectHosts(context.Background(), connectedHosts); err != nil {
+					logger.Printf("Failed to disconnect from hosts: %s", err)
 				}
 			}
-			return nil
+			return err
+		case <-c.stopCh:
+			return c.disconnectHosts(context.Background(), connectedHosts)
+		}
+	}
+}
+
+// replaceHosts asks the pool for more hosts if fewer than minHosts of the
+// client's hosts are among its peers, and connects to them. It returns the
+// hosts that are still connected and the new ones. Failures are logged, so
+// that the client tries again on its next update.
+func (c *Client) replaceHosts(ctx context.Context, p pool.Pool, hosts []store.Node, peerIDs []string, minHosts int) []store.Node {
+	peers := make(map[string]struct{}, len(peerIDs))
+	for _, id := range peerIDs {
+		peers[id] = struct{}{}
+	}
+	connected := make([]store.Node, 0, len(hosts))
+	for _, host := range hosts {
+		if _, ok := peers[string(host.ID)]; ok {
+			connected = append(connected, host)
+		}
+	}
+	if len(connected) >= minHosts {
+		return connected
+	}
+
+	logger.Printf("Connected to %d of %d hosts, requesting replacements...", len(connected), minHosts)
+	// Only ask for the missing hosts, and tell the pool which hosts we still
+	// have so that it keeps them and offers different ones.
+	connectedIDs := make([]string, 0, len(connected))
+	for _, host := range connected {
+		connectedIDs = append(connectedIDs, string(host.ID))
+	}
+	resp, err := p.Client(ctx, pool.ClientRequest{
+		Kind:           c.EthNode.Kind().String(),
+		PreferredHosts: c.PreferredHosts,
+		Distribution:   c.Distribution,
+		NumHosts:       minHosts - len(connected),
+		ConnectedHosts: connectedIDs,
+	})
+	if err != nil {
+		logger.Printf("Failed to request replacement hosts: %s", err)
+		return connected
+	}
+	for _, node := range resp.Hosts {
+		if len(connected) >= minHosts {
+			break
+		}
+		if _, ok := peers[string(node.ID)]; ok {
+			continue
+		}
+		if err := c.connectHost(ctx, node); err != nil {
+			logger.Printf("Failed to connect to replacement host %q: %s", node.ID, err)
+			continue
+		}
+		connected = append(connected, node)
+	}
+	logger.Printf("Connected to %d of %d hosts after replacing lost hosts.", len(connected), minHosts)
+	return connected
+}
+
+func (c *Client) disconnectHosts(ctx context.Context, hosts []store.Node) error {
+	for _, node := range hosts {
+		if err := c.EthNode.DisconnectPeer(ctx, node.URI); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func (c *Client) updatePeers(ctx context.Context, p pool.Pool) error {
+// updatePeers sends the node's peers to the pool, and returns their IDs.
+func (c *Client) updatePeers(ctx context.Context, p pool.Pool) ([]string, error) {
 	peers, err := c.EthNode.Peers(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	peerIDs := make([]string, 0, len(peers))
 	for _, p := range peers {
 		peerIDs = append(peerIDs, p.ID)
 	}
 
-	update, err := p.Update(ctx, pool.UpdateRequest{Peers: peerIDs})
+	update, err := p.Update(ctx, pool.UpdateRequest{
+		Peers: peerIDs,
+		Role:  pool.RoleClient,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if c.BalanceCallback != nil && update.Balance != nil {
 		c.BalanceCallback(*update.Balance)
 	}
 
-	var credit *big.Int
+	credit := "unbilled"
 	if update.Balance != nil {
-		credit = &update.Balance.Credit
+		credit = pretty.FormatCredit(&update.Balance.Credit, c.CreditUnit)
 	}
 
 	if len(update.InvalidPeers) > 0 {
@@ -130,12 +305,12 @@ func (c *Client) updatePeers(ctx context.Context, p pool.Pool) error {
 		// tracking their host. That means the client is getting a free ride
 		// and it's up to the host to kick the client when the host deems
 		// necessary.
-		logger.Printf("Update: %d peers connected, %d expired in pool, %d balance with pool.", len(peerIDs), len(update.InvalidPeers), credit)
+		logger.Printf("Update: %d peers connected, %d expired in pool, %s balance with pool.", len(peerIDs), len(update.InvalidPeers), credit)
 	} else {
-		logger.Printf("Update: %d peers connected, %d balance with pool.", len(peerIDs), credit)
+		logger.Printf("Update: %d peers connected, %s balance with pool.", len(peerIDs), credit)
 	}
 
-	return nil
+	return peerIDs, nil
 }
 
 // Disconnect from hosts, also stop serving updates.
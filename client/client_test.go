@@ -1,7 +1,11 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/vipnode/vipnode/internal/fakenode"
 	"github.com/vipnode/vipnode/pool"
@@ -25,3 +29,160 @@ func TestClient(t *testing.T) {
 		URI: "foo",
 	})
 }
+
+func TestClientAltURIs(t *testing.T) {
+	primaryURI := "enode://host@192.0.2.1:30303"
+	altURI := "enode://host@[2001:db8::1]:30303"
+
+	node := fakenode.Node("foo")
+	node.ConnectPeerErr = func(nodeURI string) error {
+		if nodeURI == primaryURI {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	client := New(node)
+
+	p := pool.StaticPool{}
+	p.Nodes = append(p.Nodes, store.Node{
+		ID:      "host",
+		URI:     primaryURI,
+		AltURIs: []string{altURI},
+	})
+	if err := client.Start(&p); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	want := fakenode.Calls{
+		fakenode.Call("ConnectPeer", primaryURI),
+		fakenode.Call("ConnectPeer", altURI),
+	}
+	if !reflect.DeepEqual(node.Calls, want) {
+		t.Errorf("wrong calls:\n got: %v\nwant: %v", node.Calls, want)
+	}
+	if len(node.FakePeers) != 1 || node.FakePeers[0].ID != "host" {
+		t.Errorf("expected to be connected to host via the alt URI: %v", node.FakePeers)
+	}
+}
+
+func TestClientConnectTimeout(t *testing.T) {
+	defer func(interval time.Duration) { connectPollInterval = interval }(connectPollInterval)
+	connectPollInterval = 5 * time.Millisecond
+
+	hostURI := "enode://host@192.0.2.1:30303"
+	node := fakenode.Node("foo")
+	node.DialOnly = true
+	client := New(node)
+	client.ConnectTimeout = 50 * time.Millisecond
+
+	p := pool.StaticPool{}
+	p.Nodes = append(p.Nodes, store.Node{
+		ID:  "host",
+		URI: hostURI,
+	})
+
+	start := time.Now()
+	err := client.Start(&p)
+	elapsed := time.Since(start)
+
+	timeoutErr, ok := err.(ConnectTimeoutError)
+	if !ok {
+		t.Fatalf("expected ConnectTimeoutError, got: %v", err)
+	}
+	if timeoutErr.HostID != "host" || timeoutErr.URI != hostURI || timeoutErr.Timeout != client.ConnectTimeout {
+		t.Errorf("wrong timeout error: %+v", timeoutErr)
+	}
+	if elapsed < client.ConnectTimeout {
+		t.Errorf("timed out too early: %s", elapsed)
+	}
+
+	want := fakenode.Calls{
+		fakenode.Call("ConnectPeer", hostURI),
+		fakenode.Call("DisconnectPeer", hostURI),
+	}
+	if !reflect.DeepEqual(node.Calls, want) {
+		t.Errorf("wrong calls:\n got: %v\nwant: %v", node.Calls, want)
+	}
+
+	// Connects once the host shows up as a peer
+	node.DialOnly = false
+	node.Calls = fakenode.Calls{}
+	if err := client.Start(&p); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+}
+
+// clientRequestsPool is a StaticPool that records its Client requests.
+type clientRequestsPool struct {
+	pool.StaticPool
+	requests []pool.ClientRequest
+}
+
+func (p *clientRequestsPool) Client(ctx context.Context, req pool.ClientRequest) (*pool.ClientResponse, error) {
+	p.requests = append(p.requests, req)
+	return p.StaticPool.Client(ctx, req)
+}
+
+func TestClientReplaceHosts(t *testing.T) {
+	node := fakenode.Node("foo")
+	client := New(node)
+
+	p := &clientRequestsPool{}
+	p.Nodes = []store.Node{
+		{ID: "a", URI: "enode://a@192.0.2.1:30303"},
+		{ID: "b", URI: "enode://b@192.0.2.2:30303"},
+	}
+	if err := client.Start(p); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	hosts := p.Nodes
+
+	// Nothing to replace while both hosts are peers.
+	peerIDs, err := client.updatePeers(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.replaceHosts(context.Background(), p, hosts, peerIDs, 2); !reflect.DeepEqual(got, hosts) {
+		t.Errorf("wrong hosts: %v", got)
+	}
+	if len(p.requests) != 1 {
+		t.Fatalf("expected only the initial request, got: %v", p.requests)
+	}
+
+	// Host a drops, and the pool offers c as a replacement.
+	node.FakePeers = node.FakePeers[1:]
+	p.Nodes = []store.Node{
+		hosts[1],
+		{ID: "c", URI: "enode://c@192.0.2.3:30303"},
+	}
+	node.Calls = nil
+	peerIDs, err = client.updatePeers(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := client.replaceHosts(context.Background(), p, hosts, peerIDs, 2)
+	if want := p.Nodes; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong hosts:\n got: %v\nwant: %v", got, want)
+	}
+	if len(p.requests) != 2 {
+		t.Fatalf("expected a request for replacement hosts, got: %v", p.requests)
+	}
+	if got, want := p.requests[1].ConnectedHosts, []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong connected hosts: got %v; want %v", got, want)
+	}
+	if got, want := p.requests[1].NumHosts, 1; got != want {
+		t.Errorf("wrong number of hosts requested: got %d; want %d", got, want)
+	}
+	if len(p.requests[1].PreferredHosts) != 0 {
+		t.Errorf("connected hosts should not be preferred: %v", p.requests[1].PreferredHosts)
+	}
+	want := fakenode.Calls{
+		fakenode.Call("ConnectPeer", "enode://c@192.0.2.3:30303"),
+	}
+	if !reflect.DeepEqual(node.Calls, want) {
+		t.Errorf("wrong calls:\n got: %v\nwant: %v", node.Calls, want)
+	}
+}
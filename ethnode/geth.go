@@ -21,6 +21,7 @@ var _ EthNode = &gethNode{}
 
 type gethNode struct {
 	client *rpc.Client
+	agent  UserAgent
 }
 
 func (n *gethNode) ContractBackend() bind.ContractBackend {
@@ -31,8 +32,17 @@ func (n *gethNode) Kind() NodeKind {
 	return Geth
 }
 
+func (n *gethNode) UserAgent() UserAgent {
+	return n.agent
+}
+
+// CheckCompatible returns an error if the node's version is older than
+// MinVersions, or if it does not have the admin RPC APIs that we need
+// enabled.
 func (n *gethNode) CheckCompatible(ctx context.Context) error {
-	// TODO: Make sure we have the necessary APIs available, maybe version check?
+	if err := checkVersion(n.agent); err != nil {
+		return err
+	}
 	var result interface{}
 	err := n.client.CallContext(ctx, &result, "admin_addTrustedPeer", "")
 	if err == nil {
@@ -93,3 +103,7 @@ func (n *gethNode) BlockNumber(ctx context.Context) (uint64, error) {
 	}
 	return strconv.ParseUint(result, 0, 64)
 }
+
+func (n *gethNode) Syncing(ctx context.Context) (bool, error) {
+	return syncing(ctx, n.client)
+}
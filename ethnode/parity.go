@@ -2,6 +2,7 @@ package ethnode
 
 import (
 	"context"
+	"errors"
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -17,6 +18,7 @@ type parityPeers struct {
 
 type parityNode struct {
 	client *rpc.Client
+	agent  UserAgent
 }
 
 func (n *parityNode) ContractBackend() bind.ContractBackend {
@@ -27,6 +29,28 @@ func (n *parityNode) Kind() NodeKind {
 	return Parity
 }
 
+func (n *parityNode) UserAgent() UserAgent {
+	return n.agent
+}
+
+// CheckCompatible returns an error if the node's version is older than
+// MinVersions, or if it does not have the parity RPC APIs that we need
+// enabled, such as when --jsonrpc-apis is missing parity_set.
+func (n *parityNode) CheckCompatible(ctx context.Context) error {
+	if err := checkVersion(n.agent); err != nil {
+		return err
+	}
+	var result interface{}
+	err := n.client.CallContext(ctx, &result, "parity_addReservedPeer", "")
+	if err == nil {
+		return errors.New("failed to detect compatibility")
+	}
+	if err, ok := err.(codedError); ok && err.ErrorCode() == errCodeMethodNotFound {
+		return err
+	}
+	return nil
+}
+
 func (n *parityNode) ConnectPeer(ctx context.Context, nodeURI string) error {
 	// Parity doesn't have a way to just add peers, so we overload
 	// addReservedPeer for this.
@@ -73,3 +97,7 @@ func (n *parityNode) BlockNumber(ctx context.Context) (uint64, error) {
 	}
 	return strconv.ParseUint(result, 0, 64)
 }
+
+func (n *parityNode) Syncing(ctx context.Context) (bool, error) {
+	return syncing(ctx, n.client)
+}
@@ -2,6 +2,9 @@ package ethnode
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -60,6 +63,95 @@ func (n NodeKind) String() string {
 	}
 }
 
+// Semver is a parsed semantic version of a node client, such as v1.8.27.
+type Semver struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Semver) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// IsZero returns true if the version is unknown.
+func (v Semver) IsZero() bool {
+	return v == Semver{}
+}
+
+// Less returns true if v is an older version than other.
+func (v Semver) Less(other Semver) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+var semverPattern = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)`)
+
+// ParseSemver finds the version in a node's name, as reported by
+// web3_clientVersion or admin_nodeInfo, such as
+// "Geth/v1.8.27-stable/linux-amd64/go1.12.5". The version is the first
+// "/"-separated component that starts with vX.Y.Z, since custom node names
+// can come before it. It returns false if no version is found.
+func ParseSemver(name string) (Semver, bool) {
+	for _, part := range strings.Split(name, "/") {
+		m := semverPattern.FindStringSubmatch(part)
+		if m == nil {
+			continue
+		}
+		var v Semver
+		var err error
+		if v.Major, err = strconv.Atoi(m[1]); err != nil {
+			continue
+		}
+		if v.Minor, err = strconv.Atoi(m[2]); err != nil {
+			continue
+		}
+		if v.Patch, err = strconv.Atoi(m[3]); err != nil {
+			continue
+		}
+		return v, true
+	}
+	return Semver{}, false
+}
+
+// MinVersions is the oldest version of each kind of node that is compatible,
+// checked by RemoteNode. Older geth nodes don't support admin_addTrustedPeer.
+// Nodes whose version can't be parsed, such as custom builds, are not
+// checked.
+var MinVersions = map[NodeKind]Semver{
+	Geth: {Major: 1, Minor: 8, Patch: 0},
+}
+
+// VersionError is returned when a node's version is older than its kind's
+// entry in MinVersions.
+type VersionError struct {
+	Kind       NodeKind
+	Version    Semver
+	MinVersion Semver
+}
+
+func (err VersionError) Error() string {
+	return fmt.Sprintf("%s version %s is not supported, upgrade to %s or newer", err.Kind, err.Version, err.MinVersion)
+}
+
+// checkVersion returns VersionError if the agent's version is older than the
+// minimum for its kind.
+func checkVersion(agent UserAgent) error {
+	minVersion, ok := MinVersions[agent.Kind]
+	if !ok || agent.Semver.IsZero() {
+		return nil
+	}
+	if agent.Semver.Less(minVersion) {
+		return VersionError{Kind: agent.Kind, Version: agent.Semver, MinVersion: minVersion}
+	}
+	return nil
+}
+
 // UserAgent is the metadata about node client.
 type UserAgent struct {
 	Version     string // Result of web3_clientVersion
@@ -67,6 +159,7 @@ type UserAgent struct {
 
 	// Parsed/derived values
 	Kind       NodeKind  // Node implementation
+	Semver     Semver    // Parsed Version, zero if unknown
 	Network    NetworkID // Network ID
 	IsFullNode bool      // Is this a full node? (or a light client?)
 }
@@ -90,19 +183,27 @@ func ParseUserAgent(clientVersion, protocolVersion, netVersion string) (*UserAge
 	} else if strings.HasPrefix(agent.Version, "Parity-Ethereum/") || strings.HasPrefix(agent.Version, "Parity/") {
 		agent.Kind = Parity
 	}
+	agent.Semver, _ = ParseSemver(agent.Version)
 
 	protocol, err := strconv.ParseInt(protocolVersion, 0, 32)
 	if err != nil {
 		return nil, err
 	}
+	agent.IsFullNode = isFullNode(agent.Kind, protocol)
+	return agent, nil
+}
+
+// isFullNode returns false if the eth_protocolVersion of the given kind of
+// node indicates that it's a light client.
+func isFullNode(kind NodeKind, protocol int64) bool {
 	// FIXME: Can't find any docs on how this protocol value is supposed to be
 	// parsed, so just using anecdotal values for now.
-	if agent.Kind == Parity && protocol == 1 {
-		agent.IsFullNode = false
-	} else if agent.Kind == Geth && protocol == 10002 {
-		agent.IsFullNode = false
+	if kind == Parity && protocol == 1 {
+		return false
+	} else if kind == Geth && protocol == 10002 {
+		return false
 	}
-	return agent, nil
+	return true
 }
 
 // Dial is a wrapper around go-ethereum/rpc.Dial with client detection.
@@ -129,13 +230,53 @@ func DetectClient(client *rpc.Client) (*UserAgent, error) {
 	if err := client.Call(&netVersion, "net_version"); err != nil {
 		return nil, err
 	}
-	return ParseUserAgent(clientVersion, protocolVersion, netVersion)
+	agent, err := ParseUserAgent(clientVersion, protocolVersion, netVersion)
+	if err != nil {
+		return nil, err
+	}
+	if agent.Semver.IsZero() {
+		// Some custom builds only report their version in the node name.
+		var nodeInfo struct {
+			Name string `json:"name"`
+		}
+		if err := client.Call(&nodeInfo, "admin_nodeInfo"); err == nil {
+			agent.Semver, _ = ParseSemver(nodeInfo.Name)
+		}
+	}
+	if agent.Kind == Unknown {
+		// Custom parity builds can have a different client version prefix,
+		// so fall back to checking for a parity-only API.
+		var versionInfo json.RawMessage
+		if err := client.Call(&versionInfo, "parity_versionInfo"); err == nil {
+			agent.Kind = Parity
+			protocol, _ := strconv.ParseInt(protocolVersion, 0, 32)
+			agent.IsFullNode = isFullNode(agent.Kind, protocol)
+		}
+	}
+	return agent, nil
 }
 
 // PeerInfo stores the node ID and client metadata about a peer.
 type PeerInfo struct {
 	ID   string `json:"id"`   // Unique node identifier (also the encryption pubkey)
 	Name string `json:"name"` // Name of the node, including client type, version, OS, custom data
+
+	// Protocols maps the peer's protocol names to their protocol-specific
+	// metadata. Parity includes unused protocols with a null value.
+	Protocols map[string]json.RawMessage `json:"protocols,omitempty"`
+	Network   struct {
+		Trusted bool `json:"trusted"` // Only reported by geth
+	} `json:"network"`
+}
+
+// IsLightClient returns true if the peer is connected over a light client
+// protocol (les or pip) and not the full eth protocol.
+func (p PeerInfo) IsLightClient() bool {
+	has := func(name string) bool {
+		v, ok := p.Protocols[name]
+		return ok && len(v) > 0 && string(v) != "null"
+	}
+	return !has("eth") && (has("les") || has("pip"))
 }
 
 // EthNode is the normalized interface between different kinds of nodes.
@@ -144,6 +285,8 @@ type EthNode interface {
 
 	// Kind returns the kind of node this is.
 	Kind() NodeKind
+	// UserAgent returns the metadata detected when connecting to the node.
+	UserAgent() UserAgent
 	// Enode returns this node's enode://...
 	Enode(ctx context.Context) (string, error)
 	// AddTrustedPeer adds a nodeID to a set of nodes that can always connect, even
@@ -159,6 +302,18 @@ type EthNode interface {
 	Peers(ctx context.Context) ([]PeerInfo, error)
 	// BlockNumber returns the current sync'd block number.
 	BlockNumber(ctx context.Context) (uint64, error)
+	// Syncing returns true if the node is still syncing the chain.
+	Syncing(ctx context.Context) (bool, error)
+}
+
+// syncing calls eth_syncing, which returns false once the node is in sync,
+// or an object with the sync progress otherwise.
+func syncing(ctx context.Context, client *rpc.Client) (bool, error) {
+	var result json.RawMessage
+	if err := client.CallContext(ctx, &result, "eth_syncing"); err != nil {
+		return false, err
+	}
+	return string(result) != "false", nil
 }
 
 // RemoteNode autodetects the node kind and returns the appropriate EthNode
@@ -170,11 +325,16 @@ func RemoteNode(client *rpc.Client) (EthNode, error) {
 	}
 	switch version.Kind {
 	case Parity:
-		return &parityNode{client: client}, nil
+		node := &parityNode{client: client, agent: *version}
+		ctx := context.TODO()
+		if err := node.CheckCompatible(ctx); err != nil {
+			return nil, err
+		}
+		return node, nil
 	default:
 		// Treat everything else as Geth
 		// FIXME: Is this a bad idea?
-		node := &gethNode{client: client}
+		node := &gethNode{client: client, agent: *version}
 		ctx := context.TODO()
 		if err := node.CheckCompatible(ctx); err != nil {
 			return nil, err
@@ -1,6 +1,12 @@
 package ethnode
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
 
 func TestParseUserAgent(t *testing.T) {
 	testcases := []struct {
@@ -28,3 +34,188 @@ func TestParseUserAgent(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSemver(t *testing.T) {
+	testcases := []struct {
+		name    string
+		want    Semver
+		wantErr bool
+	}{
+		{"Geth/v1.8.27-stable-4bcc0a37/linux-amd64/go1.11.9", Semver{1, 8, 27}, false},
+		{"Geth/foo/v1.8.13-unstable/linux-amd64/go1.10.3", Semver{1, 8, 13}, false},
+		{"Geth/v1.10.1-stable/linux-amd64/go1.16", Semver{1, 10, 1}, false},
+		{"Parity-Ethereum//v2.0.5-stable-7dc4d349a1-20180917/x86_64-linux-gnu/rustc1.29.0", Semver{2, 0, 5}, false},
+		{"Parity//v1.11.11-stable-cb03f380a-20180910/x86_64-linux-gnu/rustc1.28.0", Semver{1, 11, 11}, false},
+		{"Geth/linux-amd64/go1.10.3", Semver{}, true},
+		{"", Semver{}, true},
+	}
+
+	for i, tc := range testcases {
+		got, ok := ParseSemver(tc.name)
+		if ok == tc.wantErr {
+			t.Errorf("[case %d] unexpected parse result for %q: %t", i, tc.name, ok)
+		}
+		if got != tc.want {
+			t.Errorf("[case %d] got: %s; want %s", i, got, tc.want)
+		}
+	}
+
+	if !(Semver{1, 7, 3}).Less(Semver{1, 8, 0}) || (Semver{1, 8, 0}).Less(Semver{1, 8, 0}) || (Semver{2, 0, 0}).Less(Semver{1, 9, 9}) {
+		t.Error("wrong version order")
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	testcases := []struct {
+		clientVersion string
+		wantErr       bool
+	}{
+		{"Geth/v1.7.3-stable-4bcc0a37/linux-amd64/go1.9.2", true},
+		{"Geth/v1.8.0-stable/linux-amd64/go1.9.2", false},
+		{"Geth/v1.8.27-stable-4bcc0a37/linux-amd64/go1.11.9", false},
+		{"Geth/custom/linux-amd64/go1.11.9", false},
+		{"Parity-Ethereum//v1.7.0-stable-5f2cabd-20170727/x86_64-linux-gnu/rustc1.18.0", false},
+	}
+
+	for i, tc := range testcases {
+		agent, err := ParseUserAgent(tc.clientVersion, "63", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkVersion(*agent)
+		if tc.wantErr {
+			if _, ok := err.(VersionError); !ok {
+				t.Errorf("[case %d] expected VersionError, got: %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("[case %d] unexpected error: %s", i, err)
+		}
+	}
+}
+
+type FakeWeb3 struct{ version string }
+
+func (s *FakeWeb3) ClientVersion() string { return s.version }
+
+type FakeEth struct{}
+
+func (s *FakeEth) ProtocolVersion() string { return "63" }
+
+type FakeNet struct{}
+
+func (s *FakeNet) Version() string { return "1" }
+
+type FakeParityInfo struct{}
+
+func (s *FakeParityInfo) VersionInfo() map[string]string {
+	return map[string]string{"hash": "0x0"}
+}
+
+type FakeParitySet struct{}
+
+func (s *FakeParitySet) AddReservedPeer(enode string) (bool, error) {
+	if enode == "" {
+		return false, errors.New("invalid enode")
+	}
+	return true, nil
+}
+
+func fakeRPC(t *testing.T, clientVersion string, services ...interface{}) *rpc.Client {
+	t.Helper()
+	server := rpc.NewServer()
+	namespaces := map[string]interface{}{
+		"web3": &FakeWeb3{clientVersion},
+		"eth":  &FakeEth{},
+		"net":  &FakeNet{},
+	}
+	for name, service := range namespaces {
+		if err := server.RegisterName(name, service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, service := range services {
+		if err := server.RegisterName("parity", service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rpc.DialInProc(server)
+}
+
+func TestRemoteNodeParity(t *testing.T) {
+	testcases := []struct {
+		name          string
+		clientVersion string
+		services      []interface{}
+		wantKind      NodeKind
+		wantErr       bool
+	}{
+		{"parity", "Parity-Ethereum//v2.0.5-stable-7dc4d349a1-20180917/x86_64-linux-gnu/rustc1.29.0", []interface{}{&FakeParitySet{}}, Parity, false},
+		{"custom parity", "Custom//v2.0.5", []interface{}{&FakeParityInfo{}, &FakeParitySet{}}, Parity, false},
+		{"parity missing set api", "Parity-Ethereum//v2.0.5-stable-7dc4d349a1-20180917/x86_64-linux-gnu/rustc1.29.0", nil, Parity, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fakeRPC(t, tc.clientVersion, tc.services...)
+			defer client.Close()
+
+			agent, err := DetectClient(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agent.Kind != tc.wantKind {
+				t.Errorf("wrong kind: got %s; want %s", agent.Kind, tc.wantKind)
+			}
+
+			node, err := RemoteNode(client)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected compatibility error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if node.Kind() != tc.wantKind {
+				t.Errorf("wrong node kind: got %s; want %s", node.Kind(), tc.wantKind)
+			}
+			if !node.UserAgent().IsFullNode {
+				t.Errorf("expected full node: %+v", node.UserAgent())
+			}
+		})
+	}
+}
+
+func TestPeerInfoIsLightClient(t *testing.T) {
+	tests := []struct {
+		JSON string
+		Want bool
+	}{
+		// geth admin_peers
+		{`{"id":"a","protocols":{"les":{"version":2,"difficulty":1,"head":"0x1"}},"network":{"inbound":true,"trusted":false}}`, true},
+		{`{"id":"a","protocols":{"eth":{"version":63,"difficulty":1,"head":"0x1"}}}`, false},
+		{`{"id":"a","protocols":{"eth":"handshake"}}`, false},
+		// parity parity_netPeers
+		{`{"id":"a","protocols":{"eth":null,"pip":{"version":1,"difficulty":"0x1","head":"0x1"}}}`, true},
+		{`{"id":"a","protocols":{"eth":{"version":63,"difficulty":"0x1","head":"0x1"},"pip":null}}`, false},
+		{`{"id":"a"}`, false},
+	}
+	for _, tc := range tests {
+		var peer PeerInfo
+		if err := json.Unmarshal([]byte(tc.JSON), &peer); err != nil {
+			t.Fatal(err)
+		}
+		if got := peer.IsLightClient(); got != tc.Want {
+			t.Errorf("IsLightClient(%s): got %t; want %t", tc.JSON, got, tc.Want)
+		}
+	}
+
+	var peer PeerInfo
+	if err := json.Unmarshal([]byte(`{"id":"a","network":{"trusted":true}}`), &peer); err != nil {
+		t.Fatal(err)
+	}
+	if !peer.Network.Trusted {
+		t.Error("expected trusted peer")
+	}
+}
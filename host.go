@@ -6,7 +6,6 @@ import (
 	"os"
 	"os/signal"
 
-	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/host"
 	"github.com/vipnode/vipnode/jsonrpc2"
 	ws "github.com/vipnode/vipnode/jsonrpc2/ws/gorilla"
@@ -19,19 +18,15 @@ func runHost(options Options) error {
 	if err != nil {
 		return err
 	}
-	privkey, err := findNodeKey(options.Host.NodeKey)
+	keys, err := loadSigner(remoteNode, options.Host.NodeKey, options.Host.SigningKey)
 	if err != nil {
-		return ErrExplain{err, "Failed to find node private key. Use --nodekey to specify the correct path."}
+		return err
 	}
-	// Confirm that nodeID matches the private key
-	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	nodeID := keys.NodeID
 	remoteEnode, err := remoteNode.Enode(context.Background())
 	if err != nil {
 		return err
 	}
-	if err := matchEnode(remoteEnode, nodeID); err != nil {
-		return err
-	}
 
 	if options.Host.Payout == "" {
 		logger.Warning("No --payout address provided, will not receive pool payments.")
@@ -48,6 +43,16 @@ func runHost(options Options) error {
 		// because the pool will use the connection's ip as the host.
 		h.NodeURI = remoteEnode
 	}
+	h.SourceIP = options.Host.SourceIP
+	h.EnforceWhitelist = options.Host.EnforceWhitelist
+	h.Version = Version
+	h.MaxPeers = options.Host.MaxPeers
+	for _, uri := range options.Host.AltNodeURI {
+		if err := matchEnode(uri, nodeID); err != nil {
+			return err
+		}
+	}
+	h.AltNodeURIs = options.Host.AltNodeURI
 
 	if options.Host.Pool == ":memory:" {
 		// Support for in-memory pool. This is primarily for testing.
@@ -57,37 +62,70 @@ func runHost(options Options) error {
 		if err := rpcPool.Server.Register("vipnode_", p); err != nil {
 			return err
 		}
-		remotePool := pool.Remote(rpcPool, privkey)
+		if err := keys.Bind(context.Background(), rpcPool); err != nil {
+			return err
+		}
+		remotePool := keys.Remote(rpcPool)
 		if err := h.Start(remotePool); err != nil {
 			return err
 		}
 		return h.Wait()
 	}
 
-	// Dial host to pool
+	// Dial host to pool. The connection is re-established if it drops, after
+	// which the host registers with the pool again.
+	var remotePool pool.Pool
+	reconnectOpts := ws.ReconnectOptions{
+		KeepaliveInterval: options.Host.Keepalive,
+		OnReconnect: func() {
+			logger.Infof("Reconnected to vipnode pool: %s", options.Host.Pool)
+			if err := h.Resume(remotePool); err != nil {
+				logger.Errorf("Failed to register with the pool after reconnecting: %s", err)
+			}
+		},
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
-	poolCodec, err := ws.WebSocketDial(ctx, options.Host.Pool)
+	poolCodec, err := ws.WebSocketDialReconnecting(ctx, options.Host.Pool, reconnectOpts)
 	cancel()
 	if err != nil {
 		return ErrExplainRetry{ErrExplain{err, "Failed to connect to the pool RPC API."}}
 	}
+	if options.DebugRPC {
+		poolCodec = jsonrpc2.DebugCodec(options.Host.Pool, poolCodec)
+	}
 	logger.Infof("Connected to vipnode pool: %s", options.Host.Pool)
 
 	rpcServer := &jsonrpc2.Server{}
 	if err := rpcServer.RegisterMethod("vipnode_whitelist", h, "Whitelist"); err != nil {
 		return err
 	}
+	if err := rpcServer.RegisterMethod("vipnode_capabilities", h, "Capabilities"); err != nil {
+		return err
+	}
+	if err := rpcServer.RegisterMethod("vipnode_peers", h, "Peers"); err != nil {
+		return err
+	}
 	rpcPool := jsonrpc2.Remote{
 		Client: &jsonrpc2.Client{},
 		Server: rpcServer,
 		Codec:  poolCodec,
 	}
 
+	remote := keys.Remote(&rpcPool)
+	remotePool = remote
 	errChan := make(chan error)
 	go func() {
 		errChan <- rpcPool.Serve()
 	}()
-	remotePool := pool.Remote(&rpcPool, privkey)
+	ctx, cancel = context.WithTimeout(context.Background(), rpcTimeout)
+	err = remote.Ping(ctx)
+	cancel()
+	if err != nil {
+		return ErrExplainRetry{ErrExplain{err, "Failed to get a response from the pool RPC API."}}
+	}
+	if err := keys.Bind(context.Background(), &rpcPool); err != nil {
+		return err
+	}
 	if err := h.Start(remotePool); err != nil {
 		if jsonrpc2.IsErrorCode(err, jsonrpc2.ErrCodeMethodNotFound, jsonrpc2.ErrCodeInvalidParams) {
 			err = ErrExplain{err, fmt.Sprintf(`Missing a required RPC method. Make sure your vipnode binary is up to date. (Current version: %s)`, Version)}
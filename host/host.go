@@ -2,9 +2,11 @@ package host
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/vipnode/vipnode/ethnode"
+	"github.com/vipnode/vipnode/jsonrpc2"
 	"github.com/vipnode/vipnode/pool"
 	"github.com/vipnode/vipnode/pool/store"
 )
@@ -21,10 +23,11 @@ type client struct {
 
 func New(node ethnode.EthNode, payout string) *Host {
 	return &Host{
-		node:   node,
-		payout: payout,
-		stopCh: make(chan struct{}),
-		waitCh: make(chan error, 1),
+		node:      node,
+		payout:    payout,
+		whitelist: map[string]struct{}{},
+		stopCh:    make(chan struct{}),
+		waitCh:    make(chan error, 1),
 	}
 }
 
@@ -41,21 +44,102 @@ type Host struct {
 	// node runs on a different IP from the vipnode agent.
 	NodeURI string
 
+	// SourceIP, if set, is the IP address or network interface name that
+	// clients should connect to. It replaces the host of the advertised
+	// NodeURI, which is useful when the host has multiple interfaces.
+	SourceIP string
+
+	// AltNodeURIs are additional enode:// connection strings that clients
+	// can try if NodeURI is unreachable, such as an IPv6 address or a relay.
+	AltNodeURIs []string
+
+	// EnforceWhitelist disconnects light client peers that the pool has not
+	// whitelisted, so that clients can't skip the pool by connecting to the
+	// host's p2p port directly. Peers that were connected before the host
+	// started, or that the node reports as trusted, are allowed.
+	EnforceWhitelist bool
+
+	// Version is the version of the vipnode agent, which is reported to the
+	// pool when registering.
+	Version string
+
+	// MaxPeers is the number of clients that the node can accept, which is
+	// reported to the pool so that it stops sending clients once the node is
+	// full. Zero means no limit.
+	MaxPeers int
+
 	node   ethnode.EthNode
 	payout string
 	stopCh chan struct{}
 	waitCh chan error
+
+	mu        sync.Mutex
+	whitelist map[string]struct{}
 }
 
 // Whitelist a client for this host.
 func (h *Host) Whitelist(ctx context.Context, nodeID string) error {
 	logger.Printf("Received whitelist request: %s", nodeID)
-	return h.node.AddTrustedPeer(ctx, nodeID)
+	if err := h.node.AddTrustedPeer(ctx, nodeID); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.whitelist[nodeID] = struct{}{}
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Host) unwhitelist(nodeID string) {
+	h.mu.Lock()
+	delete(h.whitelist, nodeID)
+	h.mu.Unlock()
+}
+
+// isAllowedPeer returns true unless the peer is a light client that isn't
+// whitelisted or trusted.
+func (h *Host) isAllowedPeer(peer ethnode.PeerInfo) bool {
+	if !peer.IsLightClient() || peer.Network.Trusted {
+		return true
+	}
+	h.mu.Lock()
+	_, ok := h.whitelist[peer.ID]
+	h.mu.Unlock()
+	return ok
+}
+
+// Capabilities reports what kind of node this host is running, so that the
+// pool can reject hosts that can't serve clients.
+func (h *Host) Capabilities(ctx context.Context) (*pool.HostCapabilities, error) {
+	agent := h.node.UserAgent()
+	syncing, err := h.node.Syncing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pool.HostCapabilities{
+		Kind:       agent.Kind.String(),
+		IsFullNode: agent.IsFullNode,
+		Syncing:    syncing,
+	}, nil
+}
+
+// Peers returns the IDs of the peers that the node is connected to, so that
+// the pool can verify the peers in the host's updates.
+func (h *Host) Peers(ctx context.Context) ([]string, error) {
+	peers, err := h.node.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		ids = append(ids, peer.ID)
+	}
+	return ids, nil
 }
 
 // Disconnect a client from this host and remove from whitelist.
 func (h *Host) Disconnect(ctx context.Context, nodeID string) error {
 	logger.Printf("Received disconnect request: %s", nodeID)
+	h.unwhitelist(nodeID)
 	if err := h.node.RemoveTrustedPeer(ctx, nodeID); err != nil {
 		return err
 	}
@@ -67,6 +151,10 @@ func (h *Host) updatePeers(ctx context.Context, p pool.Pool) error {
 	if err != nil {
 		return err
 	}
+	syncing, err := h.node.Syncing(ctx)
+	if err != nil {
+		return err
+	}
 
 	peers, err := h.node.Peers(ctx)
 	if err != nil {
@@ -74,21 +162,35 @@ func (h *Host) updatePeers(ctx context.Context, p pool.Pool) error {
 	}
 	peerUpdate := make([]string, 0, len(peers))
 	for _, peer := range peers {
+		if h.EnforceWhitelist && !h.isAllowedPeer(peer) {
+			logger.Printf("Disconnecting light client that was not whitelisted by the pool: %s", peer.ID)
+			if err := h.node.DisconnectPeer(ctx, peer.ID); err != nil {
+				return err
+			}
+			continue
+		}
 		peerUpdate = append(peerUpdate, peer.ID)
 	}
 	update, err := p.Update(ctx, pool.UpdateRequest{
 		Peers:       peerUpdate,
 		BlockNumber: block,
+		Role:        pool.RoleHost,
+		Syncing:     syncing,
 	})
 	if err != nil {
 		return err
 	}
+	balance := "unbilled"
+	if update.Balance != nil {
+		balance = update.Balance.String()
+	}
 	if len(update.InvalidPeers) == 0 {
-		logger.Printf("Sent pool update: %d peers; Current balance: %s", len(peerUpdate), update.Balance)
+		logger.Printf("Sent pool update: %d peers; Current balance: %s", len(peerUpdate), balance)
 		return nil
 	}
-	logger.Printf("Sent pool update: %d peers; Disconnecting from %d invalid peers. Current balance: %s", len(peerUpdate), len(update.InvalidPeers), update.Balance)
+	logger.Printf("Sent pool update: %d peers; Disconnecting from %d invalid peers. Current balance: %s", len(peerUpdate), len(update.InvalidPeers), balance)
 	for _, peerID := range update.InvalidPeers {
+		h.unwhitelist(peerID)
 		// FIXME: Are there recoverable errors here?
 		if err := h.node.RemoveTrustedPeer(ctx, peerID); err != nil {
 			return err
@@ -124,16 +226,23 @@ func (h *Host) Start(p pool.Pool) error {
 	}
 	logger.Printf("Connected to local node: %s", enode)
 
-	hostReq := pool.HostRequest{
-		Kind:    h.node.Kind().String(),
-		Payout:  h.payout,
-		NodeURI: h.NodeURI,
+	if h.EnforceWhitelist {
+		// Keep peers from before we started, they were likely whitelisted
+		// by a previous run.
+		peers, err := h.node.Peers(startCtx)
+		if err != nil {
+			return err
+		}
+		h.mu.Lock()
+		for _, peer := range peers {
+			h.whitelist[peer.ID] = struct{}{}
+		}
+		h.mu.Unlock()
 	}
-	resp, err := p.Host(startCtx, hostReq)
-	if err != nil {
+
+	if err := h.register(startCtx, p, enode); err != nil {
 		return err
 	}
-	logger.Printf("Registered on pool: Version %s", resp.PoolVersion)
 
 	// TODO: Resume tracking peers that we care about (in case of interrupted
 	// shutdown)?
@@ -148,6 +257,57 @@ func (h *Host) Start(p pool.Pool) error {
 	return nil
 }
 
+// register announces the host to the pool. enode is the local node's enode,
+// used if NodeURI is unset.
+func (h *Host) register(ctx context.Context, p pool.Pool, enode string) error {
+	nodeURI := h.NodeURI
+	if h.SourceIP != "" {
+		ip, err := resolveSourceIP(h.SourceIP)
+		if err != nil {
+			return err
+		}
+		if nodeURI == "" {
+			nodeURI = enode
+		}
+		if nodeURI, err = withSourceIP(nodeURI, ip); err != nil {
+			return err
+		}
+		logger.Printf("Advertising enode with source IP: %s", nodeURI)
+	}
+
+	hostReq := pool.HostRequest{
+		Kind:        h.node.Kind().String(),
+		Payout:      h.payout,
+		NodeURI:     nodeURI,
+		AltNodeURIs: h.AltNodeURIs,
+		Version:     h.Version,
+		MaxPeers:    h.MaxPeers,
+	}
+	resp, err := p.Host(ctx, hostReq)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Registered on pool: Version %s", resp.PoolVersion)
+	return nil
+}
+
+// Resume registers the host with the pool again and sends a fresh peer
+// update, such as after the pool connection was re-established. Start must
+// have been called first.
+func (h *Host) Resume(p pool.Pool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	enode, err := h.node.Enode(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.register(ctx, p, enode); err != nil {
+		return err
+	}
+	return h.updatePeers(ctx, p)
+}
+
 func (h *Host) serveUpdates(p pool.Pool) error {
 	ticker := time.Tick(store.KeepaliveInterval)
 	for {
@@ -156,6 +316,12 @@ func (h *Host) serveUpdates(p pool.Pool) error {
 			ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
 			err := h.updatePeers(ctx, p)
 			cancel()
+			if err == jsonrpc2.ErrCodecReset {
+				// The pool connection is being re-established, Resume
+				// takes over once it's back.
+				logger.Printf("Skipped pool update while reconnecting to the pool")
+				continue
+			}
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,147 @@
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vipnode/vipnode/ethnode"
+	"github.com/vipnode/vipnode/internal/fakenode"
+	"github.com/vipnode/vipnode/pool"
+)
+
+type hostRequestPool struct {
+	pool.StaticPool
+	req pool.HostRequest
+}
+
+func (p *hostRequestPool) Host(ctx context.Context, req pool.HostRequest) (*pool.HostResponse, error) {
+	p.req = req
+	return &pool.HostResponse{}, nil
+}
+
+func TestHostSourceIP(t *testing.T) {
+	h := New(fakenode.Node("foo"), "")
+	h.NodeURI = "enode://foo@[::]:30304?discport=0"
+	h.SourceIP = "10.1.2.3"
+
+	p := &hostRequestPool{}
+	if err := h.Start(p); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	if got, want := p.req.NodeURI, "enode://foo@10.1.2.3:30304?discport=0"; got != want {
+		t.Errorf("wrong advertised enode: got %q; want %q", got, want)
+	}
+}
+
+type updateRequestPool struct {
+	pool.StaticPool
+	req pool.UpdateRequest
+}
+
+func (p *updateRequestPool) Update(ctx context.Context, req pool.UpdateRequest) (*pool.UpdateResponse, error) {
+	p.req = req
+	return &pool.UpdateResponse{}, nil
+}
+
+func TestHostEnforceWhitelist(t *testing.T) {
+	peer := func(id string, protocol string, trusted bool) ethnode.PeerInfo {
+		p := ethnode.PeerInfo{
+			ID:        id,
+			Protocols: map[string]json.RawMessage{protocol: json.RawMessage(`{"version":2}`)},
+		}
+		p.Network.Trusted = trusted
+		return p
+	}
+
+	node := fakenode.Node("host")
+	node.FakePeers = []ethnode.PeerInfo{peer("existing", "les", false)}
+	h := New(node, "")
+	h.EnforceWhitelist = true
+
+	p := &updateRequestPool{}
+	if err := h.Start(p); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	if err := h.Whitelist(context.Background(), "client"); err != nil {
+		t.Fatal(err)
+	}
+	node.FakePeers = []ethnode.PeerInfo{
+		peer("existing", "les", false),
+		peer("client", "les", false),
+		peer("intruder", "les", false),
+		peer("trusted", "les", true),
+		peer("full", "eth", false),
+	}
+	node.Calls = fakenode.Calls{}
+	if err := h.updatePeers(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (fakenode.Calls{fakenode.Call("DisconnectPeer", "intruder")}); !reflect.DeepEqual(node.Calls, want) {
+		t.Errorf("node calls:\n  got %q;\n want %q", node.Calls, want)
+	}
+	if want := []string{"existing", "client", "trusted", "full"}; !reflect.DeepEqual(p.req.Peers, want) {
+		t.Errorf("update peers: got %q; want %q", p.req.Peers, want)
+	}
+
+	// Clients that the pool disconnects are no longer allowed
+	if err := h.Disconnect(context.Background(), "client"); err != nil {
+		t.Fatal(err)
+	}
+	node.Calls = fakenode.Calls{}
+	if err := h.updatePeers(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	want := fakenode.Calls{
+		fakenode.Call("DisconnectPeer", "client"),
+		fakenode.Call("DisconnectPeer", "intruder"),
+	}
+	if !reflect.DeepEqual(node.Calls, want) {
+		t.Errorf("node calls:\n  got %q;\n want %q", node.Calls, want)
+	}
+}
+
+func TestResolveSourceIP(t *testing.T) {
+	if ip, err := resolveSourceIP("::1"); err != nil {
+		t.Error(err)
+	} else if ip.String() != "::1" {
+		t.Errorf("wrong ip: %s", ip)
+	}
+	if _, err := resolveSourceIP("not-an-interface"); err == nil {
+		t.Error("expected error for unknown interface")
+	}
+}
+
+func TestHostSyncing(t *testing.T) {
+	node := fakenode.Node("host")
+	node.FakeSyncing = true
+	h := New(node, "")
+
+	p := &updateRequestPool{}
+	if err := h.Start(p); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	if !p.req.Syncing {
+		t.Error("expected update to report syncing")
+	}
+	if caps, err := h.Capabilities(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if !caps.Syncing {
+		t.Error("expected capabilities to report syncing")
+	}
+
+	node.FakeSyncing = false
+	if err := h.updatePeers(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if p.req.Syncing {
+		t.Error("expected update to report in sync")
+	}
+}
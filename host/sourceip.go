@@ -0,0 +1,59 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// resolveSourceIP returns the IP address for source, which is either an IP
+// address or the name of a network interface. For interfaces, the first IPv4
+// address is preferred.
+func resolveSourceIP(source string) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source IP or interface %q: %s", source, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var r net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if r == nil {
+			r = ipnet.IP
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("network interface %q has no IP address", source)
+	}
+	return r, nil
+}
+
+// withSourceIP returns the enode:// URI with its host replaced by ip. The
+// port and query parameters are retained.
+func withSourceIP(nodeURI string, ip net.IP) (string, error) {
+	u, err := url.Parse(nodeURI)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "enode" {
+		return "", fmt.Errorf("invalid enode URI: %s", nodeURI)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "30303"
+	}
+	u.Host = net.JoinHostPort(ip.String(), port)
+	return u.String(), nil
+}
@@ -36,6 +36,14 @@ type FakeNode struct {
 	Calls           Calls
 	FakePeers       []ethnode.PeerInfo
 	FakeBlockNumber uint64
+	FakeSyncing     bool
+	IsLightNode     bool
+
+	// ConnectPeerErr, if set, is called to decide whether ConnectPeer fails.
+	ConnectPeerErr func(nodeURI string) error
+	// DialOnly makes ConnectPeer succeed without adding the peer, like a
+	// node that starts dialing but never completes the handshake.
+	DialOnly bool
 }
 
 func (n *FakeNode) ContractBackend() bind.ContractBackend {
@@ -44,6 +52,9 @@ func (n *FakeNode) ContractBackend() bind.ContractBackend {
 
 func (n *FakeNode) Kind() ethnode.NodeKind                    { return n.NodeKind }
 func (n *FakeNode) Enode(ctx context.Context) (string, error) { return n.NodeID, nil }
+func (n *FakeNode) UserAgent() ethnode.UserAgent {
+	return ethnode.UserAgent{Kind: n.NodeKind, IsFullNode: !n.IsLightNode}
+}
 func (n *FakeNode) AddTrustedPeer(ctx context.Context, nodeID string) error {
 	n.Calls = append(n.Calls, Call("AddTrustedPeer", nodeID))
 	return nil
@@ -54,6 +65,14 @@ func (n *FakeNode) RemoveTrustedPeer(ctx context.Context, nodeID string) error {
 }
 func (n *FakeNode) ConnectPeer(ctx context.Context, nodeURI string) error {
 	n.Calls = append(n.Calls, Call("ConnectPeer", nodeURI))
+	if n.ConnectPeerErr != nil {
+		if err := n.ConnectPeerErr(nodeURI); err != nil {
+			return err
+		}
+	}
+	if n.DialOnly {
+		return nil
+	}
 	uri, err := url.Parse(nodeURI)
 	if err != nil {
 		return err
@@ -73,6 +92,9 @@ func (n *FakeNode) Peers(ctx context.Context) ([]ethnode.PeerInfo, error) {
 func (n *FakeNode) BlockNumber(ctx context.Context) (uint64, error) {
 	return n.FakeBlockNumber, nil
 }
+func (n *FakeNode) Syncing(ctx context.Context) (bool, error) {
+	return n.FakeSyncing, nil
+}
 
 func FakePeers(num int) []ethnode.PeerInfo {
 	peers := make([]ethnode.PeerInfo, 0, num)
@@ -0,0 +1,24 @@
+package pretty
+
+import "testing"
+
+func TestAbbrev(t *testing.T) {
+	tests := []struct {
+		In     string
+		Ranges []int
+		Want   string
+	}{
+		{"", nil, ""},
+		{"abcd", nil, "abcd"},
+		{"abcdefghijkl", nil, "abcdefghijkl"},
+		{"abcdefghijklm", nil, "abcdefghijkl…"},
+		{"abcdefghij", []int{8}, "abcdefgh…"},
+		{"abcdefghij", []int{10, 4}, "abcdefghij"},
+		{"abcdefghijk", []int{10, 4}, "abcd…"},
+	}
+	for _, tc := range tests {
+		if got := Abbrev(tc.In, tc.Ranges...).String(); got != tc.Want {
+			t.Errorf("Abbrev(%q, %v): got %q; want %q", tc.In, tc.Ranges, got, tc.Want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package pretty
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Unit is a denomination of credit, measured in decimal places of wei.
+type Unit struct {
+	Name     string
+	Decimals int
+}
+
+var (
+	Wei   = Unit{Name: "wei", Decimals: 0}
+	Gwei  = Unit{Name: "gwei", Decimals: 9}
+	Ether = Unit{Name: "eth", Decimals: 18}
+)
+
+// Units are the built-in units recognized by ParseUnit and ParseCredit.
+var Units = []Unit{Wei, Gwei, Ether}
+
+// ParseUnit returns the unit with the given name, such as "gwei" or "eth". A
+// custom unit can be described as "name:decimals", such as "credit:12".
+func ParseUnit(s string) (Unit, error) {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		decimals, err := strconv.Atoi(s[i+1:])
+		if err != nil || decimals < 0 {
+			return Unit{}, fmt.Errorf("invalid unit decimals: %q", s)
+		}
+		name := s[:i]
+		if !isUnitName(name) {
+			return Unit{}, fmt.Errorf("invalid unit name: %q", s)
+		}
+		return Unit{Name: name, Decimals: decimals}, nil
+	}
+	name := strings.ToLower(s)
+	if name == "ether" {
+		return Ether, nil
+	}
+	for _, unit := range Units {
+		if unit.Name == name {
+			return unit, nil
+		}
+	}
+	return Unit{}, fmt.Errorf("unknown unit: %q", s)
+}
+
+func isUnitName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCredit parses an amount with an optional unit suffix, such as
+// "0.5eth", "100 gwei", or "42", into wei. Amounts without a suffix are in
+// defaultUnit. Custom units are recognized as suffixes in addition to the
+// built-in Units. Fractions smaller than one wei are an error.
+func ParseCredit(s string, defaultUnit Unit, custom ...Unit) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	end := len(s)
+	for end > 0 && isUnitName(s[end-1:end]) {
+		end--
+	}
+	number, suffix := strings.TrimSpace(s[:end]), s[end:]
+
+	unit := defaultUnit
+	if suffix != "" {
+		var err error
+		if unit, err = findUnit(suffix, custom); err != nil {
+			return nil, err
+		}
+	}
+	if number == "" {
+		return nil, fmt.Errorf("missing credit amount: %q", s)
+	}
+
+	neg := strings.HasPrefix(number, "-")
+	number = strings.TrimPrefix(number, "-")
+	whole, frac := number, ""
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		whole, frac = number[:i], number[i+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return nil, fmt.Errorf("invalid credit amount: %q", s)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > unit.Decimals {
+		return nil, fmt.Errorf("credit amount is more precise than 1 wei: %q", s)
+	}
+
+	digits := whole + frac + strings.Repeat("0", unit.Decimals-len(frac))
+	amount, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid credit amount: %q", s)
+	}
+	if neg {
+		amount.Neg(amount)
+	}
+	return amount, nil
+}
+
+func findUnit(name string, custom []Unit) (Unit, error) {
+	for _, unit := range custom {
+		if strings.EqualFold(unit.Name, name) {
+			return unit, nil
+		}
+	}
+	return ParseUnit(name)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatCredit formats an amount of wei in the given unit, such as
+// "0.5eth", without trailing zeros. The result can be parsed by ParseCredit.
+// The zero Unit formats as wei.
+func FormatCredit(amount *big.Int, unit Unit) string {
+	if unit.Name == "" {
+		unit = Wei
+	}
+	if amount == nil {
+		amount = new(big.Int)
+	}
+	digits := new(big.Int).Abs(amount).String()
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	if unit.Decimals == 0 {
+		return sign + digits + unit.Name
+	}
+	if len(digits) <= unit.Decimals {
+		digits = strings.Repeat("0", unit.Decimals-len(digits)+1) + digits
+	}
+	i := len(digits) - unit.Decimals
+	whole, frac := digits[:i], strings.TrimRight(digits[i:], "0")
+	if frac == "" {
+		return sign + whole + unit.Name
+	}
+	return sign + whole + "." + frac + unit.Name
+}
@@ -0,0 +1,109 @@
+package pretty
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseCredit(t *testing.T) {
+	credit := Unit{Name: "credit", Decimals: 12}
+	tests := []struct {
+		In   string
+		Want string
+	}{
+		{"42", "42"},
+		{"42wei", "42"},
+		{"1gwei", "1000000000"},
+		{"0.5eth", "500000000000000000"},
+		{"0.5 ETH", "500000000000000000"},
+		{"1.25ether", "1250000000000000000"},
+		{".5gwei", "500000000"},
+		{"-2.5gwei", "-2500000000"},
+		{"1.000gwei", "1000000000"},
+		{"3credit", "3000000000000"},
+		{"0.000000000001credit", "1"},
+	}
+	for _, tc := range tests {
+		got, err := ParseCredit(tc.In, Wei, credit)
+		if err != nil {
+			t.Errorf("ParseCredit(%q): %s", tc.In, err)
+			continue
+		}
+		if got.String() != tc.Want {
+			t.Errorf("ParseCredit(%q): got %s; want %s", tc.In, got, tc.Want)
+		}
+	}
+
+	for _, in := range []string{"", "eth", "1.5", "0.1wei", "1.2.3gwei", "1foo", "1e18", "0.0000000000000000001eth"} {
+		if got, err := ParseCredit(in, Wei); err == nil {
+			t.Errorf("ParseCredit(%q): expected error, got %s", in, got)
+		}
+	}
+
+	if got, err := ParseCredit("1.5", Gwei); err != nil || got.String() != "1500000000" {
+		t.Errorf("ParseCredit with default unit: got %s, %v", got, err)
+	}
+}
+
+func TestFormatCredit(t *testing.T) {
+	tests := []struct {
+		In   int64
+		Unit Unit
+		Want string
+	}{
+		{42, Unit{}, "42wei"},
+		{42, Wei, "42wei"},
+		{0, Ether, "0eth"},
+		{1000000000, Gwei, "1gwei"},
+		{1500000000, Gwei, "1.5gwei"},
+		{1, Gwei, "0.000000001gwei"},
+		{-2500000000, Gwei, "-2.5gwei"},
+		{500000000000000000, Ether, "0.5eth"},
+		{3000000000000, Unit{Name: "credit", Decimals: 12}, "3credit"},
+	}
+	for _, tc := range tests {
+		if got := FormatCredit(big.NewInt(tc.In), tc.Unit); got != tc.Want {
+			t.Errorf("FormatCredit(%d, %s): got %q; want %q", tc.In, tc.Unit.Name, got, tc.Want)
+		}
+	}
+}
+
+func TestCreditRoundTrip(t *testing.T) {
+	credit := Unit{Name: "credit", Decimals: 3}
+	amounts := []string{"0", "1", "999", "1000", "123456789", "-1500", "1000000000000000000", "123456789012345678901234567890"}
+	for _, unit := range append(Units, credit) {
+		for _, s := range amounts {
+			amount, _ := new(big.Int).SetString(s, 10)
+			formatted := FormatCredit(amount, unit)
+			parsed, err := ParseCredit(formatted, Wei, credit)
+			if err != nil {
+				t.Errorf("ParseCredit(%q): %s", formatted, err)
+				continue
+			}
+			if parsed.Cmp(amount) != 0 {
+				t.Errorf("round trip of %s in %s: got %s via %q", amount, unit.Name, parsed, formatted)
+			}
+		}
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	for in, want := range map[string]Unit{
+		"wei":       Wei,
+		"GWEI":      Gwei,
+		"ether":     Ether,
+		"credit:12": {Name: "credit", Decimals: 12},
+	} {
+		got, err := ParseUnit(in)
+		if err != nil {
+			t.Errorf("ParseUnit(%q): %s", in, err)
+		} else if got != want {
+			t.Errorf("ParseUnit(%q): got %v; want %v", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "foo", "credit:", "credit:-1", ":3", "cr3dit:3"} {
+		if _, err := ParseUnit(in); err == nil {
+			t.Errorf("ParseUnit(%q): expected error", in)
+		}
+	}
+}
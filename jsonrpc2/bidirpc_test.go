@@ -41,12 +41,12 @@ func TestBidirectionalService(t *testing.T) {
 	}
 
 	if !reflect.DeepEqual(reqClient, &reqServer) {
-		t.Errorf("server/client request mismatch: %q != %q", reqServer, reqClient)
+		t.Errorf("server/client request mismatch: %v != %v", reqServer, reqClient)
 	}
 
 	resp := barServer.Handle(context.Background(), &reqServer)
 	if string(resp.ID) != string(reqClient.ID) {
-		t.Errorf("server/client request ID mismatch: %s", resp)
+		t.Errorf("server/client request ID mismatch: %v", resp)
 	}
 
 	go json.NewEncoder(barPipe).Encode(resp)
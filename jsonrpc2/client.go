@@ -8,6 +8,9 @@ import (
 type Requester interface {
 	// Request takes call inputs and creates a valid request Message.
 	Request(method string, params ...interface{}) (*Message, error)
+	// Notify takes call inputs and creates a notification Message, which is
+	// a request without an ID that does not get a response.
+	Notify(method string, params ...interface{}) (*Message, error)
 }
 
 var _ Requester = &Client{}
@@ -22,6 +25,17 @@ func (c *Client) NextID() int {
 }
 
 func (c *Client) Request(method string, params ...interface{}) (*Message, error) {
+	msg, err := c.Notify(method, params...)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID, err = json.Marshal(c.NextID()); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *Client) Notify(method string, params ...interface{}) (*Message, error) {
 	msg := &Message{
 		Request: &Request{
 			Method: method,
@@ -29,9 +43,6 @@ func (c *Client) Request(method string, params ...interface{}) (*Message, error)
 		Version: Version,
 	}
 	var err error
-	if msg.ID, err = json.Marshal(c.NextID()); err != nil {
-		return nil, err
-	}
 	if msg.Request.Params, err = json.Marshal(params); err != nil {
 		return nil, err
 	}
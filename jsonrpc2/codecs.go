@@ -2,11 +2,18 @@ package jsonrpc2
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
-
-	"github.com/vipnode/vipnode/internal/pretty"
+	"strings"
 )
 
+// ErrCodecReset is returned by codecs that re-establish their connection on
+// their own. ReadMessage returns it once the connection is lost, since
+// responses to requests that were already sent won't arrive, and
+// WriteMessage returns it until the connection is back. The codec stays
+// usable either way.
+var ErrCodecReset = errors.New("jsonrpc2: codec connection was reset")
+
 type rwc struct {
 	io.Reader
 	io.Writer
@@ -54,7 +61,9 @@ func (codec *jsonCodec) Close() error {
 }
 
 // DebugCodec logs each incoming and outgoing message with a given label prefix
-// (use something like the IP address or user ID).
+// (use something like the IP address or user ID). Signatures of signed
+// requests are redacted. Wrapping a codec is optional, so there is no logging
+// overhead unless it's used.
 func DebugCodec(labelPrefix string, codec Codec) *debugCodec {
 	return &debugCodec{
 		Codec: codec,
@@ -69,24 +78,56 @@ type debugCodec struct {
 
 func (codec *debugCodec) ReadMessage() (*Message, error) {
 	msg, err := codec.Codec.ReadMessage()
-	dump, _ := json.Marshal(msg)
-	out := pretty.Abbrev(string(dump), 100)
+	out := dumpMessage(msg)
 	if err != nil {
-		logger.Printf("%s <- Error(%q) - %s\n", codec.Label, err.Error(), out)
+		logf("%s <- Error(%q) - %s\n", codec.Label, err.Error(), out)
 	} else {
-		logger.Printf("%s <- %s\n", codec.Label, out)
+		logf("%s <- %s\n", codec.Label, out)
 	}
 	return msg, err
 }
 
 func (codec *debugCodec) WriteMessage(msg *Message) error {
 	err := codec.Codec.WriteMessage(msg)
-	dump, _ := json.Marshal(msg)
-	out := pretty.Abbrev(string(dump), 100)
+	out := dumpMessage(msg)
 	if err != nil {
-		logger.Printf("%s  -> Error(%q) - %s\n", codec.Label, err.Error(), out)
+		logf("%s  -> Error(%q) - %s\n", codec.Label, err.Error(), out)
 	} else {
-		logger.Printf("%s  -> %s\n", codec.Label, out)
+		logf("%s  -> %s\n", codec.Label, out)
 	}
 	return err
 }
+
+const redacted = `"REDACTED"`
+
+// dumpMessage encodes the message for logging, with the signature of signed
+// requests redacted. Signed requests have positional params that start with
+// (signature, pubkey, nonce, ...).
+func dumpMessage(msg *Message) string {
+	if msg != nil && msg.Batch != nil {
+		dumps := make([]string, 0, len(msg.Batch))
+		for _, m := range msg.Batch {
+			dumps = append(dumps, dumpMessage(m))
+		}
+		return "[" + strings.Join(dumps, ",") + "]"
+	}
+	if msg == nil || msg.Request == nil {
+		dump, _ := json.Marshal(msg)
+		return string(dump)
+	}
+
+	var params []json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err == nil && len(params) >= 3 {
+		var sig, pubkey string
+		var nonce int64
+		if json.Unmarshal(params[0], &sig) == nil && json.Unmarshal(params[1], &pubkey) == nil && json.Unmarshal(params[2], &nonce) == nil {
+			params[0] = json.RawMessage(redacted)
+			r := *msg
+			r.Request = &Request{Method: msg.Method}
+			r.Params, _ = json.Marshal(params)
+			msg = &r
+		}
+	}
+	dump, _ := json.Marshal(msg)
+	return string(dump)
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -35,6 +37,74 @@ func TestCodec(t *testing.T) {
 	}
 
 	if !reflect.DeepEqual(msg, msg2) {
-		t.Errorf("got: %q; want %q", msg2, msg)
+		t.Errorf("got: %v; want %v", msg2, msg)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that is safe to write to from the Serve
+// goroutines of other tests while it's read.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDebugCodec(t *testing.T) {
+	var logBuf lockedBuffer
+	SetLogger(&logBuf)
+	defer SetLogger(ioutil.Discard)
+
+	newCodec := func() Codec {
+		var buf bytes.Buffer
+		return IOCodec(rwc{&buf, &buf, ioutil.NopCloser(&buf)})
+	}
+	signed := &Message{
+		Request: &Request{
+			Method: "vipnode_host",
+			Params: []byte(`["c2lnbmF0dXJl","abcd",42,{"kind":"geth"}]`),
+		},
+		ID:      []byte("1"),
+		Version: "2.0",
+	}
+	roundtrip := func(codec Codec) {
+		if err := codec.WriteMessage(signed); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := codec.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Disabled
+	roundtrip(newCodec())
+	if logBuf.String() != "" {
+		t.Errorf("unexpected log output: %s", logBuf.String())
+	}
+
+	// Enabled
+	roundtrip(DebugCodec("test", newCodec()))
+	out := logBuf.String()
+	if got, want := strings.Count(out, `"method":"vipnode_host"`), 2; got != want {
+		t.Errorf("logged %d messages; want %d: %s", got, want, out)
+	}
+	if !strings.Contains(out, `"params":["REDACTED","abcd",42,{"kind":"geth"}]`) {
+		t.Errorf("missing redacted params: %s", out)
+	}
+	if strings.Contains(out, "c2lnbmF0dXJl") {
+		t.Errorf("signature was not redacted: %s", out)
+	}
+	if string(signed.Params) != `["c2lnbmF0dXJl","abcd",42,{"kind":"geth"}]` {
+		t.Errorf("original message was modified: %s", signed.Params)
 	}
 }
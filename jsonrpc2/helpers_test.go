@@ -24,6 +24,40 @@ func (f *FruitService) Durian() error {
 	return errors.New("durian failure")
 }
 
+// hostsError is an error with structured data.
+type hostsError struct {
+	Hosts []string
+}
+
+func (err hostsError) Error() string {
+	return fmt.Sprintf("%d hosts failed", len(err.Hosts))
+}
+
+func (err hostsError) ErrorData() interface{} {
+	return err
+}
+
+// quotaError is an error with its own error code.
+type quotaError struct{}
+
+func (err quotaError) Error() string {
+	return "quota exceeded"
+}
+
+func (err quotaError) ErrorCode() int {
+	return -32001
+}
+
+type HostsService struct{}
+
+func (h *HostsService) Connect() error {
+	return hostsError{Hosts: []string{"foo", "bar"}}
+}
+
+func (h *HostsService) Reserve() error {
+	return quotaError{}
+}
+
 type Pinger struct {
 	PongService Service
 }
@@ -63,3 +97,12 @@ func (f *Fib) Fibonacci(ctx context.Context, a int, b int, steps int) (int, erro
 	}
 	return b, nil
 }
+
+type Signaler struct {
+	Signals chan string
+}
+
+func (s *Signaler) Signal(msg string) error {
+	s.Signals <- msg
+	return nil
+}
@@ -61,6 +61,11 @@ func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("content-type", httpContentType)
 	resp := h.Server.Handle(r.Context(), msg)
+	if resp == nil {
+		// Notifications don't get a response
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	err = codec.WriteMessage(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -71,3 +71,48 @@ func TestHTTPService(t *testing.T) {
 	default:
 	}
 }
+
+func TestHTTPServerBatch(t *testing.T) {
+	server := HTTPServer{}
+	if err := server.Register("", &FruitService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+	endpoint := fmt.Sprintf("http://%s", serverConn.Addr().String())
+	go http.Serve(serverConn, &server)
+
+	body := `[{"jsonrpc":"2.0","id":1,"method":"apple"},{"jsonrpc":"2.0","method":"banana"},{"jsonrpc":"2.0","id":2,"method":"cherry"}]`
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var respMsgs []Message
+	if err := json.NewDecoder(resp.Body).Decode(&respMsgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(respMsgs) != 2 {
+		t.Fatalf("wrong number of responses: %d", len(respMsgs))
+	}
+	for i, want := range []string{`"Apple"`, `"Cherry"`} {
+		if got := string(respMsgs[i].Result); got != want {
+			t.Errorf("response %d: got %s; want %s", i, got, want)
+		}
+	}
+
+	// Only notifications, so no content
+	body = `[{"jsonrpc":"2.0","method":"banana"}]`
+	resp, err = http.Post(endpoint, "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("wrong status for notification batch: %d", resp.StatusCode)
+	}
+}
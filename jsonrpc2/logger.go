@@ -1,20 +1,70 @@
 package jsonrpc2
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"log"
+	"sync/atomic"
 )
 
-var logger *log.Logger
+// logger holds the package *log.Logger. It's swapped atomically, since
+// SetLogger can be called while Remotes are serving.
+var logger atomic.Value
 
 // SetLogger overrides the logger output for this package.
 func SetLogger(w io.Writer) {
 	flags := log.Flags()
 	prefix := "[jsonrpc2] "
-	logger = log.New(w, prefix, flags)
+	logger.Store(log.New(w, prefix, flags))
 }
 
 func init() {
 	SetLogger(ioutil.Discard)
 }
+
+// logf writes to the package logger that is set with SetLogger.
+func logf(format string, v ...interface{}) {
+	logger.Load().(*log.Logger).Printf(format, v...)
+}
+
+// Logger receives the log messages of a Remote. The context carries the
+// fields of the connection and message that the log message is about, see
+// LogFieldsFromContext. Loggers must be goroutine-safe.
+type Logger interface {
+	Printf(ctx context.Context, format string, v ...interface{})
+}
+
+// LogFields are the context fields of a Remote's log message.
+type LogFields struct {
+	// RemoteAddr is the address of the other end of the connection.
+	RemoteAddr string
+	// ID is the ID of the message, if it has one.
+	ID string
+	// Method is the method of the message, if it's a request.
+	Method string
+}
+
+type logContext string
+
+var ctxLogFields logContext = "logfields"
+
+// withLogFields returns a context that carries the log fields.
+func withLogFields(ctx context.Context, fields LogFields) context.Context {
+	return context.WithValue(ctx, ctxLogFields, fields)
+}
+
+// LogFieldsFromContext returns the log fields from the context passed to a
+// Logger.
+func LogFieldsFromContext(ctx context.Context) LogFields {
+	fields, _ := ctx.Value(ctxLogFields).(LogFields)
+	return fields
+}
+
+// packageLogger is the default Logger, it writes to the package logger that
+// is set with SetLogger.
+type packageLogger struct{}
+
+func (packageLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	logf(format, v...)
+}
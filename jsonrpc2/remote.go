@@ -9,8 +9,6 @@ import (
 	"time"
 )
 
-// TODO: Handle batch?
-
 // ServePipe sets up symmetric server/clients over a net.Pipe() and starts
 // both in goroutines. Useful for testing. Services still need to be registered.
 func ServePipe() (*Remote, *Remote) {
@@ -75,10 +73,33 @@ type Remote struct {
 	// PendingDiscard is the number of oldest messages that get discarded when PendingLimit is reached.
 	PendingDiscard int
 
+	// Logger receives the Remote's log messages, such as dropped responses.
+	// If nil, then messages are written to the package logger that is set
+	// with SetLogger.
+	Logger Logger
+
 	mu      sync.Mutex
 	pending map[string]pendingMsg
 }
 
+// log writes a log message about msg through the Remote's Logger. The
+// message's fields are passed in the context.
+func (r *Remote) log(msg *Message, format string, v ...interface{}) {
+	fields := LogFields{
+		RemoteAddr: r.Codec.RemoteAddr(),
+		ID:         string(msg.ID),
+	}
+	if msg.Request != nil {
+		fields.Method = msg.Method
+	}
+	ctx := withLogFields(context.Background(), fields)
+	if r.Logger == nil {
+		packageLogger{}.Printf(ctx, format, v...)
+		return
+	}
+	r.Logger.Printf(ctx, format, v...)
+}
+
 // clearPending removes num oldest entries, must hold the r.mu lock.
 func (r *Remote) cleanPending(num int) {
 	// Clear oldest entries
@@ -111,42 +132,93 @@ func (r *Remote) getPendingChan(key string) chan Message {
 func (r *Remote) handleRequest(msg *Message) error {
 	ctx := context.WithValue(context.Background(), ctxService, r)
 	resp := r.Server.Handle(ctx, msg)
+	if resp == nil {
+		// Notifications don't get a response
+		return nil
+	}
 	return r.Codec.WriteMessage(resp)
 }
 
 func (r *Remote) Serve() error {
 	for {
 		msg, err := r.Codec.ReadMessage()
+		if err == ErrCodecReset {
+			r.resetPending()
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		if msg.Request != nil {
+		if msg.Request != nil || msg.Batch != nil {
 			// FIXME: Anything we can do with error handling here?
 			go r.handleRequest(msg)
 		} else if len(msg.ID) > 0 {
-			r.getPendingChan(string(msg.ID)) <- *msg
+			// Response to a pending call. Notifications never get one, so
+			// there is nothing else to match.
+			r.deliverPending(msg)
 		} else {
-			logger.Printf("Remote.Serve(): Dropping invalid message: %s", msg)
+			r.log(msg, "Remote.Serve(): Dropping invalid message: %v", msg)
 		}
 	}
 }
 
-// receive blocks until the given message ID is received. Use Call for an
-// end-to-end solution.
-func (r *Remote) receive(ctx context.Context, ID json.RawMessage) (*Message, error) {
-	key := string(ID)
+// deliverPending passes a response to the call that is waiting for it.
+// Responses that no call is waiting for, such as late responses to calls
+// that were cancelled, are dropped.
+func (r *Remote) deliverPending(msg *Message) {
+	key := string(msg.ID)
+	r.mu.Lock()
+	pending, ok := r.pending[key]
+	r.mu.Unlock()
+	if !ok {
+		r.log(msg, "Remote.Serve(): Dropping response without a pending call: %s", key)
+		return
+	}
 	select {
-	case msg := <-r.getPendingChan(key):
+	case pending.msgChan <- *msg:
+	default:
+		r.log(msg, "Remote.Serve(): Dropping duplicate response: %s", key)
+	}
+}
+
+// resetPending fails all of the pending calls with ErrCodecReset, since their
+// responses were lost with the connection. Like deliverPending, it must only
+// be called from Serve.
+func (r *Remote) resetPending() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, pending := range r.pending {
+		close(pending.msgChan)
+		delete(r.pending, key)
+	}
+}
+
+// receive blocks until the response for the given message ID is received on
+// msgChan, or until ctx is done. Either way, the pending call is removed.
+// msgChan must come from getPendingChan, called before the request is sent.
+// Use Call for an end-to-end solution.
+func (r *Remote) receive(ctx context.Context, ID json.RawMessage, msgChan <-chan Message) (*Message, error) {
+	key := string(ID)
+	defer func() {
 		r.mu.Lock()
 		delete(r.pending, key)
 		r.mu.Unlock()
+	}()
+	select {
+	case msg, ok := <-msgChan:
+		if !ok {
+			return nil, ErrCodecReset
+		}
 		return &msg, nil
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 }
 
-// Call handles sending an RPC and receiving the corresponding response synchronously.
+// Call handles sending an RPC and receiving the corresponding response
+// synchronously. If ctx is done before the response arrives, then Call
+// returns ctx.Err() and the response is dropped when it arrives. If the
+// codec's connection is reset before then, Call returns ErrCodecReset.
 func (r *Remote) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
 	if r.Client == nil {
 		r.Client = &Client{}
@@ -155,12 +227,31 @@ func (r *Remote) Call(ctx context.Context, result interface{}, method string, pa
 	if err != nil {
 		return err
 	}
+	// Register the pending call before sending, so that the response can't
+	// arrive before it's expected.
+	msgChan := r.getPendingChan(string(req.ID))
 	if err = r.Codec.WriteMessage(req); err != nil {
+		r.mu.Lock()
+		delete(r.pending, string(req.ID))
+		r.mu.Unlock()
 		return err
 	}
-	resp, err := r.receive(ctx, req.ID)
+	resp, err := r.receive(ctx, req.ID, msgChan)
 	if err != nil {
 		return err
 	}
 	return resp.UnmarshalResult(result)
 }
+
+// Notify sends a notification, which is a request that does not get a
+// response, so it returns once the message is written.
+func (r *Remote) Notify(ctx context.Context, method string, params ...interface{}) error {
+	if r.Client == nil {
+		r.Client = &Client{}
+	}
+	req, err := r.Client.Notify(method, params...)
+	if err != nil {
+		return err
+	}
+	return r.Codec.WriteMessage(req)
+}
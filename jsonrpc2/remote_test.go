@@ -3,6 +3,8 @@ package jsonrpc2
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net"
 	"reflect"
 	"sort"
@@ -35,7 +37,7 @@ func TestRemoteManual(t *testing.T) {
 		t.Error(err)
 	}
 	if !reflect.DeepEqual(req, req2) {
-		t.Errorf("message does not match:\n  got: %s\n  want: %s", req2, req)
+		t.Errorf("message does not match:\n  got: %v\n  want: %v", req2, req)
 	}
 	if err := g.Wait(); err != nil {
 		t.Error(err)
@@ -51,6 +53,55 @@ func TestRemoteManual(t *testing.T) {
 	}
 }
 
+func TestRemoteErrorData(t *testing.T) {
+	server, client := ServePipe()
+	server.Server.Register("", &HostsService{})
+	server.Server.Register("", &FruitService{})
+
+	err := client.Call(context.Background(), nil, "connect")
+	errResp, ok := err.(*ErrResponse)
+	if !ok {
+		t.Fatalf("expected *ErrResponse, got: %T %v", err, err)
+	}
+	if got, want := errResp.Error(), "2 hosts failed"; got != want {
+		t.Errorf("got message %q; want %q", got, want)
+	}
+	var data hostsError
+	if err := errResp.UnmarshalData(&data); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"foo", "bar"}; !reflect.DeepEqual(data.Hosts, want) {
+		t.Errorf("got data hosts %v; want %v", data.Hosts, want)
+	}
+
+	// Errors without data don't have any.
+	err = client.Call(context.Background(), nil, "durian")
+	if errResp, ok := err.(*ErrResponse); !ok {
+		t.Fatalf("expected *ErrResponse, got: %T %v", err, err)
+	} else if len(errResp.Data) != 0 {
+		t.Errorf("unexpected error data: %s", errResp.Data)
+	}
+}
+
+func TestRemoteErrorCode(t *testing.T) {
+	server, client := ServePipe()
+	server.Server.Register("", &HostsService{})
+
+	err := client.Call(context.Background(), nil, "reserve")
+	if !IsErrorCode(err, -32001) {
+		t.Errorf("expected error code -32001, got: %T %v", err, err)
+	}
+	if got, want := err.Error(), "quota exceeded"; got != want {
+		t.Errorf("got message %q; want %q", got, want)
+	}
+
+	// Errors without a code are internal errors.
+	err = client.Call(context.Background(), nil, "connect")
+	if !IsErrorCode(err, ErrCodeInternal) {
+		t.Errorf("expected ErrCodeInternal, got: %T %v", err, err)
+	}
+}
+
 func TestRemoteBidirectional(t *testing.T) {
 	pingerClient, pongerClient := ServePipe()
 
@@ -155,3 +206,223 @@ func TestRemoteServeInvalid(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// chanLogger is a Logger that sends the fields of each log message on a
+// channel.
+type chanLogger chan LogFields
+
+func (l chanLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	l <- LogFieldsFromContext(ctx)
+}
+
+func TestRemoteLogFields(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	logs := make(chanLogger, 1)
+	remote := Remote{
+		Codec:  &jsonCodec{rwc: c1, remoteAddr: "192.0.2.1:8080"},
+		Server: &Server{},
+		Logger: logs,
+	}
+	go remote.Serve()
+
+	// Response without a pending call is dropped and logged.
+	resp := &Message{
+		Response: &Response{Result: json.RawMessage(`"pong"`)},
+		ID:       json.RawMessage(`42`),
+		Version:  Version,
+	}
+	if err := IOCodec(c2).WriteMessage(resp); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-logs:
+		if want := (LogFields{RemoteAddr: "192.0.2.1:8080", ID: "42"}); got != want {
+			t.Errorf("got log fields: %+v; want: %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for log message")
+	}
+}
+
+func TestRemoteNotify(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	signaler := &Signaler{Signals: make(chan string, 1)}
+	server := Remote{Codec: IOCodec(c2), Server: &Server{}, Client: &Client{}}
+	server.Server.Register("", signaler)
+	server.Server.Register("", &Ponger{})
+	go server.Serve()
+
+	client := Remote{Codec: IOCodec(c1), Client: &Client{}}
+	go func() {
+		if err := client.Notify(context.Background(), "signal", "going down"); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case got := <-signaler.Signals:
+		if got != "going down" {
+			t.Errorf("wrong signal: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not handled")
+	}
+
+	// The next frame must be the response to a regular call, since the
+	// notification does not get one.
+	req, err := client.Client.Request("pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.Codec.WriteMessage(req)
+	resp, err := client.Codec.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.ID) != string(req.ID) {
+		t.Errorf("unexpected frame: %s", dumpMessage(resp))
+	}
+}
+
+func TestServerNotification(t *testing.T) {
+	signaler := &Signaler{Signals: make(chan string, 1)}
+	s := Server{}
+	s.Register("", signaler)
+
+	req, err := (&Client{}).Notify("signal", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ID) != 0 {
+		t.Errorf("notification has an ID: %s", req.ID)
+	}
+	if resp := s.Handle(context.Background(), req); resp != nil {
+		t.Errorf("unexpected response to notification: %s", dumpMessage(resp))
+	}
+	if got := <-signaler.Signals; got != "hello" {
+		t.Errorf("wrong signal: %q", got)
+	}
+}
+
+func TestRemoteCallTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	// Server reads requests but never replies
+	server := IOCodec(c2)
+	requests := make(chan *Message, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			msg, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			requests <- msg
+		}
+	}()
+
+	client := &Remote{Codec: IOCodec(c1), Client: &Client{}}
+	serveDone := make(chan struct{})
+	go func() {
+		defer close(serveDone)
+		client.Serve()
+	}()
+
+	// Closing the pipe stops both goroutines, wait for them so that they
+	// don't outlive the test.
+	defer func() {
+		client.Codec.Close()
+		server.Close()
+		<-serveDone
+		<-readDone
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var result string
+	if err := client.Call(ctx, &result, "hang"); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got: %v", err)
+	}
+
+	client.mu.Lock()
+	numPending := len(client.pending)
+	client.mu.Unlock()
+	if numPending != 0 {
+		t.Errorf("pending call was not removed: %d pending", numPending)
+	}
+
+	// A late response is dropped rather than pinned to the pending map
+	req := <-requests
+	if err := server.WriteMessage(&Message{ID: req.ID, Version: Version, Response: &Response{Result: json.RawMessage(`"late"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	client.mu.Lock()
+	numPending = len(client.pending)
+	client.mu.Unlock()
+	if numPending != 0 {
+		t.Errorf("late response was kept: %d pending", numPending)
+	}
+}
+
+// resetCodec is a Codec whose ReadMessage returns whatever is sent on reads,
+// so that tests can inject ErrCodecReset.
+type resetCodec struct {
+	Codec
+	reads chan error
+}
+
+func (c *resetCodec) ReadMessage() (*Message, error) {
+	return nil, <-c.reads
+}
+
+func TestRemoteCallReset(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	// Drain requests, which never get a reply
+	go io.Copy(ioutil.Discard, c2)
+
+	codec := &resetCodec{Codec: IOCodec(c1), reads: make(chan error)}
+	client := &Remote{Codec: codec, Client: &Client{}}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- client.Serve()
+	}()
+
+	callErr := make(chan error, 1)
+	go func() {
+		var result string
+		callErr <- client.Call(context.Background(), &result, "hang")
+	}()
+	// Wait for the call to be pending before resetting
+	for {
+		client.mu.Lock()
+		numPending := len(client.pending)
+		client.mu.Unlock()
+		if numPending > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	codec.reads <- ErrCodecReset
+
+	select {
+	case err := <-callErr:
+		if err != ErrCodecReset {
+			t.Errorf("expected reset error, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call did not fail on reset")
+	}
+
+	// Serve keeps going after a reset, until a real error
+	codec.reads <- io.EOF
+	if err := <-serveErr; err != io.EOF {
+		t.Errorf("expected serve to stop with EOF, got: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"unicode"
 )
@@ -24,12 +25,36 @@ type Handler interface {
 
 var nullResult = json.RawMessage([]byte("null"))
 
+// ListMethodsMethod is the built-in RPC method that returns the names of the
+// server's registered methods, unless a registered method has the same name.
+const ListMethodsMethod = "rpc_listMethods"
+
 var _ Handler = &Server{}
 
+// HandlerFunc executes a single request and returns its result, which is
+// encoded as JSON in the response. If the error is an *ErrResponse, then it's
+// returned as is, otherwise it's returned with the error's ErrorCode if it has
+// one, or ErrCodeInternal.
+type HandlerFunc func(ctx context.Context, req *Request) (result interface{}, err error)
+
+// Middleware wraps a HandlerFunc, such as to time or authorize requests. It
+// can short-circuit a request by returning an error without calling next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
 // Server contains the method registry.
 type Server struct {
-	mu       sync.Mutex
-	registry map[string]Method
+	mu         sync.Mutex
+	registry   map[string]Method
+	middleware []Middleware
+}
+
+// Use adds middleware that wraps the dispatch of each request, including
+// requests for methods that aren't registered. Middleware runs in the order
+// that it was added, so the first middleware sees the request first.
+func (s *Server) Use(mw Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mw)
 }
 
 // Register adds valid methods from the receiver to the registry with the given
@@ -63,6 +88,21 @@ func (s *Server) Register(prefix string, receiver interface{}) error {
 	return nil
 }
 
+// Methods returns the sorted names of the registered methods, with the
+// prefixes they were registered with. Built-in methods like
+// ListMethodsMethod are not included.
+func (s *Server) Methods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.registry))
+	for name := range s.registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // RegisterMethod registers a single methodName from receiver with the given rpcName.
 func (s *Server) RegisterMethod(rpcName string, receiver interface{}, methodName string) error {
 	s.mu.Lock()
@@ -82,7 +122,23 @@ func (s *Server) RegisterMethod(rpcName string, receiver interface{}, methodName
 }
 
 // Handle executes a request message against the server registry.
+// Notifications (requests without an ID) are executed, but return a nil
+// response. A batch request is handled by executing each of its requests
+// concurrently, and returns a batch of their responses in the same order, or
+// nil if there are no responses.
 func (s *Server) Handle(ctx context.Context, req *Message) *Message {
+	if req.Batch != nil {
+		return s.handleBatch(ctx, req.Batch)
+	}
+	r := s.handle(ctx, req)
+	if req.Request != nil && len(req.ID) == 0 {
+		// Notification
+		return nil
+	}
+	return r
+}
+
+func (s *Server) handle(ctx context.Context, req *Message) *Message {
 	r := &Message{
 		Response: &Response{
 			Result: nullResult,
@@ -99,40 +155,117 @@ func (s *Server) Handle(ctx context.Context, req *Message) *Message {
 	}
 
 	s.mu.Lock()
-	m, ok := s.registry[req.Request.Method]
+	var h HandlerFunc = s.dispatch
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
 	s.mu.Unlock()
 
+	res, err := h(ctx, req.Request)
+	if err != nil {
+		errResp, ok := err.(*ErrResponse)
+		if !ok {
+			errResp = &ErrResponse{
+				Code:    ErrCodeInternal,
+				Message: err.Error(),
+			}
+			if codeErr, ok := err.(interface{ ErrorCode() int }); ok {
+				errResp.Code = codeErr.ErrorCode()
+			}
+			if dataErr, ok := err.(DataError); ok {
+				if data, err := json.Marshal(dataErr.ErrorData()); err != nil {
+					logf("failed to encode error data of %q: %s", req.Method, err)
+				} else {
+					errResp.Data = data
+				}
+			}
+		}
+		r.Error = errResp
+		return r
+	}
+	if res == nil {
+		return r
+	}
+	if r.Result, err = json.Marshal(res); err != nil {
+		r.Error = &ErrResponse{
+			Code:    ErrCodeServer,
+			Message: fmt.Sprintf("failed to encode response: %s", err),
+		}
+	}
+	return r
+}
+
+// dispatch calls the registered method of the request.
+func (s *Server) dispatch(ctx context.Context, req *Request) (interface{}, error) {
+	s.mu.Lock()
+	m, ok := s.registry[req.Method]
+	s.mu.Unlock()
+
+	if !ok && req.Method == ListMethodsMethod {
+		return s.Methods(), nil
+	}
 	if !ok {
-		r.Response.Error = &ErrResponse{
+		return nil, &ErrResponse{
 			Code:    ErrCodeMethodNotFound,
 			Message: fmt.Sprintf("method not found: %s", req.Method),
 		}
-		return r
 	}
 	args, err := parsePositionalArguments(req.Params, m.ArgTypes)
 	if err != nil {
-		r.Error = &ErrResponse{
+		return nil, &ErrResponse{
 			Code:    ErrCodeInvalidParams,
 			Message: fmt.Sprintf("invalid params: %s %s", req.Method, req.Params),
 		}
-		return r
 	}
 	res, err := m.Call(ctx, args)
-	if err != nil {
-		r.Error = &ErrResponse{
+	if errResp, ok := err.(*ErrResponse); ok {
+		// Errors from the method are internal, even if they came from
+		// another RPC call, so that its code isn't mistaken for our own.
+		err = &ErrResponse{
 			Code:    ErrCodeInternal,
-			Message: err.Error(),
+			Message: errResp.Error(),
 		}
-		return r
 	}
-	if res == nil {
-		return r
+	return res, err
+}
+
+func (s *Server) handleBatch(ctx context.Context, batch []*Message) *Message {
+	if len(batch) == 0 {
+		return &Message{
+			Response: &Response{
+				Result: nullResult,
+				Error: &ErrResponse{
+					Code:    ErrCodeInvalidRequest,
+					Message: "server received empty batch request",
+				},
+			},
+			Version: Version,
+		}
 	}
-	if r.Result, err = json.Marshal(res); err != nil {
-		r.Error = &ErrResponse{
-			Code:    ErrCodeServer,
-			Message: fmt.Sprintf("failed to encode response: %s", err),
+
+	responses := make([]*Message, len(batch))
+	var wg sync.WaitGroup
+	wg.Add(len(batch))
+	for i, req := range batch {
+		if req.Batch != nil {
+			// Batches can't be nested
+			req = &Message{}
 		}
+		go func(i int, req *Message) {
+			defer wg.Done()
+			responses[i] = s.Handle(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+
+	r := &Message{Batch: []*Message{}}
+	for _, resp := range responses {
+		if resp != nil {
+			r.Batch = append(r.Batch, resp)
+		}
+	}
+	if len(r.Batch) == 0 {
+		return nil
 	}
 	return r
 }
@@ -3,6 +3,8 @@ package jsonrpc2
 import (
 	"context"
 	"encoding/json"
+	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -21,7 +23,7 @@ func TestServer(t *testing.T) {
 		},
 	})
 	if resp.Error != nil {
-		t.Errorf("unexpected error: %q", resp)
+		t.Errorf("unexpected error: %v", resp)
 	}
 
 	if string(resp.Result) != `"Apple"` {
@@ -36,10 +38,160 @@ func TestServer(t *testing.T) {
 		},
 	})
 	if resp.Error != nil {
-		t.Errorf("unexpected error: %q", resp)
+		t.Errorf("unexpected error: %v", resp)
 	}
 
 	if string(resp.Response.Result) != "null" {
 		t.Errorf("unexpected result: %q", resp.Result)
 	}
 }
+
+func TestServerBatch(t *testing.T) {
+	s := Server{}
+	if err := s.Register("foo_", &FruitService{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var req Message
+	batch := `[
+		{"jsonrpc": "2.0", "id": 1, "method": "foo_apple"},
+		{"jsonrpc": "2.0", "method": "foo_banana"},
+		{"jsonrpc": "2.0", "id": "two", "method": "foo_durian"},
+		{"jsonrpc": "2.0", "id": 3, "method": "foo_cherry"},
+		42,
+		{"jsonrpc": "2.0", "id": 4, "method": "foo_unknown"}
+	]`
+	if err := json.Unmarshal([]byte(batch), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Batch) != 6 {
+		t.Fatalf("wrong batch size: %d", len(req.Batch))
+	}
+
+	resp := s.Handle(context.Background(), &req)
+	if resp == nil {
+		t.Fatal("missing batch response")
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[` +
+		`{"result":"Apple","id":1,"jsonrpc":"2.0"},` +
+		`{"result":null,"error":{"code":-32603,"message":"durian failure"},"id":"two","jsonrpc":"2.0"},` +
+		`{"result":"Cherry","id":3,"jsonrpc":"2.0"},` +
+		`{"result":null,"error":{"code":-32600,"message":"server received misformed request"},"jsonrpc":"2.0"},` +
+		`{"result":null,"error":{"code":-32601,"message":"method not found: foo_unknown"},"id":4,"jsonrpc":"2.0"}` +
+		`]`
+	if string(out) != want {
+		t.Errorf("wrong batch response:\n got: %s\nwant: %s", out, want)
+	}
+
+	// Batch of notifications has no response
+	req = Message{}
+	if err := json.Unmarshal([]byte(`[{"jsonrpc": "2.0", "method": "foo_banana"}]`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if resp := s.Handle(context.Background(), &req); resp != nil {
+		t.Errorf("unexpected response to notifications: %v", resp)
+	}
+
+	// Empty batch is invalid
+	req = Message{}
+	if err := json.Unmarshal([]byte(`[]`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if resp := s.Handle(context.Background(), &req); resp == nil || resp.Error == nil || resp.Error.Code != ErrCodeInvalidRequest {
+		t.Errorf("expected invalid request error for empty batch, got: %v", resp)
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	var order []string
+	counts := map[string]int{}
+	var mu sync.Mutex
+	counter := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, req *Request) (interface{}, error) {
+				mu.Lock()
+				order = append(order, name)
+				counts[req.Method] += 1
+				mu.Unlock()
+				return next(ctx, req)
+			}
+		}
+	}
+	deny := func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			if req.Method == "cherry" {
+				return nil, &ErrResponse{Code: ErrCodeServer, Message: "denied"}
+			}
+			return next(ctx, req)
+		}
+	}
+
+	local := Local{}
+	if err := local.Register("", &FruitService{}); err != nil {
+		t.Fatal(err)
+	}
+	local.Use(counter("first"))
+	local.Use(counter("second"))
+	local.Use(deny)
+
+	var got string
+	if err := local.Call(context.Background(), &got, "apple"); err != nil {
+		t.Error(err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("middleware order: got %v; want %v", order, want)
+	}
+	err := local.Call(context.Background(), &got, "cherry")
+	if !IsErrorCode(err, ErrCodeServer) {
+		t.Errorf("expected short-circuit error, got: %v", err)
+	}
+	if err := local.Call(context.Background(), nil, "durian"); !IsErrorCode(err, ErrCodeInternal) {
+		t.Errorf("expected internal error, got: %v", err)
+	}
+
+	server, client := ServePipe()
+	server.Server.(*Server).Use(counter("remote"))
+	if err := server.Server.Register("", &FruitService{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(context.Background(), &got, "apple"); err != nil {
+		t.Error(err)
+	}
+	if err := client.Call(context.Background(), &got, "unknown"); !IsErrorCode(err, ErrCodeMethodNotFound) {
+		t.Errorf("expected method not found, got: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"apple": 3, "cherry": 2, "durian": 2, "unknown": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts: got %v; want %v", counts, want)
+	}
+}
+
+func TestServerListMethods(t *testing.T) {
+	local := Local{}
+	if err := local.Register("foo_", &FruitService{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Register("vipnode_", &Ponger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"foo_apple", "foo_banana", "foo_cherry", "foo_durian", "vipnode_pong"}
+	if got := local.Server.Methods(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got methods %v; want %v", got, want)
+	}
+
+	var got []string
+	if err := local.Call(context.Background(), &got, ListMethodsMethod); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s result %v; want %v", ListMethodsMethod, got, want)
+	}
+}
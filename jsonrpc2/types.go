@@ -1,6 +1,7 @@
 package jsonrpc2
 
 import (
+	"bytes"
 	"encoding/json"
 )
 
@@ -22,6 +23,45 @@ type Message struct {
 	*Response
 	ID      json.RawMessage `json:"id,omitempty"`
 	Version string          `json:"jsonrpc"` // TODO: Replace this with a null-type that encodes to 2.0, like https://go-review.googlesource.com/c/tools/+/136675/1/internal/jsonrpc2/jsonrpc2.go#221
+
+	// Batch is set when the message is a batch of messages, which is encoded
+	// as a JSON array instead of an object. The other fields of a batch
+	// message are unused.
+	Batch []*Message `json:"-"`
+}
+
+// message has the same fields as Message without its custom JSON methods.
+type message Message
+
+// MarshalJSON encodes a batch message as an array of its messages, or any
+// other message as an object.
+func (msg Message) MarshalJSON() ([]byte, error) {
+	if msg.Batch != nil {
+		return json.Marshal(msg.Batch)
+	}
+	return json.Marshal(message(msg))
+}
+
+// UnmarshalJSON decodes a JSON array into a batch message, or a JSON object
+// into a single message. Elements of a batch that are not valid messages are
+// decoded as empty messages, so that they can be answered individually.
+func (msg *Message) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(trimmed, &elements); err != nil {
+			return err
+		}
+		batch := make([]*Message, len(elements))
+		for i, element := range elements {
+			batch[i] = &Message{}
+			if err := json.Unmarshal(element, (*message)(batch[i])); err != nil {
+				batch[i] = &Message{}
+			}
+		}
+		*msg = Message{Batch: batch}
+		return nil
+	}
+	return json.Unmarshal(data, (*message)(msg))
 }
 
 type Request struct {
@@ -49,6 +89,8 @@ func (resp *Response) UnmarshalResult(result interface{}) error {
 }
 
 // ErrResponse is returned as part of a Response message when there is an error.
+// Data is additional information about the error, which the server sets if
+// the method's error is a DataError.
 type ErrResponse struct {
 	Code    int             `json:"code"`
 	Message string          `json:"message"`
@@ -63,6 +105,23 @@ func (err *ErrResponse) ErrorCode() int {
 	return err.Code
 }
 
+// UnmarshalData decodes the error's data into v. It returns nil without
+// changing v if the error has no data.
+func (err *ErrResponse) UnmarshalData(v interface{}) error {
+	if len(err.Data) == 0 || string(err.Data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(err.Data, v)
+}
+
+// DataError is implemented by errors that have structured data for the data
+// field of the error response, such as which hosts failed, so that callers
+// don't need to parse it out of the message.
+type DataError interface {
+	error
+	ErrorData() interface{}
+}
+
 // IsErrorCode returns true iff the error has an ErrorCode. If allowedCodes
 // is provided, then it also checks that it matches one of the allowedCodes.
 func IsErrorCode(err error, allowedCodes ...int) bool {
@@ -0,0 +1,44 @@
+package ws
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// Close status codes from RFC 6455, section 7.4.1.
+const (
+	CloseInvalidPayload = 1007
+	CloseInternalError  = 1011
+)
+
+// maxCloseReason is the longest reason that fits in a close frame, which is
+// limited to 125 bytes including the 2 byte status code.
+const maxCloseReason = 123
+
+// ErrorCloser is implemented by codecs that can tell the other side why the
+// connection is being closed, such as with a websocket close frame.
+type ErrorCloser interface {
+	// CloseWithError sends the cause of the closure to the other side before
+	// closing the connection.
+	CloseWithError(err error) error
+}
+
+// CloseStatus returns the close status code and reason to report for err.
+// Messages that can't be decoded are reported as invalid payloads, anything
+// else as an internal error. The reason is truncated to fit in a close frame.
+func CloseStatus(err error) (code int, reason string) {
+	code = CloseInternalError
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		code = CloseInvalidPayload
+	}
+	reason = err.Error()
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+		// Don't leave a partial UTF-8 sequence at the end
+		for len(reason) > 0 && !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	return code, reason
+}
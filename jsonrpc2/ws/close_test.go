@@ -0,0 +1,28 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCloseStatus(t *testing.T) {
+	code, reason := CloseStatus(errors.New("oops"))
+	if code != CloseInternalError || reason != "oops" {
+		t.Errorf("got %d %q; want %d %q", code, reason, CloseInternalError, "oops")
+	}
+
+	var msg struct{}
+	err := json.Unmarshal([]byte("not json"), &msg)
+	if code, _ := CloseStatus(err); code != CloseInvalidPayload {
+		t.Errorf("got code %d for a syntax error; want %d", code, CloseInvalidPayload)
+	}
+
+	// Multi-byte runes that don't line up with the limit are dropped whole.
+	_, reason = CloseStatus(errors.New(strings.Repeat("é", 100)))
+	if len(reason) > maxCloseReason || !utf8.ValidString(reason) {
+		t.Errorf("reason was not truncated to a valid %d bytes: %d bytes", maxCloseReason, len(reason))
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/vipnode/vipnode/jsonrpc2"
+	jsonrpc2ws "github.com/vipnode/vipnode/jsonrpc2/ws"
 )
 
 type rwc struct {
@@ -20,45 +23,146 @@ type rwc struct {
 // WebSocketDial returns a Codec that wraps a client-side connection with JSON
 // encoding and decoding.
 func WebSocketDial(ctx context.Context, url string) (jsonrpc2.Codec, error) {
+	return (&Dialer{}).Dial(ctx, url)
+}
+
+// Dialer dials websocket connections, optionally keeping them alive with
+// pings.
+type Dialer struct {
+	// KeepaliveInterval is how often to ping the server, which keeps idle
+	// connections from being dropped by NATs and firewalls along the way. If
+	// zero, no pings are sent.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveTimeout is how long to wait for a pong after each ping before
+	// closing the connection. If zero, KeepaliveInterval is used. Pongs are
+	// only received while a ReadMessage call is in progress.
+	KeepaliveTimeout time.Duration
+}
+
+// Dial returns a Codec that wraps a client-side connection with JSON
+// encoding and decoding.
+func (d *Dialer) Dial(ctx context.Context, url string) (jsonrpc2.Codec, error) {
 	conn, _, _, err := ws.Dial(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 
-	return clientWebSocketCodec(conn), nil
+	codec := clientWebSocketCodec(conn)
+	if d.KeepaliveInterval > 0 {
+		timeout := d.KeepaliveTimeout
+		if timeout <= 0 {
+			timeout = d.KeepaliveInterval
+		}
+		go codec.keepalive(d.KeepaliveInterval, timeout)
+	}
+	return codec, nil
 }
 
-func clientWebSocketCodec(conn net.Conn) jsonrpc2.Codec {
-	r := wsutil.NewReader(conn, ws.StateClientSide)
-	w := wsutil.NewWriter(conn, ws.StateClientSide, ws.OpBinary)
-	return &wsCodec{
-		inner:      jsonrpc2.IOCodec(rwc{r, w, conn}),
-		r:          r,
-		w:          w,
-		remoteAddr: conn.RemoteAddr().String(),
-	}
+func clientWebSocketCodec(conn net.Conn) *wsCodec {
+	return newWebSocketCodec(conn, ws.StateClientSide)
 }
 
 // serverWebSocketCodec returns a server-side Codec that wraps JSON encoding and
 // decoding over a websocket connection.
-func serverWebSocketCodec(conn net.Conn) jsonrpc2.Codec {
-	r := wsutil.NewReader(conn, ws.StateServerSide)
-	w := wsutil.NewWriter(conn, ws.StateServerSide, ws.OpBinary)
-	return &wsCodec{
+func serverWebSocketCodec(conn net.Conn) *wsCodec {
+	return newWebSocketCodec(conn, ws.StateServerSide)
+}
+
+func newWebSocketCodec(conn net.Conn, state ws.State) *wsCodec {
+	r := wsutil.NewReader(conn, state)
+	w := wsutil.NewWriter(conn, state, ws.OpBinary)
+	codec := &wsCodec{
 		inner:      jsonrpc2.IOCodec(rwc{r, w, conn}),
+		conn:       conn,
+		state:      state,
 		r:          r,
 		w:          w,
 		remoteAddr: conn.RemoteAddr().String(),
+		pong:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
 	}
+	r.OnIntermediate = codec.handleControl
+	return codec
 }
 
 var _ jsonrpc2.Codec = &wsCodec{}
+var _ jsonrpc2ws.ErrorCloser = &wsCodec{}
+
+// closeTimeout is how long CloseWithError waits to send the close frame.
+const closeTimeout = time.Second
 
 type wsCodec struct {
 	inner      jsonrpc2.Codec
+	conn       net.Conn
+	state      ws.State
 	r          *wsutil.Reader
 	w          *wsutil.Writer
 	remoteAddr string
+
+	// muWrite is held while writing a frame, so that control frames don't
+	// interleave with messages.
+	muWrite   sync.Mutex
+	pong      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// lockedWriter writes control frames on the connection, holding the codec's
+// write lock for each frame.
+type lockedWriter struct {
+	codec *wsCodec
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.codec.muWrite.Lock()
+	defer w.codec.muWrite.Unlock()
+	return w.codec.conn.Write(p)
+}
+
+// handleControl responds to control frames, such as pings, and notes pongs
+// for the keepalive.
+func (codec *wsCodec) handleControl(hdr ws.Header, r io.Reader) error {
+	if hdr.OpCode == ws.OpPong {
+		select {
+		case codec.pong <- struct{}{}:
+		default:
+		}
+	}
+	return wsutil.ControlFrameHandler(lockedWriter{codec}, codec.state)(hdr, r)
+}
+
+// keepalive pings the other side every interval, and closes the connection
+// if a pong doesn't arrive within timeout.
+func (codec *wsCodec) keepalive(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-codec.closed:
+			return
+		}
+
+		// Drop any unsolicited pong from before this ping
+		select {
+		case <-codec.pong:
+		default:
+		}
+		if err := wsutil.WriteMessage(lockedWriter{codec}, codec.state, ws.OpPing, nil); err != nil {
+			codec.Close()
+			return
+		}
+
+		select {
+		case <-codec.pong:
+		case <-time.After(timeout):
+			codec.Close()
+			return
+		case <-codec.closed:
+			return
+		}
+	}
 }
 
 func (codec *wsCodec) RemoteAddr() string {
@@ -66,14 +170,27 @@ func (codec *wsCodec) RemoteAddr() string {
 }
 
 func (codec *wsCodec) ReadMessage() (*jsonrpc2.Message, error) {
-	_, err := codec.r.NextFrame()
-	if err != nil {
-		return nil, err
+	for {
+		hdr, err := codec.r.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if !hdr.OpCode.IsControl() {
+			break
+		}
+		if err := codec.handleControl(hdr, codec.r); err != nil {
+			return nil, err
+		}
+		if err := codec.r.Discard(); err != nil {
+			return nil, err
+		}
 	}
 	return codec.inner.ReadMessage()
 }
 
 func (codec *wsCodec) WriteMessage(msg *jsonrpc2.Message) error {
+	codec.muWrite.Lock()
+	defer codec.muWrite.Unlock()
 	err := codec.inner.WriteMessage(msg)
 	if err != nil {
 		return err
@@ -85,9 +202,27 @@ func (codec *wsCodec) WriteMessage(msg *jsonrpc2.Message) error {
 }
 
 func (codec *wsCodec) Close() error {
+	codec.closeOnce.Do(func() {
+		close(codec.closed)
+	})
 	return codec.inner.Close()
 }
 
+// CloseWithError sends a close frame with the status and reason for err, then
+// closes the connection. If the other side already closed the connection,
+// then it's closed without sending anything.
+func (codec *wsCodec) CloseWithError(err error) error {
+	if _, ok := err.(wsutil.ClosedError); ok || err == io.EOF {
+		return codec.Close()
+	}
+	code, reason := jsonrpc2ws.CloseStatus(err)
+	body := ws.NewCloseFrameBody(ws.StatusCode(code), reason)
+	// Best effort, the connection may already be broken
+	codec.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	wsutil.WriteMessage(lockedWriter{codec}, codec.state, ws.OpClose, body)
+	return codec.Close()
+}
+
 // Upgrader upgrades an HTTP request to a WebSocket request and returns the
 // appropriate jsonrpc2 codec.
 type Upgrader struct {
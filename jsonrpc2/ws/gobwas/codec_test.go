@@ -1,8 +1,11 @@
 package gobwas
 
 import (
+	"io"
+	"io/ioutil"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/vipnode/vipnode/jsonrpc2"
 )
@@ -31,3 +34,69 @@ func TestWebSocketCodec(t *testing.T) {
 		t.Errorf("wrong message: %v", msg)
 	}
 }
+
+func TestWebSocketKeepalive(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	clientCodec := clientWebSocketCodec(c1)
+	serverCodec := serverWebSocketCodec(c2)
+	go clientCodec.keepalive(5*time.Millisecond, 50*time.Millisecond)
+
+	// The server answers pings while it waits for a message.
+	serverMsg := make(chan *jsonrpc2.Message, 1)
+	go func() {
+		msg, err := serverCodec.ReadMessage()
+		if err != nil {
+			t.Error(err)
+		}
+		serverMsg <- msg
+	}()
+	clientMsg := make(chan error, 1)
+	go func() {
+		_, err := clientCodec.ReadMessage()
+		clientMsg <- err
+	}()
+
+	// Several keepalive rounds pass without the connection being closed.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-clientMsg:
+		t.Fatalf("client connection closed: %v", err)
+	default:
+	}
+
+	if err := clientCodec.WriteMessage(&jsonrpc2.Message{Version: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-serverMsg; msg == nil || msg.Version != "foo" {
+		t.Errorf("wrong message: %v", msg)
+	}
+	clientCodec.Close()
+}
+
+func TestWebSocketKeepaliveTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	// The server swallows pings without answering them.
+	go io.Copy(ioutil.Discard, c2)
+
+	clientCodec := clientWebSocketCodec(c1)
+	go clientCodec.keepalive(5*time.Millisecond, 10*time.Millisecond)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := clientCodec.ReadMessage()
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("expected an error after the connection was closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed after a missed pong")
+	}
+}
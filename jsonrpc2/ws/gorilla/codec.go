@@ -3,26 +3,25 @@ package gorilla
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/jsonrpc2/ws"
 )
 
 // WebSocketDial returns a Codec that wraps a client-side connection with JSON
 // encoding and decoding.
 func WebSocketDial(ctx context.Context, url string) (jsonrpc2.Codec, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	return &wsCodec{conn: conn}, nil
+	return (&Dialer{}).Dial(ctx, url)
 }
 
 var _ jsonrpc2.Codec = &wsCodec{}
+var _ ws.ErrorCloser = &wsCodec{}
 
 func overrideEOF(err error) error {
 	if err == nil {
@@ -38,6 +37,47 @@ type wsCodec struct {
 	muWrite sync.Mutex
 	muRead  sync.Mutex
 	conn    *websocket.Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newCodec(conn *websocket.Conn) *wsCodec {
+	return &wsCodec{
+		conn:   conn,
+		closed: make(chan struct{}),
+	}
+}
+
+// keepalive pings the other side every interval until the codec is closed.
+// Reads fail if a pong doesn't arrive within timeout of a ping, so it must
+// be started before the first ReadMessage. Pongs are only received while a
+// ReadMessage call is in progress.
+func (codec *wsCodec) keepalive(interval, timeout time.Duration) {
+	deadline := func() time.Time {
+		return time.Now().Add(interval + timeout)
+	}
+	codec.conn.SetReadDeadline(deadline())
+	codec.conn.SetPongHandler(func(string) error {
+		return codec.conn.SetReadDeadline(deadline())
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-codec.closed:
+				return
+			}
+			// WriteControl is safe to call alongside WriteMessage
+			if err := codec.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				codec.Close()
+				return
+			}
+		}
+	}()
 }
 
 func (codec *wsCodec) RemoteAddr() string {
@@ -47,10 +87,20 @@ func (codec *wsCodec) RemoteAddr() string {
 func (codec *wsCodec) ReadMessage() (*jsonrpc2.Message, error) {
 	codec.muRead.Lock()
 	defer codec.muRead.Unlock()
-	var msg jsonrpc2.Message
-	if err := codec.conn.ReadJSON(&msg); err != nil {
+	_, r, err := codec.conn.NextReader()
+	if err != nil {
 		return nil, overrideEOF(err)
 	}
+	// Decoding errors are returned as-is, unlike connection errors, so that
+	// they can be reported to the other side.
+	var msg jsonrpc2.Message
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		if err == io.EOF {
+			// One value per message, so an empty message is unexpected
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
 	return &msg, nil
 }
 
@@ -61,9 +111,27 @@ func (codec *wsCodec) WriteMessage(msg *jsonrpc2.Message) error {
 }
 
 func (codec *wsCodec) Close() error {
+	codec.closeOnce.Do(func() { close(codec.closed) })
 	return codec.conn.Close()
 }
 
+// closeTimeout is how long CloseWithError waits to send the close frame.
+const closeTimeout = time.Second
+
+// CloseWithError sends a close frame with the status and reason for err, then
+// closes the connection. If the other side already closed the connection,
+// then it's closed without sending anything.
+func (codec *wsCodec) CloseWithError(err error) error {
+	if _, ok := err.(*websocket.CloseError); ok || err == io.EOF {
+		return codec.Close()
+	}
+	code, reason := ws.CloseStatus(err)
+	msg := websocket.FormatCloseMessage(code, reason)
+	// Best effort, the connection may already be broken
+	codec.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+	return codec.Close()
+}
+
 // Upgrader upgrades an HTTP request to a WebSocket request and returns the
 // appropriate jsonrpc2 codec.
 type Upgrader struct {
@@ -75,5 +143,5 @@ func (u *Upgrader) Upgrade(r *http.Request, w http.ResponseWriter, h http.Header
 	if err != nil {
 		return nil, err
 	}
-	return &wsCodec{conn: conn}, nil
+	return newCodec(conn), nil
 }
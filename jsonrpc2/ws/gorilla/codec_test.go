@@ -0,0 +1,52 @@
+package gorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vipnode/vipnode/jsonrpc2/ws"
+)
+
+func TestCloseWithError(t *testing.T) {
+	upgrader := &Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec, err := upgrader.Upgrade(r, w, nil)
+		if err != nil {
+			return
+		}
+		_, err = codec.ReadMessage()
+		if err == nil {
+			t.Error("expected a decoding error")
+			codec.Close()
+			return
+		}
+		codec.(ws.ErrorCloser).CloseWithError(err)
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.DialContext(context.Background(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("expected a close error, got: %v", err)
+	}
+	if closeErr.Code != ws.CloseInvalidPayload {
+		t.Errorf("got close code %d; want %d", closeErr.Code, ws.CloseInvalidPayload)
+	}
+	if closeErr.Text == "" {
+		t.Error("close frame is missing the reason")
+	}
+}
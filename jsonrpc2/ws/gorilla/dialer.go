@@ -0,0 +1,111 @@
+package gorilla
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vipnode/vipnode/jsonrpc2"
+)
+
+// ErrCertificateNotPinned is returned when none of the server's TLS
+// certificates match a pinned fingerprint.
+var ErrCertificateNotPinned = errors.New("server certificate does not match any pinned fingerprint")
+
+// ParseFingerprint parses a hex-encoded SHA-256 fingerprint, such as the
+// output of `openssl x509 -noout -fingerprint -sha256`. Colons are optional.
+func ParseFingerprint(s string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint %q: %s", s, err)
+	}
+	if len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("invalid fingerprint %q: must be a SHA-256 hash", s)
+	}
+	return fingerprint, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate.
+func Fingerprint(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.Raw)
+	return sum[:]
+}
+
+// Dialer dials websocket connections, optionally pinning the server's TLS
+// certificate.
+type Dialer struct {
+	// TLSConfig is used for wss:// connections. If nil, the default
+	// configuration is used.
+	TLSConfig *tls.Config
+
+	// PinnedFingerprints is the set of SHA-256 fingerprints of allowed
+	// server certificates. Each fingerprint is either of a full certificate
+	// or of its public key (SubjectPublicKeyInfo), and can match any
+	// certificate in the server's chain. If empty, then any certificate that
+	// passes normal verification is accepted.
+	PinnedFingerprints [][]byte
+
+	// KeepaliveInterval is how often to ping the server, which keeps idle
+	// connections from being dropped by NATs and firewalls along the way,
+	// and notices connections that dropped silently. If zero, no pings are
+	// sent.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveTimeout is how long to wait for a pong after each ping before
+	// failing reads on the connection. If zero, KeepaliveInterval is used.
+	KeepaliveTimeout time.Duration
+}
+
+func (d *Dialer) verifyPinned(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certSum := sha256.Sum256(cert.Raw)
+		keySum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pinned := range d.PinnedFingerprints {
+			if string(pinned) == string(certSum[:]) || string(pinned) == string(keySum[:]) {
+				return nil
+			}
+		}
+	}
+	return ErrCertificateNotPinned
+}
+
+// Dial returns a Codec that wraps a client-side connection with JSON
+// encoding and decoding.
+func (d *Dialer) Dial(ctx context.Context, url string) (jsonrpc2.Codec, error) {
+	dialer := *websocket.DefaultDialer
+	if d.TLSConfig != nil {
+		dialer.TLSClientConfig = d.TLSConfig.Clone()
+	}
+	if len(d.PinnedFingerprints) > 0 {
+		if dialer.TLSClientConfig == nil {
+			dialer.TLSClientConfig = &tls.Config{}
+		}
+		// Called after the normal chain verification succeeds
+		dialer.TLSClientConfig.VerifyPeerCertificate = d.verifyPinned
+	}
+
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	codec := newCodec(conn)
+	if d.KeepaliveInterval > 0 {
+		timeout := d.KeepaliveTimeout
+		if timeout <= 0 {
+			timeout = d.KeepaliveInterval
+		}
+		codec.keepalive(d.KeepaliveInterval, timeout)
+	}
+	return codec, nil
+}
@@ -0,0 +1,186 @@
+package gorilla
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vipnode/vipnode/jsonrpc2"
+)
+
+func TestDialerPinnedCertificate(t *testing.T) {
+	upgrader := &Upgrader{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec, err := upgrader.Upgrade(r, w, nil)
+		if err != nil {
+			return
+		}
+		defer codec.Close()
+		msg, err := codec.ReadMessage()
+		if err != nil {
+			return
+		}
+		codec.WriteMessage(msg)
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	keySum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	wrongSum := sha256.Sum256([]byte("not a certificate"))
+
+	testCases := []struct {
+		Name    string
+		Pinned  [][]byte
+		WantErr error
+	}{
+		{"no pins", nil, nil},
+		{"cert fingerprint", [][]byte{wrongSum[:], Fingerprint(server.Certificate())}, nil},
+		{"key fingerprint", [][]byte{keySum[:]}, nil},
+		{"mismatched fingerprint", [][]byte{wrongSum[:]}, ErrCertificateNotPinned},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dialer := &Dialer{
+				TLSConfig:          &tls.Config{RootCAs: roots},
+				PinnedFingerprints: tc.Pinned,
+			}
+			codec, err := dialer.Dial(context.Background(), url)
+			if tc.WantErr != nil {
+				if err != tc.WantErr {
+					t.Fatalf("got error %v; want %v", err, tc.WantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer codec.Close()
+
+			if err := codec.WriteMessage(&jsonrpc2.Message{Version: "foo"}); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := codec.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Version != "foo" {
+				t.Errorf("wrong message: %v", msg)
+			}
+		})
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("foo"))
+	colons := "2C:26:B4:6B:68:FF:C6:8F:F9:9B:45:3C:1D:30:41:34:13:42:2D:70:64:83:BF:A0:F9:8A:5E:88:62:66:E7:AE"
+	for _, s := range []string{colons, strings.Replace(colons, ":", "", -1)} {
+		got, err := ParseFingerprint(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(sum[:]) {
+			t.Errorf("got %x; want %x", got, sum)
+		}
+	}
+
+	for _, s := range []string{"", "abcd", "zz"} {
+		if _, err := ParseFingerprint(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestDialerKeepalive(t *testing.T) {
+	upgrader := &Upgrader{}
+	serverMsg := make(chan *jsonrpc2.Message, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec, err := upgrader.Upgrade(r, w, nil)
+		if err != nil {
+			return
+		}
+		defer codec.Close()
+		// Pings are answered while the server waits for a message.
+		msg, err := codec.ReadMessage()
+		if err != nil {
+			t.Error(err)
+		}
+		serverMsg <- msg
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	dialer := &Dialer{KeepaliveInterval: 5 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond}
+	codec, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.Close()
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := codec.ReadMessage()
+		readErr <- err
+	}()
+
+	// Several keepalive rounds pass without the connection failing.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-readErr:
+		t.Fatalf("client connection failed: %v", err)
+	default:
+	}
+
+	if err := codec.WriteMessage(&jsonrpc2.Message{Version: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-serverMsg; msg == nil || msg.Version != "foo" {
+		t.Errorf("wrong message: %v", msg)
+	}
+}
+
+func TestDialerKeepaliveTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Never reads, so pings go unanswered.
+		<-done
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	dialer := &Dialer{KeepaliveInterval: 5 * time.Millisecond, KeepaliveTimeout: 10 * time.Millisecond}
+	codec, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := codec.ReadMessage()
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("expected an error after a missed pong")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read did not fail after a missed pong")
+	}
+}
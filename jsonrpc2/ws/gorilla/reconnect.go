@@ -0,0 +1,191 @@
+package gorilla
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vipnode/vipnode/jsonrpc2"
+)
+
+const defaultMinBackoff = 1 * time.Second
+const defaultMaxBackoff = 1 * time.Minute
+
+// ReconnectOptions configures a codec returned by WebSocketDialReconnecting.
+type ReconnectOptions struct {
+	// Dialer is used for the initial connection and every reconnect. If
+	// nil, a default Dialer is used.
+	Dialer *Dialer
+
+	// MinBackoff is the delay before retrying a failed reconnect, which
+	// doubles with every failure up to MaxBackoff. If unset, MinBackoff
+	// defaults to 1 second and MaxBackoff to 1 minute (or MinBackoff, if
+	// that's longer).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// KeepaliveInterval overrides the Dialer's KeepaliveInterval if set, so
+	// that a connection that drops silently is noticed and re-dialed.
+	KeepaliveInterval time.Duration
+
+	// OnReconnect is called in its own goroutine after every successful
+	// reconnect, such as to repeat any registration that was done over the
+	// previous connection.
+	OnReconnect func()
+}
+
+// WebSocketDialReconnecting returns a Codec like WebSocketDial, except that it
+// re-dials url whenever the connection is lost instead of failing for good.
+// While it's reconnecting, ReadMessage and WriteMessage return
+// jsonrpc2.ErrCodecReset rather than blocking, so a jsonrpc2.Remote fails its
+// in-flight calls right away. Only the initial dial is bound by ctx.
+func WebSocketDialReconnecting(ctx context.Context, url string, opts ReconnectOptions) (jsonrpc2.Codec, error) {
+	if opts.Dialer == nil {
+		opts.Dialer = &Dialer{}
+	}
+	if opts.KeepaliveInterval > 0 {
+		dialer := *opts.Dialer
+		dialer.KeepaliveInterval = opts.KeepaliveInterval
+		opts.Dialer = &dialer
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	codec, err := opts.Dialer.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	closeCtx, cancel := context.WithCancel(context.Background())
+	return &reconnectCodec{
+		url:    url,
+		opts:   opts,
+		ctx:    closeCtx,
+		cancel: cancel,
+		codec:  codec,
+		addr:   codec.RemoteAddr(),
+	}, nil
+}
+
+var _ jsonrpc2.Codec = &reconnectCodec{}
+
+// reconnectCodec wraps a websocket codec that is replaced when it fails. Only
+// the reader reconnects, so that there is a single reconnect loop.
+type reconnectCodec struct {
+	url    string
+	opts   ReconnectOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	codec jsonrpc2.Codec // nil while reconnecting
+	addr  string
+}
+
+func (c *reconnectCodec) current() jsonrpc2.Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.codec
+}
+
+// drop closes codec and starts reconnecting, unless it was already replaced.
+func (c *reconnectCodec) drop(codec jsonrpc2.Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.codec == codec {
+		c.codec = nil
+		codec.Close()
+	}
+}
+
+// reconnect dials until it succeeds, backing off between failures. It
+// returns io.EOF if the codec is closed first.
+func (c *reconnectCodec) reconnect() (jsonrpc2.Codec, error) {
+	backoff := c.opts.MinBackoff
+	for {
+		codec, err := c.opts.Dialer.Dial(c.ctx, c.url)
+		if err == nil {
+			c.mu.Lock()
+			if c.ctx.Err() != nil {
+				// Closed while the dial was finishing
+				c.mu.Unlock()
+				codec.Close()
+				return nil, io.EOF
+			}
+			c.codec = codec
+			c.addr = codec.RemoteAddr()
+			c.mu.Unlock()
+			if c.opts.OnReconnect != nil {
+				go c.opts.OnReconnect()
+			}
+			return codec, nil
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return nil, io.EOF
+		}
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+func (c *reconnectCodec) RemoteAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addr
+}
+
+func (c *reconnectCodec) ReadMessage() (*jsonrpc2.Message, error) {
+	codec := c.current()
+	if codec == nil {
+		var err error
+		if codec, err = c.reconnect(); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := codec.ReadMessage()
+	if err == nil {
+		return msg, nil
+	}
+	if c.ctx.Err() != nil {
+		return nil, io.EOF
+	}
+	c.drop(codec)
+	return nil, jsonrpc2.ErrCodecReset
+}
+
+func (c *reconnectCodec) WriteMessage(msg *jsonrpc2.Message) error {
+	codec := c.current()
+	if codec == nil {
+		return jsonrpc2.ErrCodecReset
+	}
+	if err := codec.WriteMessage(msg); err != nil {
+		if c.ctx.Err() != nil {
+			return io.EOF
+		}
+		// Closing the connection makes the reader notice and reconnect.
+		c.drop(codec)
+		return jsonrpc2.ErrCodecReset
+	}
+	return nil
+}
+
+func (c *reconnectCodec) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.codec == nil {
+		return nil
+	}
+	return c.codec.Close()
+}
@@ -0,0 +1,85 @@
+package gorilla
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/jsonrpc2"
+)
+
+func TestWebSocketDialReconnecting(t *testing.T) {
+	// The server echoes one message per connection and then drops it.
+	var conns int32
+	upgrader := &Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codec, err := upgrader.Upgrade(r, w, nil)
+		if err != nil {
+			return
+		}
+		defer codec.Close()
+		atomic.AddInt32(&conns, 1)
+		msg, err := codec.ReadMessage()
+		if err != nil {
+			return
+		}
+		codec.WriteMessage(msg)
+	}))
+	defer server.Close()
+
+	reconnected := make(chan struct{}, 1)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	codec, err := WebSocketDialReconnecting(context.Background(), url, ReconnectOptions{
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		OnReconnect: func() { reconnected <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.Close()
+
+	req := &jsonrpc2.Message{ID: json.RawMessage(`1`), Request: &jsonrpc2.Request{Method: "echo"}}
+	if err := codec.WriteMessage(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	// The server drops the connection after echoing.
+	if _, err := codec.ReadMessage(); err != jsonrpc2.ErrCodecReset {
+		t.Fatalf("read after drop: got %v; want ErrCodecReset", err)
+	}
+	// Writes fail fast until the reader reconnects.
+	if err := codec.WriteMessage(req); err != jsonrpc2.ErrCodecReset {
+		t.Fatalf("write while reconnecting: got %v; want ErrCodecReset", err)
+	}
+
+	// The next read reconnects and then blocks, so run it in the background
+	// and wait for the reconnect.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := codec.ReadMessage()
+		readErr <- err
+	}()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reconnect")
+	}
+	if err := codec.WriteMessage(req); err != nil {
+		t.Fatalf("write after reconnect: %s", err)
+	}
+	if err := <-readErr; err != nil {
+		t.Fatalf("read after reconnect: %s", err)
+	}
+
+	if got, want := atomic.LoadInt32(&conns), int32(2); got != want {
+		t.Errorf("got %d connections; want %d", got, want)
+	}
+}
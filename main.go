@@ -27,6 +27,7 @@ import (
 	"github.com/vipnode/vipnode/internal/pretty"
 	"github.com/vipnode/vipnode/jsonrpc2"
 	"github.com/vipnode/vipnode/pool"
+	"github.com/vipnode/vipnode/pool/balance"
 	"github.com/vipnode/vipnode/pool/payment"
 )
 
@@ -41,37 +42,83 @@ type Options struct {
 	PrintConfig bool   `long:"print-config" description:"Print the current configuration to stdout."`
 	Verbose     []bool `short:"v" long:"verbose" description:"Show verbose logging."`
 	Version     bool   `long:"version" description:"Print version and exit."`
+	DebugRPC    bool   `long:"debug-rpc" description:"Log every JSON-RPC message sent and received over websocket connections, with signatures redacted."`
 
 	Client struct {
 		Args struct {
 			VIPNode string `positional-arg-name:"vipnode" description:"vipnode pool URL or stand-alone vipnode enode string"`
 		} `positional-args:"yes"`
-		RPC     string `long:"rpc" description:"RPC path or URL of the client node."`
-		NodeKey string `long:"nodekey" description:"Path to the client node's private key."`
+		RPC            string        `long:"rpc" description:"RPC path or URL of the client node."`
+		NodeKey        string        `long:"nodekey" description:"Path to the client node's private key."`
+		SigningKey     string        `long:"signing-key" description:"Path to a separate private key for signing pool requests, so that the node key isn't needed. Run once with --nodekey to bind it."`
+		PreferHost     []string      `long:"prefer-host" description:"Node ID of a host to prefer connecting to, if the pool has it available. Can be repeated."`
+		PinCert        []string      `long:"pin-cert" description:"SHA-256 fingerprint of an allowed pool TLS certificate or public key. Can be repeated. (Only with wss:// pools)"`
+		Distribution   string        `long:"distribution" description:"How to spread the client across hosts: 'spread' for hosts run by different operators, or 'minimal' for a single host. (Default: pool's choice)" choice:"spread" choice:"minimal"`
+		Units          string        `long:"units" description:"Units to display balances in. (wei|gwei|eth or a custom name:decimals)" default:"eth"`
+		ConnectTimeout time.Duration `long:"connect-timeout" description:"How long to wait for the node to finish connecting to each host before giving up. (0 to not wait)" default:"30s"`
 	} `command:"client" description:"Connect to a vipnode as a client."`
 
 	Host struct {
-		Pool    string `long:"pool" description:"Pool to participate in." default:"wss://pool.vipnode.org/"`
-		RPC     string `long:"rpc" description:"RPC path or URL of the host node."`
-		NodeKey string `long:"nodekey" description:"Path to the host node's private key."`
-		NodeURI string `long:"enode" description:"Public enode://... URI for clients to connect to. (If node is on a different IP from the vipnode agent)"`
-		Payout  string `long:"payout" description:"Ethereum wallet address to receive pool payments."`
+		Pool             string        `long:"pool" description:"Pool to participate in." default:"wss://pool.vipnode.org/"`
+		RPC              string        `long:"rpc" description:"RPC path or URL of the host node."`
+		NodeKey          string        `long:"nodekey" description:"Path to the host node's private key."`
+		SigningKey       string        `long:"signing-key" description:"Path to a separate private key for signing pool requests, so that the node key isn't needed. Run once with --nodekey to bind it."`
+		NodeURI          string        `long:"enode" description:"Public enode://... URI for clients to connect to. (If node is on a different IP from the vipnode agent)"`
+		AltNodeURI       []string      `long:"alt-enode" description:"Additional public enode://... URI that clients can try if --enode is unreachable, such as an IPv6 address. Can be repeated."`
+		EnforceWhitelist bool          `long:"enforce-whitelist" description:"Disconnect light clients that connect without being whitelisted by the pool."`
+		MaxPeers         int           `long:"max-peers" description:"Number of clients that the host node can accept, such as its --maxpeers setting. The pool stops sending clients once it's full. (0 for no limit)" default:"0"`
+		SourceIP         string        `long:"source-ip" description:"IP address or network interface name for clients to connect to, replacing the host of the advertised enode. (If the host has multiple interfaces)"`
+		Payout           string        `long:"payout" description:"Ethereum wallet address to receive pool payments."`
+		Keepalive        time.Duration `long:"keepalive" description:"How often to ping the pool, so that a connection that drops silently is noticed and re-established. (0 to disable)" default:"30s"`
 	} `command:"host" description:"Host a vipnode."`
 
 	Pool struct {
-		Bind        string `long:"bind" description:"Address and port to listen on." default:"0.0.0.0:8080"`
-		Store       string `long:"store" description:"Storage driver. (persist|memory)" default:"persist"`
-		DataDir     string `long:"datadir" description:"Path for storing the persistent database."`
-		TLSHost     string `long:"tlshost" description:"Acquire an ACME TLS cert for this host (forces bind to port :443)."`
-		AllowOrigin string `long:"allow-origin" description:"Include Access-Control-Allow-Origin header for CORS."`
-		Contract    struct {
-			RPC        string `long:"rpc" description:"Path or URL of an Ethereum RPC provider for payment contract operations. Must match the network of the contract."`
-			Addr       string `long:"address" description:"Deployed contract address, prefixed with network name scheme. (Example: \"rinkeby://0xb2f8987986259facdc539ac1745f7a0b395972b1\")"`
-			KeyStore   string `long:"keystore" description:"Path to encrypted JSON wallet keystore for contract operator. (Password set in KEYSTORE_PASSPHRASE env)"`
-			Price      uint64 `long:"price" description:"Price per minute (in wei)." default:"100000000000"`
-			MinBalance string `long:"min-balance" description:"Minimum balance required to join as a client (in wei or 'off')." default:"100000000000"`
-			Welcome    string `long:"welcome" description:"Welcome message for clients. (Example: \"Welcome, {{.NodeID}}\")"`
+		Bind             string        `long:"bind" description:"Address and port to listen on." default:"0.0.0.0:8080"`
+		Store            string        `long:"store" description:"Storage driver. (persist|memory|redis|postgres)" default:"persist"`
+		DataDir          string        `long:"datadir" description:"Path for storing the persistent database."`
+		RedisURL         string        `long:"redis-url" description:"Redis URL to use with --store=redis." default:"redis://localhost:6379/0"`
+		PostgresURL      string        `long:"postgres-url" description:"Postgres URL to use with --store=postgres." default:"postgres://localhost:5432/vipnode"`
+		Snapshot         string        `long:"snapshot" description:"Path to periodically save the memory store to, restored on startup. (Only with --store=memory)"`
+		TLSHost          string        `long:"tlshost" description:"Acquire an ACME TLS cert for this host (forces bind to port :443)."`
+		AllowOrigin      string        `long:"allow-origin" description:"Include Access-Control-Allow-Origin header for CORS."`
+		NumRequestHosts  int           `long:"num-request-hosts" description:"Number of hosts to offer to each connecting client." default:"3"`
+		MaxCreditedHosts int           `long:"max-credited-hosts" description:"Maximum number of hosts a client is billed for per update. (0 for no limit)" default:"0"`
+		MaxSourceHosts   int           `long:"max-source-hosts" description:"Maximum number of active hosts registered from the same IP. (0 for no limit)" default:"0"`
+		HostSelection    string        `long:"host-selection" description:"How to choose hosts for clients: 'random', 'least-peers' to prefer less loaded hosts, 'least-clients' to spread clients round-robin, or 'balance' to prefer hosts that earned more credit." choice:"random" choice:"least-peers" choice:"least-clients" choice:"balance" default:"random"`
+		MinHostVersion   string        `long:"min-host-version" description:"Minimum vipnode version that hosts must run, older hosts are refused. (Example: v2.3.0)"`
+		VerifyHostPeers  bool          `long:"verify-host-peers" description:"Cross-check each host update against the peers that its node reports, and freeze the credit of hosts that report peers they don't have."`
+		WhitelistTimeout time.Duration `long:"whitelist-timeout" description:"How long to wait for hosts to respond to whitelist requests and other pool calls. Raise it for hosts on high-latency links." default:"5s"`
+		MaxRequestSkew   time.Duration `long:"max-request-skew" description:"How far the timestamp of a signed request can be from the pool's time before it's rejected as expired. (0 to only check nonces)" default:"30s"`
+		PayoutCooldown   time.Duration `long:"payout-cooldown" description:"Minimum time between changes to a host's payout account. (0 for no limit)" default:"0"`
+		HostRateLimit    int           `long:"host-rate-limit" description:"Maximum number of new hosts registered from the same IP per hour. (0 for no limit)" default:"0"`
+		Operators        []string      `long:"operator" description:"Node ID of a pool operator who is allowed to call operator-only methods, such as vipnode_stats. Can be repeated."`
+		StatsD           string        `long:"statsd" description:"Address of a StatsD agent to send pool metrics to over UDP. (Example: localhost:8125)"`
+		InstanceID       string        `long:"instance-id" description:"Identifies this pool instance when multiple instances share a store, such as redis behind a load balancer."`
+		InstanceBind     string        `long:"instance-bind" description:"Address to serve the internal RPC for other pool instances on. Must not be publicly reachable. (Example: 10.0.0.2:8081)"`
+		InstancePeers    []string      `long:"instance-peer" description:"Internal RPC endpoint of another pool instance to relay host calls through, as id=url. Can be repeated. (Example: b=http://10.0.0.3:8081)"`
+		Contract         struct {
+			RPC               string            `long:"rpc" description:"Path or URL of an Ethereum RPC provider for payment contract operations. Must match the network of the contract."`
+			Addr              string            `long:"address" description:"Deployed contract address, prefixed with network name scheme. (Example: \"rinkeby://0xb2f8987986259facdc539ac1745f7a0b395972b1\")"`
+			KeyStore          string            `long:"keystore" description:"Path to encrypted JSON wallet keystore for contract operator. (Password set in KEYSTORE_PASSPHRASE env)"`
+			Price             uint64            `long:"price" description:"Price per minute (in wei)." default:"100000000000"`
+			KindPrice         map[string]uint64 `long:"kind-price" description:"Price per minute (in wei) for clients of a kind, in place of --price. Can be repeated. (Example: les:50000000000)"`
+			MinBalance        string            `long:"min-balance" description:"Minimum balance required to join as a client (in wei, with an optional unit like 0.01eth, or 'off')." default:"100000000000"`
+			TrialCredit       string            `long:"trial-credit" description:"Credit granted to new clients, who are disconnected once it runs out (in wei, with an optional unit like 0.01eth). Replaces --min-balance."`
+			DisableTrial      bool              `long:"disable-trial" description:"Refuse clients that don't have a linked account with a funded balance, instead of granting them trial credit."`
+			CreditHostReports bool              `long:"credit-host-reports" description:"Also bill clients for the time that their hosts report being connected to them, not only what the clients report. Only use with trusted hosts."`
+			ConnectGrace      time.Duration     `long:"connect-grace" description:"How long new client-host connections go unbilled, so clients aren't charged for the time it takes to connect." default:"0"`
+			BalanceWorkers    int               `long:"balance-workers" description:"Maximum number of contract balance events handled concurrently." default:"4"`
+			SubmitTimeout     time.Duration     `long:"submit-timeout" description:"How long to wait for the Ethereum node to accept a withdraw transaction." default:"30s"`
+			ConfirmTimeout    time.Duration     `long:"confirm-timeout" description:"How long to wait for a withdraw transaction to be mined before reporting its hash as pending." default:"5m"`
+			Welcome           string            `long:"welcome" description:"Welcome message for clients. (Example: \"Welcome, {{.NodeID}}\")"`
 		} `group:"contract" namespace:"contract"`
+		WebSocket struct {
+			PendingLimit     int           `long:"pending-limit" description:"Number of unanswered calls to track per websocket connection before the oldest are discarded." default:"50"`
+			PendingDiscard   int           `long:"pending-discard" description:"Number of oldest unanswered calls to discard once the pending limit is reached." default:"10"`
+			HandshakeTimeout time.Duration `long:"handshake-timeout" description:"Maximum duration of the websocket upgrade handshake. (0 for no limit)"`
+			ReadBufferSize   int           `long:"read-buffer-size" description:"Websocket read buffer size in bytes. (0 for the default)"`
+			WriteBufferSize  int           `long:"write-buffer-size" description:"Websocket write buffer size in bytes. (0 for the default)"`
+		} `group:"websocket" namespace:"ws"`
 	} `command:"pool" description:"Start a vipnode pool coordinator."`
 }
 
@@ -286,6 +333,9 @@ func main() {
 		ethnode.SetLogger(logWriter)
 		jsonrpc2.SetLogger(logWriter)
 	}
+	if options.DebugRPC {
+		jsonrpc2.SetLogger(logWriter)
+	}
 
 	if !strings.HasPrefix(Version, "v") || strings.HasPrefix(Version, "v0.") {
 		logger.Warningf("This is a pre-release version (%s). It can stop working at any time.", Version)
@@ -318,11 +368,38 @@ func main() {
 		switch typedErr.ErrorCode() {
 		case jsonrpc2.ErrCodeMethodNotFound, jsonrpc2.ErrCodeInvalidParams:
 			err = ErrExplain{err, `Missing a required RPC method. Make sure your Ethereum node is up to date.`}
+		case balance.ErrCodeBalanceExhausted:
+			err = ErrExplain{err, `Your balance with the pool ran out, so the pool disconnected you from its hosts. Add credit to your account to keep using the pool.`}
 		case jsonrpc2.ErrCodeInternal:
 			if err.Error() == (pool.NoHostNodesError{}).Error() {
 				err = ErrExplain{err, `The pool does not have any hosts who are ready to serve your kind of client right now. Try again later or contact the pool operator for help.`}
 				break
 			}
+			if strings.HasPrefix(err.Error(), "connection refused") {
+				err = ErrExplain{err, `The pool refused to accept this client. Contact the pool operator or check your account balance.`}
+				break
+			}
+			if strings.HasPrefix(err.Error(), "light node host rejected") {
+				err = ErrExplain{err, `Hosts must run a full node, because light nodes can't serve other light clients. Restart your node in full sync mode to host.`}
+				break
+			}
+			if strings.HasPrefix(err.Error(), "host identity verification failed") {
+				err = ErrExplain{err, `The pool rejected the host's enode URI because it does not match the host's node key. Check that --enode and --nodekey belong to the same node.`}
+				break
+			}
+			if err.Error() == pool.ErrTooManyHosts.Error() {
+				err = ErrExplain{err, `The pool has too many hosts registered from your IP address. Try again later or contact the pool operator for help.`}
+				break
+			}
+			if strings.HasSuffix(err.Error(), "request timestamp is outside of the allowed clock skew") {
+				err = ErrExplain{err, `The pool rejected the request because it was signed too long ago, or your system clock does not match the pool's. Check that your system clock is synchronized.`}
+				break
+			}
+			var noCompatErr pool.NoCompatibleHostsError
+			if errResp, ok := err.(*jsonrpc2.ErrResponse); ok && errResp.UnmarshalData(&noCompatErr) == nil && len(noCompatErr.Available) > 0 {
+				err = ErrExplain{err, fmt.Sprintf(`The pool does not have any hosts for your kind of client right now, but it does have hosts of other kinds: %s. Try running one of those kinds of node, or try again later.`, strings.Join(noCompatErr.Available, ", "))}
+				break
+			}
 			fallthrough
 		default:
 			err = ErrExplain{err, fmt.Sprintf(`Unexpected RPC error occurred: %T (code %d). Please open an issue at https://github.com/vipnode/vipnode`, typedErr, typedErr.ErrorCode())}
@@ -6,10 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -19,14 +19,20 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/websocket"
 	"github.com/vipnode/vipnode/ethnode"
+	"github.com/vipnode/vipnode/internal/pretty"
+	"github.com/vipnode/vipnode/jsonrpc2"
 	ws "github.com/vipnode/vipnode/jsonrpc2/ws/gorilla"
 	"github.com/vipnode/vipnode/pool"
 	"github.com/vipnode/vipnode/pool/balance"
+	"github.com/vipnode/vipnode/pool/metrics"
 	"github.com/vipnode/vipnode/pool/payment"
 	"github.com/vipnode/vipnode/pool/status"
 	"github.com/vipnode/vipnode/pool/store"
 	badgerStore "github.com/vipnode/vipnode/pool/store/badger"
+	redisStore "github.com/vipnode/vipnode/pool/store/redis"
+	sqlStore "github.com/vipnode/vipnode/pool/store/sql"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -47,8 +53,23 @@ func runPool(options Options) error {
 	var storeDriver store.Store
 	switch options.Pool.Store {
 	case "memory":
-		storeDriver = store.MemoryStore()
+		memStore := store.MemoryStore()
+		storeDriver = memStore
 		defer storeDriver.Close()
+		if path := options.Pool.Snapshot; path != "" {
+			if err := memStore.RestoreFile(path); err != nil {
+				return err
+			}
+			logger.Infof("Memory store snapshots enabled: %s", path)
+			go func() {
+				for range time.Tick(time.Minute) {
+					if err := memStore.SnapshotFile(path); err != nil {
+						logger.Errorf("Failed to snapshot memory store: %s", err)
+					}
+				}
+			}()
+			defer memStore.SnapshotFile(path)
+		}
 	case "persist":
 		fallthrough
 	case "badger":
@@ -65,13 +86,42 @@ func runPool(options Options) error {
 		}
 		defer storeDriver.Close()
 		logger.Infof("Persistent store using badger backend: %s", dir)
+	case "redis":
+		var err error
+		storeDriver, err = redisStore.Open(options.Pool.RedisURL)
+		if err != nil {
+			return err
+		}
+		defer storeDriver.Close()
+		logger.Infof("Shared store using redis backend.")
+	case "postgres":
+		var err error
+		storeDriver, err = sqlStore.Open(options.Pool.PostgresURL)
+		if err != nil {
+			return err
+		}
+		defer storeDriver.Close()
+		logger.Infof("Shared store using postgres backend.")
 	default:
 		return errors.New("storage driver not implemented")
 	}
 
+	// Purge old nonces periodically, since not all stores expire them.
+	go func() {
+		for range time.Tick(store.ExpireNonce) {
+			n, err := storeDriver.PurgeNonces(context.Background(), time.Now().Add(-store.ExpireNonce))
+			if err != nil {
+				logger.Errorf("Failed to purge nonces: %s", err)
+			} else if n > 0 {
+				logger.Debugf("Purged %d expired nonces.", n)
+			}
+		}
+	}()
+
 	balanceStore := store.BalanceStore(storeDriver)
 	var settleHandler payment.SettleHandler
 	var depositGetter func(ctx context.Context) (*big.Int, error)
+	var withdrawableGetter func(ctx context.Context, account store.Account) (*big.Int, error)
 	if options.Pool.Contract.Addr != "" {
 		// Payment contract implements NodeBalanceStore used by the balance
 		// manager, but with contract awareness.
@@ -114,7 +164,7 @@ func runPool(options Options) error {
 			logger.Warningf("Contract payment starting in read-only mode because --contract-keystore was not set. Withdraw and settlement attempts will fail.")
 		}
 
-		contract, err := payment.ContractPayment(storeDriver, contractAddr, ethclient, transactOpts)
+		contract, err := payment.ContractPayment(storeDriver, contractAddr, ethclient, transactOpts, options.Pool.Contract.BalanceWorkers)
 		if err != nil {
 			if err, ok := err.(payment.AddressMismatchError); ok {
 				return ErrExplain{
@@ -124,29 +174,66 @@ func runPool(options Options) error {
 			}
 			return err
 		}
+		contract.SubmitTimeout = options.Pool.Contract.SubmitTimeout
+		contract.ConfirmTimeout = options.Pool.Contract.ConfirmTimeout
 		balanceStore = contract
 		settleHandler = contract.OpSettle
 
 		depositGetter = func(ctx context.Context) (*big.Int, error) {
 			return ethclient.PendingBalanceAt(ctx, contractAddr)
 		}
+		// Withdraws can't pay out more than the contract holds. The cap is
+		// contract-wide on purpose: host credit is only tracked by the pool,
+		// so there's no per-account amount on-chain, and OpSettle pays it out
+		// of the contract's total funds.
+		withdrawableGetter = func(ctx context.Context, account store.Account) (*big.Int, error) {
+			return ethclient.PendingBalanceAt(ctx, contractAddr)
+		}
 	}
 
 	// Setup balance manager
 	creditPerInterval := big.NewInt(int64(options.Pool.Contract.Price))
-	balanceManager := balance.PayPerInterval(
-		balanceStore,
-		time.Minute*1, // Interval
-		creditPerInterval,
-	)
-
-	if options.Pool.Contract.MinBalance != "off" {
-		minBalance, err := strconv.ParseInt(options.Pool.Contract.MinBalance, 0, 64)
+	var balanceManager balance.Manager
+	if options.Pool.Contract.TrialCredit != "" {
+		trialCredit, err := pretty.ParseCredit(options.Pool.Contract.TrialCredit, pretty.Wei)
 		if err != nil {
 			return err
 		}
+		trialBalance := balance.TrialBalance(
+			balanceStore,
+			time.Minute*1, // Interval
+			creditPerInterval,
+			trialCredit,
+		)
+		trialBalance.CreditHostReports = options.Pool.Contract.CreditHostReports
+		trialBalance.ConnectGrace = options.Pool.Contract.ConnectGrace
+		trialBalance.DisableTrial = options.Pool.Contract.DisableTrial
+		if err := setKindPrices(trialBalance, options.Pool.Contract.KindPrice); err != nil {
+			return err
+		}
+		balanceManager = trialBalance
+	} else {
+		payPerInterval := balance.PayPerInterval(
+			balanceStore,
+			time.Minute*1, // Interval
+			creditPerInterval,
+		)
+		payPerInterval.CreditHostReports = options.Pool.Contract.CreditHostReports
+		payPerInterval.ConnectGrace = options.Pool.Contract.ConnectGrace
+		payPerInterval.DisableTrial = options.Pool.Contract.DisableTrial
+		if err := setKindPrices(payPerInterval, options.Pool.Contract.KindPrice); err != nil {
+			return err
+		}
+
+		if options.Pool.Contract.MinBalance != "off" {
+			minBalance, err := pretty.ParseCredit(options.Pool.Contract.MinBalance, pretty.Wei)
+			if err != nil {
+				return err
+			}
 
-		balanceManager.MinBalance = big.NewInt(minBalance)
+			payPerInterval.MinBalance = minBalance
+		}
+		balanceManager = payPerInterval
 	}
 
 	// Setup welcome message template
@@ -162,6 +249,57 @@ func runPool(options Options) error {
 
 	p := pool.New(storeDriver, balanceManager)
 	p.Version = fmt.Sprintf("vipnode/pool/%s", Version)
+	p.NumRequestHosts = options.Pool.NumRequestHosts
+	p.MaxCreditedHosts = options.Pool.MaxCreditedHosts
+	p.MaxHostsPerSource = options.Pool.MaxSourceHosts
+	switch options.Pool.HostSelection {
+	case "least-peers":
+		p.HostSelector = store.LeastPeersSelector{}
+	case "least-clients":
+		p.HostSelector = store.LeastClientsSelector{}
+	case "balance":
+		p.HostSelector = store.BalanceWeightedSelector{Balances: storeDriver}
+	}
+	p.MinHostVersion = options.Pool.MinHostVersion
+	p.VerifyHostPeers = options.Pool.VerifyHostPeers
+	p.HostRegistrationLimit = options.Pool.HostRateLimit
+	p.HostRegistrationWindow = time.Hour
+	p.PayoutChangeCooldown = options.Pool.PayoutCooldown
+	p.WhitelistTimeout = options.Pool.WhitelistTimeout
+	p.MaxRequestSkew = options.Pool.MaxRequestSkew
+	p.Operators = options.Pool.Operators
+	if options.Pool.StatsD != "" {
+		conn, err := net.Dial("udp", options.Pool.StatsD)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		p.Metrics = metrics.StatsD(conn)
+	}
+	p.InstanceID = options.Pool.InstanceID
+	if len(options.Pool.InstancePeers) > 0 {
+		instances := map[string]jsonrpc2.Service{}
+		for _, peer := range options.Pool.InstancePeers {
+			parts := strings.SplitN(peer, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid --instance-peer value, must be id=url: %q", peer)
+			}
+			instances[parts[0]] = &jsonrpc2.HTTPService{Endpoint: parts[1]}
+		}
+		p.InstanceRouter = pool.RelayRouter(instances)
+	}
+	if options.Pool.InstanceBind != "" {
+		instanceServer := &jsonrpc2.HTTPServer{}
+		if err := instanceServer.Register("instance_", &pool.InstanceService{Pool: p}); err != nil {
+			return err
+		}
+		logger.Infof("Serving internal instance RPC on: %s", options.Pool.InstanceBind)
+		go func() {
+			if err := http.ListenAndServe(options.Pool.InstanceBind, instanceServer); err != nil {
+				logger.Errorf("Internal instance RPC failed: %s", err)
+			}
+		}()
+	}
 	p.ClientMessager = func(nodeID string) string {
 		var buf bytes.Buffer
 		err := welcomeTmpl.Execute(&buf, struct {
@@ -176,9 +314,32 @@ func runPool(options Options) error {
 		return buf.String()
 	}
 
+	// Remove nodes that stopped updating without disconnecting.
+	go func() {
+		for range time.Tick(store.ExpireInterval) {
+			n, err := p.ExpireNodes(context.Background(), p.Clock.Now().Add(-store.ExpireNodeInterval))
+			if err != nil {
+				logger.Errorf("Failed to expire nodes: %s", err)
+			} else if n > 0 {
+				logger.Debugf("Expired %d inactive nodes.", n)
+			}
+		}
+	}()
+
+	wsOptions := options.Pool.WebSocket
 	handler := &server{
-		ws:     &ws.Upgrader{},
-		header: http.Header{},
+		ws: &ws.Upgrader{
+			Upgrader: websocket.Upgrader{
+				HandshakeTimeout: wsOptions.HandshakeTimeout,
+				ReadBufferSize:   wsOptions.ReadBufferSize,
+				WriteBufferSize:  wsOptions.WriteBufferSize,
+			},
+		},
+		header:   http.Header{},
+		debugLog: options.DebugRPC,
+
+		pendingLimit:   wsOptions.PendingLimit,
+		pendingDiscard: wsOptions.PendingDiscard,
 	}
 	if options.Pool.AllowOrigin != "" {
 		handler.header.Set("Access-Control-Allow-Origin", options.Pool.AllowOrigin)
@@ -199,8 +360,9 @@ func runPool(options Options) error {
 			fee := big.NewInt(2500000000000000) // 0.0025 ETH
 			return amount.Sub(amount, fee)
 		},
-		WithdrawMin: big.NewInt(5000000000000000), // 0.005 ETH
-		Settle:      settleHandler,
+		WithdrawMin:  big.NewInt(5000000000000000), // 0.005 ETH
+		Settle:       settleHandler,
+		Withdrawable: withdrawableGetter,
 	}
 	if err := handler.Register("pool_", payment); err != nil {
 		return err
@@ -241,3 +403,16 @@ func unlockTransactor(keystorePath string) (*bind.TransactOpts, error) {
 	}
 	return bind.NewTransactor(r, pw)
 }
+
+// setKindPrices sets the price per interval of each client kind on the
+// balance manager.
+func setKindPrices(manager interface {
+	SetKindRate(kind string, creditPerInterval *big.Int) error
+}, prices map[string]uint64) error {
+	for kind, price := range prices {
+		if err := manager.SetKindRate(kind, new(big.Int).SetUint64(price)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
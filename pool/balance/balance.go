@@ -1,12 +1,24 @@
 package balance
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/vipnode/vipnode/pool/store"
 )
 
+// ErrNoBalance is returned by a Manager's OnUpdate when it does not track a
+// balance for the node, so there is no balance to report. It is not a
+// failure.
+var ErrNoBalance = errors.New("node has no balance")
+
+// ErrDepositRequired is returned by OnConnect when the trial is disabled and
+// the client has no linked account with a funded balance.
+var ErrDepositRequired = errors.New("deposit required: node has no funded account")
+
 // LowBalanceError is returned when the account's positive balance check fails.
 type LowBalanceError struct {
 	MinBalance     *big.Int
@@ -17,14 +29,55 @@ func (err LowBalanceError) Error() string {
 	return fmt.Sprintf("low balance error: Current balance (%d) is less than the required minimum (%d)", err.CurrentBalance, err.MinBalance)
 }
 
+// BalanceExhaustedError is returned when a client's balance runs out, such as
+// after its trial credit is spent. The pool disconnects the client.
+type BalanceExhaustedError struct {
+	NodeID store.NodeID
+	Credit *big.Int
+}
+
+func (err BalanceExhaustedError) Error() string {
+	return fmt.Sprintf("balance exhausted: node %s has %d credit remaining", err.NodeID, err.Credit)
+}
+
+// ErrCodeBalanceExhausted is the RPC error code of BalanceExhaustedError, so
+// that clients can tell that the pool disconnected them.
+const ErrCodeBalanceExhausted = -32001
+
+// ErrorCode returns ErrCodeBalanceExhausted, which is sent as the code of the
+// RPC error response.
+func (err BalanceExhaustedError) ErrorCode() int {
+	return ErrCodeBalanceExhausted
+}
+
+// ConnectRefusedError is returned by OnConnect to refuse a client's
+// connection, such as when its trial was already used.
+type ConnectRefusedError struct {
+	Reason string
+}
+
+func (err ConnectRefusedError) Error() string {
+	return fmt.Sprintf("connection refused: %s", err.Reason)
+}
+
 // Manager is the minimal interface required to support a payment scheme. The
 // payment implementation will receive handler calls.
-// TODO: OnConnect, OnDisconnect, etc? OnConnect would be useful for time-based trials.
-// TODO: Support error type that forces a disconnect (eg. trial expired?)
 type Manager interface {
-	// OnClient is called when a client connects to the pool. If an error is
-	// returned, the client is disconnected with the error.
-	OnClient(node store.Node) error
+	// OnConnect is called when a client connects to the pool. If an error is
+	// returned, such as ConnectRefusedError, then the client's connection is
+	// refused with the error.
+	OnConnect(ctx context.Context, node store.Node) error
+	// OnDisconnect is called when a node disconnects from the pool, after its
+	// final update is settled. It returns the node's final balance.
+	OnDisconnect(ctx context.Context, node store.Node) (store.Balance, error)
 	// OnUpdate is called every time the state of a node's peers is updated.
-	OnUpdate(node store.Node, peers []store.Node) (store.Balance, error)
+	// If the node is not billed, then ErrNoBalance is returned. If only some
+	// of the peers could be credited, then the balance is returned along with
+	// a store.BatchError of the failures.
+	OnUpdate(ctx context.Context, node store.Node, peers []store.Node) (store.Balance, error)
+	// ProjectCredit returns how much more credit a host would earn per
+	// interval if it served addPeers more clients, at the current pricing.
+	// It does not change any balances. If hosts are not credited, then
+	// ErrNoBalance is returned.
+	ProjectCredit(addPeers int) (credit *big.Int, interval time.Duration, err error)
 }
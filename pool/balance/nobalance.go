@@ -1,14 +1,28 @@
 package balance
 
-import "github.com/vipnode/vipnode/pool/store"
+import (
+	"context"
+	"math/big"
+	"time"
 
-// NoBalance always returns an empty balance
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// NoBalance does not track balances, so OnUpdate always returns ErrNoBalance.
 type NoBalance struct{}
 
-func (b NoBalance) OnUpdate(node store.Node, peers []store.Node) (store.Balance, error) {
-	return store.Balance{}, nil
+func (b NoBalance) OnUpdate(ctx context.Context, node store.Node, peers []store.Node) (store.Balance, error) {
+	return store.Balance{}, ErrNoBalance
 }
 
-func (b NoBalance) OnClient(node store.Node) error {
+func (b NoBalance) OnConnect(ctx context.Context, node store.Node) error {
 	return nil
 }
+
+func (b NoBalance) OnDisconnect(ctx context.Context, node store.Node) (store.Balance, error) {
+	return store.Balance{}, ErrNoBalance
+}
+
+func (b NoBalance) ProjectCredit(addPeers int) (*big.Int, time.Duration, error) {
+	return nil, 0, ErrNoBalance
+}
@@ -1,19 +1,27 @@
 package balance
 
 import (
+	"context"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
+	"github.com/vipnode/vipnode/pool/clock"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
+// defaultMaxCreditPeriod is the longest period that one update is credited
+// for when payPerInterval.MaxCreditPeriod is zero.
+const defaultMaxCreditPeriod = 5 * store.KeepaliveInterval
+
 // PayPerInterval creates a balance Manager which implements a pay-per-interval scheme.
 func PayPerInterval(storeDriver store.BalanceStore, interval time.Duration, creditPerInterval *big.Int) *payPerInterval {
 	return &payPerInterval{
 		Store:             storeDriver,
 		Interval:          interval,
 		CreditPerInterval: *creditPerInterval,
+		Clock:             clock.Real(),
 	}
 }
 
@@ -24,37 +32,187 @@ type payPerInterval struct {
 	// normalized by Interval to be the same over time.
 	Interval time.Duration
 	// CreditPerInterval is the cost per interval that gets credited to the host (and debited from the client)
+	// It's the default rate for clients whose kind has no rate set with
+	// SetKindRate.
 	CreditPerInterval big.Int
 	// MinBalance, if set, is the minimum balance a node must have before it gets errored out.
 	MinBalance *big.Int
+	// DisableTrial refuses clients that don't have a linked account with a
+	// funded balance, with ErrDepositRequired. Trial credit is not granted.
+	DisableTrial bool
+
+	// CreditHostReports also bills the client-host pairings that hosts
+	// report, instead of only the ones that clients report. Each pairing is
+	// still credited once for any period, whichever side reports it. Hosts
+	// could bill clients that aren't connected to them, so only enable this
+	// if hosts are trusted. Which periods were credited is only tracked in
+	// memory, so a pairing whose sides report to different pool instances
+	// that share a store can be credited twice. Don't enable this for
+	// multiple instances.
+	CreditHostReports bool
+
+	// ConnectGrace is how long a new client-host pairing goes unbilled, so
+	// that a client isn't charged for the time before it established its
+	// peers. The grace starts from the reporting node's previous update, so
+	// a new client's first update after connecting is free if it comes
+	// within the grace.
+	ConnectGrace time.Duration
+
+	// MaxCreditPeriod is the longest period that a single update is
+	// credited for. Longer gaps since the previous update, such as from a
+	// LastSeen that is stale after a pool restart, are only credited for
+	// MaxCreditPeriod so that they don't mint an unbounded credit. If zero,
+	// then five keepalive intervals are credited at most.
+	MaxCreditPeriod time.Duration
+
+	// Clock is used to measure intervals. If nil, the real clock is used.
+	Clock clock.Clock
+
+	mu sync.Mutex
+	// credited is when each client-host pairing was last credited. The
+	// times come from Clock, so with the real clock they are compared by
+	// their monotonic reading. They're only kept in memory, see
+	// CreditHostReports.
+	credited map[pairing]creditMark
+	// kindRates are the credits per interval that were set with
+	// SetKindRate, by client kind.
+	kindRates map[string]*big.Int
+}
+
+// pairing is a client connected to a host, which is what gets billed.
+type pairing struct {
+	client store.NodeID
+	host   store.NodeID
+}
 
-	// now is used for testing to override time-based behaviour
-	now func() time.Time
+// creditMark is how far a pairing has been credited.
+type creditMark struct {
+	until time.Time
+	// retry is set if crediting the period after until failed, so the next
+	// credit starts from until even if the reporting node was seen later.
+	retry bool
 }
 
-func (b *payPerInterval) intervalCredit(lastSeen time.Time) *big.Int {
-	if b.now == nil {
-		b.now = time.Now
+func (b *payPerInterval) now() time.Time {
+	if b.Clock == nil {
+		b.Clock = clock.Real()
+	}
+	return b.Clock.Now()
+}
+
+// SetKindRate sets the credit per interval that clients of the kind pay, in
+// place of CreditPerInterval, such as to price light clients differently. An
+// empty kind replaces the default rate for the kinds without their own. It's
+// safe to call while the manager is in use, so that the pool can be repriced
+// without a restart.
+func (b *payPerInterval) SetKindRate(kind string, creditPerInterval *big.Int) error {
+	if creditPerInterval == nil || creditPerInterval.Sign() <= 0 {
+		return fmt.Errorf("payPerInterval: Invalid rate for kind %q: %d", kind, creditPerInterval)
+	}
+	b.mu.Lock()
+	if b.kindRates == nil {
+		b.kindRates = map[string]*big.Int{}
+	}
+	b.kindRates[kind] = new(big.Int).Set(creditPerInterval)
+	b.mu.Unlock()
+	return nil
+}
+
+// kindRate returns the credit per interval that clients of the kind pay. The
+// result must not be modified.
+func (b *payPerInterval) kindRate(kind string) *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rate, ok := b.kindRates[kind]; ok {
+		return rate
+	}
+	if rate, ok := b.kindRates[""]; ok {
+		return rate
 	}
-	delta := big.NewInt(int64(b.now().Sub(lastSeen)))
+	return &b.CreditPerInterval
+}
+
+// creditBetween returns the credit owed from start to end at the rate per
+// interval, for at most MaxCreditPeriod. If end is not after start, such as
+// when the wall clock moved backward since a LastSeen that was loaded from the
+// store, then nothing is owed. Times that come from the real clock carry a
+// monotonic reading, so intervals between them are not affected by wall clock
+// changes, but times loaded from the store lose it.
+func (b *payPerInterval) creditBetween(start, end time.Time, rate *big.Int) *big.Int {
+	if !end.After(start) {
+		return new(big.Int)
+	}
+	maxPeriod := b.MaxCreditPeriod
+	if maxPeriod <= 0 {
+		maxPeriod = defaultMaxCreditPeriod
+	}
+	period := end.Sub(start)
+	if period > maxPeriod {
+		period = maxPeriod
+	}
+	delta := big.NewInt(int64(period))
 	interval := big.NewInt(int64(b.Interval))
-	credit := new(big.Int).Mul(delta, &b.CreditPerInterval)
+	credit := new(big.Int).Mul(delta, rate)
 	return credit.Div(credit, interval)
 }
 
-// OnClient is called when a client connects to the pool. If an error is
-// returned, the client is disconnected with the error.
-func (b *payPerInterval) OnClient(node store.Node) error {
-	if b.MinBalance == nil {
+// pairingCredit returns the credit owed for a pairing up to now, and marks it
+// as credited. Credit is owed since the pairing was last credited, or since
+// the reporting node's previous update if that's later, so that a pairing
+// reported by both sides is only credited once. New pairings are owed from
+// the end of the ConnectGrace. If the credit fails to be added, then
+// creditFailed must be called with the returned start.
+func (b *payPerInterval) pairingCredit(pair pairing, lastSeen time.Time, now time.Time, rate *big.Int) (credit *big.Int, start time.Time) {
+	b.mu.Lock()
+	if b.credited == nil {
+		b.credited = map[pairing]creditMark{}
+	}
+	start = lastSeen
+	mark, ok := b.credited[pair]
+	if !ok {
+		mark.until = lastSeen.Add(b.ConnectGrace)
+	}
+	if mark.retry || mark.until.After(start) {
+		start = mark.until
+	}
+	if now.After(start) {
+		b.credited[pair] = creditMark{until: now}
+	} else if !ok {
+		// Remember when the grace ends for the next update
+		b.credited[pair] = creditMark{until: start}
+	}
+	b.mu.Unlock()
+
+	return b.creditBetween(start, now, rate), start
+}
+
+// creditFailed undoes pairingCredit's mark for a credit from start to now
+// that failed to be added, so that the period is credited by the next
+// update.
+func (b *payPerInterval) creditFailed(pair pairing, start time.Time, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if mark, ok := b.credited[pair]; ok && mark.until.Equal(now) {
+		b.credited[pair] = creditMark{until: start, retry: true}
+	}
+}
+
+// OnConnect is called when a client connects to the pool. If an error is
+// returned, the client's connection is refused with the error.
+func (b *payPerInterval) OnConnect(ctx context.Context, node store.Node) error {
+	if b.MinBalance == nil && (!b.DisableTrial || node.IsHost) {
 		return nil
 	}
-	balance, err := b.Store.GetNodeBalance(node.ID)
+	balance, err := b.Store.GetNodeBalance(ctx, node.ID)
 	if err != nil {
 		return err
 	}
 	// TODO: Write a test for this
 	total := new(big.Int).Add(&balance.Credit, &balance.Deposit)
-	if b.MinBalance.Cmp(total) > 0 {
+	if b.DisableTrial && !node.IsHost && !isFunded(balance, total) {
+		return ErrDepositRequired
+	}
+	if b.MinBalance != nil && b.MinBalance.Cmp(total) > 0 {
 		return LowBalanceError{
 			CurrentBalance: total,
 			MinBalance:     b.MinBalance,
@@ -63,29 +221,74 @@ func (b *payPerInterval) OnClient(node store.Node) error {
 	return nil
 }
 
+// isFunded returns whether the balance belongs to a linked account with a
+// positive total.
+func isFunded(balance store.Balance, total *big.Int) bool {
+	return balance.Account != "" && total.Sign() > 0
+}
+
+// OnDisconnect returns the node's final balance. The balance is already
+// settled by the final OnUpdate.
+func (b *payPerInterval) OnDisconnect(ctx context.Context, node store.Node) (store.Balance, error) {
+	b.mu.Lock()
+	for pair := range b.credited {
+		if pair.client == node.ID || pair.host == node.ID {
+			delete(b.credited, pair)
+		}
+	}
+	b.mu.Unlock()
+	return b.Store.GetNodeBalance(ctx, node.ID)
+}
+
 // OnUpdate takes a node instance (with a LastSeen timestamp of the previous
-// update) and the current active peers.
-func (b *payPerInterval) OnUpdate(node store.Node, peers []store.Node) (store.Balance, error) {
-	if node.IsHost {
+// update) and the current active peers. Credit only flows from clients to
+// their hosts, once per pairing for any period of time. Peers that can't be
+// credited are reported in a store.BatchError, returned along with the
+// node's balance.
+func (b *payPerInterval) OnUpdate(ctx context.Context, node store.Node, peers []store.Node) (store.Balance, error) {
+	if node.IsHost && !b.CreditHostReports {
 		// We ignore host updates, only update balance on client updates. If
 		// client fails to update, then the host will disconnect.
-		return b.Store.GetNodeBalance(node.ID)
+		return b.Store.GetNodeBalance(ctx, node.ID)
 	}
 	if b.Interval <= 0 || b.CreditPerInterval.Cmp(new(big.Int)) == 0 {
 		// FIXME: Ideally this should be caught earlier. Maybe move to an earlier On* callback once we have more. Also check to make sure the values are big enough for the int64/float64 math.
 		return store.Balance{}, fmt.Errorf("payPerInterval: Invalid interval settings: %d per %s", &b.CreditPerInterval, b.Interval)
 	}
-
-	credit := b.intervalCredit(node.LastSeen)
-	if credit.Cmp(new(big.Int)) == 0 {
-		// No time passed?
-		return b.Store.GetNodeBalance(node.ID)
+	if node.IsHost {
+		return b.onHostUpdate(ctx, node, peers)
 	}
 
-	total := new(big.Int)
+	// Peers that fail to be credited don't stop the others, and the client
+	// is only charged for the peers that were credited. Peers that are
+	// clients themselves are not paid.
+	now := b.now()
+	rate := b.kindRate(node.Kind)
+	credits := make([]store.NodeCredit, 0, len(peers))
+	starts := make([]time.Time, 0, len(peers))
 	for _, peer := range peers {
-		b.Store.AddNodeBalance(peer.ID, credit)
-		total.Add(total, credit)
+		if !peer.IsHost {
+			continue
+		}
+		credit, start := b.pairingCredit(pairing{client: node.ID, host: peer.ID}, node.LastSeen, now, rate)
+		if credit.Sign() == 0 {
+			// No time passed?
+			continue
+		}
+		credits = append(credits, store.NodeCredit{NodeID: peer.ID, Credit: credit})
+		starts = append(starts, start)
+	}
+	if len(credits) == 0 {
+		return b.Store.GetNodeBalance(ctx, node.ID)
+	}
+	results, creditErr := store.AddNodeBalances(ctx, b.Store, credits)
+	total := new(big.Int)
+	for i, err := range results {
+		if err == nil {
+			total.Add(total, credits[i].Credit)
+		} else {
+			b.creditFailed(pairing{client: node.ID, host: credits[i].NodeID}, starts[i], now)
+		}
 	}
 
 	// If this comparison is in the wrong place, it could make the pool
@@ -99,13 +302,64 @@ func (b *payPerInterval) OnUpdate(node store.Node, peers []store.Node) (store.Ba
 		}
 	}
 
-	if err := b.Store.AddNodeBalance(node.ID, new(big.Int).Neg(total)); err != nil {
+	if err := b.Store.AddNodeBalance(ctx, node.ID, new(big.Int).Neg(total)); err != nil {
 		return store.Balance{}, err
 	}
-	balance, err := b.Store.GetNodeBalance(node.ID)
+	balance, err := b.Store.GetNodeBalance(ctx, node.ID)
+	if err != nil {
+		return balance, err
+	}
+	return balance, creditErr
+}
+
+// onHostUpdate charges the clients that a host reports for any time that
+// their own updates haven't already paid for, and credits the host.
+func (b *payPerInterval) onHostUpdate(ctx context.Context, node store.Node, peers []store.Node) (store.Balance, error) {
+	now := b.now()
+	charges := make([]store.NodeCredit, 0, len(peers))
+	starts := make([]time.Time, 0, len(peers))
+	for _, peer := range peers {
+		if peer.IsHost {
+			continue
+		}
+		credit, start := b.pairingCredit(pairing{client: peer.ID, host: node.ID}, node.LastSeen, now, b.kindRate(peer.Kind))
+		if credit.Sign() == 0 {
+			continue
+		}
+		charges = append(charges, store.NodeCredit{NodeID: peer.ID, Credit: credit.Neg(credit)})
+		starts = append(starts, start)
+	}
+	results, chargeErr := store.AddNodeBalances(ctx, b.Store, charges)
+	total := new(big.Int)
+	for i, err := range results {
+		if err == nil {
+			total.Sub(total, charges[i].Credit)
+		} else {
+			b.creditFailed(pairing{client: charges[i].NodeID, host: node.ID}, starts[i], now)
+		}
+	}
+	if total.Sign() != 0 {
+		if err := b.Store.AddNodeBalance(ctx, node.ID, total); err != nil {
+			return store.Balance{}, err
+		}
+	}
+	balance, err := b.Store.GetNodeBalance(ctx, node.ID)
 	if err != nil {
 		return balance, err
 	}
+	return balance, chargeErr
+}
 
-	return b.Store.GetNodeBalance(node.ID)
+// ProjectCredit returns the default credit per interval for each of the
+// added peers, which is what the host would be credited for serving them for
+// one Interval.
+func (b *payPerInterval) ProjectCredit(addPeers int) (*big.Int, time.Duration, error) {
+	if b.Interval <= 0 || b.CreditPerInterval.Cmp(new(big.Int)) == 0 {
+		return nil, 0, fmt.Errorf("payPerInterval: Invalid interval settings: %d per %s", &b.CreditPerInterval, b.Interval)
+	}
+	if addPeers < 0 {
+		return nil, 0, fmt.Errorf("payPerInterval: Invalid number of peers to project: %d", addPeers)
+	}
+	credit := new(big.Int).Mul(b.kindRate(""), big.NewInt(int64(addPeers)))
+	return credit, b.Interval, nil
 }
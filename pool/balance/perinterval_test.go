@@ -1,37 +1,127 @@
 package balance
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/big"
 	"testing"
 	"time"
 
+	"github.com/vipnode/vipnode/pool/clock"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
 func TestPerIntervalCredit(t *testing.T) {
 	now := time.Now()
 	balanceManager := &payPerInterval{
-		Interval:          time.Minute * 1,
-		CreditPerInterval: *big.NewInt(1000),
-		now:               func() time.Time { return now },
+		Interval: time.Minute * 1,
 	}
 
-	amount := balanceManager.intervalCredit(now.Add(-time.Minute * 2))
+	amount := balanceManager.creditBetween(now.Add(-time.Minute*2), now, big.NewInt(1000))
 	if got, want := amount.Int64(), int64(2000); want != got {
 		t.Errorf("got: %d; want: %d", got, want)
 	}
 }
 
+func TestPerIntervalCreditClockBackward(t *testing.T) {
+	now := time.Now()
+	balanceManager := &payPerInterval{
+		Interval: time.Minute * 1,
+	}
+
+	// LastSeen is ahead of the clock, such as after an NTP correction
+	amount := balanceManager.creditBetween(now.Add(time.Minute*2), now, big.NewInt(1000))
+	if got := amount.Int64(); got != 0 {
+		t.Errorf("got: %d; want: 0", got)
+	}
+}
+
+func TestPerIntervalCreditMaxPeriod(t *testing.T) {
+	now := time.Now()
+	balanceManager := &payPerInterval{
+		Interval: time.Minute * 1,
+	}
+
+	// LastSeen is stale, such as after a pool restart
+	amount := balanceManager.creditBetween(now.Add(-time.Hour*3), now, big.NewInt(1000))
+	if got, want := amount.Int64(), int64(5000); got != want {
+		t.Errorf("default max period: got: %d; want: %d", got, want)
+	}
+
+	balanceManager.MaxCreditPeriod = time.Minute * 2
+	amount = balanceManager.creditBetween(now.Add(-time.Hour*3), now, big.NewInt(1000))
+	if got, want := amount.Int64(), int64(2000); got != want {
+		t.Errorf("custom max period: got: %d; want: %d", got, want)
+	}
+}
+
+func TestPerIntervalPairingCredit(t *testing.T) {
+	now := time.Now()
+	balanceManager := &payPerInterval{
+		Interval:     time.Minute * 1,
+		ConnectGrace: time.Minute * 1,
+	}
+	rate := big.NewInt(1000)
+	pairingCredit := func(pair pairing, lastSeen time.Time, now time.Time) int64 {
+		credit, _ := balanceManager.pairingCredit(pair, lastSeen, now, rate)
+		return credit.Int64()
+	}
+	pair := pairing{client: "client", host: "host"}
+	lastSeen := now.Add(-time.Minute * 3)
+
+	// New pairings are owed from the end of the grace
+	if got, want := pairingCredit(pair, lastSeen, now), int64(2000); got != want {
+		t.Errorf("new pairing: got: %d; want: %d", got, want)
+	}
+
+	// The other side of the pairing reports the same period
+	if got := pairingCredit(pair, lastSeen, now); got != 0 {
+		t.Errorf("credited twice: got: %d; want: 0", got)
+	}
+
+	// Only the time since the pairing was last credited is owed
+	now = now.Add(time.Minute * 1)
+	if got, want := pairingCredit(pair, lastSeen, now), int64(1000); got != want {
+		t.Errorf("since last credit: got: %d; want: %d", got, want)
+	}
+
+	// A credit that failed is owed again, even after the node was seen
+	failedAt := now
+	now = now.Add(time.Minute * 1)
+	credit, start := balanceManager.pairingCredit(pair, failedAt, now, rate)
+	if got, want := credit.Int64(), int64(1000); got != want {
+		t.Errorf("before failure: got: %d; want: %d", got, want)
+	}
+	balanceManager.creditFailed(pair, start, now)
+	now = now.Add(time.Minute * 1)
+	if got, want := pairingCredit(pair, now.Add(-time.Minute), now), int64(2000); got != want {
+		t.Errorf("after failure: got: %d; want: %d", got, want)
+	}
+
+	// A pairing still within its grace is owed nothing, even later
+	other := pairing{client: "client", host: "other"}
+	if got := pairingCredit(other, now, now.Add(time.Second*30)); got != 0 {
+		t.Errorf("within grace: got: %d; want: 0", got)
+	}
+	if got, want := pairingCredit(other, now.Add(time.Second*30), now.Add(time.Minute*2)), int64(1000); got != want {
+		t.Errorf("after grace: got: %d; want: %d", got, want)
+	}
+}
+
 func TestPerInterval(t *testing.T) {
+	ctx := context.Background()
 	storeDriver := store.MemoryStore()
 
-	now := time.Now()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
 	balanceManager := &payPerInterval{
 		Store:             storeDriver,
 		Interval:          time.Minute * 1,
 		CreditPerInterval: *big.NewInt(1000),
-		now:               func() time.Time { return now },
+		Clock:             fakeClock,
 	}
+	now := fakeClock.Now()
 
 	nodes := []store.Node{}
 	{
@@ -47,7 +137,7 @@ func TestPerInterval(t *testing.T) {
 				IsHost:   id == "a",
 			}
 			nodes = append(nodes, node)
-			if err := storeDriver.SetNode(node); err != nil {
+			if err := storeDriver.SetNode(ctx, node); err != nil {
 				t.Fatal(err)
 			}
 		}
@@ -55,7 +145,7 @@ func TestPerInterval(t *testing.T) {
 
 	check := func(node store.Node, peers []store.Node, wantBalance int64) {
 		t.Helper()
-		balance, err := balanceManager.OnUpdate(node, peers)
+		balance, err := balanceManager.OnUpdate(ctx, node, peers)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -69,15 +159,503 @@ func TestPerInterval(t *testing.T) {
 
 	nodes[0].LastSeen = now
 	nodes[1].LastSeen = now
-	now = now.Add(time.Minute * 5)
+	fakeClock.Add(time.Minute * 5)
+	now = fakeClock.Now()
 
 	check(nodes[1], nodes[0:1], -5000)
 	check(nodes[0], nodes[1:], 5000) // host
 
 	nodes[0].LastSeen = now
 	nodes[1].LastSeen = now
-	now = now.Add(time.Minute * 2)
+	fakeClock.Add(time.Minute * 2)
+	now = fakeClock.Now()
 
 	check(nodes[1], nodes[0:1], -7000)
 	check(nodes[0], nodes[1:], 7000) // host
 }
+
+// failingBalanceStore fails to credit one node.
+type failingBalanceStore struct {
+	store.BalanceStore
+	failID store.NodeID
+}
+
+func (s failingBalanceStore) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
+	if nodeID == s.failID {
+		return errors.New("credit failed")
+	}
+	return s.BalanceStore.AddNodeBalance(ctx, nodeID, credit)
+}
+
+func TestPerIntervalPartialCredit(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             failingBalanceStore{storeDriver, "b"},
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		Clock:             fakeClock,
+	}
+
+	client := store.Node{ID: "c", LastSeen: fakeClock.Now()}
+	peers := []store.Node{
+		{ID: "a", IsHost: true, LastSeen: fakeClock.Now()},
+		{ID: "b", IsHost: true, LastSeen: fakeClock.Now()},
+		{ID: "d", IsHost: true, LastSeen: fakeClock.Now()},
+	}
+	for _, n := range append(peers, client) {
+		if err := storeDriver.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fakeClock.Add(time.Minute)
+	balance, err := balanceManager.OnUpdate(ctx, client, peers)
+	batchErr, ok := err.(store.BatchError)
+	if !ok {
+		t.Fatalf("expected BatchError, got: %v", err)
+	}
+	if batchErr.Total != 3 || len(batchErr.Failed) != 1 || batchErr.Failed[0].ID != "b" {
+		t.Errorf("wrong batch error: %s", batchErr)
+	}
+
+	// The client is only charged for the hosts that were credited
+	if got, want := balance.Credit.Int64(), int64(-2000); got != want {
+		t.Errorf("client balance: got %d; want %d", got, want)
+	}
+	for id, want := range map[store.NodeID]int64{"a": 1000, "b": 0, "d": 1000} {
+		b, err := storeDriver.GetNodeBalance(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.Credit.Int64(); got != want {
+			t.Errorf("host %s balance: got %d; want %d", id, got, want)
+		}
+	}
+
+	// The period that failed is credited by the next update
+	balanceManager.Store = storeDriver
+	client.LastSeen = fakeClock.Now()
+	fakeClock.Add(time.Minute)
+	balance, err = balanceManager.OnUpdate(ctx, client, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := balance.Credit.Int64(), int64(-6000); got != want {
+		t.Errorf("client balance after retry: got %d; want %d", got, want)
+	}
+	for id, want := range map[store.NodeID]int64{"a": 2000, "b": 2000, "d": 2000} {
+		b, err := storeDriver.GetNodeBalance(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.Credit.Int64(); got != want {
+			t.Errorf("host %s balance after retry: got %d; want %d", id, got, want)
+		}
+	}
+}
+
+func TestPerIntervalProjectCredit(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		Clock:             fakeClock,
+	}
+
+	const addPeers = 3
+	credit, interval, err := balanceManager.ProjectCredit(addPeers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval != balanceManager.Interval {
+		t.Errorf("got interval %s; want %s", interval, balanceManager.Interval)
+	}
+	if got, want := credit.Int64(), int64(addPeers*1000); got != want {
+		t.Errorf("got projected credit %d; want %d", got, want)
+	}
+
+	// The projection matches what the host is credited after serving that
+	// many clients for one interval, and it did not change any balances.
+	host := store.Node{ID: "host", IsHost: true, LastSeen: fakeClock.Now()}
+	if err := storeDriver.SetNode(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if balance, err := storeDriver.GetNodeBalance(ctx, host.ID); err != nil {
+		t.Fatal(err)
+	} else if balance.Credit.Sign() != 0 {
+		t.Errorf("projection changed the host balance: %d", &balance.Credit)
+	}
+	clients := []store.Node{}
+	for _, id := range []store.NodeID{"a", "b", "c"} {
+		client := store.Node{ID: id, LastSeen: fakeClock.Now()}
+		if err := storeDriver.SetNode(ctx, client); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	fakeClock.Add(interval)
+	for _, client := range clients {
+		if _, err := balanceManager.OnUpdate(ctx, client, []store.Node{host}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	balance, err := storeDriver.GetNodeBalance(ctx, host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Credit.Cmp(credit) != 0 {
+		t.Errorf("host was credited %d; projected %d", &balance.Credit, credit)
+	}
+
+	if _, _, err := balanceManager.ProjectCredit(-1); err == nil {
+		t.Error("expected an error for a negative peer count")
+	}
+}
+
+func TestPerIntervalPairing(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		CreditHostReports: true,
+		Clock:             fakeClock,
+	}
+
+	host := store.Node{ID: "a", IsHost: true, LastSeen: fakeClock.Now()}
+	client := store.Node{ID: "b", LastSeen: fakeClock.Now()}
+	otherClient := store.Node{ID: "c", LastSeen: fakeClock.Now()}
+	for _, n := range []store.Node{host, client, otherClient} {
+		if err := storeDriver.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(node *store.Node, peers ...store.Node) {
+		t.Helper()
+		if _, err := balanceManager.OnUpdate(ctx, *node, peers); err != nil {
+			t.Fatal(err)
+		}
+		node.LastSeen = fakeClock.Now()
+	}
+	check := func(node store.Node, want int64) {
+		t.Helper()
+		balance, err := storeDriver.GetNodeBalance(ctx, node.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := balance.Credit.Int64(); got != want {
+			t.Errorf("node %s: got credit %d; want %d", node.ID, got, want)
+		}
+	}
+
+	// Both sides report the pairing in the same interval, client first.
+	fakeClock.Add(time.Minute)
+	update(&client, host)
+	update(&host, client)
+	check(host, 1000)
+	check(client, -1000)
+
+	// Both sides report it again, host first.
+	fakeClock.Add(time.Minute)
+	update(&host, client)
+	update(&client, host)
+	check(host, 2000)
+	check(client, -2000)
+
+	// Only the host reports it.
+	fakeClock.Add(time.Minute)
+	update(&host, client)
+	check(host, 3000)
+	check(client, -3000)
+
+	// The client catches up, but that period was already credited.
+	fakeClock.Add(time.Minute)
+	update(&client, host)
+	check(host, 4000)
+	check(client, -4000)
+
+	// Clients don't pay other clients, whichever side reports it.
+	fakeClock.Add(time.Minute)
+	update(&client, host, otherClient)
+	update(&otherClient, client)
+	check(host, 5000)
+	check(client, -5000)
+	check(otherClient, 0)
+}
+
+func TestPerIntervalConnectGrace(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		ConnectGrace:      time.Minute + 30*time.Second,
+		Clock:             fakeClock,
+	}
+
+	host := store.Node{ID: "a", IsHost: true, LastSeen: fakeClock.Now()}
+	client := store.Node{ID: "b", LastSeen: fakeClock.Now()}
+	for _, n := range []store.Node{host, client} {
+		if err := storeDriver.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(want int64) {
+		t.Helper()
+		balance, err := balanceManager.OnUpdate(ctx, client, []store.Node{host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := balance.Credit.Int64(); got != want {
+			t.Errorf("got client credit %d; want %d", got, want)
+		}
+		client.LastSeen = fakeClock.Now()
+	}
+
+	// The first update after connecting is within the grace
+	fakeClock.Add(time.Minute)
+	update(0)
+
+	// The next update is prorated from the end of the grace
+	fakeClock.Add(time.Minute)
+	update(-500)
+
+	fakeClock.Add(time.Minute)
+	update(-1500)
+}
+
+func TestPerIntervalClockBackward(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		CreditHostReports: true,
+		Clock:             fakeClock,
+	}
+
+	host := store.Node{ID: "a", IsHost: true, LastSeen: fakeClock.Now()}
+	client := store.Node{ID: "b", LastSeen: fakeClock.Now()}
+	for _, n := range []store.Node{host, client} {
+		if err := storeDriver.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func(node *store.Node, peers ...store.Node) {
+		t.Helper()
+		if _, err := balanceManager.OnUpdate(ctx, *node, peers); err != nil {
+			t.Fatal(err)
+		}
+		node.LastSeen = fakeClock.Now()
+	}
+	check := func(node store.Node, want int64) {
+		t.Helper()
+		balance, err := storeDriver.GetNodeBalance(ctx, node.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := balance.Credit.Int64(); got != want {
+			t.Errorf("node %s: got credit %d; want %d", node.ID, got, want)
+		}
+	}
+
+	fakeClock.Add(time.Minute)
+	update(&client, host)
+	check(host, 1000)
+	check(client, -1000)
+
+	// The clock moves backward, no credit flows in either direction
+	fakeClock.Add(-time.Minute * 5)
+	update(&client, host)
+	update(&host, client)
+	check(host, 1000)
+	check(client, -1000)
+
+	// Billing resumes once the clock passes the last credited time
+	fakeClock.Add(time.Minute * 6)
+	update(&client, host)
+	check(host, 2000)
+	check(client, -2000)
+}
+
+// countingStore counts the balance store transactions.
+type countingStore struct {
+	store.BalanceStore
+	txns int
+}
+
+func (s *countingStore) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
+	s.txns += 1
+	return s.BalanceStore.AddNodeBalance(ctx, nodeID, credit)
+}
+
+// countingBatchStore is a countingStore that also supports batches.
+type countingBatchStore struct {
+	*countingStore
+}
+
+func (s countingBatchStore) AddNodeBalances(ctx context.Context, credits map[store.NodeID]*big.Int) error {
+	s.txns += 1
+	return s.BalanceStore.(store.BatchBalanceStore).AddNodeBalances(ctx, credits)
+}
+
+func BenchmarkPerIntervalOnUpdate(b *testing.B) {
+	ctx := context.Background()
+	const numPeers = 50
+
+	run := func(b *testing.B, batch bool) {
+		storeDriver := store.MemoryStore()
+		fakeClock := clock.NewFake(time.Now())
+		storeDriver.Clock = fakeClock
+		counter := &countingStore{BalanceStore: storeDriver}
+		var balanceStore store.BalanceStore = counter
+		if batch {
+			balanceStore = countingBatchStore{counter}
+		}
+		balanceManager := &payPerInterval{
+			Store:             balanceStore,
+			Interval:          time.Minute * 1,
+			CreditPerInterval: *big.NewInt(1000),
+			CreditHostReports: true,
+			Clock:             fakeClock,
+		}
+
+		host := store.Node{ID: "host", IsHost: true, LastSeen: fakeClock.Now()}
+		if err := storeDriver.SetNode(ctx, host); err != nil {
+			b.Fatal(err)
+		}
+		peers := make([]store.Node, 0, numPeers)
+		for i := 0; i < numPeers; i++ {
+			peer := store.Node{ID: store.NodeID(fmt.Sprintf("client%d", i)), LastSeen: fakeClock.Now()}
+			if err := storeDriver.SetNode(ctx, peer); err != nil {
+				b.Fatal(err)
+			}
+			peers = append(peers, peer)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			fakeClock.Add(time.Minute)
+			if _, err := balanceManager.OnUpdate(ctx, host, peers); err != nil {
+				b.Fatal(err)
+			}
+			host.LastSeen = fakeClock.Now()
+		}
+		b.ReportMetric(float64(counter.txns)/float64(b.N), "txns/op")
+	}
+
+	b.Run("PerPeer", func(b *testing.B) { run(b, false) })
+	b.Run("Batch", func(b *testing.B) { run(b, true) })
+}
+
+func TestPerIntervalRestartGap(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		MaxCreditPeriod:   time.Minute * 3,
+		Clock:             fakeClock,
+	}
+
+	host := store.Node{ID: "a", IsHost: true, LastSeen: fakeClock.Now()}
+	client := store.Node{ID: "b", LastSeen: fakeClock.Now()}
+	for _, n := range []store.Node{host, client} {
+		if err := storeDriver.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pool was down for hours, the client's LastSeen is stale
+	fakeClock.Add(time.Hour * 4)
+	balance, err := balanceManager.OnUpdate(ctx, client, []store.Node{host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := balance.Credit.Int64(), int64(-3000); got != want {
+		t.Errorf("got client credit %d; want %d", got, want)
+	}
+	hostBalance, err := storeDriver.GetNodeBalance(ctx, host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hostBalance.Credit.Int64(), int64(3000); got != want {
+		t.Errorf("got host credit %d; want %d", got, want)
+	}
+}
+
+func TestPerIntervalKindRates(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	balanceManager := &payPerInterval{
+		Store:             storeDriver,
+		Interval:          time.Minute * 1,
+		CreditPerInterval: *big.NewInt(1000),
+		Clock:             fakeClock,
+	}
+	if err := balanceManager.SetKindRate("les", big.NewInt(250)); err != nil {
+		t.Fatal(err)
+	}
+	if err := balanceManager.SetKindRate("parity", big.NewInt(0)); err == nil {
+		t.Error("expected error for a zero rate")
+	}
+
+	host := store.Node{ID: "host", IsHost: true, Kind: "geth"}
+	full := store.Node{ID: "full", Kind: "geth"}
+	light := store.Node{ID: "light", Kind: "les"}
+	for _, node := range []store.Node{host, full, light} {
+		if err := storeDriver.SetNode(context.Background(), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update := func(wantFull, wantLight int64) {
+		t.Helper()
+		for _, client := range []store.Node{full, light} {
+			client.LastSeen = fakeClock.Now().Add(-2 * time.Minute)
+			if _, err := balanceManager.OnUpdate(ctx, client, []store.Node{host}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for id, want := range map[store.NodeID]int64{full.ID: wantFull, light.ID: wantLight, host.ID: -wantFull - wantLight} {
+			if balance, err := storeDriver.GetNodeBalance(ctx, id); err != nil {
+				t.Fatal(err)
+			} else if got := balance.Credit.Int64(); got != want {
+				t.Errorf("%s: got balance %d; want %d", id, got, want)
+			}
+		}
+	}
+
+	// Both kinds pay for the same interval at their own rate.
+	fakeClock.Add(time.Minute * 2)
+	update(-2000, -500)
+
+	// Repricing the default applies to kinds without their own rate.
+	if err := balanceManager.SetKindRate("", big.NewInt(2000)); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Add(time.Minute * 2)
+	update(-6000, -1000)
+}
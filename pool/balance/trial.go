@@ -0,0 +1,82 @@
+package balance
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// TrialBalance creates a balance Manager which grants trialCredit to new
+// clients when they connect, then charges them per interval like
+// PayPerInterval. Once a client's balance drops below zero, OnUpdate returns
+// BalanceExhaustedError.
+func TrialBalance(storeDriver store.BalanceStore, interval time.Duration, creditPerInterval *big.Int, trialCredit *big.Int) *trialBalance {
+	return &trialBalance{
+		payPerInterval: payPerInterval{
+			Store:             storeDriver,
+			Interval:          interval,
+			CreditPerInterval: *creditPerInterval,
+			Clock:             clock.Real(),
+		},
+		TrialCredit: *trialCredit,
+	}
+}
+
+type trialBalance struct {
+	payPerInterval
+
+	// TrialCredit is granted to clients that connect with an empty balance.
+	TrialCredit big.Int
+}
+
+// OnConnect grants the trial credit to clients that have never had a
+// balance, once per client as recorded by the store. Clients whose balance
+// was already exhausted are refused. If
+// DisableTrial is set, then no credit is granted and clients without a funded
+// account are refused with ErrDepositRequired.
+func (b *trialBalance) OnConnect(ctx context.Context, node store.Node) error {
+	if node.IsHost {
+		return nil
+	}
+	balance, err := b.Store.GetNodeBalance(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	total := new(big.Int).Add(&balance.Credit, &balance.Deposit)
+	if b.DisableTrial && !isFunded(balance, total) {
+		return ErrDepositRequired
+	}
+
+	if !balance.TrialGranted && total.Sign() == 0 && balance.Account == "" {
+		granted, err := b.Store.GrantTrialCredit(ctx, node.ID, &b.TrialCredit)
+		if err != nil || granted {
+			return err
+		}
+		// Granted concurrently, such as by another pool instance
+		if balance, err = b.Store.GetNodeBalance(ctx, node.ID); err != nil {
+			return err
+		}
+		total.Add(&balance.Credit, &balance.Deposit)
+	}
+	if total.Sign() <= 0 {
+		return BalanceExhaustedError{NodeID: node.ID, Credit: total}
+	}
+	return nil
+}
+
+// OnUpdate charges the client like PayPerInterval, and returns
+// BalanceExhaustedError along with the balance once it drops below zero.
+func (b *trialBalance) OnUpdate(ctx context.Context, node store.Node, peers []store.Node) (store.Balance, error) {
+	balance, err := b.payPerInterval.OnUpdate(ctx, node, peers)
+	if _, partial := err.(store.BatchError); (err != nil && !partial) || node.IsHost {
+		return balance, err
+	}
+	total := new(big.Int).Add(&balance.Credit, &balance.Deposit)
+	if total.Sign() < 0 {
+		return balance, BalanceExhaustedError{NodeID: node.ID, Credit: total}
+	}
+	return balance, err
+}
@@ -0,0 +1,143 @@
+package balance
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+func TestTrialBalance(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	fakeClock := clock.NewFake(time.Now())
+	storeDriver.Clock = fakeClock
+
+	balanceManager := TrialBalance(storeDriver, time.Minute, big.NewInt(1000), big.NewInt(2500))
+	balanceManager.Clock = fakeClock
+
+	host := store.Node{ID: "a", IsHost: true, LastSeen: fakeClock.Now()}
+	client := store.Node{ID: "b", LastSeen: fakeClock.Now()}
+	for _, node := range []store.Node{host, client} {
+		if err := storeDriver.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := balanceManager.OnConnect(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if balance, err := storeDriver.GetNodeBalance(ctx, client.ID); err != nil {
+		t.Fatal(err)
+	} else if got, want := balance.Credit.Int64(), int64(2500); got != want {
+		t.Errorf("trial credit: got %d; want %d", got, want)
+	}
+
+	// Reconnecting doesn't grant the trial again
+	if err := balanceManager.OnConnect(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(wantCredit int64) error {
+		t.Helper()
+		fakeClock.Add(time.Minute * 2)
+		balance, err := balanceManager.OnUpdate(ctx, client, []store.Node{host})
+		client.LastSeen = fakeClock.Now()
+		if got := balance.Credit.Int64(); got != wantCredit {
+			t.Errorf("credit: got %d; want %d", got, wantCredit)
+		}
+		return err
+	}
+
+	if err := update(500); err != nil {
+		t.Fatal(err)
+	}
+	err := update(-1500)
+	exhaustedErr, ok := err.(BalanceExhaustedError)
+	if !ok {
+		t.Fatalf("expected BalanceExhaustedError, got: %v", err)
+	}
+	if exhaustedErr.NodeID != client.ID || exhaustedErr.Credit.Int64() != -1500 {
+		t.Errorf("unexpected error: %s", exhaustedErr)
+	}
+
+	// Exhausted clients are refused
+	if _, ok := balanceManager.OnConnect(ctx, client).(BalanceExhaustedError); !ok {
+		t.Errorf("expected exhausted client to be refused")
+	}
+}
+
+func TestTrialGrantedOnce(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	client := store.Node{ID: "b", LastSeen: time.Now()}
+	if err := storeDriver.SetNode(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TrialBalance(storeDriver, time.Minute, big.NewInt(1000), big.NewInt(2500)).OnConnect(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	// Spend the trial down to exactly zero
+	if err := storeDriver.AddNodeBalance(ctx, client.ID, big.NewInt(-2500)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new manager, such as after a restart or on another pool instance,
+	// doesn't grant the trial again.
+	balanceManager := TrialBalance(storeDriver, time.Minute, big.NewInt(1000), big.NewInt(2500))
+	if _, ok := balanceManager.OnConnect(ctx, client).(BalanceExhaustedError); !ok {
+		t.Errorf("expected client with a spent trial to be refused")
+	}
+	if balance, err := storeDriver.GetNodeBalance(ctx, client.ID); err != nil {
+		t.Fatal(err)
+	} else if balance.Credit.Sign() != 0 {
+		t.Errorf("trial was granted again: %s", &balance.Credit)
+	}
+}
+
+func TestTrialDisabled(t *testing.T) {
+	ctx := context.Background()
+	storeDriver := store.MemoryStore()
+	trialBalance := TrialBalance(storeDriver, time.Minute, big.NewInt(1000), big.NewInt(2500))
+	trialBalance.DisableTrial = true
+	payPerInterval := PayPerInterval(storeDriver, time.Minute, big.NewInt(1000))
+	payPerInterval.DisableTrial = true
+
+	unfunded := store.Node{ID: "a"}
+	funded := store.Node{ID: "b"}
+	host := store.Node{ID: "c", IsHost: true}
+	for _, node := range []store.Node{unfunded, funded, host} {
+		if err := storeDriver.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storeDriver.AddAccountNode(ctx, "0xb", funded.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeDriver.AddAccountBalance(ctx, "0xb", big.NewInt(5000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, manager := range []Manager{trialBalance, payPerInterval} {
+		if err := manager.OnConnect(ctx, unfunded); err != ErrDepositRequired {
+			t.Errorf("%T: unfunded client: got %v; want ErrDepositRequired", manager, err)
+		}
+		if err := manager.OnConnect(ctx, funded); err != nil {
+			t.Errorf("%T: funded client: %s", manager, err)
+		}
+		if err := manager.OnConnect(ctx, host); err != nil {
+			t.Errorf("%T: host: %s", manager, err)
+		}
+	}
+
+	// No trial credit was granted
+	if balance, err := storeDriver.GetNodeBalance(ctx, unfunded.ID); err != nil {
+		t.Fatal(err)
+	} else if balance.Credit.Sign() != 0 {
+		t.Errorf("unexpected trial credit: %d", &balance.Credit)
+	}
+}
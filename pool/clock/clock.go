@@ -0,0 +1,56 @@
+// Package clock provides a pluggable source of time, so that time-dependent
+// behaviour can be tested deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real returns a Clock that uses the system time.
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewFake returns a Fake clock that starts at the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Fake is a Clock that only changes when it's told to. It's goroutine-safe.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Now returns the fake clock's current time.
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Add advances the fake clock by d.
+func (c *Fake) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set changes the fake clock's current time to now.
+func (c *Fake) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
@@ -1,10 +1,45 @@
 package pool
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
+// ErrQuotaExceeded is returned when a client requests more hosts while it is
+// already connected to its quota of hosts.
+var ErrQuotaExceeded = errors.New("host quota exceeded")
+
+// ErrTooManyHosts is returned when a host registration is rejected because
+// too many hosts are registered, or were recently registered, from the same
+// source.
+var ErrTooManyHosts = errors.New("too many hosts registered from this source")
+
+// ErrNotHost is returned when a host-only method is called by a node that is
+// not registered as a host.
+var ErrNotHost = errors.New("node is not registered as a host")
+
+// ErrNotOperator is returned when an operator-only method is called by a node
+// that is not one of the pool's operators.
+var ErrNotOperator = errors.New("node is not a pool operator")
+
+// ErrSigningKeyUnsupported is returned when a node tries to bind a signing
+// key, but the pool's store can't save signing keys.
+var ErrSigningKeyUnsupported = errors.New("pool store does not support signing keys")
+
+// ErrPoolClosed is returned when a request needs work that can't be started
+// after the pool was closed.
+var ErrPoolClosed = errors.New("pool is closed")
+
+// ErrAccountScanUnsupported is returned when an operator asks for the
+// accounts above a balance, but the pool's store can't scan its accounts.
+var ErrAccountScanUnsupported = errors.New("pool store does not support scanning accounts")
+
+// ErrPayoutChangeTooSoon is returned when a host registers with a different
+// payout account within the pool's PayoutChangeCooldown of its last change.
+var ErrPayoutChangeTooSoon = errors.New("payout account changed too recently")
+
 // NoHostNodesError is returned when the pool does not have any hosts available.
 type NoHostNodesError struct {
 	NumTried int
@@ -17,6 +52,120 @@ func (err NoHostNodesError) Error() string {
 	return fmt.Sprintf("no available host nodes found after trying %d nodes", err.NumTried)
 }
 
+// NoCompatibleHostsError is returned when the pool has active hosts, but none
+// of them are of the requested kind.
+type NoCompatibleHostsError struct {
+	Kind      string   `json:"kind"`
+	Available []string `json:"available"`
+}
+
+func (err NoCompatibleHostsError) Error() string {
+	return fmt.Sprintf("no compatible host nodes available for kind %q, available kinds: %s", err.Kind, strings.Join(err.Available, ", "))
+}
+
+// ErrorData returns the requested kind and the available kinds, which is sent
+// in the data field of the RPC error response.
+func (err NoCompatibleHostsError) ErrorData() interface{} {
+	return err
+}
+
+// WhitelistQuorumError is returned when fewer hosts accepted a client's
+// whitelist request than the pool's WhitelistStrategy requires.
+type WhitelistQuorumError struct {
+	Quorum   int
+	Accepted int
+}
+
+func (err WhitelistQuorumError) Error() string {
+	return fmt.Sprintf("whitelist quorum not reached: %d of %d required hosts accepted", err.Accepted, err.Quorum)
+}
+
+// UpdateRoleError is returned when a node sends an update for a role that it
+// did not register as, such as a client update from a registered host.
+type UpdateRoleError struct {
+	Role   string
+	IsHost bool
+}
+
+func (err UpdateRoleError) Error() string {
+	registered := RoleClient
+	if err.IsHost {
+		registered = RoleHost
+	}
+	return fmt.Sprintf("invalid update: node sent a %q update but is registered as a %q", err.Role, registered)
+}
+
+// HostIdentityError is returned when a host registers with a NodeURI that
+// does not match its signed node ID. Nothing is registered, so the host can
+// retry with a corrected NodeURI on the same connection.
+type HostIdentityError struct {
+	NodeURI string
+	Cause   error
+}
+
+func (err HostIdentityError) Error() string {
+	return fmt.Sprintf("host identity verification failed for %q: %s", err.NodeURI, err.Cause)
+}
+
+// InvalidNodeURIError is returned when a host registers with a NodeURI that
+// is malformed, such as with another scheme or without a host and port that
+// clients could dial.
+type InvalidNodeURIError struct {
+	NodeURI string
+	Reason  string
+}
+
+func (err InvalidNodeURIError) Error() string {
+	return fmt.Sprintf("invalid node URI %q: %s", err.NodeURI, err.Reason)
+}
+
+// HostInstanceError is returned when a host's connection is held by another
+// pool instance that this instance has no route to.
+type HostInstanceError struct {
+	NodeID   string
+	Instance string
+}
+
+func (err HostInstanceError) Error() string {
+	return fmt.Sprintf("host %q is connected to another pool instance: %q", err.NodeID, err.Instance)
+}
+
+// LightHostError is returned when a host registers with a light node, which
+// can't serve other light clients.
+type LightHostError struct {
+	Kind string
+}
+
+func (err LightHostError) Error() string {
+	return fmt.Sprintf("light node host rejected: %q host is not a full node and can't serve clients", err.Kind)
+}
+
+// HostVersionError is returned when a host registers with a vipnode agent
+// version that is older than the pool's minimum host version.
+type HostVersionError struct {
+	Version    string
+	MinVersion string
+}
+
+func (err HostVersionError) Error() string {
+	version := err.Version
+	if version == "" {
+		version = "unknown"
+	}
+	return fmt.Sprintf("host version rejected: %s is older than the minimum host version %s, please upgrade vipnode", version, err.MinVersion)
+}
+
+// RateLimitedError is returned when a node calls a method more often than
+// the pool's RateLimits allow. The node can retry after RetryAfter.
+type RateLimitedError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (err RateLimitedError) Error() string {
+	return fmt.Sprintf("method %q is rate limited, retry after %s", err.Method, err.RetryAfter)
+}
+
 // VerifyFailedError is returned when a signature fails to verify. It embeds
 // the underlying Cause.
 type VerifyFailedError struct {
@@ -49,3 +198,16 @@ func (err RemoteHostErrors) Error() string {
 	}
 	return s.String()
 }
+
+// ErrorData returns the method and each host's error message, which is sent
+// in the data field of the RPC error response.
+func (err RemoteHostErrors) ErrorData() interface{} {
+	errors := make([]string, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		errors = append(errors, e.Error())
+	}
+	return struct {
+		Method string   `json:"method"`
+		Errors []string `json:"errors"`
+	}{err.Method, errors}
+}
@@ -0,0 +1,78 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// InstanceService is an internal RPC service that lets other pool instances
+// call hosts whose connection is held by Pool. It's meant to be registered
+// with the "instance_" prefix on an endpoint that only other pool instances
+// can reach, since its calls are not signed.
+type InstanceService struct {
+	Pool *VipnodePool
+}
+
+func (s *InstanceService) host(hostID string) (jsonrpc2.Service, error) {
+	service, ok := s.Pool.remoteHosts.Get(store.NodeID(hostID))
+	if !ok {
+		return nil, fmt.Errorf("missing remote service for host: %q", hostID)
+	}
+	return service, nil
+}
+
+// Whitelist calls vipnode_whitelist for the client on a host that is
+// connected to this instance.
+func (s *InstanceService) Whitelist(ctx context.Context, hostID string, clientID string) error {
+	service, err := s.host(hostID)
+	if err != nil {
+		return err
+	}
+	return service.Call(ctx, nil, "vipnode_whitelist", clientID)
+}
+
+// Disconnect calls vipnode_disconnect for the client on a host that is
+// connected to this instance.
+func (s *InstanceService) Disconnect(ctx context.Context, hostID string, clientID string) error {
+	service, err := s.host(hostID)
+	if err != nil {
+		return err
+	}
+	return service.Call(ctx, nil, "vipnode_disconnect", clientID)
+}
+
+// RelayRouter returns an InstanceRouter that relays host calls through the
+// InstanceService of the pool instance that holds the host's connection.
+// Instances maps instance IDs to a connection to their InstanceService.
+func RelayRouter(instances map[string]jsonrpc2.Service) func(ctx context.Context, instanceID string, hostID store.NodeID) (jsonrpc2.Service, error) {
+	return func(ctx context.Context, instanceID string, hostID store.NodeID) (jsonrpc2.Service, error) {
+		service, ok := instances[instanceID]
+		if !ok {
+			return nil, HostInstanceError{string(hostID), instanceID}
+		}
+		return &instanceRelay{service: service, hostID: string(hostID)}, nil
+	}
+}
+
+// instanceRelay is a jsonrpc2.Service for a host that is connected to another
+// pool instance. Only the calls that the pool makes on hosts are relayed.
+type instanceRelay struct {
+	service jsonrpc2.Service
+	hostID  string
+}
+
+func (r *instanceRelay) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	var relayMethod string
+	switch method {
+	case "vipnode_whitelist":
+		relayMethod = "instance_whitelist"
+	case "vipnode_disconnect":
+		relayMethod = "instance_disconnect"
+	default:
+		return fmt.Errorf("method can't be relayed to another pool instance: %q", method)
+	}
+	return r.service.Call(ctx, result, relayMethod, append([]interface{}{r.hostID}, params...)...)
+}
@@ -0,0 +1,96 @@
+package pool
+
+import (
+	"context"
+	"strings"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// defaultProtocols is the light client protocol that each node
+// implementation speaks, used when a kind doesn't specify one.
+var defaultProtocols = map[string]string{
+	"geth":   "les",
+	"parity": "pip",
+}
+
+// Kind describes a node's implementation and the light client protocol that
+// it speaks, encoded as "client/protocol" such as "geth/les" or "parity/pip".
+// A host can only serve clients that speak the same protocol.
+//
+// A bare implementation name such as "geth" implies its default protocol, so
+// "geth" and "geth/les" are interchangeable.
+type Kind struct {
+	Client   string
+	Protocol string
+}
+
+// ParseKind parses a kind string. An empty string returns the zero Kind,
+// which matches any kind.
+func ParseKind(s string) Kind {
+	k := Kind{Client: s}
+	if i := strings.Index(s, "/"); i >= 0 {
+		k.Client, k.Protocol = s[:i], s[i+1:]
+	}
+	if k.Protocol == "" {
+		k.Protocol = defaultProtocols[k.Client]
+	}
+	return k
+}
+
+func (k Kind) String() string {
+	if k.Protocol == "" || k.Protocol == defaultProtocols[k.Client] {
+		return k.Client
+	}
+	return k.Client + "/" + k.Protocol
+}
+
+// IsZero returns true if the kind is unspecified.
+func (k Kind) IsZero() bool {
+	return k.Client == "" && k.Protocol == ""
+}
+
+// Matches returns true if a node of the other kind is compatible with this
+// kind. The zero Kind matches everything.
+func (k Kind) Matches(other Kind) bool {
+	if k.IsZero() {
+		return true
+	}
+	return k.Client == other.Client && k.Protocol == other.Protocol
+}
+
+// aliases returns the kind strings that a compatible node could have
+// registered with. The zero Kind is store.AnyKind.
+func (k Kind) aliases() []string {
+	if k.IsZero() {
+		return []string{store.AnyKind}
+	}
+	aliases := []string{k.String()}
+	if k.Protocol != "" {
+		if full := k.Client + "/" + k.Protocol; full != aliases[0] {
+			aliases = append(aliases, full)
+		}
+	}
+	return aliases
+}
+
+// activeHosts returns up to limit active hosts that match kind, across all of
+// the kind strings that the hosts could have registered with, chosen by the
+// selector. If limit is store.NoLimit, then all matching hosts are returned.
+func activeHosts(ctx context.Context, s store.Store, kind Kind, limit int, selector store.HostSelector) ([]store.Node, error) {
+	aliases := kind.aliases()
+	if len(aliases) == 1 {
+		return s.ActiveHosts(ctx, aliases[0], limit, selector)
+	}
+
+	var r []store.Node
+	for _, alias := range aliases {
+		hosts, err := s.ActiveHosts(ctx, alias, limit, selector)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, hosts...)
+	}
+	// Select again so that no alias is favoured when trimming to the limit.
+	return store.SelectHosts(ctx, selector, r, limit), nil
+}
@@ -0,0 +1,116 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+func TestParseKind(t *testing.T) {
+	testcases := []struct {
+		In     string
+		Want   Kind
+		String string
+	}{
+		{"", Kind{}, ""},
+		{"geth", Kind{"geth", "les"}, "geth"},
+		{"geth/les", Kind{"geth", "les"}, "geth"},
+		{"parity", Kind{"parity", "pip"}, "parity"},
+		{"parity/pip", Kind{"parity", "pip"}, "parity"},
+		{"parity/les", Kind{"parity", "les"}, "parity/les"},
+		{"foo", Kind{"foo", ""}, "foo"},
+		{"foo/bar", Kind{"foo", "bar"}, "foo/bar"},
+	}
+
+	for _, tc := range testcases {
+		got := ParseKind(tc.In)
+		if got != tc.Want {
+			t.Errorf("ParseKind(%q): got %+v; want %+v", tc.In, got, tc.Want)
+		}
+		if got.String() != tc.String {
+			t.Errorf("ParseKind(%q).String(): got %q; want %q", tc.In, got.String(), tc.String)
+		}
+	}
+}
+
+func TestKindMatches(t *testing.T) {
+	testcases := []struct {
+		Client string
+		Host   string
+		Want   bool
+	}{
+		{"", "geth", true},
+		{"", "parity/les", true},
+		{"geth", "geth", true},
+		{"geth", "geth/les", true},
+		{"geth/les", "geth", true},
+		{"geth", "parity", false},
+		{"parity", "parity/les", false},
+		{"parity/les", "parity/les", true},
+		{"parity/les", "geth", false},
+	}
+
+	for _, tc := range testcases {
+		if got := ParseKind(tc.Client).Matches(ParseKind(tc.Host)); got != tc.Want {
+			t.Errorf("%q matches %q: got %t; want %t", tc.Client, tc.Host, got, tc.Want)
+		}
+	}
+}
+
+func TestActiveHostsKind(t *testing.T) {
+	ctx := context.Background()
+	s := store.MemoryStore()
+	now := time.Now()
+	for _, node := range []store.Node{
+		{ID: "a", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "b", IsHost: true, Kind: "geth/les", LastSeen: now},
+		{ID: "c", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "d", IsHost: true, Kind: "parity/les", LastSeen: now},
+	} {
+		if err := s.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		Kind  string
+		Limit int
+		Want  []store.NodeID
+	}{
+		{"", store.NoLimit, []store.NodeID{"a", "b", "c", "d"}},
+		{"geth", store.NoLimit, []store.NodeID{"a", "b"}},
+		{"geth/les", store.NoLimit, []store.NodeID{"a", "b"}},
+		{"parity", store.NoLimit, []store.NodeID{"c"}},
+		{"parity/pip", store.NoLimit, []store.NodeID{"c"}},
+		{"parity/les", store.NoLimit, []store.NodeID{"d"}},
+		{"geth", 1, nil},
+	}
+
+	for _, tc := range testcases {
+		hosts, err := activeHosts(ctx, s, ParseKind(tc.Kind), tc.Limit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.Limit > 0 {
+			if len(hosts) != tc.Limit {
+				t.Errorf("%q: got %d hosts; want %d", tc.Kind, len(hosts), tc.Limit)
+			}
+			continue
+		}
+		got := map[store.NodeID]bool{}
+		for _, h := range hosts {
+			got[h.ID] = true
+		}
+		if len(got) != len(tc.Want) || len(hosts) != len(tc.Want) {
+			t.Errorf("%q: got %v; want %v", tc.Kind, hosts, tc.Want)
+			continue
+		}
+		for _, id := range tc.Want {
+			if !got[id] {
+				t.Errorf("%q: missing host %q in %v", tc.Kind, id, hosts)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"log"
@@ -18,3 +19,43 @@ func SetLogger(w io.Writer) {
 func init() {
 	SetLogger(ioutil.Discard)
 }
+
+// Logger receives the pool's log messages. The context carries the fields of
+// the request that the message is about, see LogFieldsFromContext, so that
+// implementations can emit structured logs. Loggers must be goroutine-safe.
+type Logger interface {
+	Printf(ctx context.Context, format string, v ...interface{})
+}
+
+// LogFields are the context fields of a pool request.
+type LogFields struct {
+	// NodeID is the node that made the request.
+	NodeID string
+	// Method is the RPC method of the request, such as "vipnode_update".
+	Method string
+}
+
+type logContext string
+
+var ctxLogFields logContext = "logfields"
+
+// withLogFields returns a context that carries the request's log fields.
+func withLogFields(ctx context.Context, nodeID string, method string) context.Context {
+	return context.WithValue(ctx, ctxLogFields, LogFields{NodeID: nodeID, Method: method})
+}
+
+// LogFieldsFromContext returns the request's log fields from the context
+// passed to a Logger. The fields are empty for messages that aren't about a
+// request.
+func LogFieldsFromContext(ctx context.Context) LogFields {
+	fields, _ := ctx.Value(ctxLogFields).(LogFields)
+	return fields
+}
+
+// packageLogger is the default Logger, it writes to the package logger that
+// is set with SetLogger.
+type packageLogger struct{}
+
+func (packageLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	logger.Printf(format, v...)
+}
@@ -0,0 +1,109 @@
+// Package metrics defines the events that a pool reports for monitoring, and
+// the Sink interface that adapts them to a metrics backend such as StatsD or
+// an OpenTelemetry meter.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Names of the counters that the pool reports.
+const (
+	// Connect counts nodes that connected to the pool, tagged by role
+	// ("host" or "client") and kind.
+	Connect = "vipnode.connect"
+	// Whitelist counts the whitelist requests sent to hosts on behalf of
+	// connecting clients, tagged by result ("accepted" or "failed").
+	Whitelist = "vipnode.whitelist"
+	// BalanceUpdate counts the balance changes from node updates, tagged by
+	// role and result ("billed", "unbilled", "frozen", "low_balance",
+	// "exhausted", or "error").
+	BalanceUpdate = "vipnode.balance_update"
+	// VerifyFailed counts the signed requests that failed verification,
+	// tagged by method.
+	VerifyFailed = "vipnode.verify_failed"
+	// RateLimited counts the signed requests that were refused by the
+	// pool's rate limits, tagged by method.
+	RateLimited = "vipnode.rate_limited"
+)
+
+// Tag is a dimension of a metric.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Sink receives the metrics reported by the pool. Each metrics backend needs
+// an adapter that implements Sink, such as StatsD below, or a wrapper around
+// an OpenTelemetry meter's counters. Sinks must be goroutine-safe.
+type Sink interface {
+	// Count adds delta to the counter with the given name and tags.
+	Count(name string, delta int64, tags ...Tag)
+}
+
+// Discard is a Sink that drops all metrics.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Count(name string, delta int64, tags ...Tag) {}
+
+// Multi returns a Sink that reports metrics to each of the sinks.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Count(name string, delta int64, tags ...Tag) {
+	for _, s := range m {
+		s.Count(name, delta, tags...)
+	}
+}
+
+// StatsD returns a Sink that writes counters to w in the StatsD line format,
+// with tags in the DogStatsD extension format. Each metric is written
+// separately, so w can be a UDP connection to a StatsD agent. Write errors
+// are ignored, since metrics are best-effort.
+func StatsD(w io.Writer) Sink {
+	return &statsdSink{w: w}
+}
+
+type statsdSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *statsdSink) Count(name string, delta int64, tags ...Tag) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:%d|c", name, delta)
+	for i, tag := range tags {
+		if i == 0 {
+			buf.WriteString("|#")
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(statsdEscape(tag.Key))
+		buf.WriteByte(':')
+		buf.WriteString(statsdEscape(tag.Value))
+	}
+	buf.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(buf.Bytes())
+}
+
+// statsdEscape replaces the characters that are reserved in the StatsD line
+// format.
+var statsdEscape = strings.NewReplacer(
+	":", "_",
+	"|", "_",
+	",", "_",
+	"#", "_",
+	"\n", "_",
+).Replace
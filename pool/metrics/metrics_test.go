@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStatsD(t *testing.T) {
+	var buf bytes.Buffer
+	sink := StatsD(&buf)
+	sink.Count(Connect, 1, Tag{"role", "client"}, Tag{"kind", "geth/les"})
+	sink.Count(VerifyFailed, 2, Tag{"method", "a:b|c"})
+	sink.Count("plain", -1)
+
+	want := "vipnode.connect:1|c|#role:client,kind:geth/les\n" +
+		"vipnode.verify_failed:2|c|#method:a_b_c\n" +
+		"plain:-1|c\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var a, b bytes.Buffer
+	Multi(StatsD(&a), Discard, StatsD(&b)).Count("foo", 1)
+	if a.String() != "foo:1|c\n" || b.String() != "foo:1|c\n" {
+		t.Errorf("missing metrics: %q, %q", a.String(), b.String())
+	}
+}
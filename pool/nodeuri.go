@@ -1,14 +1,18 @@
 package pool
 
 import (
-	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/vipnode/vipnode/internal/pretty"
 )
 
 // normalizeNodeURI takes an enode:// URI string and some defaults to replace any missing components.
+// Malformed URIs, such as with another scheme or without a usable host and
+// port, return InvalidNodeURIError.
 func normalizeNodeURI(nodeURI, nodeID, defaultHost, defaultPort string) (string, error) {
 	host, port := defaultHost, defaultPort
 
@@ -16,7 +20,16 @@ func normalizeNodeURI(nodeURI, nodeID, defaultHost, defaultPort string) (string,
 		// Confirm that nodeURI matches nodeID
 		uri, err := url.Parse(nodeURI)
 		if err != nil {
-			return "", err
+			return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: err.Error()}
+		}
+		if uri.Scheme != "enode" {
+			return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: fmt.Sprintf("scheme must be enode, not %q", uri.Scheme)}
+		}
+		if uri.Opaque != "" {
+			return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: "must be formatted as enode://<id>@<host>:<port>"}
+		}
+		if strings.HasSuffix(uri.Host, ":") {
+			return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: "missing port"}
 		}
 
 		if h := uri.Hostname(); h != "::" && h != "" {
@@ -33,13 +46,19 @@ func normalizeNodeURI(nodeURI, nodeID, defaultHost, defaultPort string) (string,
 	}
 
 	if host == "" || host == "[::]" {
-		return "", errors.New("NodeURI is missing host")
+		return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: "missing host"}
+	}
+	if port == "" {
+		return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: "missing port"}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", InvalidNodeURIError{NodeURI: nodeURI, Reason: fmt.Sprintf("invalid port %q", port)}
 	}
 
 	u := &url.URL{
 		Scheme: "enode",
 		User:   url.User(nodeID),
-		Host:   host + ":" + port,
+		Host:   net.JoinHostPort(host, port),
 	}
 	return u.String(), nil
 }
@@ -27,6 +27,14 @@ func TestNormalizeNodeURI(t *testing.T) {
 			"enode://aaaa@abc.com:30303",
 			false,
 		},
+		{
+			"enode://aaaa@[2001:db8::1]:30304",
+			"aaaa",
+			"foo.com",
+			"30303",
+			"enode://aaaa@[2001:db8::1]:30304",
+			false,
+		},
 		{
 			"enode://aaaa@abc.com",
 			"bbbb",
@@ -35,6 +43,54 @@ func TestNormalizeNodeURI(t *testing.T) {
 			"",
 			true,
 		},
+		{
+			"http://aaaa@abc.com:30303",
+			"aaaa",
+			"foo.com",
+			"30303",
+			"",
+			true,
+		},
+		{
+			"enode:aaaa@abc.com:30303",
+			"aaaa",
+			"foo.com",
+			"30303",
+			"",
+			true,
+		},
+		{
+			"enode://aaaa@abc.com:",
+			"aaaa",
+			"foo.com",
+			"30303",
+			"",
+			true,
+		},
+		{
+			"enode://aaaa@abc.com",
+			"aaaa",
+			"foo.com",
+			"",
+			"",
+			true,
+		},
+		{
+			"enode://aaaa@abc.com:99999",
+			"aaaa",
+			"foo.com",
+			"30303",
+			"",
+			true,
+		},
+		{
+			"enode://aaaa@",
+			"aaaa",
+			"",
+			"30303",
+			"",
+			true,
+		},
 	}
 
 	for i, tc := range testcases {
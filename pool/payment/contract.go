@@ -9,6 +9,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/vipnode/vipnode-contract/go/vipnodepool"
 	"github.com/vipnode/vipnode/pool/store"
 )
@@ -24,15 +25,44 @@ func (e AddressMismatchError) Error() string {
 	return fmt.Sprintf("%s: got %q; want %q", e.Prelude, e.Got.Hex(), e.Want.Hex())
 }
 
+// WithdrawTimeoutError is returned when a settlement transaction isn't
+// submitted or mined in time. TxID is set if the transaction was submitted, so
+// that it can be tracked manually since it may still be mined later.
+type WithdrawTimeoutError struct {
+	TxID    string
+	Timeout time.Duration
+}
+
+func (e WithdrawTimeoutError) Error() string {
+	if e.TxID == "" {
+		return fmt.Sprintf("withdraw timed out after %s while submitting the transaction", e.Timeout)
+	}
+	return fmt.Sprintf("withdraw timed out after %s while waiting for transaction %s to be mined", e.Timeout, e.TxID)
+}
+
 var zeroInt = &big.Int{}
 
+// defaultSubmitTimeout is used when contractPayment.SubmitTimeout is unset.
+const defaultSubmitTimeout = 30 * time.Second
+
+// defaultConfirmTimeout is used when contractPayment.ConfirmTimeout is unset.
+const defaultConfirmTimeout = 5 * time.Minute
+
+// Backend is an Ethereum backend that can call the payment contract and wait
+// for its transactions to be mined, such as *ethclient.Client.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+}
+
 // ErrDepositTimelocked is returned when a balance is checked but the deposit
 // is timelocked.
 var ErrDepositTimelocked = errors.New("deposit is timelocked")
 
 // ContractPayment returns an abstraction around a vipnode pool payment
-// contract. Contract implements store.NodeBalanceStore.
-func ContractPayment(storeDriver store.AccountStore, address common.Address, backend bind.ContractBackend, transactOpts *bind.TransactOpts) (*contractPayment, error) {
+// contract. Contract implements store.NodeBalanceStore. Balance events from
+// the contract are handled by up to balanceWorkers goroutines.
+func ContractPayment(storeDriver store.AccountStore, address common.Address, backend Backend, transactOpts *bind.TransactOpts, balanceWorkers int) (*contractPayment, error) {
 	contract, err := vipnodepool.NewVipnodePool(address, backend)
 	if err != nil {
 		return nil, err
@@ -43,6 +73,8 @@ func ContractPayment(storeDriver store.AccountStore, address common.Address, bac
 		contract:     contract,
 		backend:      backend,
 		transactOpts: transactOpts,
+
+		balanceWorkers: balanceWorkers,
 	}
 
 	if transactOpts != nil {
@@ -74,15 +106,24 @@ type contractPayment struct {
 	store        store.AccountStore
 	address      common.Address
 	contract     *vipnodepool.VipnodePool
-	backend      bind.ContractBackend
+	backend      Backend
 	balanceCache balanceCache
 	transactOpts *bind.TransactOpts
+
+	balanceWorkers int
+
+	// SubmitTimeout is how long OpSettle waits for the Ethereum node to
+	// accept a transaction. If zero, then 30 seconds is used.
+	SubmitTimeout time.Duration
+	// ConfirmTimeout is how long OpSettle waits for a submitted transaction
+	// to be mined. If zero, then 5 minutes is used.
+	ConfirmTimeout time.Duration
 }
 
 // GetNodeBalance proxies the normal store implementation
 // by adding the contract deposit to the resulting balance.
-func (p *contractPayment) GetNodeBalance(nodeID store.NodeID) (store.Balance, error) {
-	balance, err := p.store.GetNodeBalance(nodeID)
+func (p *contractPayment) GetNodeBalance(ctx context.Context, nodeID store.NodeID) (store.Balance, error) {
+	balance, err := p.store.GetNodeBalance(ctx, nodeID)
 	if err != nil {
 		return balance, err
 	}
@@ -102,13 +143,13 @@ func (p *contractPayment) GetNodeBalance(nodeID store.NodeID) (store.Balance, er
 }
 
 // AddNodeBalance proxies to the underlying store.BalanceStore
-func (p *contractPayment) AddNodeBalance(nodeID store.NodeID, credit *big.Int) error {
-	return p.store.AddNodeBalance(nodeID, credit)
+func (p *contractPayment) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
+	return p.store.AddNodeBalance(ctx, nodeID, credit)
 }
 
 // GetAccountBalance returns an account's balance, which includes the contract deposit.
-func (p *contractPayment) GetAccountBalance(account store.Account) (store.Balance, error) {
-	balance, err := p.store.GetAccountBalance(account)
+func (p *contractPayment) GetAccountBalance(ctx context.Context, account store.Account) (store.Balance, error) {
+	balance, err := p.store.GetAccountBalance(ctx, account)
 	if err != nil {
 		return balance, err
 	}
@@ -122,11 +163,20 @@ func (p *contractPayment) GetAccountBalance(account store.Account) (store.Balanc
 	return balance, nil
 }
 
+// GrantTrialCredit proxies to the underlying store.BalanceStore
+func (p *contractPayment) GrantTrialCredit(ctx context.Context, nodeID store.NodeID, credit *big.Int) (bool, error) {
+	return p.store.GrantTrialCredit(ctx, nodeID, credit)
+}
+
 // AddAccountBalance proxies to the underlying store.BalanceStore
-func (p *contractPayment) AddAccountBalance(account store.Account, credit *big.Int) error {
-	return p.store.AddAccountBalance(account, credit)
+func (p *contractPayment) AddAccountBalance(ctx context.Context, account store.Account, credit *big.Int) error {
+	return p.store.AddAccountBalance(ctx, account, credit)
 }
 
+// SubscribeBalance calls handler for each balance event emitted by the
+// contract. The handler is called from a bounded pool of workers, in order
+// for each account. If the handler falls behind, incoming events are held back until
+// it catches up.
 func (p *contractPayment) SubscribeBalance(ctx context.Context, handler func(account store.Account, amount *big.Int)) error {
 	sink := make(chan *vipnodepool.VipnodePoolBalance, 1)
 	sub, err := p.contract.WatchBalance(&bind.WatchOpts{
@@ -135,13 +185,17 @@ func (p *contractPayment) SubscribeBalance(ctx context.Context, handler func(acc
 	if err != nil {
 		return err
 	}
+	dispatcher := newBalanceDispatcher(p.balanceWorkers, handler)
 	eventHandler := func() error {
 		for {
 			select {
 			case balanceEvent := <-sink:
 				account := store.Account(balanceEvent.Account.Hex())
 				logger.Printf("SubscribeBalance: Processing event for account: %s", account)
-				go handler(account, balanceEvent.Balance)
+				if err := dispatcher.Dispatch(ctx, account, balanceEvent.Balance); err != nil {
+					sub.Unsubscribe()
+					return err
+				}
 			case err := <-sub.Err():
 				return err
 			case <-ctx.Done():
@@ -151,6 +205,7 @@ func (p *contractPayment) SubscribeBalance(ctx context.Context, handler func(acc
 		}
 	}
 	go func() {
+		defer dispatcher.Close()
 		// FIXME: This is a hacky retry loop because we don't have a convenient
 		// way to bubble up errors. Need to refactor someday.
 		retryTimeout := time.Minute * 10 // Abort if we fail more often than once in 10min
@@ -189,19 +244,57 @@ func (p *contractPayment) GetBalance(account store.Account) (*big.Int, error) {
 	return r.Balance, nil
 }
 
+func (p *contractPayment) submitTimeout() time.Duration {
+	if p.SubmitTimeout == 0 {
+		return defaultSubmitTimeout
+	}
+	return p.SubmitTimeout
+}
+
+func (p *contractPayment) confirmTimeout() time.Duration {
+	if p.ConfirmTimeout == 0 {
+		return defaultConfirmTimeout
+	}
+	return p.ConfirmTimeout
+}
+
 // OpSettle replaces the current on-chain balance for account with newBalance
-// and disburses withdrawAmount to the account wallet.
+// and disburses withdrawAmount to the account wallet. It returns once the
+// transaction is mined, or a WithdrawTimeoutError if that takes longer than
+// the configured timeouts.
 func (p *contractPayment) OpSettle(account store.Account, paymentAmount *big.Int, newBalance *big.Int) (tx string, err error) {
 	if p.transactOpts == nil {
 		return "", errors.New("OpSettle contract write failed: Payment provider is in read-only mode.")
 	}
 	addr := common.HexToAddress(string(account))
 
+	submitCtx, cancel := context.WithTimeout(context.Background(), p.submitTimeout())
+	defer cancel()
+	opts := *p.transactOpts
+	opts.Context = submitCtx
+
 	// TODO: Check balance of transactor/operator before executing transactions.
 	// TODO: p.contract.OpWithdraw occasionally, especially if operator is running low on funds to cover fees.
-	txn, err := p.contract.OpSettle(p.transactOpts, addr, paymentAmount, newBalance)
+	txn, err := p.contract.OpSettle(&opts, addr, paymentAmount, newBalance)
 	if err != nil {
+		if submitCtx.Err() == context.DeadlineExceeded {
+			return "", WithdrawTimeoutError{Timeout: p.submitTimeout()}
+		}
 		return "", err
 	}
-	return txn.Hash().Hex(), nil
+	tx = txn.Hash().Hex()
+
+	confirmCtx, cancel := context.WithTimeout(context.Background(), p.confirmTimeout())
+	defer cancel()
+	receipt, err := bind.WaitMined(confirmCtx, p.backend, txn)
+	if err == context.DeadlineExceeded {
+		return tx, WithdrawTimeoutError{TxID: tx, Timeout: p.confirmTimeout()}
+	}
+	if err != nil {
+		return tx, err
+	}
+	if receipt.Status == types.ReceiptStatusFailed {
+		return tx, fmt.Errorf("OpSettle transaction failed: %s", tx)
+	}
+	return tx, nil
 }
@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vipnode/vipnode-contract/go/vipnodepool"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// stuckBackend accepts transactions but never mines them. If blockSend is
+// set, then it doesn't accept them either.
+type stuckBackend struct {
+	Backend // Unused methods panic
+
+	blockSend bool
+	sent      []*types.Transaction
+}
+
+func (b *stuckBackend) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (b *stuckBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return 0, nil
+}
+
+func (b *stuckBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (b *stuckBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 100000, nil
+}
+
+func (b *stuckBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if b.blockSend {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+func (b *stuckBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return nil, ethereum.NotFound
+}
+
+func newStuckPayment(t *testing.T, backend *stuckBackend) *contractPayment {
+	address := common.HexToAddress("0xb2f8987986259facdc539ac1745f7a0b395972b1")
+	contract, err := vipnodepool.NewVipnodePool(address, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &contractPayment{
+		address:      address,
+		contract:     contract,
+		backend:      backend,
+		transactOpts: bind.NewKeyedTransactor(key),
+
+		SubmitTimeout:  50 * time.Millisecond,
+		ConfirmTimeout: 50 * time.Millisecond,
+	}
+}
+
+func TestOpSettleTimeout(t *testing.T) {
+	account := store.Account("0x961Aa96FebeE5465149a0787B03bFa14D8e9033F")
+
+	t.Run("confirm", func(t *testing.T) {
+		backend := &stuckBackend{}
+		p := newStuckPayment(t, backend)
+		tx, err := p.OpSettle(account, big.NewInt(42), big.NewInt(0))
+		if len(backend.sent) != 1 {
+			t.Fatalf("expected 1 sent transaction, got: %d", len(backend.sent))
+		}
+		want := backend.sent[0].Hash().Hex()
+		if tx != want {
+			t.Errorf("got tx %q; want %q", tx, want)
+		}
+		timeoutErr, ok := err.(WithdrawTimeoutError)
+		if !ok {
+			t.Fatalf("expected WithdrawTimeoutError, got: %v", err)
+		}
+		if timeoutErr.TxID != want {
+			t.Errorf("got error tx %q; want %q", timeoutErr.TxID, want)
+		}
+	})
+
+	t.Run("submit", func(t *testing.T) {
+		backend := &stuckBackend{blockSend: true}
+		p := newStuckPayment(t, backend)
+		tx, err := p.OpSettle(account, big.NewInt(42), big.NewInt(0))
+		if tx != "" {
+			t.Errorf("expected no tx, got: %q", tx)
+		}
+		if timeoutErr, ok := err.(WithdrawTimeoutError); !ok || timeoutErr.TxID != "" {
+			t.Errorf("expected WithdrawTimeoutError without a tx, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package payment
+
+import (
+	"context"
+	"hash/fnv"
+	"math/big"
+	"sync"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// defaultBalanceWorkers is used when a non-positive number of balance
+// handler workers is requested.
+const defaultBalanceWorkers = 4
+
+// balanceQueueSize is the number of pending events each worker buffers
+// before Dispatch blocks.
+const balanceQueueSize = 16
+
+type balanceEvent struct {
+	account store.Account
+	amount  *big.Int
+}
+
+// balanceDispatcher calls a balance handler from a fixed number of workers.
+// Events for the same account are always handled by the same worker, so they
+// are handled in the order they were dispatched. Dispatch blocks once the
+// account's worker falls behind, which pushes back on the event source
+// instead of spawning more goroutines.
+type balanceDispatcher struct {
+	handler func(account store.Account, amount *big.Int)
+	queues  []chan balanceEvent
+	wg      sync.WaitGroup
+}
+
+func newBalanceDispatcher(workers int, handler func(account store.Account, amount *big.Int)) *balanceDispatcher {
+	if workers <= 0 {
+		workers = defaultBalanceWorkers
+	}
+	d := &balanceDispatcher{
+		handler: handler,
+		queues:  make([]chan balanceEvent, workers),
+	}
+	d.wg.Add(workers)
+	for i := range d.queues {
+		d.queues[i] = make(chan balanceEvent, balanceQueueSize)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+func (d *balanceDispatcher) work(queue <-chan balanceEvent) {
+	defer d.wg.Done()
+	for event := range queue {
+		d.handler(event.account, event.amount)
+	}
+}
+
+// Dispatch queues an event for its account's worker. It blocks while that
+// worker's queue is full, or until ctx is done.
+func (d *balanceDispatcher) Dispatch(ctx context.Context, account store.Account, amount *big.Int) error {
+	h := fnv.New32a()
+	h.Write([]byte(account))
+	queue := d.queues[h.Sum32()%uint32(len(d.queues))]
+	select {
+	case queue <- balanceEvent{account, amount}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the workers once they have handled all of the queued events.
+// Dispatch must not be called after Close.
+func (d *balanceDispatcher) Close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,88 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+func TestBalanceDispatcher(t *testing.T) {
+	const workers = 3
+	const numAccounts = 10
+	const numEvents = 1000
+
+	var running, maxRunning int32
+	var mu sync.Mutex
+	got := map[store.Account][]int64{}
+
+	d := newBalanceDispatcher(workers, func(account store.Account, amount *big.Int) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Microsecond)
+
+		mu.Lock()
+		got[account] = append(got[account], amount.Int64())
+		mu.Unlock()
+	})
+
+	ctx := context.Background()
+	for i := 0; i < numEvents; i++ {
+		account := store.Account(fmt.Sprintf("0x%02d", i%numAccounts))
+		if err := d.Dispatch(ctx, account, big.NewInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	if max := atomic.LoadInt32(&maxRunning); max > workers {
+		t.Errorf("handler concurrency exceeded: got %d; want at most %d", max, workers)
+	}
+
+	total := 0
+	for account, amounts := range got {
+		total += len(amounts)
+		for i := 1; i < len(amounts); i++ {
+			if amounts[i] < amounts[i-1] {
+				t.Errorf("account %s: events out of order: %d after %d", account, amounts[i], amounts[i-1])
+				break
+			}
+		}
+	}
+	if total != numEvents {
+		t.Errorf("lost events: handled %d; want %d", total, numEvents)
+	}
+}
+
+func TestBalanceDispatcherBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	d := newBalanceDispatcher(1, func(account store.Account, amount *big.Int) {
+		<-release
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// One event is held by the worker and the rest fill its queue, so the
+	// next dispatch blocks until the context expires.
+	var err error
+	for i := 0; i <= balanceQueueSize+1 && err == nil; i++ {
+		err = d.Dispatch(ctx, store.Account("0x01"), big.NewInt(1))
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected blocked dispatch to time out, got: %v", err)
+	}
+
+	close(release)
+	d.Close()
+}
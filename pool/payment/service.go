@@ -25,6 +25,35 @@ func (err WithdrawBalanceMinimumError) Error() string {
 	return fmt.Sprintf("account balance (%d) is below the minimum required to withdraw (%d)", err.Balance, err.Minimum)
 }
 
+// WithdrawAmountError is returned when a partial withdraw amount is outside of
+// the range that the account can withdraw.
+type WithdrawAmountError struct {
+	Amount *big.Int
+	Min    *big.Int
+	Max    *big.Int
+}
+
+func (err WithdrawAmountError) Error() string {
+	return fmt.Sprintf("withdraw amount (%d) must be between %d and %d", err.Amount, err.Min, err.Max)
+}
+
+// ErrNothingWithdrawable is returned when an account has a balance to
+// withdraw, but none of it can be paid out on-chain.
+var ErrNothingWithdrawable = errors.New("no balance can be withdrawn on-chain right now, the pool operator has been notified")
+
+// ErrWithdrawFeeExceeded is returned when the withdraw fee is as much as the
+// amount being withdrawn, so nothing would be paid out.
+var ErrWithdrawFeeExceeded = errors.New("withdraw amount does not cover the withdraw fee")
+
+// WithdrawDiscrepancy describes a withdraw that was capped because the amount
+// credited to the account was more than could be paid out on-chain. The
+// difference remains in the account's balance.
+type WithdrawDiscrepancy struct {
+	Account      store.Account
+	Requested    *big.Int
+	Withdrawable *big.Int
+}
+
 // AccountResponse is returned on RPC calls to pool_account
 type AccountResponse struct {
 	NodeShortIDs []string      `json:"node_short_ids"`
@@ -49,14 +78,29 @@ type PaymentService struct {
 	WithdrawFee func(*big.Int) *big.Int
 	// WithdrawMin (optional) is the minimum amount required to allow a withdraw.
 	WithdrawMin *big.Int
+	// RetainMin (optional) is the minimum balance that must remain in the
+	// account after a partial withdraw.
+	RetainMin *big.Int
+
+	// Withdrawable (optional) returns the most that can be paid out to the
+	// account on-chain, such as the funds available in the payment
+	// contract. Withdraws of more than that are capped to it, and the
+	// discrepancy is reported to OnDiscrepancy.
+	Withdrawable func(ctx context.Context, account store.Account) (*big.Int, error)
+	// OnDiscrepancy (optional) is called when a withdraw is capped by
+	// Withdrawable, so that the operator can review the account. It's
+	// logged either way.
+	OnDiscrepancy func(WithdrawDiscrepancy)
 }
 
-func (p *PaymentService) verify(sig string, method string, wallet string, nonce int64, args ...interface{}) error {
-	if err := p.NonceStore.CheckAndSaveNonce(wallet, nonce); err != nil {
+func (p *PaymentService) verify(ctx context.Context, sig string, method string, wallet string, nonce int64, args ...interface{}) error {
+	if err := request.Verify(sig, method, wallet, nonce, args...); err != nil {
 		return pool.VerifyFailedError{Cause: err, Method: method}
 	}
 
-	if err := request.Verify(sig, method, wallet, nonce, args...); err != nil {
+	// The nonce is only saved for signed requests, so that other wallets
+	// can't use up a wallet's nonces.
+	if err := p.NonceStore.CheckAndSaveNonce(ctx, wallet, nonce); err != nil {
 		return pool.VerifyFailedError{Cause: err, Method: method}
 	}
 	return nil
@@ -65,7 +109,7 @@ func (p *PaymentService) verify(sig string, method string, wallet string, nonce
 // Account is an *unverified* endpoint for retrieving the balance and list of
 // node shortIDs associated with a wallet.
 func (p *PaymentService) Account(ctx context.Context, wallet string) (*AccountResponse, error) {
-	balance, err := p.BalanceStore.GetAccountBalance(store.Account(wallet))
+	balance, err := p.BalanceStore.GetAccountBalance(ctx, store.Account(wallet))
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +117,7 @@ func (p *PaymentService) Account(ctx context.Context, wallet string) (*AccountRe
 		Balance: balance,
 	}
 
-	nodeIDs, err := p.AccountStore.GetAccountNodes(store.Account(wallet))
+	nodeIDs, err := p.AccountStore.GetAccountNodes(ctx, store.Account(wallet))
 	if err != nil {
 		return nil, err
 	}
@@ -86,25 +130,42 @@ func (p *PaymentService) Account(ctx context.Context, wallet string) (*AccountRe
 
 // AddNode authorizes a nodeID to be spent by a wallet account.
 func (p *PaymentService) AddNode(ctx context.Context, sig string, wallet string, nonce int64, nodeID string) error {
-	if err := p.verify(sig, "pool_addNode", wallet, nonce, nodeID); err != nil {
+	if err := p.verify(ctx, sig, "pool_addNode", wallet, nonce, nodeID); err != nil {
 		return err
 	}
 
-	return p.AccountStore.AddAccountNode(store.Account(wallet), store.NodeID(nodeID))
+	return p.AccountStore.AddAccountNode(ctx, store.Account(wallet), store.NodeID(nodeID))
 }
 
 // Withdraw schedules a balance withdraw for an account
 func (p *PaymentService) Withdraw(ctx context.Context, sig string, wallet string, nonce int64) error {
-	if err := p.verify(sig, "pool_withdraw", wallet, nonce); err != nil {
+	if err := p.verify(ctx, sig, "pool_withdraw", wallet, nonce); err != nil {
 		return err
 	}
+	return p.withdraw(ctx, store.Account(wallet), nil)
+}
 
+// WithdrawAmount schedules a partial withdraw of amount, a decimal string in
+// wei, for an account. The rest of the balance remains in the account.
+func (p *PaymentService) WithdrawAmount(ctx context.Context, sig string, wallet string, nonce int64, amount string) error {
+	if err := p.verify(ctx, sig, "pool_withdrawAmount", wallet, nonce, amount); err != nil {
+		return err
+	}
+	withdrawAmount, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return fmt.Errorf("invalid withdraw amount: %q", amount)
+	}
+	return p.withdraw(ctx, store.Account(wallet), withdrawAmount)
+}
+
+// withdraw settles amount from the account's balance, or the whole balance if
+// amount is nil.
+func (p *PaymentService) withdraw(ctx context.Context, account store.Account, amount *big.Int) error {
 	if p.Settle == nil {
 		return ErrWithdrawDisabled
 	}
 
-	account := store.Account(wallet)
-	balance, err := p.BalanceStore.GetAccountBalance(account)
+	balance, err := p.BalanceStore.GetAccountBalance(ctx, account)
 	if err != nil {
 		return err
 	}
@@ -119,15 +180,62 @@ func (p *PaymentService) Withdraw(ctx context.Context, sig string, wallet string
 		}
 	}
 
+	newBalance := big.NewInt(0)
+	if amount != nil {
+		min, max := big.NewInt(1), new(big.Int).Set(total)
+		if p.WithdrawMin != nil && p.WithdrawMin.Cmp(min) > 0 {
+			min.Set(p.WithdrawMin)
+		}
+		if p.RetainMin != nil {
+			max.Sub(max, p.RetainMin)
+		}
+		if amount.Cmp(min) < 0 || amount.Cmp(max) > 0 {
+			return WithdrawAmountError{Amount: amount, Min: min, Max: max}
+		}
+		newBalance.Sub(total, amount)
+		total = new(big.Int).Set(amount)
+	}
+
+	if p.Withdrawable != nil {
+		withdrawable, err := p.Withdrawable(ctx, account)
+		if err != nil {
+			return err
+		}
+		if total.Cmp(withdrawable) > 0 {
+			p.reportDiscrepancy(WithdrawDiscrepancy{
+				Account:      account,
+				Requested:    new(big.Int).Set(total),
+				Withdrawable: withdrawable,
+			})
+			if withdrawable.Sign() <= 0 || (p.WithdrawMin != nil && withdrawable.Cmp(p.WithdrawMin) < 0) {
+				return ErrNothingWithdrawable
+			}
+			// The shortfall stays in the account's balance
+			shortfall := new(big.Int).Sub(total, withdrawable)
+			newBalance.Add(newBalance, shortfall)
+			total = new(big.Int).Set(withdrawable)
+		}
+	}
+
 	if p.WithdrawFee != nil {
 		total = p.WithdrawFee(total)
+		if total.Sign() <= 0 {
+			return ErrWithdrawFeeExceeded
+		}
 	}
 
-	newBalance := big.NewInt(0)
 	txID, err := p.Settle(account, total, newBalance)
 	if err != nil {
 		return err
 	}
-	logger.Printf("Withdraw from account %q for %d: %s", account, total, txID)
+	logger.Printf("Withdraw from account %q for %d, remaining balance %d: %s", account, total, newBalance, txID)
 	return nil
 }
+
+// reportDiscrepancy logs a capped withdraw and passes it to OnDiscrepancy.
+func (p *PaymentService) reportDiscrepancy(d WithdrawDiscrepancy) {
+	logger.Printf("Withdraw discrepancy for account %q: requested %d, but only %d is withdrawable on-chain", d.Account, d.Requested, d.Withdrawable)
+	if p.OnDiscrepancy != nil {
+		p.OnDiscrepancy(d)
+	}
+}
@@ -33,6 +33,7 @@ func (c *fakeContract) OpSettle(account store.Account, paymentAmount *big.Int, n
 }
 
 func TestPaymentWithdraw(t *testing.T) {
+	ctx := context.Background()
 	feeFn := func(amount *big.Int) *big.Int {
 		// Always remove 1000 as fee
 		return amount.Sub(amount, big.NewInt(1000))
@@ -75,7 +76,7 @@ func TestPaymentWithdraw(t *testing.T) {
 		t.Errorf("expected WithdrawBalanceMinimumError error, got: %s", err)
 	}
 
-	if err := memStore.AddAccountBalance(store.Account(wallet), big.NewInt(5000)); err != nil {
+	if err := memStore.AddAccountBalance(ctx, store.Account(wallet), big.NewInt(5000)); err != nil {
 		t.Fatal(err)
 	}
 
@@ -93,3 +94,164 @@ func TestPaymentWithdraw(t *testing.T) {
 	}
 
 }
+
+func TestPaymentWithdrawAmount(t *testing.T) {
+	ctx := context.Background()
+	contract := &fakeContract{
+		Balance: map[store.Account]big.Int{},
+		Paid:    map[store.Account]big.Int{},
+	}
+
+	memStore := store.MemoryStore()
+	p := PaymentService{
+		NonceStore:   memStore,
+		AccountStore: memStore,
+		BalanceStore: memStore,
+
+		Settle: contract.OpSettle,
+		WithdrawFee: func(amount *big.Int) *big.Int {
+			return amount.Sub(amount, big.NewInt(100))
+		},
+		WithdrawMin: big.NewInt(500),
+		RetainMin:   big.NewInt(1000),
+	}
+
+	privkey := keygen.HardcodedKey(t)
+	wallet := crypto.PubkeyToAddress(privkey.PublicKey).Hex()
+	nonce := time.Now().UnixNano()
+	withdraw := func(amount string) error {
+		t.Helper()
+		nonce++
+		req := request.AddressRequest{
+			Method:    "pool_withdrawAmount",
+			Address:   wallet,
+			Nonce:     nonce,
+			ExtraArgs: []interface{}{amount},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.WithdrawAmount(context.Background(), sig, wallet, nonce, amount)
+	}
+
+	if err := memStore.AddAccountBalance(ctx, store.Account(wallet), big.NewInt(5000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []string{"0", "499", "4001", "-1"} {
+		if _, ok := withdraw(amount).(WithdrawAmountError); !ok {
+			t.Errorf("expected WithdrawAmountError for amount %s", amount)
+		}
+	}
+	if err := withdraw("lots"); err == nil {
+		t.Error("expected invalid amount error")
+	}
+	if len(contract.Paid) != 0 {
+		t.Fatalf("unexpected payments: %v", contract.Paid)
+	}
+
+	if err := withdraw("2000"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contract.Paid[store.Account(wallet)], big.NewInt(1900); got.Cmp(want) != 0 {
+		t.Errorf("wrong paid amount: got: %d; want %d", &got, want)
+	}
+	if got, want := contract.Balance[store.Account(wallet)], big.NewInt(3000); got.Cmp(want) != 0 {
+		t.Errorf("wrong remaining balance: got: %d; want %d", &got, want)
+	}
+}
+
+func TestPaymentWithdrawDiscrepancy(t *testing.T) {
+	ctx := context.Background()
+	contract := &fakeContract{
+		Balance: map[store.Account]big.Int{},
+		Paid:    map[store.Account]big.Int{},
+	}
+	withdrawable := big.NewInt(3000)
+	var discrepancies []WithdrawDiscrepancy
+
+	memStore := store.MemoryStore()
+	p := PaymentService{
+		NonceStore:   memStore,
+		AccountStore: memStore,
+		BalanceStore: memStore,
+
+		Settle: contract.OpSettle,
+		Withdrawable: func(ctx context.Context, account store.Account) (*big.Int, error) {
+			return new(big.Int).Set(withdrawable), nil
+		},
+		OnDiscrepancy: func(d WithdrawDiscrepancy) {
+			discrepancies = append(discrepancies, d)
+		},
+	}
+
+	privkey := keygen.HardcodedKey(t)
+	wallet := crypto.PubkeyToAddress(privkey.PublicKey).Hex()
+	account := store.Account(wallet)
+	nonce := time.Now().UnixNano()
+	withdraw := func() error {
+		t.Helper()
+		nonce++
+		req := request.AddressRequest{
+			Method:  "pool_withdraw",
+			Address: wallet,
+			Nonce:   nonce,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Withdraw(context.Background(), sig, wallet, nonce)
+	}
+
+	if err := memStore.AddAccountBalance(ctx, account, big.NewInt(5000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := withdraw(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := contract.Paid[account], big.NewInt(3000); got.Cmp(want) != 0 {
+		t.Errorf("wrong paid amount: got: %d; want %d", &got, want)
+	}
+	if got, want := contract.Balance[account], big.NewInt(2000); got.Cmp(want) != 0 {
+		t.Errorf("wrong remaining balance: got: %d; want %d", &got, want)
+	}
+	if len(discrepancies) != 1 {
+		t.Fatalf("got %d discrepancies; want 1", len(discrepancies))
+	}
+	if d := discrepancies[0]; d.Account != account || d.Requested.Cmp(big.NewInt(5000)) != 0 || d.Withdrawable.Cmp(withdrawable) != 0 {
+		t.Errorf("wrong discrepancy: %+v", d)
+	}
+
+	// Nothing can be withdrawn, the balance is left alone
+	withdrawable.SetInt64(0)
+	if err := withdraw(); err != ErrNothingWithdrawable {
+		t.Errorf("expected ErrNothingWithdrawable, got: %v", err)
+	}
+	if len(discrepancies) != 2 {
+		t.Errorf("got %d discrepancies; want 2", len(discrepancies))
+	}
+	if got, want := contract.Paid[account], big.NewInt(3000); got.Cmp(want) != 0 {
+		t.Errorf("wrong paid amount: got: %d; want %d", &got, want)
+	}
+
+	// The capped amount is below the minimum
+	p.WithdrawMin = big.NewInt(1000)
+	withdrawable.SetInt64(500)
+	if err := withdraw(); err != ErrNothingWithdrawable {
+		t.Errorf("expected ErrNothingWithdrawable, got: %v", err)
+	}
+
+	// The fee takes all of the capped amount
+	withdrawable.SetInt64(1500)
+	p.WithdrawFee = func(amount *big.Int) *big.Int {
+		return amount.Sub(amount, big.NewInt(1500))
+	}
+	if err := withdraw(); err != ErrWithdrawFeeExceeded {
+		t.Errorf("expected ErrWithdrawFeeExceeded, got: %v", err)
+	}
+	if got, want := contract.Paid[account], big.NewInt(3000); got.Cmp(want) != 0 {
+		t.Errorf("wrong paid amount: got: %d; want %d", &got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package pool
+
+import (
+	"context"
+
+	"github.com/vipnode/vipnode/jsonrpc2"
+)
+
+// confirmHostPeers asks the host that sent the request for the peers that
+// its node is actually connected to, with vipnode_peers, and splits the
+// reported peers into the ones that the node confirmed and the ones that it
+// didn't. An error is returned if the host can't be asked, such as when it
+// predates vipnode_peers.
+func (p *VipnodePool) confirmHostPeers(ctx context.Context, reported []string) (confirmed []string, unconfirmed []string, err error) {
+	service, err := jsonrpc2.CtxService(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
+	defer cancel()
+	var actual []string
+	if err := service.Call(callCtx, &actual, "vipnode_peers"); err != nil {
+		return nil, nil, err
+	}
+
+	connected := make(map[string]struct{}, len(actual))
+	for _, peer := range actual {
+		connected[peer] = struct{}{}
+	}
+	confirmed = make([]string, 0, len(reported))
+	for _, peer := range reported {
+		if _, ok := connected[peer]; ok {
+			confirmed = append(confirmed, peer)
+		} else {
+			unconfirmed = append(unconfirmed, peer)
+		}
+	}
+	return confirmed, unconfirmed, nil
+}
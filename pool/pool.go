@@ -2,16 +2,17 @@ package pool
 
 import (
 	"context"
+	"time"
 
 	"github.com/vipnode/vipnode/pool/store"
 )
 
 // TODO: Add HostRequest.Network and ClientRequest.Network?
-// TODO: Add HostRequest.HostVersion?
 
 // HostRequest is the request type for Host RPC calls.
 type HostRequest struct {
-	// Kind is the type of node the host supports: geth, parity
+	// Kind is the type of node the host supports, such as geth or
+	// parity/pip. See ParseKind for the format.
 	Kind string `json:"kind"`
 	// Payout sets the wallet account to register the host credit towards.
 	Payout string `json:"payout"`
@@ -19,6 +20,17 @@ type HostRequest struct {
 	// separate IP from the actual node host. Otherwise, the pool will
 	// automatically use the same IP and default port as the host connecting.
 	NodeURI string `json:"node_uri,omitempty"`
+	// AltNodeURIs are optional additional enode URIs that the host is
+	// reachable on, such as over IPv6 or a relay. Clients try them in order
+	// if NodeURI fails. Each must match the host's node ID.
+	AltNodeURIs []string `json:"alt_node_uris,omitempty"`
+	// Version is the version of the vipnode agent that the host is running,
+	// such as "v2.3.1". Pools can refuse hosts below a minimum version.
+	Version string `json:"version,omitempty"`
+	// MaxPeers is the number of clients that the host's node can accept,
+	// such as its --maxpeers setting. The pool stops offering the host to
+	// clients once it's full. Zero means no limit.
+	MaxPeers int `json:"max_peers,omitempty"`
 }
 
 // HostResponse is the response type for Host RPC calls.
@@ -26,11 +38,53 @@ type HostResponse struct {
 	PoolVersion string `json:"pool_version"`
 }
 
+// HostCapabilities is the response type for the vipnode_capabilities RPC call
+// that the pool makes to a host while it registers.
+type HostCapabilities struct {
+	// Kind is the type of node the host is running: geth, parity
+	Kind string `json:"kind"`
+	// IsFullNode is false if the host is running a light node, which can't
+	// serve other light clients.
+	IsFullNode bool `json:"is_full_node"`
+	// Syncing is true if the host's node is still syncing the chain, so it
+	// can't serve chain data to clients yet.
+	Syncing bool `json:"syncing,omitempty"`
+}
+
 // ClientRequest is the request type for Client RPC calls.
 type ClientRequest struct {
+	// Kind is the type of node the client is, such as geth or geth/les. Only
+	// hosts with a matching kind are returned. See ParseKind for the format.
 	Kind string `json:"kind"`
+	// PreferredHosts is an optional list of host node IDs that the client
+	// would like to connect to, such as hosts it had a good experience with.
+	// The pool returns any that are still active and compatible before
+	// filling the rest with its own selection.
+	PreferredHosts []string `json:"preferred_hosts,omitempty"`
+	// Distribution is how the client would like to be spread across hosts,
+	// one of the Distribute* values. The pool's default is used if empty.
+	Distribution string `json:"distribution,omitempty"`
+	// NumHosts is how many hosts the client would like, such as to replace
+	// the hosts it lost. The pool's own number is used if it's zero or
+	// larger.
+	NumHosts int `json:"num_hosts,omitempty"`
+	// ConnectedHosts is an optional list of host node IDs that the client is
+	// still connected to. They stay assigned to the client, and aren't
+	// returned again.
+	ConnectedHosts []string `json:"connected_hosts,omitempty"`
 }
 
+// Distributions that a client can request for its hosts.
+const (
+	// DistributeSpread prefers hosts run by different operators on
+	// different networks, so that the client is resilient to any one of
+	// them going away.
+	DistributeSpread = "spread"
+	// DistributeMinimal connects the client to a single host, to minimize
+	// its cost.
+	DistributeMinimal = "minimal"
+)
+
 // ClientResponse is the response type for Client RPC calls.
 type ClientResponse struct {
 	// Hosts that have whitelisted the client NodeID and are ready for the
@@ -42,18 +96,69 @@ type ClientResponse struct {
 	// instructions for interfacing with this pool. For example, a link to the
 	// DApp for adding a balance deposit.
 	Message string `json:"message,omitempty"`
+	// Tried is the number of candidate hosts that the pool asked to
+	// whitelist the client, including the hosts that failed.
+	Tried int `json:"tried,omitempty"`
+	// Errors are the reasons that the candidate hosts which weren't returned
+	// failed to whitelist the client.
+	Errors []string `json:"errors,omitempty"`
 }
 
+// Roles that a node can send updates as.
+const (
+	RoleHost   = "host"
+	RoleClient = "client"
+)
+
 // UpdateRequest is the request type for Update RPC calls.
 type UpdateRequest struct {
 	Peers       []string `json:"peers"`
 	BlockNumber uint64   `json:"block_number"`
+	// Role is the role that the node is sending the update as, either
+	// RoleHost or RoleClient. If set, it must match the role that the node
+	// registered with.
+	Role string `json:"role,omitempty"`
+	// Syncing is true if a host's node is still syncing the chain. Syncing
+	// hosts are offered to clients after the hosts that are in sync.
+	Syncing bool `json:"syncing,omitempty"`
 }
 
 // UpdateResponse is the response type for Update RPC calls.
 type UpdateResponse struct {
 	Balance      *store.Balance `json:"balance,omitempty"`
 	InvalidPeers []string       `json:"invalid_peers"`
+	// Unbilled is true if the pool does not track a balance for the node, in
+	// which case Balance is nil.
+	Unbilled bool `json:"unbilled,omitempty"`
+	// UnconfirmedPeers are the reported peers that the host's node did not
+	// report being connected to, when the pool verifies host peers. They
+	// are ignored, and the host's credit is frozen for the update.
+	UnconfirmedPeers []string `json:"unconfirmed_peers,omitempty"`
+}
+
+// ProjectionRequest is the request type for Projection RPC calls.
+type ProjectionRequest struct {
+	// Peers is the number of additional clients to project earnings for.
+	Peers int `json:"peers"`
+}
+
+// ProjectionResponse is the response type for Projection RPC calls. Credit
+// amounts are decimal strings in wei, like in store.Balance.
+type ProjectionResponse struct {
+	Peers             int           `json:"peers"`
+	Interval          time.Duration `json:"interval,omitempty"`
+	CreditPerInterval string        `json:"credit_per_interval,omitempty"`
+	CreditPerHour     string        `json:"credit_per_hour,omitempty"`
+	// Unbilled is true if the pool does not credit hosts, in which case
+	// there is nothing to project.
+	Unbilled bool `json:"unbilled,omitempty"`
+}
+
+// SubscribeBalanceRequest is the request type for SubscribeBalance RPC calls.
+type SubscribeBalanceRequest struct {
+	// Interval is the time between balance pushes. If zero, then
+	// store.KeepaliveInterval is used.
+	Interval time.Duration `json:"interval"`
 }
 
 // Pool represents a vipnode pool for coordinating between clients and hosts.
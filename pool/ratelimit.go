@@ -0,0 +1,175 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// sourceLimiter allows up to limit events per source within a sliding
+// window, safe for concurrent use.
+type sourceLimiter struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// Allow records an event for the source and returns true, unless the source
+// already had limit events within the window.
+func (l *sourceLimiter) Allow(source string, limit int, window time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = map[string][]time.Time{}
+	}
+
+	// Drop events that fell out of the window
+	since := now.Add(-window)
+	events := l.events[source]
+	i := 0
+	for i < len(events) && !events[i].After(since) {
+		i++
+	}
+	events = events[i:]
+
+	if len(events) >= limit {
+		l.events[source] = events
+		return false
+	}
+	l.events[source] = append(events, now)
+	return true
+}
+
+// Prune removes sources without any events within the window.
+func (l *sourceLimiter) Prune(window time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	since := now.Add(-window)
+	for source, events := range l.events {
+		if len(events) == 0 || !events[len(events)-1].After(since) {
+			delete(l.events, source)
+		}
+	}
+}
+
+// changeLimiter tracks the latest value per key, and allows the value to
+// change at most once per cooldown, safe for concurrent use.
+type changeLimiter struct {
+	mu      sync.Mutex
+	changes map[string]valueChange
+}
+
+type valueChange struct {
+	value   string
+	changed time.Time
+}
+
+// Allow returns true unless the key's value is different and it already
+// changed within the cooldown. The first value seen for a key is not counted
+// as a change. Allow doesn't record anything, call Record once the value is
+// applied.
+func (l *changeLimiter) Allow(key string, value string, cooldown time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last, ok := l.changes[key]
+	if !ok || last.value == value {
+		return true
+	}
+	return now.Sub(last.changed) >= cooldown
+}
+
+// Record sets the key's value, and counts it as a change if the key already
+// had a different value.
+func (l *changeLimiter) Record(key string, value string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.changes == nil {
+		l.changes = map[string]valueChange{}
+	}
+
+	last, ok := l.changes[key]
+	if !ok {
+		l.changes[key] = valueChange{value: value}
+		return
+	}
+	if last.value == value {
+		return
+	}
+	l.changes[key] = valueChange{value: value, changed: now}
+}
+
+// RateLimit is a token bucket limit on how often a node can call a method.
+// Each node's bucket holds up to Burst calls, and refills one call per
+// Interval.
+type RateLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// tokenLimiter keeps a token bucket per key, safe for concurrent use. Full
+// buckets are pruned periodically, since they're the same as a new bucket.
+type tokenLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]tokenBucket
+	pruned  time.Time
+}
+
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// refill returns the bucket with the tokens that were added since it was
+// last updated.
+func (b tokenBucket) refill(now time.Time) tokenBucket {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(b.limit.Interval)
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+		b.updated = now
+	}
+	return b
+}
+
+// tokenPruneInterval is how often the full buckets are pruned.
+const tokenPruneInterval = time.Minute
+
+// Allow takes a token from the key's bucket and returns true. If the bucket
+// is empty, then it returns false along with how long until the next token
+// is available.
+func (l *tokenLimiter) Allow(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]tokenBucket{}
+	}
+	if now.Sub(l.pruned) >= tokenPruneInterval {
+		l.prune(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = tokenBucket{tokens: float64(limit.Burst), updated: now}
+	}
+	bucket.limit = limit
+	bucket = bucket.refill(now)
+
+	if bucket.tokens < 1 {
+		l.buckets[key] = bucket
+		return false, time.Duration((1 - bucket.tokens) * float64(limit.Interval))
+	}
+	bucket.tokens -= 1
+	l.buckets[key] = bucket
+	return true, 0
+}
+
+// prune removes the buckets that are full. It must be called with the lock
+// held.
+func (l *tokenLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.refill(now).tokens >= float64(bucket.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
@@ -0,0 +1,85 @@
+package pool
+
+import (
+	"sync"
+
+	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// hostRegistry keeps track of the RPC services of connected hosts, safe for
+// concurrent use.
+type hostRegistry struct {
+	mu      sync.Mutex
+	hosts   map[store.NodeID]jsonrpc2.Service
+	sources map[store.NodeID]string
+}
+
+func newHostRegistry() *hostRegistry {
+	return &hostRegistry{
+		hosts:   map[store.NodeID]jsonrpc2.Service{},
+		sources: map[store.NodeID]string{},
+	}
+}
+
+// Add registers the service for a host, replacing any existing service.
+func (r *hostRegistry) Add(id store.NodeID, service jsonrpc2.Service) {
+	r.AddFromSource(id, service, "")
+}
+
+// AddFromSource registers the service for a host along with the source that
+// it registered from, such as its IP address.
+func (r *hostRegistry) AddFromSource(id store.NodeID, service jsonrpc2.Service, source string) {
+	r.mu.Lock()
+	r.hosts[id] = service
+	r.sources[id] = source
+	r.mu.Unlock()
+}
+
+// FromSource returns the IDs of hosts that registered from the source.
+func (r *hostRegistry) FromSource(source string) []store.NodeID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []store.NodeID
+	for id, s := range r.sources {
+		if s == source {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Remove unregisters the service for a host, returning true if it was
+// registered.
+func (r *hostRegistry) Remove(id store.NodeID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.hosts[id]
+	delete(r.hosts, id)
+	delete(r.sources, id)
+	return ok
+}
+
+// Get returns the registered service for a host.
+func (r *hostRegistry) Get(id store.NodeID) (jsonrpc2.Service, bool) {
+	r.mu.Lock()
+	service, ok := r.hosts[id]
+	r.mu.Unlock()
+	return service, ok
+}
+
+// Candidates pairs each node with its registered service. Nodes without a
+// registered service are returned separately as missing.
+func (r *hostRegistry) Candidates(nodes []store.Node) (found []hostService, missing []store.Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found = make([]hostService, 0, len(nodes))
+	for _, node := range nodes {
+		if service, ok := r.hosts[node.ID]; ok {
+			found = append(found, hostService{node, service})
+		} else {
+			missing = append(missing, node)
+		}
+	}
+	return found, missing
+}
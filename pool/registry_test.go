@@ -0,0 +1,77 @@
+package pool
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+func TestHostRegistry(t *testing.T) {
+	r := newHostRegistry()
+	r.Add("a", delayService{})
+	r.Add("b", delayService{})
+
+	if _, ok := r.Get("a"); !ok {
+		t.Error("missing registered host a")
+	}
+	if _, ok := r.Get("c"); ok {
+		t.Error("unexpected unregistered host c")
+	}
+
+	found, missing := r.Candidates([]store.Node{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	if len(found) != 2 || found[0].ID != "a" || found[1].ID != "b" {
+		t.Errorf("wrong candidates found: %v", found)
+	}
+	if len(missing) != 1 || missing[0].ID != "c" {
+		t.Errorf("wrong candidates missing: %v", missing)
+	}
+
+	if !r.Remove("a") {
+		t.Error("failed to remove registered host a")
+	}
+	if r.Remove("a") {
+		t.Error("removed host a twice")
+	}
+	if _, ok := r.Get("a"); ok {
+		t.Error("host a still registered after removal")
+	}
+}
+
+func TestHostRegistryConcurrent(t *testing.T) {
+	r := newHostRegistry()
+	nodes := make([]store.Node, 10)
+	for i := range nodes {
+		nodes[i] = store.Node{ID: store.NodeID(fmt.Sprintf("node%d", i))}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				node := nodes[(i+j)%len(nodes)]
+				switch j % 4 {
+				case 0:
+					r.Add(node.ID, delayService{})
+				case 1:
+					r.Get(node.ID)
+				case 2:
+					r.Candidates(nodes)
+				case 3:
+					r.Remove(node.ID)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, node := range nodes {
+		r.Add(node.ID, delayService{})
+	}
+	if found, missing := r.Candidates(nodes); len(found) != len(nodes) || len(missing) != 0 {
+		t.Errorf("wrong candidates after concurrent access: %d found, %d missing", len(found), len(missing))
+	}
+}
@@ -3,10 +3,13 @@ package pool
 import (
 	"context"
 	"crypto/ecdsa"
+	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool/store"
 	"github.com/vipnode/vipnode/request"
 )
 
@@ -20,6 +23,17 @@ func Remote(client jsonrpc2.Service, privkey *ecdsa.PrivateKey) *RemotePool {
 	}
 }
 
+// RemoteSigner returns a RemotePool like Remote, but requests are signed by a
+// separate signing key on behalf of nodeID. The signing key must be bound to
+// the node first with BindSigningKey, signed by the node key.
+func RemoteSigner(client jsonrpc2.Service, signingKey *ecdsa.PrivateKey, nodeID string) *RemotePool {
+	return &RemotePool{
+		client:  client,
+		privkey: signingKey,
+		nodeID:  nodeID,
+	}
+}
+
 // Type assert for Pool implementation.
 var _ Pool = &RemotePool{}
 
@@ -34,6 +48,19 @@ func (p *RemotePool) getNonce() int64 {
 	return time.Now().UnixNano()
 }
 
+// Ping checks that the pool is reachable and responding, such as before
+// trusting a new connection to it. It's not signed.
+func (p *RemotePool) Ping(ctx context.Context) error {
+	var result string
+	if err := p.client.Call(ctx, &result, "vipnode_ping"); err != nil {
+		return err
+	}
+	if result != "pong" {
+		return fmt.Errorf("unexpected vipnode_ping response: %q", result)
+	}
+	return nil
+}
+
 func (p *RemotePool) Host(ctx context.Context, req HostRequest) (*HostResponse, error) {
 	signedReq := request.NodeRequest{
 		Method:    "vipnode_host",
@@ -123,3 +150,151 @@ func (p *RemotePool) Withdraw(ctx context.Context) error {
 	var result interface{}
 	return p.client.Call(ctx, &result, signedReq.Method, args...)
 }
+
+// Rewhitelist asks the pool to re-issue vipnode_whitelist to this host for
+// all of its current clients.
+func (p *RemotePool) Rewhitelist(ctx context.Context) error {
+	signedReq := request.NodeRequest{
+		Method: "vipnode_rewhitelist",
+		NodeID: p.nodeID,
+		Nonce:  p.getNonce(),
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	return p.client.Call(ctx, &result, signedReq.Method, args...)
+}
+
+// Drain asks the pool to stop offering this host to new clients, such as
+// before maintenance. The pool removes the host after its drain grace period,
+// unless the host registers again first.
+func (p *RemotePool) Drain(ctx context.Context) error {
+	signedReq := request.NodeRequest{
+		Method: "vipnode_drain",
+		NodeID: p.nodeID,
+		Nonce:  p.getNonce(),
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	return p.client.Call(ctx, &result, signedReq.Method, args...)
+}
+
+// BindSigningKey binds the signing key with the hex-encoded public key
+// signerID to this node, so that a RemoteSigner can make requests on behalf of
+// the node. It must be called on a RemotePool that signs with the node key.
+func (p *RemotePool) BindSigningKey(ctx context.Context, signerID string) error {
+	signedReq := request.NodeRequest{
+		Method:    "vipnode_bindSigningKey",
+		NodeID:    p.nodeID,
+		Nonce:     p.getNonce(),
+		ExtraArgs: []interface{}{signerID},
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	return p.client.Call(ctx, &result, signedReq.Method, args...)
+}
+
+// Stats returns aggregate statistics about the pool. The pool only accepts
+// the request if this node is one of its operators.
+func (p *RemotePool) Stats(ctx context.Context) (*PoolStats, error) {
+	signedReq := request.NodeRequest{
+		Method: "vipnode_stats",
+		NodeID: p.nodeID,
+		Nonce:  p.getNonce(),
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return nil, err
+	}
+	var result PoolStats
+	if err := p.client.Call(ctx, &result, signedReq.Method, args...); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold. The pool only accepts the request if this node is one of its
+// operators.
+func (p *RemotePool) AccountsAbove(ctx context.Context, threshold *big.Int) ([]store.Account, error) {
+	signedReq := request.NodeRequest{
+		Method:    "vipnode_accountsAbove",
+		NodeID:    p.nodeID,
+		Nonce:     p.getNonce(),
+		ExtraArgs: []interface{}{threshold.String()},
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return nil, err
+	}
+	var result []store.Account
+	if err := p.client.Call(ctx, &result, signedReq.Method, args...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Evict asks the pool to remove the node with evictID, and if it's a host,
+// to disconnect its clients. The pool only accepts the request if this node
+// is one of its operators.
+func (p *RemotePool) Evict(ctx context.Context, evictID string) error {
+	signedReq := request.NodeRequest{
+		Method:    "vipnode_evict",
+		NodeID:    p.nodeID,
+		Nonce:     p.getNonce(),
+		ExtraArgs: []interface{}{evictID},
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	return p.client.Call(ctx, nil, signedReq.Method, args...)
+}
+
+// SubscribeBalance asks the pool to push the node's balance every interval by
+// calling vipnode_balance on this connection.
+func (p *RemotePool) SubscribeBalance(ctx context.Context, req SubscribeBalanceRequest) error {
+	signedReq := request.NodeRequest{
+		Method:    "vipnode_subscribeBalance",
+		NodeID:    p.nodeID,
+		Nonce:     p.getNonce(),
+		ExtraArgs: []interface{}{req},
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	return p.client.Call(ctx, &result, signedReq.Method, args...)
+}
+
+// UnsubscribeBalance stops the balance pushes started by SubscribeBalance.
+func (p *RemotePool) UnsubscribeBalance(ctx context.Context) error {
+	signedReq := request.NodeRequest{
+		Method: "vipnode_unsubscribeBalance",
+		NodeID: p.nodeID,
+		Nonce:  p.getNonce(),
+	}
+
+	args, err := signedReq.SignedArgs(p.privkey)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	return p.client.Call(ctx, &result, signedReq.Method, args...)
+}
@@ -2,17 +2,26 @@ package pool
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/internal/keygen"
 	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/metrics"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
 func TestRemotePoolClient(t *testing.T) {
+	ctx := context.Background()
 	pool := New(store.MemoryStore(), nil)
 	pool.skipWhitelist = true
 
@@ -24,19 +33,19 @@ func TestRemotePoolClient(t *testing.T) {
 
 	// Add self to pool first, then let's see if we're advised to connect to
 	// self (this probably should error at some point but good test for now).
-	if err := pool.Store.SetNode(store.Node{ID: "foo", URI: "enode://foo", IsHost: true, Kind: "geth", LastSeen: time.Now()}); err != nil {
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "foo", URI: "enode://foo", IsHost: true, Kind: "geth", LastSeen: time.Now()}); err != nil {
 		t.Fatal("failed to add host node:", err)
 	}
-	if err := pool.Store.SetNode(store.Node{ID: "bar", URI: "enode://bar", IsHost: true, Kind: "parity", LastSeen: time.Now()}); err != nil {
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "bar", URI: "enode://bar", IsHost: true, Kind: "parity", LastSeen: time.Now()}); err != nil {
 		t.Fatal("failed to add host node:", err)
 	}
 
 	// This peer will be ignored because LastSeen was too long ago
-	if err := pool.Store.SetNode(store.Node{ID: "oldpeer", URI: "enode://oldpeer", IsHost: true, Kind: "parity", LastSeen: time.Now().Add(-5 * store.KeepaliveInterval)}); err != nil {
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "oldpeer", URI: "enode://oldpeer", IsHost: true, Kind: "parity", LastSeen: time.Now().Add(-5 * store.KeepaliveInterval)}); err != nil {
 		t.Fatal("failed to add host node:", err)
 	}
 
-	nodes, err := pool.Store.ActiveHosts("", 3)
+	nodes, err := pool.Store.ActiveHosts(ctx, store.AnyKind, 3, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -58,9 +67,41 @@ func TestRemotePoolClient(t *testing.T) {
 	}
 }
 
+// wrongPong is a pool that responds to vipnode_ping with the wrong value.
+type wrongPong struct{}
+
+func (wrongPong) Ping(ctx context.Context) string {
+	return "ping"
+}
+
+func TestRemotePoolPing(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	if err := Remote(client, keygen.HardcodedKey(t)).Ping(ctx); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// A mismatched response is an error.
+	server, client = jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", wrongPong{})
+	if err := Remote(client, keygen.HardcodedKey(t)).Ping(ctx); err == nil || !strings.Contains(err.Error(), "unexpected vipnode_ping response") {
+		t.Errorf("expected unexpected response error, got: %v", err)
+	}
+
+	// So is a pool that doesn't serve vipnode_ping.
+	_, client = jsonrpc2.ServePipe()
+	if err := Remote(client, keygen.HardcodedKey(t)).Ping(ctx); !jsonrpc2.IsErrorCode(err, jsonrpc2.ErrCodeMethodNotFound) {
+		t.Errorf("expected method not found error, got: %v", err)
+	}
+}
+
 func TestRemotePoolHost(t *testing.T) {
 	pool := New(store.MemoryStore(), nil)
 	pool.skipWhitelist = true
+	pool.skipHostCheck = true
 
 	server, host := jsonrpc2.ServePipe()
 	server.Server.Register("vipnode_", pool)
@@ -111,3 +152,1048 @@ func TestRemotePoolHost(t *testing.T) {
 		}
 	}
 }
+
+func TestRemotePoolHostRetry(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.skipHostCheck = true
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	otherID := discv5.PubkeyID(&keygen.HardcodedKeyIdx(t, 1).PublicKey).String()
+	remote := Remote(host, privkey)
+
+	// Register with another node's URI
+	badURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", otherID)
+	_, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: badURI})
+	if err == nil {
+		t.Fatal("expected identity error")
+	}
+	if !jsonrpc2.IsErrorCode(err, jsonrpc2.ErrCodeInternal) {
+		t.Errorf("unexpected error type: %T", err)
+	}
+	if !strings.HasPrefix(err.Error(), "host identity verification failed") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+		t.Errorf("rejected host was registered: %v", err)
+	}
+
+	// Retry with the correct URI on the same connection
+	goodURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", nodeID)
+	if _, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: goodURI}); err != nil {
+		t.Fatal(err)
+	}
+	node, err := pool.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.URI != goodURI {
+		t.Errorf("wrong node URI: %s", node.URI)
+	}
+}
+
+func TestRemotePoolHostInvalidURI(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.skipHostCheck = true
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	otherID := discv5.PubkeyID(&keygen.HardcodedKeyIdx(t, 1).PublicKey).String()
+	remote := Remote(host, privkey)
+
+	testcases := []struct {
+		nodeURI    string
+		wantPrefix string
+	}{
+		{fmt.Sprintf("enode://%s@127.0.0.1:", nodeID), "invalid node URI"},
+		{fmt.Sprintf("https://%s@127.0.0.1:30303", nodeID), "invalid node URI"},
+		{fmt.Sprintf("enode://%s@127.0.0.1:30303", otherID), "host identity verification failed"},
+	}
+	for i, tc := range testcases {
+		_, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: tc.nodeURI})
+		if err == nil {
+			t.Errorf("Case #%d: expected error", i)
+		} else if !strings.HasPrefix(err.Error(), tc.wantPrefix) {
+			t.Errorf("Case #%d: unexpected error: %s", i, err)
+		}
+	}
+	if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+		t.Errorf("rejected host was registered: %v", err)
+	}
+}
+
+func TestRemotePoolHostAltURIs(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	otherID := discv5.PubkeyID(&keygen.HardcodedKeyIdx(t, 1).PublicKey).String()
+	remote := Remote(host, privkey)
+
+	nodeURI := fmt.Sprintf("enode://%s@192.0.2.1:30303", nodeID)
+	altURI := fmt.Sprintf("enode://%s@[2001:db8::1]:30303", nodeID)
+
+	// Alternative URIs must match the node ID too
+	badURI := fmt.Sprintf("enode://%s@[2001:db8::1]:30303", otherID)
+	if _, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI, AltNodeURIs: []string{badURI}}); err == nil {
+		t.Error("expected identity error for mismatched alt URI")
+	}
+
+	if _, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI, AltNodeURIs: []string{nodeURI, altURI}}); err != nil {
+		t.Fatal(err)
+	}
+	node, err := pool.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{nodeURI, altURI}; !reflect.DeepEqual(node.URIs(), want) {
+		t.Errorf("wrong node URIs:\n got: %v\nwant: %v", node.URIs(), want)
+	}
+}
+
+func TestRemotePoolMinHostVersion(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	pool.MinHostVersion = "v2.3.0"
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	remote := Remote(host, privkey)
+	nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", nodeID)
+
+	for _, version := range []string{"", "v2.2.0"} {
+		_, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI, Version: version})
+		if err == nil || !strings.HasPrefix(err.Error(), "host version rejected") {
+			t.Errorf("version %q: expected host version error, got: %v", version, err)
+		}
+	}
+	if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+		t.Errorf("rejected host was registered: %v", err)
+	}
+
+	if _, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI, Version: "v2.3.0"}); err != nil {
+		t.Fatal(err)
+	}
+	node, err := pool.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Version != "v2.3.0" {
+		t.Errorf("wrong host version: %q", node.Version)
+	}
+}
+
+// FakeCapabilities responds to vipnode_capabilities requests from the pool.
+type FakeCapabilities HostCapabilities
+
+func (c FakeCapabilities) Capabilities(ctx context.Context) (*HostCapabilities, error) {
+	caps := HostCapabilities(c)
+	return &caps, nil
+}
+
+func TestRemotePoolHostCapabilities(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		Name    string
+		Caps    *HostCapabilities
+		WantErr error
+	}{
+		{"full node", &HostCapabilities{Kind: "geth", IsFullNode: true}, nil},
+		{"light node", &HostCapabilities{Kind: "geth", IsFullNode: false}, LightHostError{Kind: "geth"}},
+		{"unsupported", nil, nil},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pool := New(store.MemoryStore(), nil)
+
+			server, host := jsonrpc2.ServePipe()
+			defer server.Close()
+			defer host.Close()
+			server.Server.Register("vipnode_", pool)
+			if tc.Caps != nil {
+				if err := host.Server.RegisterMethod("vipnode_capabilities", FakeCapabilities(*tc.Caps), "Capabilities"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			privkey := keygen.HardcodedKeyIdx(t, i)
+			nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+			remote := Remote(host, privkey)
+			nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", nodeID)
+
+			_, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI})
+			if tc.WantErr != nil {
+				if err == nil || err.Error() != tc.WantErr.Error() {
+					t.Fatalf("got error %v; want %v", err, tc.WantErr)
+				}
+				if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+					t.Errorf("rejected host was registered: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != nil {
+				t.Errorf("host was not registered: %v", err)
+			}
+		})
+	}
+}
+
+// BalanceRecorder receives vipnode_balance pushes from the pool.
+type BalanceRecorder struct {
+	ch chan store.Balance
+}
+
+func (r *BalanceRecorder) Balance(ctx context.Context, balance store.Balance) error {
+	r.ch <- balance
+	return nil
+}
+
+func TestRemotePoolSubscribeBalance(t *testing.T) {
+	ctx := context.Background()
+	defer func(interval time.Duration) { minBalanceInterval = interval }(minBalanceInterval)
+	minBalanceInterval = 0
+
+	pool := New(store.MemoryStore(), nil)
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	recorder := &BalanceRecorder{ch: make(chan store.Balance, 10)}
+	if err := client.Server.RegisterMethod("vipnode_balance", recorder, "Balance"); err != nil {
+		t.Fatal(err)
+	}
+
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	remote := Remote(client, privkey)
+
+	if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), Kind: "geth", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Store.AddNodeBalance(ctx, store.NodeID(nodeID), big.NewInt(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.SubscribeBalance(context.Background(), SubscribeBalanceRequest{Interval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case balance := <-recorder.ch:
+			if balance.Credit.Cmp(big.NewInt(42)) != 0 {
+				t.Errorf("wrong balance credit: %d", &balance.Credit)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for balance push %d", i)
+		}
+	}
+
+	if err := remote.UnsubscribeBalance(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drain any push that was in flight during the unsubscribe.
+	time.Sleep(20 * time.Millisecond)
+	for len(recorder.ch) > 0 {
+		<-recorder.ch
+	}
+
+	select {
+	case <-recorder.ch:
+		t.Error("received balance push after unsubscribing")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRemotePoolHostSourceLimit(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.skipHostCheck = true
+
+	register := func(privkey *ecdsa.PrivateKey, payout string) error {
+		server, host := jsonrpc2.ServePipe()
+		server.Server.Register("vipnode_", pool)
+		nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+		_, err := Remote(host, privkey).Host(context.Background(), HostRequest{
+			Kind:    "geth",
+			Payout:  payout,
+			NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", nodeID),
+		})
+		return err
+	}
+
+	// Pipes don't have a remote address, so hosts are grouped by payout.
+	const payout = "0x0000000000000000000000000000000000000001"
+	pool.MaxHostsPerSource = 3
+	var accepted []*ecdsa.PrivateKey
+	numRejected := 0
+	for i := 0; i < 10; i++ {
+		privkey := keygen.NewKey(t)
+		err := register(privkey, payout)
+		if err == nil {
+			accepted = append(accepted, privkey)
+			continue
+		}
+		if err.Error() != ErrTooManyHosts.Error() {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		numRejected++
+	}
+	if len(accepted) != 3 || numRejected != 7 {
+		t.Errorf("got %d accepted and %d rejected; want 3 and 7", len(accepted), numRejected)
+	}
+
+	// Reconnecting hosts and other sources are still accepted
+	if err := register(accepted[0], payout); err != nil {
+		t.Errorf("reconnecting host rejected: %s", err)
+	}
+	if err := register(keygen.NewKey(t), "0x0000000000000000000000000000000000000002"); err != nil {
+		t.Errorf("host from another source rejected: %s", err)
+	}
+
+	// Inactive hosts don't count towards the limit
+	node, err := pool.Store.GetNode(ctx, store.NodeID(discv5.PubkeyID(&accepted[1].PublicKey).String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.LastSeen = time.Now().Add(-store.ExpireInterval * 2)
+	if err := pool.Store.SetNode(ctx, *node); err != nil {
+		t.Fatal(err)
+	}
+	if err := register(keygen.NewKey(t), payout); err != nil {
+		t.Errorf("host replacing an inactive host rejected: %s", err)
+	}
+
+	// Registration rate limit applies even when the active hosts are below
+	// the cap
+	const payout3 = "0x0000000000000000000000000000000000000003"
+	pool.MaxHostsPerSource = 0
+	pool.HostRegistrationLimit = 2
+	pool.HostRegistrationWindow = time.Hour
+	numAccepted := 0
+	for i := 0; i < 5; i++ {
+		err := register(keygen.NewKey(t), payout3)
+		if err == nil {
+			numAccepted++
+		} else if err.Error() != ErrTooManyHosts.Error() {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if numAccepted != 2 {
+		t.Errorf("got %d accepted within the rate limit; want 2", numAccepted)
+	}
+}
+
+// WhitelistRecorder receives vipnode_whitelist calls from the pool.
+type WhitelistRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *WhitelistRecorder) Whitelist(ctx context.Context, nodeID string) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, nodeID)
+	r.mu.Unlock()
+	return nil
+}
+
+// Reset clears the recorded calls, like a host that lost its trusted peers.
+func (r *WhitelistRecorder) Reset() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	sort.Strings(calls)
+	return calls
+}
+
+func TestRemotePoolRewhitelist(t *testing.T) {
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	recorder := &WhitelistRecorder{}
+	if err := host.Server.RegisterMethod("vipnode_whitelist", recorder, "Whitelist"); err != nil {
+		t.Fatal(err)
+	}
+
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	remote := Remote(host, privkey)
+	nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", nodeID)
+	if _, err := remote.Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI}); err != nil {
+		t.Fatal(err)
+	}
+
+	var clientIDs []string
+	for i := 1; i <= 2; i++ {
+		clientPrivkey := keygen.HardcodedKeyIdx(t, i)
+		clientIDs = append(clientIDs, discv5.PubkeyID(&clientPrivkey.PublicKey).String())
+
+		server, client := jsonrpc2.ServePipe()
+		server.Server.Register("vipnode_", pool)
+		if _, err := Remote(client, clientPrivkey).Client(context.Background(), ClientRequest{Kind: "geth"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(clientIDs)
+	if got := recorder.Reset(); !reflect.DeepEqual(got, clientIDs) {
+		t.Fatalf("initial whitelist calls: got %q; want %q", got, clientIDs)
+	}
+
+	// Host reports its clients as peers
+	if _, err := remote.Update(context.Background(), UpdateRequest{Peers: clientIDs}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Host restarts and loses its trusted peers, then asks for them again
+	if err := remote.Rewhitelist(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.Reset(); !reflect.DeepEqual(got, clientIDs) {
+		t.Errorf("rewhitelist calls: got %q; want %q", got, clientIDs)
+	}
+
+	// Only hosts can ask for their clients to be whitelisted again
+	clientRemote := Remote(host, keygen.HardcodedKeyIdx(t, 1))
+	if err := clientRemote.Rewhitelist(context.Background()); err == nil || err.Error() != ErrNotHost.Error() {
+		t.Errorf("expected ErrNotHost, got: %v", err)
+	}
+}
+
+func TestRemotePoolInstances(t *testing.T) {
+	ctx := context.Background()
+	// Two pool instances share a store, like behind a load balancer.
+	sharedStore := store.MemoryStore()
+	poolA := New(sharedStore, nil)
+	poolA.InstanceID = "a"
+	poolA.skipHostCheck = true
+	poolB := New(sharedStore, nil)
+	poolB.InstanceID = "b"
+	poolB.skipHostCheck = true
+
+	// Host connects to instance A
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", poolA)
+	recorder := &WhitelistRecorder{}
+	if err := host.Server.RegisterMethod("vipnode_whitelist", recorder, "Whitelist"); err != nil {
+		t.Fatal(err)
+	}
+	hostPrivkey := keygen.HardcodedKeyIdx(t, 0)
+	hostID := discv5.PubkeyID(&hostPrivkey.PublicKey).String()
+	nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID)
+	if _, err := Remote(host, hostPrivkey).Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI}); err != nil {
+		t.Fatal(err)
+	}
+	if node, err := sharedStore.GetNode(ctx, store.NodeID(hostID)); err != nil {
+		t.Fatal(err)
+	} else if node.Instance != "a" {
+		t.Errorf("wrong host instance: %q", node.Instance)
+	}
+
+	// Client connects to instance B
+	clientPrivkey := keygen.HardcodedKeyIdx(t, 1)
+	clientID := discv5.PubkeyID(&clientPrivkey.PublicKey).String()
+	connect := func() (*ClientResponse, error) {
+		server, client := jsonrpc2.ServePipe()
+		server.Server.Register("vipnode_", poolB)
+		return Remote(client, clientPrivkey).Client(context.Background(), ClientRequest{Kind: "geth"})
+	}
+
+	// Without a route to instance A, the host can't be whitelisted.
+	_, err := connect()
+	if err == nil || !strings.Contains(err.Error(), HostInstanceError{hostID, "a"}.Error()) {
+		t.Errorf("expected HostInstanceError, got: %v", err)
+	}
+	if got := recorder.Reset(); len(got) != 0 {
+		t.Errorf("unexpected whitelist calls: %q", got)
+	}
+
+	// Instance B routes the whitelist call through instance A.
+	poolB.InstanceRouter = func(ctx context.Context, instanceID string, hostID store.NodeID) (jsonrpc2.Service, error) {
+		if instanceID != poolA.InstanceID {
+			return nil, fmt.Errorf("unknown instance: %q", instanceID)
+		}
+		service, ok := poolA.remoteHosts.Get(hostID)
+		if !ok {
+			return nil, fmt.Errorf("instance %q is missing host: %q", instanceID, hostID)
+		}
+		return service, nil
+	}
+	resp, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 || string(resp.Hosts[0].ID) != hostID {
+		t.Errorf("wrong hosts: %v", resp.Hosts)
+	}
+	if got, want := recorder.Reset(), []string{clientID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("whitelist calls: got %q; want %q", got, want)
+	}
+}
+
+func TestRemotePoolInstanceRelay(t *testing.T) {
+	sharedStore := store.MemoryStore()
+	poolA := New(sharedStore, nil)
+	poolA.InstanceID = "a"
+	poolA.skipHostCheck = true
+	poolB := New(sharedStore, nil)
+	poolB.InstanceID = "b"
+
+	// Instance B reaches instance A over its internal service
+	instanceServer, instanceClient := jsonrpc2.ServePipe()
+	if err := instanceServer.Server.Register("instance_", &InstanceService{Pool: poolA}); err != nil {
+		t.Fatal(err)
+	}
+	poolB.InstanceRouter = RelayRouter(map[string]jsonrpc2.Service{"a": instanceClient})
+
+	// Host connects to instance A
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", poolA)
+	recorder := &WhitelistRecorder{}
+	if err := host.Server.RegisterMethod("vipnode_whitelist", recorder, "Whitelist"); err != nil {
+		t.Fatal(err)
+	}
+	hostPrivkey := keygen.HardcodedKeyIdx(t, 0)
+	hostID := discv5.PubkeyID(&hostPrivkey.PublicKey).String()
+	nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID)
+	if _, err := Remote(host, hostPrivkey).Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client connects to instance B, which forwards the whitelist to A
+	clientPrivkey := keygen.HardcodedKeyIdx(t, 1)
+	clientID := discv5.PubkeyID(&clientPrivkey.PublicKey).String()
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", poolB)
+	resp, err := Remote(client, clientPrivkey).Client(context.Background(), ClientRequest{Kind: "geth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 || string(resp.Hosts[0].ID) != hostID {
+		t.Errorf("wrong hosts: %v", resp.Hosts)
+	}
+	if got, want := recorder.Reset(), []string{clientID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("whitelist calls: got %q; want %q", got, want)
+	}
+
+	// Errors from the owning instance are returned
+	relay, err := poolB.InstanceRouter(context.Background(), "a", store.NodeID("unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Call(context.Background(), nil, "vipnode_whitelist", clientID); err == nil {
+		t.Error("expected error whitelisting on an unknown host")
+	}
+	if err := relay.Call(context.Background(), nil, "vipnode_update", clientID); err == nil {
+		t.Error("expected error relaying an unsupported method")
+	}
+	if _, err := poolB.InstanceRouter(context.Background(), "c", store.NodeID(hostID)); err == nil {
+		t.Error("expected error routing to an unknown instance")
+	}
+}
+
+// metricsRecorder is a metrics.Sink that captures counters.
+type metricsRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (r *metricsRecorder) Count(name string, delta int64, tags ...metrics.Tag) {
+	for _, tag := range tags {
+		name += fmt.Sprintf(",%s=%s", tag.Key, tag.Value)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts == nil {
+		r.counts = map[string]int64{}
+	}
+	r.counts[name] += delta
+}
+
+func TestRemotePoolMetrics(t *testing.T) {
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	recorder := &metricsRecorder{}
+	pool.Metrics = recorder
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	if err := host.Server.RegisterMethod("vipnode_whitelist", &WhitelistRecorder{}, "Whitelist"); err != nil {
+		t.Fatal(err)
+	}
+	hostPrivkey := keygen.HardcodedKeyIdx(t, 0)
+	hostID := discv5.PubkeyID(&hostPrivkey.PublicKey).String()
+	nodeURI := fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID)
+	if _, err := Remote(host, hostPrivkey).Host(context.Background(), HostRequest{Kind: "geth", NodeURI: nodeURI}); err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	clientPrivkey := keygen.HardcodedKeyIdx(t, 1)
+	clientID := discv5.PubkeyID(&clientPrivkey.PublicKey).String()
+	clientRemote := Remote(client, clientPrivkey)
+	if _, err := clientRemote.Client(context.Background(), ClientRequest{Kind: "geth"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientRemote.Update(context.Background(), UpdateRequest{Peers: []string{hostID}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Update(context.Background(), "badsig", clientID, time.Now().UnixNano(), UpdateRequest{}); err == nil {
+		t.Fatal("expected verify error")
+	}
+
+	want := map[string]int64{
+		"vipnode.connect,role=host,kind=geth":                1,
+		"vipnode.connect,role=client,kind=geth":              1,
+		"vipnode.whitelist,result=accepted":                  1,
+		"vipnode.balance_update,role=client,result=unbilled": 1,
+		"vipnode.verify_failed,method=vipnode_update":        1,
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if !reflect.DeepEqual(recorder.counts, want) {
+		t.Errorf("wrong metrics:\n got: %v\nwant: %v", recorder.counts, want)
+	}
+}
+
+func TestRemotePoolStats(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	now := time.Now()
+	stale := now.Add(-2 * store.ExpireInterval)
+	nodes := []store.Node{
+		{ID: "host1", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "host2", IsHost: true, Kind: "geth", LastSeen: stale},
+		{ID: "host3", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "client1", Kind: "geth", LastSeen: now},
+		{ID: "client2", Kind: "geth", LastSeen: now},
+		{ID: "client3", Kind: "parity", LastSeen: stale},
+	}
+	for _, n := range nodes {
+		if err := pool.Store.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Store.AddNodeBalance(ctx, "host1", big.NewInt(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Store.AddNodeBalance(ctx, "host3", big.NewInt(234)); err != nil {
+		t.Fatal(err)
+	}
+
+	operatorKey := keygen.HardcodedKeyIdx(t, 0)
+	otherKey := keygen.HardcodedKeyIdx(t, 1)
+	pool.Operators = []string{discv5.PubkeyID(&operatorKey.PublicKey).String()}
+
+	if _, err := Remote(client, otherKey).Stats(context.Background()); err == nil || !strings.Contains(err.Error(), ErrNotOperator.Error()) {
+		t.Errorf("expected not operator error, got: %v", err)
+	}
+
+	stats, err := Remote(client, operatorKey).Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PoolStats{
+		NumHosts:   3,
+		NumClients: 3,
+		Kinds: map[string]KindStats{
+			"geth":   {ActiveHosts: 1, StaleHosts: 1, ActiveClients: 2},
+			"parity": {ActiveHosts: 1, StaleClients: 1},
+		},
+		TotalCredit: "1234",
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("wrong stats:\n got: %+v\nwant: %+v", stats, want)
+	}
+}
+
+func TestRemotePoolAccountsAbove(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	credits := map[store.Account]int64{
+		"0xabove": 5000,
+		"0xbelow": 500,
+		"0xequal": 1000,
+	}
+	for account, credit := range credits {
+		if err := pool.Store.AddAccountBalance(ctx, account, big.NewInt(credit)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	operatorKey := keygen.HardcodedKeyIdx(t, 0)
+	otherKey := keygen.HardcodedKeyIdx(t, 1)
+	pool.Operators = []string{discv5.PubkeyID(&operatorKey.PublicKey).String()}
+
+	if _, err := Remote(client, otherKey).AccountsAbove(context.Background(), big.NewInt(1000)); err == nil || !strings.Contains(err.Error(), ErrNotOperator.Error()) {
+		t.Errorf("expected not operator error, got: %v", err)
+	}
+
+	accounts, err := Remote(client, operatorKey).AccountsAbove(context.Background(), big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []store.Account{"0xabove"}; !reflect.DeepEqual(accounts, want) {
+		t.Errorf("got: %v; want: %v", accounts, want)
+	}
+}
+
+type disconnectRecorder struct {
+	mu      sync.Mutex
+	clients []string
+}
+
+func (r *disconnectRecorder) Disconnect(ctx context.Context, nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = append(r.clients, nodeID)
+	return nil
+}
+
+func TestRemotePoolEvict(t *testing.T) {
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	operatorKey := keygen.HardcodedKeyIdx(t, 0)
+	pool.Operators = []string{discv5.PubkeyID(&operatorKey.PublicKey).String()}
+
+	// Register a host that serves vipnode_disconnect
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	recorder := &disconnectRecorder{}
+	if err := host.Server.RegisterMethod("vipnode_disconnect", recorder, "Disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	hostKey := keygen.HardcodedKeyIdx(t, 1)
+	hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+	ctx := context.Background()
+	if _, err := Remote(host, hostKey).Host(ctx, HostRequest{Kind: "geth", NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID)}); err != nil {
+		t.Fatal(err)
+	}
+	clientID := discv5.PubkeyID(&keygen.HardcodedKeyIdx(t, 2).PublicKey).String()
+	if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(clientID), Kind: "geth", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Store.UpdateNodePeers(ctx, store.NodeID(hostID), []string{clientID}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	server2, operator := jsonrpc2.ServePipe()
+	server2.Server.Register("vipnode_", pool)
+	if err := Remote(operator, keygen.HardcodedKeyIdx(t, 2)).Evict(ctx, hostID); err == nil || !strings.Contains(err.Error(), ErrNotOperator.Error()) {
+		t.Errorf("expected not operator error, got: %v", err)
+	}
+
+	if err := Remote(operator, operatorKey).Evict(ctx, hostID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Store.GetNode(ctx, store.NodeID(hostID)); err != store.ErrUnregisteredNode {
+		t.Errorf("evicted host is still registered: %v", err)
+	}
+	if _, ok := pool.remoteHosts.Get(store.NodeID(hostID)); ok {
+		t.Error("evicted host is still connected")
+	}
+	recorder.mu.Lock()
+	if want := []string{clientID}; !reflect.DeepEqual(recorder.clients, want) {
+		t.Errorf("disconnected clients: got %v; want %v", recorder.clients, want)
+	}
+	recorder.mu.Unlock()
+
+	if err := Remote(operator, operatorKey).Evict(ctx, hostID); err == nil || !strings.Contains(err.Error(), store.ErrUnregisteredNode.Error()) {
+		t.Errorf("expected unregistered node error, got: %v", err)
+	}
+}
+
+func TestRemotePoolDrain(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	pool.skipWhitelist = true
+	pool.DrainGrace = time.Hour
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	var hostIDs []string
+	var hosts []*RemotePool
+	for i := 0; i < 2; i++ {
+		hostKey := keygen.HardcodedKeyIdx(t, i)
+		hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+		remote := Remote(host, hostKey)
+		if _, err := remote.Host(ctx, HostRequest{Kind: "geth", NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:3030%d", hostID, i)}); err != nil {
+			t.Fatal(err)
+		}
+		hostIDs = append(hostIDs, hostID)
+		hosts = append(hosts, remote)
+	}
+
+	if err := hosts[0].Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The draining host gets no new clients
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	clientRemote := Remote(client, keygen.HardcodedKeyIdx(t, 2))
+	for i := 0; i < 10; i++ {
+		resp, err := clientRemote.Client(ctx, ClientRequest{Kind: "geth"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != 1 || resp.Hosts[0].ID != store.NodeID(hostIDs[1]) {
+			t.Fatalf("got hosts %v; want only %q", resp.Hosts, hostIDs[1])
+		}
+	}
+
+	// But it isn't removed until the grace period is over
+	if node, err := pool.Store.GetNode(ctx, store.NodeID(hostIDs[0])); err != nil {
+		t.Fatalf("draining host was removed: %s", err)
+	} else if !node.Draining {
+		t.Errorf("host is not draining: %v", node)
+	}
+
+	// Only hosts can be drained
+	if err := clientRemote.Drain(ctx); err == nil || err.Error() != ErrNotHost.Error() {
+		t.Errorf("expected ErrNotHost, got: %v", err)
+	}
+
+	// Closing the pool doesn't wait for the grace period
+	closeCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := pool.Close(closeCtx); err != nil {
+		t.Errorf("unexpected close error: %s", err)
+	}
+}
+
+func TestRemotePoolDrainGrace(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	pool.DrainGrace = 10 * time.Millisecond
+
+	server, host := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+	hostKey := keygen.HardcodedKeyIdx(t, 0)
+	hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+	remote := Remote(host, hostKey)
+	if _, err := remote.Host(ctx, HostRequest{Kind: "geth", NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := pool.Store.GetNode(ctx, store.NodeID(hostID))
+		if err == store.ErrUnregisteredNode {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("drained host was not removed after the grace period")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRemotePoolSigningKey(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	nodeKey := keygen.HardcodedKeyIdx(t, 0)
+	signingKey := keygen.HardcodedKeyIdx(t, 1)
+	nodeID := discv5.PubkeyID(&nodeKey.PublicKey).String()
+	signerID := discv5.PubkeyID(&signingKey.PublicKey).String()
+	if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), Kind: "geth", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := RemoteSigner(client, signingKey, nodeID)
+	if _, err := signer.Update(ctx, UpdateRequest{Peers: []string{}}); err == nil {
+		t.Fatal("expected unbound signing key to fail verification")
+	}
+
+	if err := Remote(client, nodeKey).BindSigningKey(ctx, signerID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Update(ctx, UpdateRequest{Peers: []string{}}); err != nil {
+		t.Errorf("expected bound signing key to be accepted: %s", err)
+	}
+
+	// The signing key can't rebind itself
+	otherID := discv5.PubkeyID(&keygen.HardcodedKeyIdx(t, 2).PublicKey).String()
+	if err := signer.BindSigningKey(ctx, otherID); err == nil {
+		t.Error("expected signing key to be refused for binding")
+	}
+
+	// Unbinding revokes the signing key
+	if err := Remote(client, nodeKey).BindSigningKey(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Update(ctx, UpdateRequest{Peers: []string{}}); err == nil {
+		t.Error("expected unbound signing key to fail verification")
+	}
+}
+
+func TestRemotePoolNoCompatibleHosts(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "bar", URI: "enode://bar", IsHost: true, Kind: "parity", LastSeen: time.Now()}); err != nil {
+		t.Fatal("failed to add host node:", err)
+	}
+
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	_, err := Remote(client, keygen.HardcodedKey(t)).Client(ctx, ClientRequest{Kind: "geth"})
+	errResp, ok := err.(*jsonrpc2.ErrResponse)
+	if !ok {
+		t.Fatalf("expected *jsonrpc2.ErrResponse, got: %T", err)
+	}
+	var data NoCompatibleHostsError
+	if err := errResp.UnmarshalData(&data); err != nil {
+		t.Fatal(err)
+	}
+	if want := (NoCompatibleHostsError{Kind: "geth", Available: []string{"parity"}}); !reflect.DeepEqual(data, want) {
+		t.Errorf("wrong error data: got %+v; want %+v", data, want)
+	}
+}
+
+func TestRemotePoolClock(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	pool := New(memStore, nil)
+	pool.Clock = fakeClock
+	pool.skipHostCheck = true
+	pool.skipWhitelist = true
+
+	server, client := jsonrpc2.ServePipe()
+	server.Server.Register("vipnode_", pool)
+
+	hostKey := keygen.HardcodedKeyIdx(t, 0)
+	hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+	if _, err := Remote(client, hostKey).Host(ctx, HostRequest{
+		Kind:    "geth",
+		NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", hostID),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	node, err := memStore.GetNode(ctx, store.NodeID(hostID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.LastSeen.Equal(fakeClock.Now()) {
+		t.Errorf("host was not seen at the pool's time: %s", node.LastSeen)
+	}
+
+	clientKey := keygen.HardcodedKeyIdx(t, 1)
+	resp, err := Remote(client, clientKey).Client(ctx, ClientRequest{Kind: "geth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 {
+		t.Errorf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+
+	// The host expires on the same clock that it was seen on
+	fakeClock.Add(store.ExpireInterval * 2)
+	if _, err := Remote(client, clientKey).Client(ctx, ClientRequest{Kind: "geth"}); err == nil || err.Error() != (NoHostNodesError{}).Error() {
+		t.Errorf("expected NoHostNodesError, got: %v", err)
+	}
+}
+
+func TestRemotePoolPayoutChangeCooldown(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipHostCheck = true
+	pool.PayoutChangeCooldown = time.Hour
+
+	// Hosts on the pipe are limited by their payout address
+	pool.MaxHostsPerSource = 1
+
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	registerKey := func(privkey *ecdsa.PrivateKey, payout string) error {
+		server, host := jsonrpc2.ServePipe()
+		server.Server.Register("vipnode_", pool)
+		_, err := Remote(host, privkey).Host(context.Background(), HostRequest{
+			Kind:    "geth",
+			Payout:  payout,
+			NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", discv5.PubkeyID(&privkey.PublicKey).String()),
+		})
+		return err
+	}
+	register := func(payout string) error {
+		return registerKey(privkey, payout)
+	}
+
+	const payout1 = "0x0000000000000000000000000000000000000001"
+	const payout2 = "0x0000000000000000000000000000000000000002"
+	const payout3 = "0x0000000000000000000000000000000000000003"
+	const payout4 = "0x0000000000000000000000000000000000000004"
+	if err := register(payout1); err != nil {
+		t.Fatal(err)
+	}
+	if err := register(payout1); err != nil {
+		t.Errorf("reconnecting with the same payout rejected: %s", err)
+	}
+
+	// A change that fails after the cooldown check doesn't use it up
+	if err := registerKey(keygen.HardcodedKeyIdx(t, 1), payout4); err != nil {
+		t.Fatal(err)
+	}
+	if err := register(payout4); err == nil || err.Error() != ErrTooManyHosts.Error() {
+		t.Errorf("expected ErrTooManyHosts, got: %v", err)
+	}
+	if err := register(payout2); err != nil {
+		t.Errorf("first payout change rejected: %s", err)
+	}
+	if err := register(payout3); err == nil || err.Error() != ErrPayoutChangeTooSoon.Error() {
+		t.Errorf("expected ErrPayoutChangeTooSoon, got: %v", err)
+	}
+
+	node, err := pool.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Payout != payout2 {
+		t.Errorf("wrong payout: %q", node.Payout)
+	}
+}
@@ -3,13 +3,19 @@ package pool
 import (
 	"context"
 	"fmt"
+	"math/big"
+	"net"
 	"net/url"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/internal/pretty"
 	"github.com/vipnode/vipnode/jsonrpc2"
 	"github.com/vipnode/vipnode/pool/balance"
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/metrics"
 	"github.com/vipnode/vipnode/pool/store"
 	"github.com/vipnode/vipnode/request"
 )
@@ -28,56 +34,458 @@ func New(storeDriver store.Store, manager balance.Manager) *VipnodePool {
 	return &VipnodePool{
 		Store:          storeDriver,
 		BalanceManager: manager,
-		remoteHosts:    map[store.NodeID]jsonrpc2.Service{},
+		remoteHosts:    newHostRegistry(),
+		balanceSubs:    map[store.NodeID]chan struct{}{},
+		closing:        make(chan struct{}),
+		Clock:          clock.Real(),
 	}
 }
 
-const poolWhitelistTimeout = 5 * time.Second
+// defaultWhitelistTimeout is how long the pool waits for its RPC calls to
+// nodes when VipnodePool.WhitelistTimeout is zero.
+const defaultWhitelistTimeout = 5 * time.Second
+
+// clientTimeoutFactor is how many whitelist timeouts the pool spends on
+// whitelisting a connecting client with its hosts in total, including any
+// backup hosts that are tried after the first hosts fail.
+const clientTimeoutFactor = 2
+
+// backupHostsFactor is how many more candidate hosts than requested are
+// looked up for a connecting client, so that backup hosts can be whitelisted
+// if the first hosts fail.
+const backupHostsFactor = 3
+
+// defaultDrainGrace is how long a draining host keeps its clients when
+// VipnodePool.DrainGrace is zero.
+const defaultDrainGrace = 5 * store.KeepaliveInterval
+
+// defaultNumRequestHosts is the number of candidate hosts requested for a
+// client when VipnodePool.NumRequestHosts is zero.
+const defaultNumRequestHosts = 3
+
+// maxAltNodeURIs is the most alternative URIs that a host can register,
+// additional URIs are ignored.
+const maxAltNodeURIs = 8
+
+// spreadSampleFactor is how many more active hosts than requested are looked
+// up for clients that ask for DistributeSpread, to choose diverse hosts from.
+const spreadSampleFactor = 8
+
+// maxPreferredHosts is the most preferred hosts that are looked up for a
+// client request, additional preferred hosts are ignored.
+const maxPreferredHosts = 16
+
+// minBalanceInterval is the shortest interval that a balance subscription can
+// request, to avoid flooding the connection.
+var minBalanceInterval = 5 * time.Second
+
+// WhitelistStrategy describes when to stop waiting for candidate hosts to
+// accept a client's whitelist request.
+type WhitelistStrategy struct {
+	// Quorum is the number of hosts that must accept before returning. The
+	// remaining pending whitelist requests are left to finish once the quorum
+	// is reached, and the hosts that accept late are asked to disconnect the
+	// client. If zero, then wait for all hosts to respond within the
+	// timeout. If fewer than Quorum hosts accept, the client request fails
+	// and the hosts that accepted are asked to disconnect the client.
+	// A Quorum above the number of hosts requested for a client, such as
+	// NumRequestHosts or the single host of DistributeMinimal, is lowered to
+	// that number.
+	Quorum int
+}
+
+var (
+	// WhitelistAll waits for all candidate hosts to respond within the
+	// timeout, and returns any that accepted.
+	WhitelistAll = WhitelistStrategy{}
+	// WhitelistFirst returns as soon as the first host accepts.
+	WhitelistFirst = WhitelistStrategy{Quorum: 1}
+)
+
+// WhitelistQuorum returns a strategy that waits for n hosts to accept.
+func WhitelistQuorum(n int) WhitelistStrategy {
+	return WhitelistStrategy{Quorum: n}
+}
 
 // VipnodePool implements a Pool service with balance tracking.
 type VipnodePool struct {
 	// Version is returned as the PoolVersion in the ClientResponse when a new client connects.
 	Version string
 
-	Store          store.Store
+	Store store.Store
+	// BalanceManager tracks the balances of connected nodes. If nil, then
+	// balance.NoBalance{} is used and the pool runs unmetered.
 	BalanceManager balance.Manager
 	ClientMessager func(nodeID string) string
 
+	// HostQuota returns the maximum number of hosts that a client can be
+	// connected to at once, such as based on the client's tier. Zero means
+	// no limit. If HostQuota is nil, then clients are not limited.
+	HostQuota func(nodeID string) int
+
+	// NumRequestHosts is the number of candidate hosts that are offered to a
+	// connecting client. If zero, then 3 hosts are requested.
+	NumRequestHosts int
+
+	// MaxCreditedHosts is the maximum number of host peers that a client's
+	// update can be billed for. Any additional hosts that the client reports
+	// are ignored for balance purposes, so a client cannot drain its balance
+	// (or credit colluding hosts) by claiming hundreds of connections. Since
+	// the balance manager only sees the bounded peers, spending limits such
+	// as the minimum balance apply to the bounded total. Zero means no limit.
+	MaxCreditedHosts int
+
+	// MaxHostsPerSource is the maximum number of active hosts that can be
+	// registered from the same source at once, where the source is the
+	// host's IP address or, if that is unknown, its payout account. This
+	// keeps a single actor from dominating host selection with many fake
+	// hosts. Zero means no limit.
+	MaxHostsPerSource int
+
+	// HostRegistrationLimit is the maximum number of new hosts that can
+	// register from the same source within HostRegistrationWindow.
+	// Reconnecting hosts are not counted. Zero means no limit.
+	HostRegistrationLimit  int
+	HostRegistrationWindow time.Duration
+
+	// PayoutChangeCooldown is the minimum time between changes to a host's
+	// payout account, so that hosts can't game the pool by rapidly switching
+	// where their credit goes. Zero means no limit.
+	PayoutChangeCooldown time.Duration
+
+	// WhitelistTimeout is how long the pool waits for a host to respond to
+	// vipnode_whitelist and the pool's other RPC calls to nodes. Hosts on
+	// high-latency links can need longer. If zero, then 5 seconds is used.
+	WhitelistTimeout time.Duration
+
+	// MaxRequestSkew is how far the timestamp of a signed request (its nonce)
+	// can be from the pool's Clock before the request is rejected as
+	// expired. If zero, then the timestamp is only checked by the nonce
+	// store.
+	MaxRequestSkew time.Duration
+
+	// Clock is the pool's source of time, such as for the LastSeen of nodes
+	// that connect, rate limits and the MaxRequestSkew check. It should be the
+	// same clock as the Store's, since the store measures node activity
+	// against it. New sets it to the real clock.
+	Clock clock.Clock
+
+	// InstanceID identifies this pool instance when multiple instances share
+	// a store, such as behind a load balancer. Hosts are recorded in the
+	// store with the instance that holds their connection.
+	InstanceID string
+
+	// InstanceRouter returns a service that relays calls to a host whose
+	// connection is held by another pool instance. If nil, then hosts that
+	// are connected to other instances can't be called by this instance.
+	// RelayRouter forwards the calls to the other instance's InstanceService.
+	InstanceRouter func(ctx context.Context, instanceID string, hostID store.NodeID) (jsonrpc2.Service, error)
+
+	// HostSelector chooses which active hosts are offered to clients, such
+	// as store.LeastPeersSelector to prefer less loaded hosts or
+	// store.LeastClientsSelector to spread clients round-robin. If nil, then
+	// hosts are chosen randomly.
+	HostSelector store.HostSelector
+
+	// MinHostVersion is the oldest vipnode agent version that hosts can
+	// run, such as "v2.3.0". Older hosts are refused when they register,
+	// and hosts that registered before the minimum was raised are no
+	// longer offered to clients. If empty, then any version is allowed.
+	MinHostVersion string
+
+	// RateLimits limits how often each node can call a method, keyed by
+	// the RPC method name such as "vipnode_update". Calls beyond the limit
+	// are refused with RateLimitedError once their signature is verified.
+	// Methods without a limit are not limited.
+	RateLimits map[string]RateLimit
+
+	// VerifyHostPeers cross-checks each host update against the peers that
+	// the host's node actually has, by calling vipnode_peers on the host,
+	// so that hosts can't earn credit for clients they don't serve.
+	// Reported peers that the node doesn't confirm are ignored, and the
+	// host's credit is frozen for that update. Hosts that can't be asked
+	// are not checked.
+	VerifyHostPeers bool
+
+	// Metrics receives counters of pool events, such as connects and
+	// whitelist results. If nil, then metrics are discarded.
+	Metrics metrics.Sink
+
+	// Logger receives the pool's log messages, with the request's fields
+	// in the context. If nil, then messages are written to the package
+	// logger that is set with SetLogger.
+	Logger Logger
+
+	// Operators are the node IDs that can sign requests for operator-only
+	// methods, such as Stats. If empty, then operator-only methods are
+	// refused.
+	Operators []string
+
+	// DrainGrace is how long a host that asked to be drained keeps serving
+	// its current clients before it's removed from the pool. If zero, then
+	// five keepalive intervals are used.
+	DrainGrace time.Duration
+
+	// WhitelistStrategy determines how many hosts need to accept the
+	// whitelist request before a client is given its hosts. The default
+	// waits for every candidate host to respond within the timeout.
+	WhitelistStrategy WhitelistStrategy
+
 	// skipWhitelist is used for testing.
 	skipWhitelist bool
+	// skipHostCheck skips the vipnode_capabilities check of registering
+	// hosts, used for testing.
+	skipHostCheck bool
+
+	mu            sync.Mutex
+	closed        bool
+	closing       chan struct{}
+	wg            sync.WaitGroup
+	remoteHosts   *hostRegistry
+	hostLimiter   sourceLimiter
+	payoutLimiter changeLimiter
+	callLimiter   tokenLimiter
+	balanceSubs   map[store.NodeID]chan struct{}
+}
+
+// goTracked runs fn in a goroutine that Close waits for. If the pool is
+// closed, then fn is not run and false is returned.
+func (p *VipnodePool) goTracked(fn func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// Close shuts down the pool: it stops the balance subscriptions, waits for
+// the in-flight whitelist requests to finish, and closes the store. If ctx is
+// done before the requests finish, then the store is closed anyway and the
+// context's error is returned. Closing a closed pool does nothing.
+func (p *VipnodePool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.closing)
+	for nodeID, stopCh := range p.balanceSubs {
+		close(stopCh)
+		delete(p.balanceSubs, nodeID)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	var waitErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+	if err := p.Store.Close(); err != nil {
+		return err
+	}
+	return waitErr
+}
+
+// verify checks a signed request. The request can be signed by the node's
+// own key, or by a signing key that was bound to the node with
+// BindSigningKey.
+func (p *VipnodePool) verify(ctx context.Context, sig string, method string, nodeID string, nonce int64, args ...interface{}) error {
+	if err := p.countVerify(method, p.verifyRequest(ctx, true, sig, method, nodeID, nonce, args...)); err != nil {
+		return err
+	}
+	return p.checkRateLimit(method, nodeID)
+}
+
+// verifyNodeKey is like verify, but only accepts requests that are signed by
+// the node's own key.
+func (p *VipnodePool) verifyNodeKey(ctx context.Context, sig string, method string, nodeID string, nonce int64, args ...interface{}) error {
+	if err := p.countVerify(method, p.verifyRequest(ctx, false, sig, method, nodeID, nonce, args...)); err != nil {
+		return err
+	}
+	return p.checkRateLimit(method, nodeID)
+}
+
+// checkRateLimit takes one of the node's calls to the method from its rate
+// limit, and returns RateLimitedError if none remain. Only verified requests
+// are counted, so that nodes can't use up each other's limits.
+func (p *VipnodePool) checkRateLimit(method string, nodeID string) error {
+	limit, ok := p.RateLimits[method]
+	if !ok || limit.Burst <= 0 || limit.Interval <= 0 {
+		return nil
+	}
+	if ok, retryAfter := p.callLimiter.Allow(method+":"+nodeID, limit, p.Clock.Now()); !ok {
+		p.count(metrics.RateLimited, metrics.Tag{Key: "method", Value: method})
+		return RateLimitedError{Method: method, RetryAfter: retryAfter}
+	}
+	return nil
+}
 
-	mu          sync.Mutex
-	remoteHosts map[store.NodeID]jsonrpc2.Service
+// countVerify counts the request verification if it failed.
+func (p *VipnodePool) countVerify(method string, err error) error {
+	if err != nil {
+		p.count(metrics.VerifyFailed, metrics.Tag{Key: "method", Value: method})
+	}
+	return err
 }
 
-func (p *VipnodePool) verify(sig string, method string, nodeID string, nonce int64, args ...interface{}) error {
-	// TODO: Switch nonce to strictly timestamp within X time
+func (p *VipnodePool) verifyRequest(ctx context.Context, allowSigningKey bool, sig string, method string, nodeID string, nonce int64, args ...interface{}) error {
 	// TODO: Switch NodeID to pubkey?
-	if err := p.Store.CheckAndSaveNonce(nodeID, nonce); err != nil {
+	if p.MaxRequestSkew > 0 {
+		if err := request.VerifyTimestamp(nonce, p.Clock.Now(), p.MaxRequestSkew); err != nil {
+			return VerifyFailedError{Cause: err, Method: method}
+		}
+	}
+
+	err := request.Verify(sig, method, nodeID, nonce, args...)
+	if err == request.ErrBadSignature && allowSigningKey {
+		err = p.verifySigningKey(ctx, sig, method, nodeID, nonce, args...)
+	}
+	if err != nil {
 		return VerifyFailedError{Cause: err, Method: method}
 	}
 
-	if err := request.Verify(sig, method, nodeID, nonce, args...); err != nil {
+	// The nonce is only saved for signed requests, so that other nodes can't
+	// use up a node's nonces.
+	if err := p.Store.CheckAndSaveNonce(ctx, nodeID, nonce); err != nil {
 		return VerifyFailedError{Cause: err, Method: method}
 	}
 	return nil
 }
 
+// verifySigningKey checks a request signature against the signing key that is
+// bound to the node, if any.
+func (p *VipnodePool) verifySigningKey(ctx context.Context, sig string, method string, nodeID string, nonce int64, args ...interface{}) error {
+	signers, ok := p.Store.(store.SigningKeyStore)
+	if !ok {
+		return request.ErrBadSignature
+	}
+	signerID, err := signers.GetSigningKey(ctx, store.NodeID(nodeID))
+	if err != nil {
+		return err
+	}
+	if signerID.IsZero() {
+		return request.ErrBadSignature
+	}
+	return request.VerifyKey(sig, string(signerID), method, nodeID, nonce, args...)
+}
+
+// count increments the metrics counter with the given name.
+func (p *VipnodePool) count(name string, tags ...metrics.Tag) {
+	if p.Metrics == nil {
+		return
+	}
+	p.Metrics.Count(name, 1, tags...)
+}
+
+// countBalanceUpdate increments the metrics.BalanceUpdate counter for the
+// result of a node's balance update.
+func (p *VipnodePool) countBalanceUpdate(node store.Node, result string) {
+	p.count(metrics.BalanceUpdate, roleTag(node), metrics.Tag{Key: "result", Value: result})
+}
+
+func roleTag(node store.Node) metrics.Tag {
+	if node.IsHost {
+		return metrics.Tag{Key: "role", Value: "host"}
+	}
+	return metrics.Tag{Key: "role", Value: "client"}
+}
+
+// log writes a log message through the pool's Logger.
+func (p *VipnodePool) log(ctx context.Context, format string, v ...interface{}) {
+	if p.Logger == nil {
+		packageLogger{}.Printf(ctx, format, v...)
+		return
+	}
+	p.Logger.Printf(ctx, format, v...)
+}
+
+// hostSelector returns the HostSelector, wrapped to exclude hosts below
+// MinHostVersion if it's set.
+func (p *VipnodePool) hostSelector() store.HostSelector {
+	if p.MinHostVersion == "" {
+		return p.HostSelector
+	}
+	return minVersionSelector{MinVersion: p.MinHostVersion, Selector: p.HostSelector}
+}
+
+// hostVersionAllowed returns whether a host's version is at least
+// MinHostVersion, if it's set.
+func (p *VipnodePool) hostVersionAllowed(version string) bool {
+	return p.MinHostVersion == "" || versionAtLeast(version, p.MinHostVersion)
+}
+
+func (p *VipnodePool) balanceManager() balance.Manager {
+	if p.BalanceManager == nil {
+		return balance.NoBalance{}
+	}
+	return p.BalanceManager
+}
+
+func (p *VipnodePool) drainGrace() time.Duration {
+	if p.DrainGrace <= 0 {
+		return defaultDrainGrace
+	}
+	return p.DrainGrace
+}
+
+func (p *VipnodePool) whitelistTimeout() time.Duration {
+	if p.WhitelistTimeout <= 0 {
+		return defaultWhitelistTimeout
+	}
+	return p.WhitelistTimeout
+}
+
+// noHostsError returns NoCompatibleHostsError if there are active hosts of
+// other kinds, or NoHostNodesError otherwise.
+func (p *VipnodePool) noHostsError(ctx context.Context, kind string) error {
+	if kind == "" {
+		return NoHostNodesError{}
+	}
+	hosts, err := p.Store.ActiveHosts(ctx, store.AnyKind, store.NoLimit, nil)
+	if err != nil || len(hosts) == 0 {
+		return NoHostNodesError{}
+	}
+	seen := map[string]struct{}{}
+	available := []string{}
+	for _, host := range hosts {
+		hostKind := ParseKind(host.Kind).String()
+		if _, ok := seen[hostKind]; ok {
+			continue
+		}
+		seen[hostKind] = struct{}{}
+		available = append(available, hostKind)
+	}
+	sort.Strings(available)
+	return NoCompatibleHostsError{
+		Kind:      kind,
+		Available: available,
+	}
+}
+
 func (p *VipnodePool) disconnectPeers(ctx context.Context, nodeID string, peers []store.Node) error {
-	callCtx, cancel := context.WithTimeout(ctx, poolWhitelistTimeout)
+	callCtx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
 	defer cancel()
 	errCh := make(chan error, 1)
-	count := 0
-	p.mu.Lock()
-	for _, peer := range peers {
-		if remote, ok := p.remoteHosts[peer.ID]; ok {
-			count += 1
-			go func() {
-				errCh <- remote.Call(callCtx, nil, "vipnode_disconnect", nodeID)
-			}()
-		}
+	remotes, _ := p.hostServices(ctx, peers)
+	count := len(remotes)
+	for _, remote := range remotes {
+		go func(service jsonrpc2.Service) {
+			errCh <- service.Call(callCtx, nil, "vipnode_disconnect", nodeID)
+		}(remote.Service)
 	}
-	p.mu.Unlock()
 
 	errors := []error{}
 	for i := 0; i < count; i++ {
@@ -96,32 +504,314 @@ func (p *VipnodePool) disconnectPeers(ctx context.Context, nodeID string, peers
 	return nil
 }
 
+// hostServices pairs each host with the service to call it on: either its
+// connection to this instance, or a relay through the instance that holds its
+// connection. Hosts that can't be reached are returned as errors.
+func (p *VipnodePool) hostServices(ctx context.Context, hosts []store.Node) ([]hostService, []error) {
+	local := make([]store.Node, 0, len(hosts))
+	found := make([]hostService, 0, len(hosts))
+	errors := []error{}
+	for _, host := range hosts {
+		if host.Instance == "" || host.Instance == p.InstanceID {
+			local = append(local, host)
+			continue
+		}
+		// Any connection this instance still has to the host is stale.
+		if p.InstanceRouter == nil {
+			errors = append(errors, HostInstanceError{string(host.ID), host.Instance})
+			continue
+		}
+		service, err := p.InstanceRouter(ctx, host.Instance, host.ID)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		found = append(found, hostService{host, service})
+	}
+
+	remotes, missing := p.remoteHosts.Candidates(local)
+	for _, node := range missing {
+		errors = append(errors, fmt.Errorf("missing remote service for candidate host: %q", node.ID))
+	}
+	return append(remotes, found...), errors
+}
+
+// candidateHosts returns up to limit active hosts of the given kind, starting
+// with any of the preferred hosts that are still active and compatible. If
+// spread is set, then the remainder is chosen from different operators and
+// networks where possible.
+func (p *VipnodePool) candidateHosts(ctx context.Context, kind Kind, limit int, preferred []string, spread bool) ([]store.Node, error) {
+	if len(preferred) > maxPreferredHosts {
+		preferred = preferred[:maxPreferredHosts]
+	}
+
+	r := make([]store.Node, 0, limit)
+	seen := map[store.NodeID]struct{}{}
+	seenSince := p.Clock.Now().Add(-store.ExpireInterval)
+	for _, id := range preferred {
+		if len(r) >= limit {
+			return r, nil
+		}
+		nodeID, err := store.ParseNodeID(id)
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[nodeID]; ok {
+			continue
+		}
+		node, err := p.Store.GetNode(ctx, nodeID)
+		if err == store.ErrUnregisteredNode {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !node.IsHost || !kind.Matches(ParseKind(node.Kind)) || !node.LastSeen.After(seenSince) || !p.hostVersionAllowed(node.Version) || node.IsFull() || node.Draining {
+			continue
+		}
+		seen[nodeID] = struct{}{}
+		r = append(r, *node)
+	}
+	if len(r) >= limit {
+		return r, nil
+	}
+
+	// Preferred hosts could be selected again, so ask for the full limit to
+	// be sure there are enough to fill the remainder.
+	fetchLimit := limit
+	if spread {
+		fetchLimit = limit * spreadSampleFactor
+	}
+	hosts, err := activeHosts(ctx, p.Store, kind, fetchLimit, p.hostSelector())
+	if err != nil {
+		return nil, err
+	}
+	if spread {
+		return spreadHosts(r, hosts, limit), nil
+	}
+	for _, node := range hosts {
+		if len(r) >= limit {
+			break
+		}
+		if _, ok := seen[node.ID]; ok {
+			continue
+		}
+		r = append(r, node)
+	}
+	return r, nil
+}
+
+// claimHostSlots reserves a slot for the client on each of the hosts, if the
+// store supports it, and returns the hosts that have capacity for the client.
+func (p *VipnodePool) claimHostSlots(ctx context.Context, clientID store.NodeID, hosts []store.Node) []store.Node {
+	slots, ok := p.Store.(store.HostSlotStore)
+	if !ok {
+		return hosts
+	}
+	r := make([]store.Node, 0, len(hosts))
+	for _, host := range hosts {
+		ok, err := slots.ClaimHostSlot(host.ID, clientID)
+		if err != nil {
+			p.log(ctx, "Failed to claim slot on host %q for client %q: %s", pretty.Abbrev(string(host.ID)), pretty.Abbrev(string(clientID)), err)
+			continue
+		}
+		if ok {
+			r = append(r, host)
+		}
+	}
+	return r
+}
+
+// releaseHostSlots releases the client's slots on the hosts, except for the
+// hosts in keep.
+func (p *VipnodePool) releaseHostSlots(ctx context.Context, clientID store.NodeID, hosts []store.Node, keep []store.Node) {
+	slots, ok := p.Store.(store.HostSlotStore)
+	if !ok {
+		return
+	}
+	kept := make(map[store.NodeID]struct{}, len(keep))
+	for _, host := range keep {
+		kept[host.ID] = struct{}{}
+	}
+	for _, host := range hosts {
+		if _, ok := kept[host.ID]; ok {
+			continue
+		}
+		if err := slots.ReleaseHostSlot(host.ID, clientID); err != nil {
+			p.log(ctx, "Failed to release slot on host %q for client %q: %s", pretty.Abbrev(string(host.ID)), pretty.Abbrev(string(clientID)), err)
+		}
+	}
+}
+
+// assignHosts counts the client towards the Clients of each of the hosts
+// that it was given, in place of the hosts it had before. The store releases
+// them again when the client is removed, whether it disconnects or expires.
+func (p *VipnodePool) assignHosts(ctx context.Context, clientID store.NodeID, hosts []store.Node) error {
+	hostIDs := make([]store.NodeID, 0, len(hosts))
+	for _, host := range hosts {
+		hostIDs = append(hostIDs, host.ID)
+	}
+	return p.Store.AssignHosts(ctx, clientID, hostIDs)
+}
+
+// spreadHosts appends hosts to selected until there are limit hosts,
+// preferring hosts whose operator and network are not selected yet. Hosts in
+// selected are skipped.
+func spreadHosts(selected []store.Node, hosts []store.Node, limit int) []store.Node {
+	seen := map[store.NodeID]struct{}{}
+	operators := map[string]struct{}{}
+	networks := map[string]struct{}{}
+	add := func(node store.Node) {
+		seen[node.ID] = struct{}{}
+		operators[hostOperator(node)] = struct{}{}
+		networks[hostNetwork(node)] = struct{}{}
+	}
+	for _, node := range selected {
+		add(node)
+	}
+
+	// Each pass relaxes the diversity requirement: first new operators on
+	// new networks, then new operators, then anything.
+	for pass := 0; pass < 3 && len(selected) < limit; pass++ {
+		for _, node := range hosts {
+			if len(selected) >= limit {
+				break
+			}
+			if _, ok := seen[node.ID]; ok {
+				continue
+			}
+			_, seenOperator := operators[hostOperator(node)]
+			_, seenNetwork := networks[hostNetwork(node)]
+			if (pass < 2 && seenOperator) || (pass < 1 && seenNetwork) {
+				continue
+			}
+			add(node)
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// hostOperator identifies who runs a host by its payout account, falling back
+// to its network for hosts without one.
+func hostOperator(node store.Node) string {
+	if node.Payout != "" {
+		return "payout:" + string(node.Payout)
+	}
+	return "network:" + hostNetwork(node)
+}
+
+// hostNetwork returns the /16 IPv4 or /32 IPv6 network of the host's URI, as
+// a rough approximation of its hosting provider and region.
+func hostNetwork(node store.Node) string {
+	u, err := url.Parse(node.URI)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		return u.Hostname()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// hostURIError returns the error for a host's NodeURI that failed to
+// normalize: InvalidNodeURIError if it's malformed, or HostIdentityError if
+// it's for another node.
+func hostURIError(nodeURI string, err error) error {
+	if _, ok := err.(InvalidNodeURIError); ok {
+		return err
+	}
+	return HostIdentityError{NodeURI: nodeURI, Cause: err}
+}
+
+// checkHostCapabilities asks the host what kind of node it's running, and
+// returns LightHostError if it's a light node. Hosts that predate the
+// vipnode_capabilities RPC are allowed.
+func (p *VipnodePool) checkHostCapabilities(ctx context.Context, service jsonrpc2.Service) (HostCapabilities, error) {
+	callCtx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
+	defer cancel()
+	var caps HostCapabilities
+	if err := service.Call(callCtx, &caps, "vipnode_capabilities"); err != nil {
+		if jsonrpc2.IsErrorCode(err, jsonrpc2.ErrCodeMethodNotFound) {
+			p.log(ctx, "Host does not support vipnode_capabilities, skipping light node check")
+			return HostCapabilities{}, nil
+		}
+		return caps, err
+	}
+	if !caps.IsFullNode {
+		return caps, LightHostError{Kind: caps.Kind}
+	}
+	return caps, nil
+}
+
+// checkUpdateState returns an error if the node is not in a state where it
+// should be sending updates for the given role.
+func checkUpdateState(node store.Node, role string) error {
+	switch role {
+	case "":
+		// Role not provided, skip the check
+	case RoleHost:
+		if !node.IsHost {
+			return UpdateRoleError{Role: role, IsHost: node.IsHost}
+		}
+	case RoleClient:
+		if node.IsHost {
+			return UpdateRoleError{Role: role, IsHost: node.IsHost}
+		}
+	default:
+		return fmt.Errorf("invalid update: unknown role %q", role)
+	}
+	return nil
+}
+
 // Update submits a list of peers that the node is connected to, returning the current account balance.
 func (p *VipnodePool) Update(ctx context.Context, sig string, nodeID string, nonce int64, req UpdateRequest) (*UpdateResponse, error) {
 	// TODO: Send sync status?
-	if err := p.verify(sig, "vipnode_update", nodeID, nonce, req); err != nil {
+	ctx, err := methodUpdate.verify(ctx, p, sig, nodeID, nonce, req)
+	if err != nil {
 		return nil, err
 	}
 
-	node, err := p.Store.GetNode(store.NodeID(nodeID))
+	node, err := p.Store.GetNode(ctx, store.NodeID(nodeID))
 	if err != nil {
 		return nil, err
 	}
+	if err := checkUpdateState(*node, req.Role); err != nil {
+		return nil, err
+	}
 	nodeBeforeUpdate := *node
 
 	peers := req.Peers
-	inactive, err := p.Store.UpdateNodePeers(store.NodeID(nodeID), peers, req.BlockNumber)
+	var unconfirmed []string
+	if node.IsHost && p.VerifyHostPeers {
+		confirmed, missing, err := p.confirmHostPeers(ctx, peers)
+		if err != nil {
+			p.log(ctx, "Host update %q: failed to verify peers, skipping check: %s", pretty.Abbrev(nodeID), err)
+		} else {
+			peers, unconfirmed = confirmed, missing
+		}
+	}
+	inactive, err := p.Store.UpdateNodePeers(ctx, store.NodeID(nodeID), peers, req.BlockNumber)
 	if err != nil {
 		return nil, err
 	}
 
+	if node.IsHost && req.Syncing != node.Syncing {
+		if err := p.setSyncing(ctx, node.ID, req.Syncing); err != nil {
+			return nil, err
+		}
+	}
+
 	resp := UpdateResponse{
 		InvalidPeers: make([]string, 0, len(inactive)),
 	}
 	for _, peer := range inactive {
 		resp.InvalidPeers = append(resp.InvalidPeers, string(peer))
 	}
-	validPeers, err := p.Store.NodePeers(store.NodeID(nodeID))
+	validPeers, err := p.Store.NodePeers(ctx, store.NodeID(nodeID))
 	if err != nil {
 		return nil, err
 	}
@@ -129,34 +819,446 @@ func (p *VipnodePool) Update(ctx context.Context, sig string, nodeID string, non
 	// FIXME: Is there a bug here when a host is connected to another host?
 	// TODO: Test InvalidPeers
 
-	nodeBalance, err := p.BalanceManager.OnUpdate(nodeBeforeUpdate, validPeers)
+	if len(unconfirmed) > 0 {
+		// The host misreported its peers, so it's not credited for this
+		// update.
+		nodeBalance, err := p.Store.GetNodeBalance(ctx, node.ID)
+		if err != nil {
+			return nil, err
+		}
+		p.countBalanceUpdate(*node, "frozen")
+		resp.Balance = &nodeBalance
+		resp.UnconfirmedPeers = unconfirmed
+		p.log(ctx, "Host update %q: %d peers, %d unconfirmed by its node. Credit frozen", pretty.Abbrev(nodeID), len(req.Peers), len(unconfirmed))
+		return &resp, nil
+	}
+
+	creditPeers := validPeers
+	if !node.IsHost && p.MaxCreditedHosts > 0 {
+		creditPeers = limitHosts(validPeers, p.MaxCreditedHosts)
+		if len(creditPeers) < len(validPeers) {
+			p.log(ctx, "Client update %q: crediting %d of %d active peers, exceeded max credited hosts", pretty.Abbrev(nodeID), len(creditPeers), len(validPeers))
+		}
+	}
+
+	nodeBalance, err := p.balanceManager().OnUpdate(ctx, nodeBeforeUpdate, creditPeers)
+	if batchErr, ok := err.(store.BatchError); ok {
+		// Some peers weren't credited, but the rest of the update succeeded.
+		p.log(ctx, "Client update %q: failed to credit peers: %s", pretty.Abbrev(nodeID), batchErr)
+		err = nil
+	}
+	if err == balance.ErrNoBalance {
+		p.countBalanceUpdate(*node, "unbilled")
+		resp.Unbilled = true
+		if node.IsHost {
+			p.log(ctx, "Host update %q: %d peers, %d active, %d invalid. Unbilled", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive))
+		} else {
+			p.log(ctx, "Client update %q: %d peers, %d active, %d invalid. Unbilled", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive))
+		}
+		return &resp, nil
+	}
 	if err != nil {
-		if _, ok := err.(balance.LowBalanceError); ok {
+		switch err.(type) {
+		case balance.LowBalanceError:
+			p.countBalanceUpdate(*node, "low_balance")
+			disconnectErr := p.disconnectPeers(ctx, nodeID, validPeers)
+			if disconnectErr != nil {
+				p.log(ctx, "Client disconnect due to low balance: %q; disconnect RPC errors: %s", pretty.Abbrev(nodeID), disconnectErr)
+			} else {
+				p.log(ctx, "Client disconnect due to low balance: %q", pretty.Abbrev(nodeID))
+			}
+		case balance.BalanceExhaustedError:
+			p.countBalanceUpdate(*node, "exhausted")
+			// The error response instructs the client to disconnect, and the
+			// client is removed so that it must connect again to resume.
+			if _, err := p.balanceManager().OnDisconnect(ctx, *node); err != nil && err != balance.ErrNoBalance {
+				p.log(ctx, "Client disconnect due to exhausted balance: %q; balance manager error: %s", pretty.Abbrev(nodeID), err)
+			}
+			if err := p.Store.RemoveNode(ctx, node.ID); err != nil {
+				return nil, err
+			}
+			p.unsubscribeBalance(node.ID)
 			disconnectErr := p.disconnectPeers(ctx, nodeID, validPeers)
 			if disconnectErr != nil {
-				logger.Printf("Client disconnect due to low balance: %q; disconnect RPC errors: %s", pretty.Abbrev(nodeID), disconnectErr)
+				p.log(ctx, "Client disconnect due to exhausted balance: %q; disconnect RPC errors: %s", pretty.Abbrev(nodeID), disconnectErr)
 			} else {
-				logger.Printf("Client disconnect due to low balance: %q", pretty.Abbrev(nodeID))
+				p.log(ctx, "Client disconnect due to exhausted balance: %q", pretty.Abbrev(nodeID))
 			}
+		default:
+			p.countBalanceUpdate(*node, "error")
 		}
 		return nil, err
 	}
+	p.countBalanceUpdate(*node, "billed")
 	resp.Balance = &nodeBalance
 
 	if node.IsHost {
-		logger.Printf("Host update %q: %d peers, %d active, %d invalid. Balance: %d", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive), &nodeBalance.Credit)
+		p.log(ctx, "Host update %q: %d peers, %d active, %d invalid. Balance: %d", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive), &nodeBalance.Credit)
 	} else {
-		logger.Printf("Client update %q: %d peers, %d active, %d invalid: Balance: %d", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive), &nodeBalance.Credit)
+		p.log(ctx, "Client update %q: %d peers, %d active, %d invalid: Balance: %d", pretty.Abbrev(nodeID), len(peers), len(validPeers), len(inactive), &nodeBalance.Credit)
 
 	}
 
 	return &resp, nil
 }
 
+// setSyncing saves whether the host is still syncing, so that syncing hosts
+// are offered to clients last.
+func (p *VipnodePool) setSyncing(ctx context.Context, nodeID store.NodeID, syncing bool) error {
+	node, err := p.Store.GetNode(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	if syncing {
+		p.log(ctx, "Host %q is syncing", pretty.Abbrev(string(nodeID)))
+	} else {
+		p.log(ctx, "Host %q is in sync", pretty.Abbrev(string(nodeID)))
+	}
+	node.Syncing = syncing
+	return p.Store.SetNode(ctx, *node)
+}
+
+// limitHosts returns the peers with at most max hosts. Hosts are ordered by
+// ID so that the same hosts are consistently credited across updates.
+func limitHosts(peers []store.Node, max int) []store.Node {
+	sorted := make([]store.Node, len(peers))
+	copy(sorted, peers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	r := make([]store.Node, 0, len(sorted))
+	numHosts := 0
+	for _, peer := range sorted {
+		if peer.IsHost {
+			if numHosts >= max {
+				continue
+			}
+			numHosts += 1
+		}
+		r = append(r, peer)
+	}
+	return r
+}
+
+// Disconnect stops tracking the node's connection and billing. The balance
+// is settled for the time since the node's last update, then the node is
+// removed and its peers are asked to disconnect from it.
+func (p *VipnodePool) Disconnect(ctx context.Context, sig string, nodeID string, nonce int64) error {
+	ctx, err := methodDisconnect.verify(ctx, p, sig, nodeID, nonce)
+	if err != nil {
+		return err
+	}
+
+	node, err := p.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		return err
+	}
+	peers, err := p.Store.NodePeers(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	return p.removeNode(ctx, *node, peers)
+}
+
+// removeNode settles the node's balance for the time since its last update,
+// then removes the node and asks its peers to disconnect from it.
+func (p *VipnodePool) removeNode(ctx context.Context, node store.Node, peers []store.Node) error {
+	nodeID := string(node.ID)
+
+	// Settle the last partial interval before the node goes away.
+	if _, err := p.balanceManager().OnUpdate(ctx, node, peers); err != nil && err != balance.ErrNoBalance {
+		p.log(ctx, "Disconnect %q: failed to settle balance: %s", pretty.Abbrev(nodeID), err)
+	}
+	if finalBalance, err := p.balanceManager().OnDisconnect(ctx, node); err == nil {
+		p.log(ctx, "Disconnect %q: final balance: %s", pretty.Abbrev(nodeID), &finalBalance)
+	} else if err != balance.ErrNoBalance {
+		p.log(ctx, "Disconnect %q: balance manager error: %s", pretty.Abbrev(nodeID), err)
+	}
+
+	if err := p.Store.RemoveNode(ctx, node.ID); err != nil {
+		return err
+	}
+	p.forgetNode(node.ID)
+
+	if err := p.disconnectPeers(ctx, nodeID, peers); err != nil {
+		p.log(ctx, "Disconnect %q: %d peers; disconnect RPC errors: %s", pretty.Abbrev(nodeID), len(peers), err)
+	} else {
+		p.log(ctx, "Disconnect %q: %d peers", pretty.Abbrev(nodeID), len(peers))
+	}
+	return nil
+}
+
+// forgetNode drops the pool's own state for a node that was removed from
+// the store.
+func (p *VipnodePool) forgetNode(nodeID store.NodeID) {
+	p.remoteHosts.Remove(nodeID)
+	p.unsubscribeBalance(nodeID)
+}
+
+// ExpireNodes removes the nodes that haven't been seen since before, and
+// cleans up after them like Disconnect. Their balances aren't settled, the
+// last update is too old to bill for, and their peers are already gone from
+// the store. It returns the number of nodes removed.
+func (p *VipnodePool) ExpireNodes(ctx context.Context, before time.Time) (int, error) {
+	expired, err := p.Store.ExpireNodes(ctx, before)
+	for _, nodeID := range expired {
+		if _, err := p.balanceManager().OnDisconnect(ctx, store.Node{ID: nodeID}); err != nil && err != balance.ErrNoBalance && err != store.ErrUnregisteredNode {
+			p.log(ctx, "Expire %q: balance manager error: %s", pretty.Abbrev(string(nodeID)), err)
+		}
+		p.forgetNode(nodeID)
+	}
+	return len(expired), err
+}
+
+// Drain takes the host out of rotation for maintenance. The host is no
+// longer offered to new clients, but its current clients keep being served
+// and billed while they move to other hosts. After the pool's DrainGrace,
+// the host is removed like in Disconnect, unless it registered again in the
+// meantime.
+func (p *VipnodePool) Drain(ctx context.Context, sig string, nodeID string, nonce int64) error {
+	ctx, err := methodDrain.verify(ctx, p, sig, nodeID, nonce)
+	if err != nil {
+		return err
+	}
+
+	node, err := p.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		return err
+	}
+	if !node.IsHost {
+		return ErrNotHost
+	}
+	if !node.Draining {
+		node.Draining = true
+		if err := p.Store.SetNode(ctx, *node); err != nil {
+			return err
+		}
+	}
+
+	grace := p.drainGrace()
+	started := p.goTracked(func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-p.closing:
+			return
+		}
+		// The request's context is done by now.
+		ctx := withLogFields(context.Background(), nodeID, "vipnode_drain")
+		if err := p.removeDrained(ctx, node.ID); err != nil {
+			p.log(ctx, "Drain %q: failed to remove host: %s", pretty.Abbrev(nodeID), err)
+		}
+	})
+	if !started {
+		return ErrPoolClosed
+	}
+	p.log(ctx, "Drain %q: removing host in %s", pretty.Abbrev(nodeID), grace)
+	return nil
+}
+
+// removeDrained removes the host if it's still draining.
+func (p *VipnodePool) removeDrained(ctx context.Context, nodeID store.NodeID) error {
+	node, err := p.Store.GetNode(ctx, nodeID)
+	if err == store.ErrUnregisteredNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !node.IsHost || !node.Draining {
+		// Registered again since it asked to be drained
+		return nil
+	}
+	peers, err := p.Store.NodePeers(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+	return p.removeNode(ctx, *node, peers)
+}
+
+// Evict forcibly removes another node from the pool, such as an abusive
+// node, instead of waiting for it to expire. If the node is a host, then it
+// is asked to disconnect its clients. The balance is settled like in
+// Disconnect. It returns store.ErrUnregisteredNode if the node isn't in the
+// pool. It must be signed by one of the pool's Operators.
+func (p *VipnodePool) Evict(ctx context.Context, sig string, nodeID string, nonce int64, evictID string) error {
+	ctx, err := methodEvict.verify(ctx, p, sig, nodeID, nonce, evictID)
+	if err != nil {
+		return err
+	}
+	node, err := p.Store.GetNode(ctx, store.NodeID(evictID))
+	if err != nil {
+		return err
+	}
+	peers, err := p.Store.NodePeers(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+
+	var hosts []hostService
+	if node.IsHost {
+		// Find the host's service before it's removed.
+		hosts, _ = p.hostServices(ctx, []store.Node{*node})
+	}
+	p.log(ctx, "Evicting %q by operator %q", pretty.Abbrev(evictID), pretty.Abbrev(nodeID))
+	if err := p.removeNode(ctx, *node, peers); err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		if err := p.disconnectClients(ctx, host.Service, peers); err != nil {
+			p.log(ctx, "Evict %q: %d clients; disconnect RPC errors: %s", pretty.Abbrev(evictID), len(peers), err)
+		}
+	}
+	return nil
+}
+
+// disconnectClients asks the host on service to disconnect from each of the
+// clients.
+func (p *VipnodePool) disconnectClients(ctx context.Context, service jsonrpc2.Service, clients []store.Node) error {
+	callCtx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
+	defer cancel()
+	errors := []error{}
+	for _, client := range clients {
+		if err := service.Call(callCtx, nil, "vipnode_disconnect", string(client.ID)); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if len(errors) > 0 {
+		return RemoteHostErrors{
+			Method: "vipnode_disconnect",
+			Errors: errors,
+		}
+	}
+	return nil
+}
+
+// BindSigningKey binds a separate signing key to the node, identified by the
+// hex-encoded public key signerID. Afterwards, the node's requests can be
+// signed by either the node key or the signing key, so that the vipnode agent
+// doesn't need access to the node's p2p private key. An empty signerID
+// removes the binding. The request must be signed by the node key.
+func (p *VipnodePool) BindSigningKey(ctx context.Context, sig string, nodeID string, nonce int64, signerID string) error {
+	ctx, err := methodBindSigningKey.verify(ctx, p, sig, nodeID, nonce, signerID)
+	if err != nil {
+		return err
+	}
+	signers, ok := p.Store.(store.SigningKeyStore)
+	if !ok {
+		return ErrSigningKeyUnsupported
+	}
+	if signerID != "" {
+		if _, err := discv5.HexID(signerID); err != nil {
+			return err
+		}
+	}
+	if err := signers.SetSigningKey(ctx, store.NodeID(nodeID), store.NodeID(signerID)); err != nil {
+		return err
+	}
+	p.log(ctx, "Bound signing key %q to node %q", pretty.Abbrev(signerID), pretty.Abbrev(nodeID))
+	return nil
+}
+
+// Rewhitelist re-issues vipnode_whitelist to the calling host for all of its
+// current clients, such as after the host's node restarted and lost its
+// trusted peers.
+func (p *VipnodePool) Rewhitelist(ctx context.Context, sig string, nodeID string, nonce int64) error {
+	ctx, err := methodRewhitelist.verify(ctx, p, sig, nodeID, nonce)
+	if err != nil {
+		return err
+	}
+
+	node, err := p.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		return err
+	}
+	if !node.IsHost {
+		return ErrNotHost
+	}
+	return p.rewhitelistClients(ctx, *node)
+}
+
+// rewhitelistClients calls vipnode_whitelist on the host for each of its
+// current clients.
+func (p *VipnodePool) rewhitelistClients(ctx context.Context, host store.Node) error {
+	service, ok := p.remoteHosts.Get(host.ID)
+	if !ok {
+		return fmt.Errorf("missing remote service for host: %q", host.ID)
+	}
+	peers, err := p.Store.NodePeers(ctx, host.ID)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
+	defer cancel()
+	errCh := make(chan error, len(peers))
+	count := 0
+	for _, peer := range peers {
+		if peer.IsHost {
+			continue
+		}
+		count++
+		go func(clientID store.NodeID) {
+			if err := service.Call(callCtx, nil, "vipnode_whitelist", string(clientID)); err != nil {
+				errCh <- store.ItemError{ID: string(clientID), Err: err}
+				return
+			}
+			errCh <- nil
+		}(peer.ID)
+	}
+
+	errors := []error{}
+	for i := 0; i < count; i++ {
+		if err := <-errCh; err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if len(errors) > 0 {
+		p.log(ctx, "Rewhitelist %q: %d clients; whitelist RPC errors: %s", pretty.Abbrev(string(host.ID)), count, RemoteHostErrors{"vipnode_whitelist", errors})
+		return RemoteHostErrors{"vipnode_whitelist", errors}
+	}
+	p.log(ctx, "Rewhitelist %q: %d clients", pretty.Abbrev(string(host.ID)), count)
+	return nil
+}
+
+// checkHostSource returns ErrTooManyHosts if a new host registering from the
+// source would exceed MaxHostsPerSource or HostRegistrationLimit.
+func (p *VipnodePool) checkHostSource(ctx context.Context, nodeID store.NodeID, source string) error {
+	if p.MaxHostsPerSource <= 0 && p.HostRegistrationLimit <= 0 {
+		return nil
+	}
+
+	seenSince := p.Clock.Now().Add(-store.ExpireInterval)
+	numActive := 0
+	for _, id := range p.remoteHosts.FromSource(source) {
+		if id == nodeID {
+			// Reconnecting hosts don't count towards the limits
+			return nil
+		}
+		node, err := p.Store.GetNode(ctx, id)
+		if err != nil || !node.LastSeen.After(seenSince) {
+			continue
+		}
+		numActive++
+	}
+	if p.MaxHostsPerSource > 0 && numActive >= p.MaxHostsPerSource {
+		return ErrTooManyHosts
+	}
+
+	if p.HostRegistrationLimit > 0 {
+		now := p.Clock.Now()
+		p.hostLimiter.Prune(p.HostRegistrationWindow, now)
+		if !p.hostLimiter.Allow(source, p.HostRegistrationLimit, p.HostRegistrationWindow, now) {
+			return ErrTooManyHosts
+		}
+	}
+	return nil
+}
+
 // Host registers a full node to participate as a vipnode host in this pool.
 func (p *VipnodePool) Host(ctx context.Context, sig string, nodeID string, nonce int64, req HostRequest) (*HostResponse, error) {
 	// TODO: Send capabilities?
-	if err := p.verify(sig, "vipnode_host", nodeID, nonce, req); err != nil {
+	ctx, err := methodHost.verify(ctx, p, sig, nodeID, nonce, req)
+	if err != nil {
 		return nil, err
 	}
 
@@ -172,31 +1274,82 @@ func (p *VipnodePool) Host(ctx context.Context, sig string, nodeID string, nonce
 	defaultPort := "30303"
 	nodeURI, err := normalizeNodeURI(req.NodeURI, nodeID, remoteHost, defaultPort)
 	if err != nil {
-		return nil, err
+		// Leave the connection intact so the host can retry with a
+		// corrected NodeURI.
+		return nil, hostURIError(req.NodeURI, err)
+	}
+	altURIs := req.AltNodeURIs
+	if len(altURIs) > maxAltNodeURIs {
+		altURIs = altURIs[:maxAltNodeURIs]
+	}
+	var altNodeURIs []string
+	for _, uri := range altURIs {
+		altNodeURI, err := normalizeNodeURI(uri, nodeID, remoteHost, defaultPort)
+		if err != nil {
+			return nil, hostURIError(uri, err)
+		}
+		if altNodeURI != nodeURI {
+			altNodeURIs = append(altNodeURIs, altNodeURI)
+		}
+	}
+
+	if !p.hostVersionAllowed(req.Version) {
+		return nil, HostVersionError{Version: req.Version, MinVersion: p.MinHostVersion}
+	}
+
+	if p.PayoutChangeCooldown > 0 && !p.payoutLimiter.Allow(nodeID, req.Payout, p.PayoutChangeCooldown, p.Clock.Now()) {
+		return nil, ErrPayoutChangeTooSoon
+	}
+
+	var caps HostCapabilities
+	if !p.skipHostCheck {
+		if caps, err = p.checkHostCapabilities(ctx, service); err != nil {
+			return nil, err
+		}
 	}
 
-	// TODO: Confirm that it's a full node, not a light node? Doesn't super matter since if i
-	// XXX: Check versions
+	source := remoteHost
+	if source == "" {
+		source = "payout:" + req.Payout
+	}
+	if err := p.checkHostSource(ctx, store.NodeID(nodeID), source); err != nil {
+		return nil, err
+	}
 
-	logger.Printf("New %q host: %q", req.Kind, nodeURI)
+	p.log(ctx, "New %q host: %q", req.Kind, nodeURI)
 
 	node := store.Node{
 		ID:       store.NodeID(nodeID),
 		URI:      nodeURI,
 		Kind:     req.Kind,
-		LastSeen: time.Now(),
+		LastSeen: p.Clock.Now(),
 		IsHost:   true,
 		Payout:   store.Account(req.Payout),
+		AltURIs:  altNodeURIs,
+		Instance: p.InstanceID,
+		Syncing:  caps.Syncing,
+		Version:  req.Version,
+		MaxPeers: req.MaxPeers,
+	}
+	// A host that registers again keeps its peers, so its count of peers
+	// carries over too. Its assigned clients are kept by the store.
+	if prev, err := p.Store.GetNode(ctx, node.ID); err == nil && prev.IsHost {
+		node.PeerCount = prev.PeerCount
+	} else if err != nil && err != store.ErrUnregisteredNode {
+		return nil, err
 	}
-	err = p.Store.SetNode(node)
+	err = p.Store.SetNode(ctx, node)
 	if err != nil {
 		return nil, err
 	}
+	if p.PayoutChangeCooldown > 0 {
+		// Only changes that were applied count towards the cooldown
+		p.payoutLimiter.Record(nodeID, req.Payout, p.Clock.Now())
+	}
 
 	// FIXME: Clean up disconnected hosts
-	p.mu.Lock()
-	p.remoteHosts[node.ID] = service
-	p.mu.Unlock()
+	p.remoteHosts.AddFromSource(node.ID, service, source)
+	p.count(metrics.Connect, roleTag(node), metrics.Tag{Key: "kind", Value: node.Kind})
 
 	resp := &HostResponse{
 		PoolVersion: p.Version,
@@ -206,13 +1359,42 @@ func (p *VipnodePool) Host(ctx context.Context, sig string, nodeID string, nonce
 
 // Client returns a list of enodes who are ready for the client node to connect.
 func (p *VipnodePool) Client(ctx context.Context, sig string, nodeID string, nonce int64, req ClientRequest) (*ClientResponse, error) {
-	if err := p.verify(sig, "vipnode_client", nodeID, nonce, req); err != nil {
+	ctx, err := methodClient.verify(ctx, p, sig, nodeID, nonce, req)
+	if err != nil {
 		return nil, err
 	}
 
 	kind := req.Kind
-	// TODO: Unhardcode this, maybe add to ClientRequest (but limit to some number)
-	numRequestHosts := 3
+	numRequestHosts := p.NumRequestHosts
+	if numRequestHosts <= 0 {
+		numRequestHosts = defaultNumRequestHosts
+	}
+	switch req.Distribution {
+	case "", DistributeSpread:
+	case DistributeMinimal:
+		numRequestHosts = 1
+	default:
+		return nil, fmt.Errorf("invalid client request: unknown distribution %q", req.Distribution)
+	}
+	if req.NumHosts > 0 && req.NumHosts < numRequestHosts {
+		numRequestHosts = req.NumHosts
+	}
+
+	if p.HostQuota != nil {
+		if quota := p.HostQuota(nodeID); quota > 0 {
+			peers, err := p.Store.NodePeers(ctx, store.NodeID(nodeID))
+			if err != nil && err != store.ErrUnregisteredNode {
+				return nil, err
+			}
+			if len(peers) >= quota {
+				p.log(ctx, "New %q client: %q (host quota of %d exceeded)", kind, pretty.Abbrev(nodeID), quota)
+				return nil, ErrQuotaExceeded
+			}
+			if remaining := quota - len(peers); remaining < numRequestHosts {
+				numRequestHosts = remaining
+			}
+		}
+	}
 
 	response := &ClientResponse{
 		PoolVersion: p.Version,
@@ -222,96 +1404,409 @@ func (p *VipnodePool) Client(ctx context.Context, sig string, nodeID string, non
 	}
 
 	node := store.Node{
-		ID:       store.NodeID(nodeID),
-		Kind:     kind,
-		LastSeen: time.Now(),
-		IsHost:   false,
+		ID:     store.NodeID(nodeID),
+		IsHost: false,
+	}
+	connectedHosts := req.ConnectedHosts
+	if len(connectedHosts) > maxPreferredHosts {
+		connectedHosts = connectedHosts[:maxPreferredHosts]
+	}
+	connected := make(map[store.NodeID]struct{}, len(connectedHosts))
+	for _, id := range connectedHosts {
+		connected[store.NodeID(id)] = struct{}{}
+	}
+	// A client that connects again keeps its record, so that its peers and
+	// balance carry over, but it's given new hosts so the hosts from its
+	// previous connection are released, except for the ones it's still
+	// connected to.
+	var kept []store.Node
+	if prev, err := p.Store.GetNode(ctx, node.ID); err == nil && !prev.IsHost {
+		for _, hostID := range prev.AssignedHosts {
+			if _, ok := connected[hostID]; ok {
+				kept = append(kept, store.Node{ID: hostID})
+			}
+		}
+		if err := p.assignHosts(ctx, node.ID, kept); err != nil {
+			p.log(ctx, "New %q client: %q (failed to release previous hosts: %s)", kind, pretty.Abbrev(nodeID), err)
+		}
+		node = *prev
+	} else if err != nil && err != store.ErrUnregisteredNode {
+		return nil, err
 	}
-	if err := p.Store.SetNode(node); err != nil {
+	node.Kind = kind
+	node.LastSeen = p.Clock.Now()
+	if err := p.Store.SetNode(ctx, node); err != nil {
 		return nil, err
 	}
 
-	if err := p.BalanceManager.OnClient(node); err != nil {
+	if err := p.balanceManager().OnConnect(ctx, node); err != nil {
+		// Connection is refused, so the client is not kept around.
+		if _, disconnectErr := p.balanceManager().OnDisconnect(ctx, node); disconnectErr != nil && disconnectErr != balance.ErrNoBalance {
+			p.log(ctx, "New %q client: %q (balance manager error for refused client: %s)", kind, pretty.Abbrev(nodeID), disconnectErr)
+		}
+		if removeErr := p.Store.RemoveNode(ctx, node.ID); removeErr != nil {
+			p.log(ctx, "New %q client: %q (failed to remove refused client: %s)", kind, pretty.Abbrev(nodeID), removeErr)
+		}
 		return nil, err
 	}
+	p.count(metrics.Connect, roleTag(node), metrics.Tag{Key: "kind", Value: node.Kind})
 
-	r, err := p.Store.ActiveHosts(kind, numRequestHosts)
+	candidates, err := p.candidateHosts(ctx, ParseKind(kind), numRequestHosts*backupHostsFactor+len(connected), req.PreferredHosts, req.Distribution == DistributeSpread)
 	if err != nil {
 		return nil, err
 	}
-	if len(r) == 0 {
-		logger.Printf("New %q client: %q (no active hosts found)", kind, pretty.Abbrev(nodeID))
-		return nil, NoHostNodesError{}
+	if len(connected) > 0 {
+		remaining := candidates[:0]
+		for _, host := range candidates {
+			if _, ok := connected[host.ID]; !ok {
+				remaining = append(remaining, host)
+			}
+		}
+		candidates = remaining
+	}
+
+	// Whitelist the client with batches of hosts until enough accept, trying
+	// backup candidates in place of the hosts that failed.
+	quorum := p.WhitelistStrategy.Quorum
+	if quorum > numRequestHosts {
+		// No more than numRequestHosts hosts are whitelisted, such as for
+		// DistributeMinimal, so a larger quorum could never be reached.
+		quorum = numRequestHosts
+	}
+	want := numRequestHosts
+	if quorum > 0 {
+		want = quorum
+	}
+	clientCtx, cancel := context.WithTimeout(ctx, clientTimeoutFactor*p.whitelistTimeout())
+	defer cancel()
+	var tried, accepted []store.Node
+	var errors []error
+	for len(accepted) < want && clientCtx.Err() == nil {
+		var batch []store.Node
+		batch, candidates = p.nextHostBatch(ctx, node.ID, candidates, numRequestHosts-len(accepted))
+		if len(batch) == 0 {
+			break
+		}
+		tried = append(tried, batch...)
+		if p.skipWhitelist {
+			accepted = append(accepted, batch...)
+			continue
+		}
+		batchQuorum := 0
+		if quorum > 0 {
+			batchQuorum = quorum - len(accepted)
+		}
+		batchAccepted, batchErrors := p.whitelistHosts(clientCtx, nodeID, batch, batchQuorum)
+		accepted = append(accepted, batchAccepted...)
+		errors = append(errors, batchErrors...)
+	}
+	if len(tried) == 0 {
+		p.log(ctx, "New %q client: %q (no active hosts found)", kind, pretty.Abbrev(nodeID))
+		return nil, p.noHostsError(ctx, kind)
 	}
 
 	if p.skipWhitelist {
-		logger.Printf("New %q client: %q (%d hosts found, skipping whitelist)", kind, pretty.Abbrev(nodeID), len(r))
-		response.Hosts = r
+		p.log(ctx, "New %q client: %q (%d hosts found, skipping whitelist)", kind, pretty.Abbrev(nodeID), len(tried))
+		if err := p.assignHosts(ctx, node.ID, append(kept, accepted...)); err != nil {
+			p.log(ctx, "New %q client: %q (failed to assign hosts: %s)", kind, pretty.Abbrev(nodeID), err)
+		}
+		response.Hosts = accepted
+		response.Tried = len(tried)
 		return response, nil
 	}
 
-	errors := []error{}
-	remotes := make([]hostService, 0, len(r))
-	p.mu.Lock()
-	for _, node := range r {
-		remote, ok := p.remoteHosts[node.ID]
-		if ok {
-			remotes = append(remotes, hostService{
-				node, remote,
-			})
-		} else {
-			errors = append(errors, fmt.Errorf("missing remote service for candidate host: %q", node.ID))
+	// Keep the candidate order, so that preferred hosts stay first.
+	rank := make(map[store.NodeID]int, len(tried))
+	for i, node := range tried {
+		rank[node.ID] = i
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return rank[accepted[i].ID] < rank[accepted[j].ID]
+	})
+
+	if len(errors) > 0 {
+		p.log(ctx, "New %q client: %q (%d hosts found, %d accepted) %s", kind, pretty.Abbrev(nodeID), len(tried), len(accepted), RemoteHostErrors{"vipnode_whitelist", errors})
+	} else {
+		p.log(ctx, "New %q client: %q (%d hosts found, %d accepted)", kind, pretty.Abbrev(nodeID), len(tried), len(accepted))
+	}
+
+	if len(accepted) >= 1 && len(accepted) >= quorum {
+		p.releaseHostSlots(ctx, node.ID, tried, accepted)
+		if err := p.assignHosts(ctx, node.ID, append(kept, accepted...)); err != nil {
+			p.log(ctx, "New %q client: %q (failed to assign hosts: %s)", kind, pretty.Abbrev(nodeID), err)
+		}
+		response.Hosts = accepted
+		response.Tried = len(tried)
+		for _, err := range errors {
+			response.Errors = append(response.Errors, err.Error())
 		}
+		return response, nil
+	}
+	p.releaseHostSlots(ctx, node.ID, tried, nil)
+
+	if len(accepted) >= 1 {
+		// The client isn't given the hosts that accepted, so they shouldn't
+		// keep it whitelisted.
+		if err := p.disconnectPeers(ctx, nodeID, accepted); err != nil {
+			p.log(ctx, "New %q client: %q (failed to revoke whitelist after quorum failed: %s)", kind, pretty.Abbrev(nodeID), err)
+		}
+		return nil, WhitelistQuorumError{
+			Quorum:   quorum,
+			Accepted: len(accepted),
+		}
+	}
+
+	if len(errors) > 0 {
+		return nil, RemoteHostErrors{"vipnode_whitelist", errors}
 	}
-	p.mu.Unlock()
+
+	return nil, NoHostNodesError{len(tried)}
+}
+
+// nextHostBatch claims slots for the client on up to n of the candidate
+// hosts, in order, and returns the claimed hosts and the remaining
+// candidates.
+func (p *VipnodePool) nextHostBatch(ctx context.Context, clientID store.NodeID, candidates []store.Node, n int) (batch []store.Node, remaining []store.Node) {
+	for len(batch) < n && len(candidates) > 0 {
+		next := n - len(batch)
+		if next > len(candidates) {
+			next = len(candidates)
+		}
+		batch = append(batch, p.claimHostSlots(ctx, clientID, candidates[:next])...)
+		candidates = candidates[next:]
+	}
+	return batch, candidates
+}
+
+// whitelistHosts calls vipnode_whitelist for the client on each of the hosts
+// in parallel, and returns the hosts that accepted within
+// the pool's WhitelistTimeout. If quorum is positive, then it returns as soon as
+// that many hosts accepted.
+func (p *VipnodePool) whitelistHosts(ctx context.Context, nodeID string, hosts []store.Node, quorum int) ([]store.Node, []error) {
+	remotes, errors := p.hostServices(ctx, hosts)
 
 	accepted := make([]store.Node, 0, len(remotes))
-	callCtx, cancel := context.WithTimeout(ctx, poolWhitelistTimeout)
 
 	// Parallelize whitelist, return any hosts that respond within the timeout.
-	errChan := make(chan error)
-	acceptChan := make(chan store.Node)
+	// Channels are buffered so that stragglers don't block once we stop
+	// waiting for them. Stragglers aren't cancelled, since the host could
+	// whitelist the client anyway. Instead, the ones that accept late are
+	// asked to disconnect the client.
+	errChan := make(chan error, len(remotes))
+	acceptChan := make(chan hostService, len(remotes))
+	var mu sync.Mutex
+	stopped := false
 
 	for _, remote := range remotes {
-		go func(service jsonrpc2.Service, node store.Node) {
-			if err := service.Call(callCtx, nil, "vipnode_whitelist", nodeID); err != nil {
+		remote := remote
+		started := p.goTracked(func() {
+			callCtx, cancel := p.backgroundContext(p.whitelistTimeout())
+			defer cancel()
+			if err := remote.Service.Call(callCtx, nil, "vipnode_whitelist", nodeID); err != nil {
 				errChan <- err
-			} else {
-				acceptChan <- node
+				return
 			}
-		}(remote.Service, remote.Node)
+			mu.Lock()
+			late := stopped
+			if !late {
+				acceptChan <- remote
+			}
+			mu.Unlock()
+			if late {
+				p.revokeWhitelist(nodeID, remote)
+			}
+		})
+		if !started {
+			errChan <- ErrPoolClosed
+		}
 	}
 
+wait:
 	for i := len(remotes); i > 0; i-- {
+		if quorum > 0 && len(accepted) >= quorum {
+			break
+		}
 		select {
-		case node := <-acceptChan:
-			accepted = append(accepted, node)
+		case remote := <-acceptChan:
+			p.count(metrics.Whitelist, metrics.Tag{Key: "result", Value: "accepted"})
+			accepted = append(accepted, remote.Node)
 		case err := <-errChan:
+			p.count(metrics.Whitelist, metrics.Tag{Key: "result", Value: "failed"})
 			errors = append(errors, err)
+		case <-ctx.Done():
+			errors = append(errors, ctx.Err())
+			break wait
 		}
 	}
-	cancel()
+
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	// Hosts that accepted while we stopped waiting are revoked too.
+	for len(acceptChan) > 0 {
+		remote := <-acceptChan
+		p.goTracked(func() {
+			p.revokeWhitelist(nodeID, remote)
+		})
+	}
 	// TODO: Penalize hosts that failed to respond within the deadline?
+	return accepted, errors
+}
 
-	if len(errors) > 0 {
-		logger.Printf("New %q client: %s (%d hosts found, %d accepted) %s", kind, nodeID[:8], len(remotes), len(accepted), RemoteHostErrors{"vipnode_whitelist", errors})
-	} else {
-		logger.Printf("New %q client: %s (%d hosts found, %d accepted)", kind, nodeID[:8], len(remotes), len(accepted))
+// revokeWhitelist asks a host that accepted the client's whitelist request
+// to disconnect the client again, because the client isn't given the host,
+// such as when the host accepted after the pool stopped waiting for it.
+func (p *VipnodePool) revokeWhitelist(nodeID string, remote hostService) {
+	// The request's context could be done by now.
+	ctx, cancel := p.backgroundContext(p.whitelistTimeout())
+	defer cancel()
+	ctx = withLogFields(ctx, nodeID, "vipnode_client")
+	if err := remote.Service.Call(ctx, nil, "vipnode_disconnect", nodeID); err != nil {
+		p.log(ctx, "Failed to revoke whitelist of client %q on host %q: %s", pretty.Abbrev(nodeID), pretty.Abbrev(string(remote.Node.ID)), err)
 	}
+}
 
-	if len(accepted) >= 1 {
-		response.Hosts = accepted
-		return response, nil
+// backgroundContext returns a context for calls that outlive the request
+// that made them. It's done after the timeout, or once the pool closes.
+func (p *VipnodePool) backgroundContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	go func() {
+		select {
+		case <-p.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// SubscribeBalance starts pushing the node's balance over the current
+// connection every interval, by calling vipnode_balance on the remote side.
+// An existing balance subscription for the node is replaced.
+func (p *VipnodePool) SubscribeBalance(ctx context.Context, sig string, nodeID string, nonce int64, req SubscribeBalanceRequest) error {
+	ctx, err := methodSubscribeBalance.verify(ctx, p, sig, nodeID, nonce, req)
+	if err != nil {
+		return err
 	}
 
-	if len(errors) > 0 {
-		return nil, RemoteHostErrors{"vipnode_whitelist", errors}
+	service, err := jsonrpc2.CtxService(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.Store.GetNode(ctx, store.NodeID(nodeID)); err != nil {
+		return err
+	}
+
+	interval := req.Interval
+	if interval <= 0 {
+		interval = store.KeepaliveInterval
+	} else if interval < minBalanceInterval {
+		interval = minBalanceInterval
+	}
+
+	stopCh := make(chan struct{})
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	if oldCh, ok := p.balanceSubs[store.NodeID(nodeID)]; ok {
+		close(oldCh)
+	}
+	p.balanceSubs[store.NodeID(nodeID)] = stopCh
+	p.mu.Unlock()
+
+	started := p.goTracked(func() {
+		p.serveBalance(service, store.NodeID(nodeID), interval, stopCh)
+	})
+	if !started {
+		return ErrPoolClosed
+	}
+	p.log(ctx, "Balance subscription %q: every %s", pretty.Abbrev(nodeID), interval)
+	return nil
+}
+
+// UnsubscribeBalance stops pushing balance updates to the node.
+func (p *VipnodePool) UnsubscribeBalance(ctx context.Context, sig string, nodeID string, nonce int64) error {
+	_, err := methodUnsubscribeBalance.verify(ctx, p, sig, nodeID, nonce)
+	if err != nil {
+		return err
+	}
+
+	p.unsubscribeBalance(store.NodeID(nodeID))
+	return nil
+}
+
+func (p *VipnodePool) unsubscribeBalance(nodeID store.NodeID) {
+	p.mu.Lock()
+	if stopCh, ok := p.balanceSubs[nodeID]; ok {
+		close(stopCh)
+		delete(p.balanceSubs, nodeID)
+	}
+	p.mu.Unlock()
+}
+
+// serveBalance pushes the node's balance to the service every interval until
+// stopCh is closed or a push fails.
+func (p *VipnodePool) serveBalance(service jsonrpc2.Service, nodeID store.NodeID, interval time.Duration, stopCh chan struct{}) {
+	ctx := withLogFields(context.Background(), string(nodeID), "vipnode_subscribeBalance")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		err := p.pushBalance(ctx, service, nodeID)
+		if err == nil {
+			continue
+		}
+		p.log(ctx, "Balance subscription %q: push failed, unsubscribing: %s", pretty.Abbrev(string(nodeID)), err)
+		p.mu.Lock()
+		if p.balanceSubs[nodeID] == stopCh {
+			delete(p.balanceSubs, nodeID)
+		}
+		p.mu.Unlock()
+		return
+	}
+}
+
+func (p *VipnodePool) pushBalance(ctx context.Context, service jsonrpc2.Service, nodeID store.NodeID) error {
+	ctx, cancel := context.WithTimeout(ctx, p.whitelistTimeout())
+	defer cancel()
+	nodeBalance, err := p.Store.GetNodeBalance(ctx, nodeID)
+	if err != nil {
+		return err
 	}
+	return service.Call(ctx, nil, "vipnode_balance", &nodeBalance)
+}
 
-	return nil, NoHostNodesError{len(r)}
+// Projection returns how much more a host would earn by serving req.Peers
+// more clients at the pool's current pricing. It's a dry run that doesn't
+// touch any balances, so the request does not need to be signed.
+func (p *VipnodePool) Projection(ctx context.Context, req ProjectionRequest) (*ProjectionResponse, error) {
+	credit, interval, err := p.balanceManager().ProjectCredit(req.Peers)
+	if err == balance.ErrNoBalance {
+		return &ProjectionResponse{Peers: req.Peers, Unbilled: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	perHour := new(big.Int).Mul(credit, big.NewInt(int64(time.Hour)))
+	perHour.Div(perHour, big.NewInt(int64(interval)))
+	return &ProjectionResponse{
+		Peers:             req.Peers,
+		Interval:          interval,
+		CreditPerInterval: credit.String(),
+		CreditPerHour:     perHour.String(),
+	}, nil
 }
 
-// Ping returns "pong", used for testing.
+// Ping returns "pong", used for testing and by RemotePool.Ping to check that
+// the pool is responding.
 func (p *VipnodePool) Ping(ctx context.Context) string {
 	return "pong"
 }
@@ -2,16 +2,53 @@ package pool
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/internal/keygen"
 	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool/balance"
+	"github.com/vipnode/vipnode/pool/clock"
 	"github.com/vipnode/vipnode/pool/store"
 	"github.com/vipnode/vipnode/request"
 )
 
+// signedClient signs a vipnode_client request with privkey at the pool's
+// current time, and sends it to the pool.
+func signedClient(t *testing.T, pool *VipnodePool, privkey *ecdsa.PrivateKey, req ClientRequest) (*ClientResponse, error) {
+	t.Helper()
+	nodeReq := request.NodeRequest{
+		Method:    "vipnode_client",
+		NodeID:    discv5.PubkeyID(&privkey.PublicKey).String(),
+		Nonce:     pool.Clock.Now().UnixNano(),
+		ExtraArgs: []interface{}{req},
+	}
+	sig, err := nodeReq.Sign(privkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool.Client(context.Background(), sig, nodeReq.NodeID, nodeReq.Nonce, req)
+}
+
+// addHosts registers active geth hosts with the IDs in the pool's store.
+func addHosts(t *testing.T, pool *VipnodePool, ids ...store.NodeID) {
+	t.Helper()
+	for _, id := range ids {
+		if err := pool.Store.SetNode(context.Background(), store.Node{ID: id, URI: "enode://" + string(id), IsHost: true, Kind: "geth", LastSeen: pool.Clock.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestPoolInstance(t *testing.T) {
 	pool := New(store.MemoryStore(), nil)
 
@@ -21,22 +58,68 @@ func TestPoolInstance(t *testing.T) {
 	}
 
 	privkey := keygen.HardcodedKey(t)
-	req := request.NodeRequest{
-		Method: "vipnode_client",
-		NodeID: discv5.PubkeyID(&privkey.PublicKey).String(),
-		Nonce:  time.Now().UnixNano(),
-		ExtraArgs: []interface{}{
-			ClientRequest{Kind: "geth"},
-		},
+	_, err := signedClient(t, pool, privkey, ClientRequest{Kind: "geth"})
+	if _, ok := err.(NoHostNodesError); !ok {
+		t.Errorf("pool.Connect direct call failed: %s", err)
 	}
-	sig, err := req.Sign(privkey)
-	if err != nil {
-		t.Fatal(err)
+}
+
+func TestPoolExpiredSignature(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	pool := New(memStore, nil)
+	pool.Clock = fakeClock
+	pool.MaxRequestSkew = 30 * time.Second
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	client := func(signedAt time.Time) error {
+		req := request.NodeRequest{
+			Method:    "vipnode_client",
+			NodeID:    nodeID,
+			Nonce:     signedAt.UnixNano(),
+			ExtraArgs: []interface{}{ClientRequest{Kind: "geth"}},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Client(context.Background(), sig, req.NodeID, req.Nonce, req.ExtraArgs[0].(ClientRequest))
+		return err
 	}
 
-	_, err = pool.Client(context.Background(), sig, req.NodeID, req.Nonce, req.ExtraArgs[0].(ClientRequest))
-	if _, ok := err.(NoHostNodesError); !ok {
-		t.Errorf("pool.Connect direct call failed: %s", err)
+	// Captured requests are rejected even though the store hasn't seen
+	// their nonce.
+	for _, signedAt := range []time.Time{
+		fakeClock.Now().Add(-time.Minute),
+		fakeClock.Now().Add(time.Minute),
+	} {
+		err := client(signedAt)
+		if verifyErr, ok := err.(VerifyFailedError); !ok || verifyErr.Cause != request.ErrRequestExpired {
+			t.Errorf("expected expired request, got: %v", err)
+		}
+	}
+
+	if _, ok := client(fakeClock.Now()).(NoHostNodesError); !ok {
+		t.Error("expected fresh request to verify")
+	}
+
+	// The pool's clock is used, not the wall clock
+	fakeClock.Add(time.Hour)
+	if _, ok := client(fakeClock.Now()).(NoHostNodesError); !ok {
+		t.Error("expected request at the pool's time to verify")
+	}
+
+	pool.MaxRequestSkew = 2 * time.Minute
+	if _, ok := client(fakeClock.Now().Add(time.Minute)).(NoHostNodesError); !ok {
+		t.Error("expected request within MaxRequestSkew to verify")
+	}
+
+	// Zero disables the check, but the store still bounds future nonces
+	pool.MaxRequestSkew = 0
+	if _, ok := client(fakeClock.Now().Add(store.MaxNonceAhead - time.Minute)).(NoHostNodesError); !ok {
+		t.Error("expected request to verify without MaxRequestSkew")
 	}
 }
 
@@ -76,3 +159,1352 @@ func TestPoolService(t *testing.T) {
 		}
 	}
 }
+
+func TestPoolNoCompatibleHosts(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "bar", URI: "enode://bar", IsHost: true, Kind: "parity", LastSeen: time.Now()}); err != nil {
+		t.Fatal("failed to add host node:", err)
+	}
+
+	privkey := keygen.HardcodedKey(t)
+	_, err := signedClient(t, pool, privkey, ClientRequest{Kind: "geth"})
+	noCompatErr, ok := err.(NoCompatibleHostsError)
+	if !ok {
+		t.Fatalf("expected NoCompatibleHostsError, got: %s", err)
+	}
+	if got, want := noCompatErr.Available, []string{"parity"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong available kinds: got %q; want %q", got, want)
+	}
+}
+
+// delayService is a jsonrpc2.Service that responds to all calls after a delay.
+// If calls is set, then the method of each call that it responded to is sent
+// on it.
+type delayService struct {
+	delay time.Duration
+	err   error
+	calls chan string
+}
+
+func (s delayService) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	select {
+	case <-time.After(s.delay):
+		if s.calls != nil {
+			s.calls <- method
+		}
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expectCalls checks that the service responded to the methods in order.
+func expectCalls(t *testing.T, calls <-chan string, methods ...string) {
+	t.Helper()
+	for _, want := range methods {
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Errorf("got call %q; want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for call %q", want)
+		}
+	}
+}
+
+func TestPoolWhitelistQuorum(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+	clientReq := ClientRequest{Kind: "geth"}
+
+	newPool := func(strategy WhitelistStrategy) *VipnodePool {
+		pool := New(store.MemoryStore(), nil)
+		pool.WhitelistStrategy = strategy
+		delays := map[store.NodeID]time.Duration{
+			"a": 0,
+			"b": 50 * time.Millisecond,
+			"c": 3 * time.Second,
+		}
+		for id, delay := range delays {
+			addHosts(t, pool, id)
+			pool.remoteHosts.Add(id, delayService{delay: delay})
+		}
+		return pool
+	}
+
+	connect := func(pool *VipnodePool) (*ClientResponse, time.Duration, error) {
+		t.Helper()
+		start := time.Now()
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		return resp, time.Since(start), err
+	}
+
+	resp, elapsed, err := connect(newPool(WhitelistQuorum(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 2 {
+		t.Errorf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+	if elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("quorum returned after unexpected duration: %s", elapsed)
+	}
+
+	firstPool := newPool(WhitelistFirst)
+	lateCalls := make(chan string, 2)
+	firstPool.remoteHosts.Add("b", delayService{delay: 50 * time.Millisecond, calls: lateCalls})
+	resp, elapsed, err = connect(firstPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 {
+		t.Errorf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+	if elapsed > time.Second {
+		t.Errorf("first returned after unexpected duration: %s", elapsed)
+	}
+	// The host that accepted late isn't returned, so it's asked to
+	// disconnect the client.
+	expectCalls(t, lateCalls, "vipnode_whitelist", "vipnode_disconnect")
+
+	failPool := newPool(WhitelistQuorum(2))
+	acceptedCalls := make(chan string, 2)
+	failPool.remoteHosts.Add("a", delayService{calls: acceptedCalls})
+	failPool.remoteHosts.Add("b", delayService{err: errors.New("whitelist failed")})
+	failPool.remoteHosts.Add("c", delayService{err: errors.New("whitelist failed")})
+	if _, _, err := connect(failPool); err != (WhitelistQuorumError{Quorum: 2, Accepted: 1}) {
+		t.Errorf("expected WhitelistQuorumError, got: %v", err)
+	}
+	// The client isn't given the host that accepted, so it's asked to
+	// disconnect the client.
+	expectCalls(t, acceptedCalls, "vipnode_whitelist", "vipnode_disconnect")
+
+	// A quorum above the number of requested hosts is lowered to it
+	largePool := newPool(WhitelistQuorum(5))
+	largePool.remoteHosts.Add("c", delayService{})
+	resp, _, err = connect(largePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 3 {
+		t.Errorf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+
+	clientReq.Distribution = DistributeMinimal
+	minimalPool := newPool(WhitelistQuorum(2))
+	minimalPool.remoteHosts.Add("c", delayService{})
+	resp, _, err = connect(minimalPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 {
+		t.Errorf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+	clientReq := ClientRequest{Kind: "geth"}
+
+	baseline := runtime.NumGoroutine()
+
+	pool := New(store.MemoryStore(), nil)
+	pool.WhitelistStrategy = WhitelistFirst
+	delays := map[store.NodeID]time.Duration{
+		"a": 0,
+		"b": 3 * time.Second,
+	}
+	for id, delay := range delays {
+		addHosts(t, pool, id)
+		pool.remoteHosts.Add(id, delayService{delay: delay})
+	}
+
+	connect := func() (*ClientResponse, error) {
+		return signedClient(t, pool, privkey, clientReq)
+	}
+	if _, err := connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pool.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Goroutines can take a moment to exit after they're done
+	numGoroutines := runtime.NumGoroutine()
+	for i := 0; i < 50 && numGoroutines > baseline; i++ {
+		time.Sleep(10 * time.Millisecond)
+		numGoroutines = runtime.NumGoroutine()
+	}
+	if numGoroutines > baseline {
+		t.Errorf("goroutines leaked after close: %d; want at most %d", numGoroutines, baseline)
+	}
+
+	if err := pool.Close(ctx); err != nil {
+		t.Errorf("closing again failed: %s", err)
+	}
+	if _, err := connect(); err == nil {
+		t.Error("expected closed pool to refuse whitelisting clients")
+	}
+}
+
+func TestPoolWhitelistTimeout(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+
+	connect := func(timeout time.Duration) error {
+		t.Helper()
+		pool := New(store.MemoryStore(), nil)
+		pool.WhitelistTimeout = timeout
+		addHosts(t, pool, "a")
+		pool.remoteHosts.Add("a", delayService{delay: 100 * time.Millisecond})
+
+		_, err := signedClient(t, pool, privkey, ClientRequest{Kind: "geth"})
+		return err
+	}
+
+	if got := New(store.MemoryStore(), nil).whitelistTimeout(); got != defaultWhitelistTimeout {
+		t.Errorf("wrong default whitelist timeout: %s", got)
+	}
+	if err := connect(time.Second); err != nil {
+		t.Errorf("expected slow host to be accepted within the timeout: %s", err)
+	}
+	if _, ok := connect(50 * time.Millisecond).(RemoteHostErrors); !ok {
+		t.Errorf("expected slow host to time out")
+	}
+}
+
+func TestPoolWhitelistBackupHosts(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.NumRequestHosts = 2
+	failing := map[store.NodeID]bool{"a": true, "b": true}
+	for _, id := range []store.NodeID{"a", "b", "c", "d", "e", "f", "g"} {
+		addHosts(t, pool, id)
+		var err error
+		if failing[id] {
+			err = errors.New("whitelist failed")
+		}
+		pool.remoteHosts.Add(id, delayService{err: err})
+	}
+
+	connect := func(clientReq ClientRequest) (*ClientResponse, error) {
+		t.Helper()
+		return signedClient(t, pool, privkey, clientReq)
+	}
+
+	// The preferred hosts are tried first and fail, so backups replace them.
+	resp, err := connect(ClientRequest{Kind: "geth", PreferredHosts: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 2 {
+		t.Fatalf("wrong number of hosts: %d", len(resp.Hosts))
+	}
+	for _, host := range resp.Hosts {
+		if failing[host.ID] {
+			t.Errorf("failed host was returned: %q", host.ID)
+		}
+	}
+	if resp.Tried != 4 {
+		t.Errorf("wrong number of tried hosts: %d", resp.Tried)
+	}
+	if want := []string{"whitelist failed", "whitelist failed"}; !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("wrong errors:\n got: %q\nwant: %q", resp.Errors, want)
+	}
+
+	// Backups are limited to backupHostsFactor times the requested hosts.
+	for _, id := range []store.NodeID{"c", "d", "e", "f", "g"} {
+		pool.remoteHosts.Add(id, delayService{err: errors.New("whitelist failed")})
+	}
+	_, err = connect(ClientRequest{Kind: "geth"})
+	hostErrs, ok := err.(RemoteHostErrors)
+	if !ok {
+		t.Fatalf("expected RemoteHostErrors, got: %v", err)
+	}
+	if got, want := len(hostErrs.Errors), pool.NumRequestHosts*backupHostsFactor; got != want {
+		t.Errorf("tried %d hosts; want %d", got, want)
+	}
+}
+
+func TestPoolUpdateRoleMismatch(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	pool := New(store.MemoryStore(), nil)
+	if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), URI: "enode://foo", IsHost: true, Kind: "geth", LastSeen: time.Now()}); err != nil {
+		t.Fatal("failed to add host node:", err)
+	}
+
+	update := func(updateReq UpdateRequest) error {
+		req := request.NodeRequest{
+			Method:    "vipnode_update",
+			NodeID:    nodeID,
+			Nonce:     time.Now().UnixNano(),
+			ExtraArgs: []interface{}{updateReq},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Update(context.Background(), sig, req.NodeID, req.Nonce, updateReq)
+		return err
+	}
+
+	err := update(UpdateRequest{Role: RoleClient})
+	if _, ok := err.(UpdateRoleError); !ok {
+		t.Errorf("expected UpdateRoleError for client update from host, got: %v", err)
+	}
+
+	if err := update(UpdateRequest{Role: RoleHost}); err != nil {
+		t.Errorf("unexpected error for host update from host: %s", err)
+	}
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	pool := New(store.MemoryStore(), nil)
+	pool.RateLimits = map[string]RateLimit{
+		"vipnode_update": {Burst: 3, Interval: 50 * time.Millisecond},
+	}
+	if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), URI: "enode://foo", IsHost: true, Kind: "geth", LastSeen: time.Now()}); err != nil {
+		t.Fatal("failed to add host node:", err)
+	}
+
+	update := func() error {
+		updateReq := UpdateRequest{Role: RoleHost}
+		req := request.NodeRequest{
+			Method:    "vipnode_update",
+			NodeID:    nodeID,
+			Nonce:     time.Now().UnixNano(),
+			ExtraArgs: []interface{}{updateReq},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pool.Update(context.Background(), sig, req.NodeID, req.Nonce, updateReq)
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := update(); err != nil {
+			t.Fatalf("update %d: %s", i, err)
+		}
+	}
+	err := update()
+	limitErr, ok := err.(RateLimitedError)
+	if !ok {
+		t.Fatalf("expected RateLimitedError, got: %v", err)
+	}
+	if limitErr.Method != "vipnode_update" || limitErr.RetryAfter <= 0 || limitErr.RetryAfter > 50*time.Millisecond {
+		t.Errorf("unexpected error: %s", limitErr)
+	}
+
+	// The bucket refills one call per interval
+	time.Sleep(limitErr.RetryAfter)
+	if err := update(); err != nil {
+		t.Errorf("update after refill: %s", err)
+	}
+	if _, ok := update().(RateLimitedError); !ok {
+		t.Errorf("expected RateLimitedError after refill was used")
+	}
+}
+
+func TestPoolHostQuota(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.HostQuota = func(nodeID string) int {
+		// Trial tier
+		return 2
+	}
+	addHosts(t, pool, "a", "b", "c")
+
+	connect := func(clientReq ClientRequest) (*ClientResponse, error) {
+		return signedClient(t, pool, privkey, clientReq)
+	}
+
+	resp, err := connect(ClientRequest{Kind: "geth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 2 {
+		t.Errorf("expected hosts to be limited by quota, got %d hosts", len(resp.Hosts))
+	}
+
+	// Client lost one of its hosts, so it asks for a replacement while
+	// keeping the other one.
+	kept := resp.Hosts[0].ID
+	if _, err := pool.Store.UpdateNodePeers(ctx, store.NodeID(nodeID), []string{string(kept)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = connect(ClientRequest{Kind: "geth", NumHosts: 1, ConnectedHosts: []string{string(kept)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Hosts) != 1 || resp.Hosts[0].ID == kept {
+		t.Fatalf("expected a replacement host other than %q, got: %v", kept, resp.Hosts)
+	}
+	client, err := pool.Store.GetNode(ctx, store.NodeID(nodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []store.NodeID{kept, resp.Hosts[0].ID}; !reflect.DeepEqual(client.AssignedHosts, want) {
+		t.Errorf("got assigned hosts %v; want %v", client.AssignedHosts, want)
+	}
+
+	// Client is now connected to its quota of hosts
+	if _, err := pool.Store.UpdateNodePeers(ctx, store.NodeID(nodeID), []string{string(kept), string(resp.Hosts[0].ID)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connect(ClientRequest{Kind: "geth"}); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded, got: %v", err)
+	}
+}
+
+type recordLogger struct {
+	fields   []LogFields
+	messages []string
+}
+
+func (l *recordLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	l.fields = append(l.fields, LogFieldsFromContext(ctx))
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestPoolLogger(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	log := &recordLogger{}
+	pool := New(store.MemoryStore(), nil)
+	pool.Logger = log
+	pool.skipWhitelist = true
+	addHosts(t, pool, "a")
+
+	clientReq := ClientRequest{Kind: "geth"}
+	if _, err := signedClient(t, pool, privkey, clientReq); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log.messages) != 1 {
+		t.Fatalf("got %d log messages; want 1: %q", len(log.messages), log.messages)
+	}
+	if want := (LogFields{NodeID: nodeID, Method: "vipnode_client"}); log.fields[0] != want {
+		t.Errorf("got log fields: %+v; want: %+v", log.fields[0], want)
+	}
+	if want := "(1 hosts found, skipping whitelist)"; !strings.HasSuffix(log.messages[0], want) {
+		t.Errorf("got log message: %q; want suffix: %q", log.messages[0], want)
+	}
+}
+
+func TestPoolNumRequestHosts(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	addHosts(t, pool, "a", "b", "c", "d", "e")
+
+	connect := func() (*ClientResponse, error) {
+		clientReq := ClientRequest{Kind: "geth"}
+		return signedClient(t, pool, privkey, clientReq)
+	}
+
+	for _, tc := range []struct {
+		NumRequestHosts int
+		Want            int
+	}{
+		{0, defaultNumRequestHosts},
+		{1, 1},
+		{4, 4},
+		{10, 5},
+	} {
+		pool.NumRequestHosts = tc.NumRequestHosts
+		resp, err := connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != tc.Want {
+			t.Errorf("NumRequestHosts=%d: got %d hosts; want %d", tc.NumRequestHosts, len(resp.Hosts), tc.Want)
+		}
+	}
+}
+
+func TestPoolSyncingHosts(t *testing.T) {
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 2
+	addHosts(t, pool, "a", "b", "c")
+	if err := pool.setSyncing(context.Background(), "a", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		clientReq := ClientRequest{Kind: "geth"}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range resp.Hosts {
+			if host.ID == "a" {
+				t.Fatalf("syncing host was offered before hosts in sync: %v", resp.Hosts)
+			}
+		}
+	}
+}
+
+func TestPoolMinHostVersion(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 3
+	pool.MinHostVersion = "v2.3.0"
+	versions := map[store.NodeID]string{"a": "v2.3.0", "b": "v2.2.9", "c": "", "d": "v2.4.1"}
+	for id, version := range versions {
+		if err := pool.Store.SetNode(ctx, store.Node{ID: id, URI: "enode://" + string(id), IsHost: true, Kind: "geth", LastSeen: time.Now(), Version: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		// Outdated hosts are excluded even if the client prefers them
+		clientReq := ClientRequest{Kind: "geth", PreferredHosts: []string{"b"}}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != 2 {
+			t.Errorf("got %d hosts; want 2: %v", len(resp.Hosts), resp.Hosts)
+		}
+		for _, host := range resp.Hosts {
+			if host.ID != "a" && host.ID != "d" {
+				t.Errorf("outdated host %q was selected", host.ID)
+			}
+		}
+	}
+}
+
+func TestPoolFullHosts(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 3
+	peers := map[store.NodeID]int{"a": 2, "b": 1, "c": 0}
+	for id, maxPeers := range peers {
+		if err := pool.Store.SetNode(ctx, store.Node{ID: id, URI: "enode://" + string(id), IsHost: true, Kind: "geth", LastSeen: time.Now(), MaxPeers: maxPeers}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Store.SetNode(ctx, store.Node{ID: "d", LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for id := range peers {
+		if _, err := pool.Store.UpdateNodePeers(ctx, id, []string{"d"}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		// Full hosts are excluded even if the client prefers them
+		clientReq := ClientRequest{Kind: "geth", PreferredHosts: []string{"b"}}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != 2 {
+			t.Errorf("got %d hosts; want 2: %v", len(resp.Hosts), resp.Hosts)
+		}
+		for _, host := range resp.Hosts {
+			if host.ID == "b" {
+				t.Errorf("full host %q was selected", host.ID)
+			}
+		}
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	ctx := context.Background()
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 1
+	pool.HostSelector = store.LeastClientsSelector{}
+	hosts := []store.NodeID{"a", "b", "c"}
+	addHosts(t, pool, hosts...)
+
+	connect := func(privkey *ecdsa.PrivateKey) *ClientResponse {
+		clientReq := ClientRequest{Kind: "geth"}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != 1 {
+			t.Fatalf("got %d hosts; want 1: %v", len(resp.Hosts), resp.Hosts)
+		}
+		return resp
+	}
+
+	const numClients = 30
+	keys := make([]*ecdsa.PrivateKey, 0, numClients)
+	assigned := map[store.NodeID]int{}
+	for i := 0; i < numClients; i++ {
+		privkey := keygen.NewKey(t)
+		keys = append(keys, privkey)
+		resp := connect(privkey)
+		assigned[resp.Hosts[0].ID] += 1
+
+		// Every host gets a client before any host gets another one.
+		for _, id := range hosts {
+			if n := assigned[id]; n < (i+1)/len(hosts) {
+				t.Fatalf("after %d clients, host %q has %d clients: %v", i+1, id, n, assigned)
+			}
+		}
+	}
+	for _, id := range hosts {
+		if got, want := assigned[id], numClients/len(hosts); got != want {
+			t.Errorf("host %q got %d clients; want %d", id, got, want)
+		}
+		node, err := pool.Store.GetNode(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Clients != assigned[id] {
+			t.Errorf("host %q has %d clients saved; want %d", id, node.Clients, assigned[id])
+		}
+	}
+
+	// Connecting again and disconnecting releases the assigned hosts.
+	for _, privkey := range keys {
+		connect(privkey)
+		nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+		req := request.NodeRequest{
+			Method: "vipnode_disconnect",
+			NodeID: nodeID,
+			Nonce:  time.Now().UnixNano(),
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pool.Disconnect(ctx, sig, req.NodeID, req.Nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range hosts {
+		node, err := pool.Store.GetNode(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Clients != 0 {
+			t.Errorf("host %q has %d clients after disconnects; want 0", id, node.Clients)
+		}
+	}
+}
+
+func TestPoolDistribution(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 3
+	now := time.Now()
+	hosts := []store.Node{
+		{ID: "b1", URI: "enode://b1@10.2.0.1:30303", Payout: "0xb"},
+		{ID: "c1", URI: "enode://c1@10.3.0.1:30303", Payout: "0xc"},
+	}
+	for i := 1; i <= 8; i++ {
+		id := fmt.Sprintf("a%d", i)
+		hosts = append(hosts, store.Node{ID: store.NodeID(id), URI: fmt.Sprintf("enode://%s@10.1.0.%d:30303", id, i), Payout: "0xa"})
+	}
+	for _, node := range hosts {
+		node.IsHost = true
+		node.Kind = "geth"
+		node.LastSeen = now
+		if err := pool.Store.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect := func(distribution string) ([]store.Node, error) {
+		clientReq := ClientRequest{Kind: "geth", Distribution: distribution}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Hosts, nil
+	}
+
+	// Host selection is random, so try a few times.
+	for i := 0; i < 10; i++ {
+		hosts, err := connect(DistributeSpread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		operators := map[store.Account]bool{}
+		for _, host := range hosts {
+			operators[host.Payout] = true
+		}
+		if want := map[store.Account]bool{"0xa": true, "0xb": true, "0xc": true}; !reflect.DeepEqual(operators, want) {
+			t.Fatalf("spread hosts are not from distinct operators: %v", hosts)
+		}
+	}
+
+	if hosts, err := connect(DistributeMinimal); err != nil {
+		t.Fatal(err)
+	} else if len(hosts) != 1 {
+		t.Errorf("minimal: got %d hosts; want 1", len(hosts))
+	}
+
+	if _, err := connect("bogus"); err == nil {
+		t.Error("expected error for unknown distribution")
+	}
+}
+
+func TestSpreadHosts(t *testing.T) {
+	hosts := []store.Node{
+		{ID: "a1", URI: "enode://a1@10.1.0.1:30303"},
+		{ID: "a2", URI: "enode://a2@10.1.0.2:30303"},
+		{ID: "b1", URI: "enode://b1@10.2.0.1:30303", Payout: "0xb"},
+		{ID: "b2", URI: "enode://b2@10.3.0.1:30303", Payout: "0xb"},
+		{ID: "c1", URI: "enode://c1@10.2.0.2:30303", Payout: "0xc"},
+	}
+	ids := func(nodes []store.Node) []string {
+		r := []string{}
+		for _, n := range nodes {
+			r = append(r, string(n.ID))
+		}
+		return r
+	}
+
+	for _, tc := range []struct {
+		Selected []store.Node
+		Limit    int
+		Want     []string
+	}{
+		// New operators on new networks first
+		{nil, 2, []string{"a1", "b1"}},
+		// Then new operators on seen networks
+		{nil, 3, []string{"a1", "b1", "c1"}},
+		// Then anything
+		{nil, 5, []string{"a1", "b1", "c1", "a2", "b2"}},
+		// Already selected hosts count towards diversity
+		{hosts[2:3], 2, []string{"b1", "a1"}},
+		{hosts[4:5], 2, []string{"c1", "a1"}},
+	} {
+		selected := append([]store.Node{}, tc.Selected...)
+		if got := ids(spreadHosts(selected, hosts, tc.Limit)); !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("spreadHosts(%q, %d): got %q; want %q", ids(tc.Selected), tc.Limit, got, tc.Want)
+		}
+	}
+}
+
+func TestPoolPreferredHosts(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+
+	pool := New(store.MemoryStore(), nil)
+	pool.skipWhitelist = true
+	pool.NumRequestHosts = 2
+	addHosts(t, pool, "a", "b", "c")
+	now := time.Now()
+	for _, node := range []store.Node{
+		{ID: "stale", URI: "enode://stale", IsHost: true, Kind: "geth", LastSeen: now.Add(-store.ExpireInterval * 2)},
+		{ID: "parity", URI: "enode://parity", IsHost: true, Kind: "parity", LastSeen: now},
+	} {
+		if err := pool.Store.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect := func(preferred ...string) []store.Node {
+		clientReq := ClientRequest{Kind: "geth", PreferredHosts: preferred}
+		resp, err := signedClient(t, pool, privkey, clientReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hosts) != 2 {
+			t.Fatalf("wrong number of hosts: %d", len(resp.Hosts))
+		}
+		if resp.Hosts[0].ID == resp.Hosts[1].ID {
+			t.Errorf("duplicate hosts: %v", resp.Hosts)
+		}
+		return resp.Hosts
+	}
+
+	// Preferred host is returned first, every time
+	for i := 0; i < 10; i++ {
+		if hosts := connect("c"); hosts[0].ID != "c" {
+			t.Errorf("preferred host is not first: %v", hosts)
+		}
+	}
+
+	// Unavailable and incompatible preferred hosts are skipped
+	if hosts := connect("unknown", "stale", "parity", "b"); hosts[0].ID != "b" {
+		t.Errorf("preferred host is not first: %v", hosts)
+	}
+
+	// Preferred hosts fill the whole response
+	if hosts := connect("b", "b", "a"); hosts[0].ID != "b" || hosts[1].ID != "a" {
+		t.Errorf("wrong preferred hosts: %v", hosts)
+	}
+}
+
+func TestPoolDisconnect(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	manager := balance.PayPerInterval(memStore, time.Minute, big.NewInt(1000))
+	manager.Clock = fakeClock
+	pool := New(memStore, manager)
+	pool.Clock = fakeClock
+
+	hostKey := keygen.HardcodedKeyIdx(t, 0)
+	clientKey := keygen.HardcodedKeyIdx(t, 1)
+	hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+	clientID := discv5.PubkeyID(&clientKey.PublicKey).String()
+
+	nonce := fakeClock.Now().UnixNano()
+	sign := func(privkey *ecdsa.PrivateKey, method string, args ...interface{}) (string, string, int64) {
+		t.Helper()
+		nonce += 1
+		req := request.NodeRequest{
+			Method:    method,
+			NodeID:    discv5.PubkeyID(&privkey.PublicKey).String(),
+			Nonce:     nonce,
+			ExtraArgs: args,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, req.NodeID, req.Nonce
+	}
+	update := func(privkey *ecdsa.PrivateKey, peers ...string) (*UpdateResponse, error) {
+		t.Helper()
+		req := UpdateRequest{Peers: peers}
+		sig, nodeID, nonce := sign(privkey, "vipnode_update", req)
+		return pool.Update(context.Background(), sig, nodeID, nonce, req)
+	}
+	disconnect := func(privkey *ecdsa.PrivateKey) error {
+		t.Helper()
+		sig, nodeID, nonce := sign(privkey, "vipnode_disconnect")
+		return pool.Disconnect(context.Background(), sig, nodeID, nonce)
+	}
+
+	if err := memStore.SetNode(ctx, store.Node{ID: store.NodeID(hostID), URI: "enode://" + hostID, IsHost: true, Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memStore.SetNode(ctx, store.Node{ID: store.NodeID(clientID), Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := update(hostKey, clientID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := update(clientKey, hostID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnect settles the partial interval since the last update.
+	fakeClock.Add(time.Minute)
+	if err := disconnect(clientKey); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := memStore.GetNodeBalance(ctx, store.NodeID(hostID)); err != nil {
+		t.Fatal(err)
+	} else if got, want := b.Credit.Int64(), int64(1000); got != want {
+		t.Errorf("wrong host balance after disconnect: got %d; want %d", got, want)
+	}
+
+	// Host is no longer billed for the disconnected client.
+	fakeClock.Add(time.Minute)
+	if resp, err := update(hostKey, clientID); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.Balance.Credit.Int64(), int64(1000); got != want {
+		t.Errorf("wrong host balance after update: got %d; want %d", got, want)
+	}
+	if _, err := update(clientKey, hostID); err != store.ErrUnregisteredNode {
+		t.Errorf("expected unregistered error for disconnected client update, got: %v", err)
+	}
+	if err := disconnect(clientKey); err != store.ErrUnregisteredNode {
+		t.Errorf("expected unregistered error for repeated disconnect, got: %v", err)
+	}
+}
+
+func TestPoolExpireNodes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	memStore := store.MemoryStore()
+	pool := New(memStore, nil)
+
+	nodes := []store.Node{
+		{ID: "a", URI: "enode://a", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "b", URI: "enode://b", IsHost: true, Kind: "geth", LastSeen: now.Add(-48 * time.Hour)},
+		{ID: "c", Kind: "geth", LastSeen: now},
+	}
+	for _, n := range nodes {
+		if err := memStore.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		if n.IsHost {
+			pool.remoteHosts.Add(n.ID, delayService{})
+		}
+	}
+	if err := memStore.AssignHosts(ctx, "c", []store.NodeID{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	pool.balanceSubs["b"] = stopCh
+
+	if n, err := pool.ExpireNodes(ctx, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("expired %d nodes; want 1", n)
+	}
+	if _, ok := pool.remoteHosts.Get("b"); ok {
+		t.Error("expired host is still connected")
+	}
+	if _, ok := pool.remoteHosts.Get("a"); !ok {
+		t.Error("active host was disconnected")
+	}
+	select {
+	case <-stopCh:
+	default:
+		t.Error("expired host's balance subscription was not stopped")
+	}
+	if client, err := memStore.GetNode(ctx, "c"); err != nil {
+		t.Fatal(err)
+	} else if got, want := client.AssignedHosts, []store.NodeID{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("client assigned hosts after expiry: got %v; want %v", got, want)
+	}
+}
+
+func TestPoolReconnect(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	manager := balance.PayPerInterval(memStore, time.Minute, big.NewInt(1000))
+	manager.Clock = fakeClock
+	pool := New(memStore, manager)
+	pool.Clock = fakeClock
+	pool.skipWhitelist = true
+
+	hostKey := keygen.HardcodedKeyIdx(t, 0)
+	clientKey := keygen.HardcodedKeyIdx(t, 1)
+	hostID := discv5.PubkeyID(&hostKey.PublicKey).String()
+	clientID := discv5.PubkeyID(&clientKey.PublicKey).String()
+
+	nonce := fakeClock.Now().UnixNano()
+	sign := func(privkey *ecdsa.PrivateKey, method string, args ...interface{}) (string, string, int64) {
+		t.Helper()
+		nonce += 1
+		req := request.NodeRequest{
+			Method:    method,
+			NodeID:    discv5.PubkeyID(&privkey.PublicKey).String(),
+			Nonce:     nonce,
+			ExtraArgs: args,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, req.NodeID, req.Nonce
+	}
+	connect := func() {
+		t.Helper()
+		req := ClientRequest{Kind: "geth"}
+		sig, nodeID, nonce := sign(clientKey, "vipnode_client", req)
+		if _, err := pool.Client(context.Background(), sig, nodeID, nonce, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update := func(privkey *ecdsa.PrivateKey, peers ...string) {
+		t.Helper()
+		req := UpdateRequest{Peers: peers}
+		sig, nodeID, nonce := sign(privkey, "vipnode_update", req)
+		if _, err := pool.Update(context.Background(), sig, nodeID, nonce, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := memStore.SetNode(ctx, store.Node{ID: store.NodeID(hostID), URI: "enode://" + hostID, IsHost: true, Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	account := store.Account("0xabc")
+	if err := memStore.AddAccountNode(ctx, account, store.NodeID(clientID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := memStore.AddAccountBalance(ctx, account, big.NewInt(5000)); err != nil {
+		t.Fatal(err)
+	}
+
+	connect()
+	update(hostKey, clientID)
+	update(clientKey, hostID)
+	fakeClock.Add(time.Minute)
+	update(hostKey, clientID)
+	update(clientKey, hostID)
+
+	before, err := memStore.GetNodeBalance(ctx, store.NodeID(clientID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Credit.Cmp(big.NewInt(5000)) >= 0 {
+		t.Fatalf("client was not billed before reconnect: %d", &before.Credit)
+	}
+
+	// Connecting again keeps the client's account, balance and peers.
+	connect()
+	if after, err := memStore.GetNodeBalance(ctx, store.NodeID(clientID)); err != nil {
+		t.Fatal(err)
+	} else if after.Account != account {
+		t.Errorf("wrong client account after reconnect: got %q; want %q", after.Account, account)
+	} else if after.Credit.Cmp(&before.Credit) != 0 {
+		t.Errorf("wrong client balance after reconnect: got %d; want %d", &after.Credit, &before.Credit)
+	}
+	if peers, err := memStore.NodePeers(ctx, store.NodeID(clientID)); err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 1 || peers[0].ID != store.NodeID(hostID) {
+		t.Errorf("wrong client peers after reconnect: %v", peers)
+	}
+	if node, err := memStore.GetNode(ctx, store.NodeID(clientID)); err != nil {
+		t.Fatal(err)
+	} else if node.PeerCount != 1 {
+		t.Errorf("wrong client peer count after reconnect: got %d; want 1", node.PeerCount)
+	}
+}
+
+func TestPoolUpdateUnbilled(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	update := func(pool *VipnodePool) *UpdateResponse {
+		t.Helper()
+		if err := pool.Store.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), Kind: "geth", LastSeen: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		updateReq := UpdateRequest{Peers: []string{}}
+		req := request.NodeRequest{
+			Method:    "vipnode_update",
+			NodeID:    nodeID,
+			Nonce:     time.Now().UnixNano(),
+			ExtraArgs: []interface{}{updateReq},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := pool.Update(context.Background(), sig, req.NodeID, req.Nonce, updateReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Node without a balance record
+	resp := update(New(store.MemoryStore(), nil))
+	if !resp.Unbilled || resp.Balance != nil {
+		t.Errorf("expected unbilled response without a balance: %+v", resp)
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `{"invalid_peers":[],"unbilled":true}`; got != want {
+		t.Errorf("wrong encoding:\n got: %s\nwant: %s", got, want)
+	}
+
+	// Billed node with a zero balance
+	memStore := store.MemoryStore()
+	resp = update(New(memStore, balance.PayPerInterval(memStore, time.Minute, big.NewInt(1000))))
+	if resp.Unbilled || resp.Balance == nil {
+		t.Fatalf("expected billed response with a balance: %+v", resp)
+	}
+	if resp.Balance.Credit.Sign() != 0 {
+		t.Errorf("expected zero credit: %s", resp.Balance)
+	}
+
+	// Pool without a balance manager
+	pool := New(store.MemoryStore(), nil)
+	pool.BalanceManager = nil
+	resp = update(pool)
+	if !resp.Unbilled || resp.Balance != nil {
+		t.Errorf("expected unbilled response without a balance manager: %+v", resp)
+	}
+}
+
+// trialManager allows each client to connect once, and records disconnects.
+type trialManager struct {
+	balance.NoBalance
+	used         map[store.NodeID]bool
+	disconnected []store.NodeID
+}
+
+func (m *trialManager) OnConnect(ctx context.Context, node store.Node) error {
+	if m.used[node.ID] {
+		return balance.ConnectRefusedError{Reason: "trial already used"}
+	}
+	m.used[node.ID] = true
+	return nil
+}
+
+func (m *trialManager) OnDisconnect(ctx context.Context, node store.Node) (store.Balance, error) {
+	m.disconnected = append(m.disconnected, node.ID)
+	return store.Balance{}, nil
+}
+
+func TestPoolConnectRefused(t *testing.T) {
+	ctx := context.Background()
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+
+	manager := &trialManager{used: map[store.NodeID]bool{}}
+	pool := New(store.MemoryStore(), manager)
+	pool.skipWhitelist = true
+	addHosts(t, pool, "a")
+
+	nonce := time.Now().UnixNano()
+	sign := func(method string, args ...interface{}) (string, int64) {
+		t.Helper()
+		nonce += 1
+		req := request.NodeRequest{
+			Method:    method,
+			NodeID:    nodeID,
+			Nonce:     nonce,
+			ExtraArgs: args,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, nonce
+	}
+	connect := func() error {
+		clientReq := ClientRequest{Kind: "geth"}
+		sig, nonce := sign("vipnode_client", clientReq)
+		_, err := pool.Client(context.Background(), sig, nodeID, nonce, clientReq)
+		return err
+	}
+
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+	sig, nonce := sign("vipnode_disconnect")
+	if err := pool.Disconnect(context.Background(), sig, nodeID, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if want := []store.NodeID{store.NodeID(nodeID)}; !reflect.DeepEqual(manager.disconnected, want) {
+		t.Errorf("OnDisconnect calls: got %v; want %v", manager.disconnected, want)
+	}
+
+	// Trial was used, so reconnecting is refused
+	err := connect()
+	if _, ok := err.(balance.ConnectRefusedError); !ok {
+		t.Fatalf("expected ConnectRefusedError, got: %v", err)
+	}
+	if _, err := pool.Store.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+		t.Errorf("refused client was registered: %v", err)
+	}
+	// The refused client is cleaned up like a disconnect
+	if want := []store.NodeID{store.NodeID(nodeID), store.NodeID(nodeID)}; !reflect.DeepEqual(manager.disconnected, want) {
+		t.Errorf("OnDisconnect calls: got %v; want %v", manager.disconnected, want)
+	}
+}
+
+func TestPoolBalanceExhausted(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	manager := balance.TrialBalance(memStore, time.Minute, big.NewInt(1000), big.NewInt(1500))
+	manager.Clock = fakeClock
+	pool := New(memStore, manager)
+	pool.Clock = fakeClock
+	pool.skipWhitelist = true
+
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	if err := memStore.SetNode(ctx, store.Node{ID: "a", URI: "enode://a", IsHost: true, Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := fakeClock.Now().UnixNano()
+	sign := func(method string, args ...interface{}) (string, int64) {
+		t.Helper()
+		nonce += 1
+		req := request.NodeRequest{
+			Method:    method,
+			NodeID:    nodeID,
+			Nonce:     nonce,
+			ExtraArgs: args,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, nonce
+	}
+	connect := func() error {
+		clientReq := ClientRequest{Kind: "geth"}
+		sig, nonce := sign("vipnode_client", clientReq)
+		_, err := pool.Client(context.Background(), sig, nodeID, nonce, clientReq)
+		return err
+	}
+	update := func() (*UpdateResponse, error) {
+		updateReq := UpdateRequest{Peers: []string{"a"}}
+		sig, nonce := sign("vipnode_update", updateReq)
+		return pool.Update(context.Background(), sig, nodeID, nonce, updateReq)
+	}
+
+	// Client registration uses the pool's clock, so it's seen at the fake
+	// time.
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := update(); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeClock.Add(time.Minute)
+	if resp, err := update(); err != nil {
+		t.Fatal(err)
+	} else if got, want := resp.Balance.Credit.Int64(), int64(500); got != want {
+		t.Errorf("credit: got %d; want %d", got, want)
+	}
+
+	fakeClock.Add(time.Minute)
+	_, err := update()
+	exhaustedErr, ok := err.(balance.BalanceExhaustedError)
+	if !ok {
+		t.Fatalf("expected BalanceExhaustedError, got: %v", err)
+	}
+	if exhaustedErr.NodeID != store.NodeID(nodeID) || exhaustedErr.Credit.Int64() != -500 {
+		t.Errorf("unexpected error: %s", exhaustedErr)
+	}
+	if _, err := memStore.GetNode(ctx, store.NodeID(nodeID)); err != store.ErrUnregisteredNode {
+		t.Errorf("exhausted client was not removed: %v", err)
+	}
+
+	// Reconnecting doesn't grant another trial
+	if _, ok := connect().(balance.BalanceExhaustedError); !ok {
+		t.Errorf("expected exhausted client to be refused")
+	}
+}
+
+func TestPoolMaxCreditedHosts(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	memStore := store.MemoryStore()
+	memStore.Clock = fakeClock
+	manager := balance.PayPerInterval(memStore, time.Minute, big.NewInt(1000))
+	manager.Clock = fakeClock
+	pool := New(memStore, manager)
+	pool.Clock = fakeClock
+	pool.MaxCreditedHosts = 2
+
+	privkey := keygen.HardcodedKey(t)
+	nodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	hostIDs := []string{"a", "b", "c"}
+	for _, id := range hostIDs {
+		if err := memStore.SetNode(ctx, store.Node{ID: store.NodeID(id), URI: "enode://" + id, IsHost: true, Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := memStore.SetNode(ctx, store.Node{ID: store.NodeID(nodeID), Kind: "geth", LastSeen: fakeClock.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := fakeClock.Now().UnixNano()
+	update := func() (*UpdateResponse, error) {
+		t.Helper()
+		nonce += 1
+		updateReq := UpdateRequest{Peers: hostIDs}
+		req := request.NodeRequest{
+			Method:    "vipnode_update",
+			NodeID:    nodeID,
+			Nonce:     nonce,
+			ExtraArgs: []interface{}{updateReq},
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pool.Update(context.Background(), sig, req.NodeID, req.Nonce, updateReq)
+	}
+
+	if _, err := update(); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Add(time.Minute)
+	resp, err := update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Balance.Credit.Int64(), int64(-2000); got != want {
+		t.Errorf("wrong client balance: got %d; want %d", got, want)
+	}
+
+	numCredited := 0
+	for _, id := range hostIDs {
+		b, err := memStore.GetNodeBalance(ctx, store.NodeID(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Credit.Sign() > 0 {
+			numCredited += 1
+		}
+	}
+	if numCredited != 2 {
+		t.Errorf("wrong number of credited hosts: got %d; want 2", numCredited)
+	}
+}
+
+func TestPoolProjection(t *testing.T) {
+	unbilled := New(store.MemoryStore(), nil)
+	resp, err := unbilled.Projection(context.Background(), ProjectionRequest{Peers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Unbilled {
+		t.Errorf("expected unbilled projection: %+v", resp)
+	}
+
+	storeDriver := store.MemoryStore()
+	p := New(storeDriver, balance.PayPerInterval(storeDriver, time.Minute, big.NewInt(1000)))
+	rpcPool := &jsonrpc2.Local{}
+	if err := rpcPool.Server.Register("vipnode_", p); err != nil {
+		t.Fatal(err)
+	}
+	var got ProjectionResponse
+	if err := rpcPool.Call(context.Background(), &got, "vipnode_projection", ProjectionRequest{Peers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := ProjectionResponse{
+		Peers:             2,
+		Interval:          time.Minute,
+		CreditPerInterval: "2000",
+		CreditPerHour:     "120000",
+	}
+	if got != want {
+		t.Errorf("got projection %+v; want %+v", got, want)
+	}
+}
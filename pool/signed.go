@@ -0,0 +1,49 @@
+package pool
+
+import "context"
+
+// signedMethod is a pool method whose requests are signed by the calling
+// node.
+type signedMethod struct {
+	// Name is the RPC method name that requests are signed for.
+	Name string
+	// NodeKeyOnly refuses requests that are signed by a signing key that was
+	// bound to the node, rather than by the node's own key.
+	NodeKeyOnly bool
+	// OperatorOnly refuses requests from nodes that aren't one of the pool's
+	// Operators.
+	OperatorOnly bool
+}
+
+// Signed methods of the pool service.
+var (
+	methodHost               = signedMethod{Name: "vipnode_host"}
+	methodClient             = signedMethod{Name: "vipnode_client"}
+	methodUpdate             = signedMethod{Name: "vipnode_update"}
+	methodDisconnect         = signedMethod{Name: "vipnode_disconnect"}
+	methodRewhitelist        = signedMethod{Name: "vipnode_rewhitelist"}
+	methodDrain              = signedMethod{Name: "vipnode_drain"}
+	methodSubscribeBalance   = signedMethod{Name: "vipnode_subscribeBalance"}
+	methodUnsubscribeBalance = signedMethod{Name: "vipnode_unsubscribeBalance"}
+	methodBindSigningKey     = signedMethod{Name: "vipnode_bindSigningKey", NodeKeyOnly: true}
+	methodEvict              = signedMethod{Name: "vipnode_evict", OperatorOnly: true}
+	methodStats              = signedMethod{Name: "vipnode_stats", OperatorOnly: true}
+	methodAccountsAbove      = signedMethod{Name: "vipnode_accountsAbove", OperatorOnly: true}
+)
+
+// verify checks a signed request to the method, where args are the method's
+// parameters after the nonce, and returns the context with the request's log
+// fields.
+func (m signedMethod) verify(ctx context.Context, p *VipnodePool, sig string, nodeID string, nonce int64, args ...interface{}) (context.Context, error) {
+	verify := p.verify
+	if m.NodeKeyOnly {
+		verify = p.verifyNodeKey
+	}
+	if err := verify(ctx, sig, m.Name, nodeID, nonce, args...); err != nil {
+		return ctx, err
+	}
+	if m.OperatorOnly && !p.isOperator(nodeID) {
+		return ctx, ErrNotOperator
+	}
+	return withLogFields(ctx, nodeID, m.Name), nil
+}
@@ -0,0 +1,101 @@
+package pool
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/vipnode/vipnode/internal/keygen"
+	"github.com/vipnode/vipnode/pool/store"
+	"github.com/vipnode/vipnode/request"
+)
+
+func TestSignedMethod(t *testing.T) {
+	memStore := store.MemoryStore()
+	pool := New(memStore, nil)
+	nodeKey := keygen.HardcodedKeyIdx(t, 0)
+	signerKey := keygen.HardcodedKeyIdx(t, 1)
+	nodeID := discv5.PubkeyID(&nodeKey.PublicKey).String()
+	signerID := discv5.PubkeyID(&signerKey.PublicKey).String()
+
+	nonce := time.Now().UnixNano()
+	sign := func(privkey *ecdsa.PrivateKey, method string, args ...interface{}) (string, int64) {
+		t.Helper()
+		nonce += 1
+		req := request.NodeRequest{
+			Method:    method,
+			NodeID:    nodeID,
+			Nonce:     nonce,
+			ExtraArgs: args,
+		}
+		sig, err := req.Sign(privkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig, req.Nonce
+	}
+
+	// Requests without arguments are signed without any extra arguments.
+	noArgsMethod := signedMethod{Name: "vipnode_test"}
+	sig, nonce := sign(nodeKey, "vipnode_test")
+	ctx, err := noArgsMethod.verify(context.Background(), pool, sig, nodeID, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := LogFieldsFromContext(ctx), (LogFields{NodeID: nodeID, Method: "vipnode_test"}); got != want {
+		t.Errorf("wrong log fields: got %+v; want %+v", got, want)
+	}
+
+	// The signature covers the method name and the arguments.
+	argsMethod := signedMethod{Name: "vipnode_test"}
+	sig, nonce = sign(nodeKey, "vipnode_test", ClientRequest{Kind: "geth"})
+	if _, err := argsMethod.verify(context.Background(), pool, sig, nodeID, nonce, ClientRequest{Kind: "parity"}); err == nil {
+		t.Error("expected request with different arguments to fail verification")
+	}
+	sig, nonce = sign(nodeKey, "vipnode_other", ClientRequest{Kind: "geth"})
+	if _, err := argsMethod.verify(context.Background(), pool, sig, nodeID, nonce, ClientRequest{Kind: "geth"}); err == nil {
+		t.Error("expected request for a different method to fail verification")
+	}
+	sig, nonce = sign(nodeKey, "vipnode_test", ClientRequest{Kind: "geth"})
+	if _, err := argsMethod.verify(context.Background(), pool, sig, nodeID, nonce, ClientRequest{Kind: "geth"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// Requests that fail to verify don't use up the node's nonces.
+	forgedSig, _ := sign(signerKey, "vipnode_test")
+	if _, err := argsMethod.verify(context.Background(), pool, forgedSig, nodeID, nonce+1000, ClientRequest{Kind: "geth"}); err == nil {
+		t.Error("expected request signed by another key to fail verification")
+	}
+	sig, nonce = sign(nodeKey, "vipnode_test", ClientRequest{Kind: "geth"})
+	if _, err := argsMethod.verify(context.Background(), pool, sig, nodeID, nonce, ClientRequest{Kind: "geth"}); err != nil {
+		t.Errorf("unexpected error after forged request: %s", err)
+	}
+
+	// Bound signing keys are refused by NodeKeyOnly methods.
+	if err := memStore.SetSigningKey(ctx, store.NodeID(nodeID), store.NodeID(signerID)); err != nil {
+		t.Fatal(err)
+	}
+	sig, nonce = sign(signerKey, "vipnode_test")
+	if _, err := noArgsMethod.verify(context.Background(), pool, sig, nodeID, nonce); err != nil {
+		t.Errorf("unexpected error for signing key: %s", err)
+	}
+	nodeKeyMethod := signedMethod{Name: "vipnode_test", NodeKeyOnly: true}
+	sig, nonce = sign(signerKey, "vipnode_test")
+	if _, err := nodeKeyMethod.verify(context.Background(), pool, sig, nodeID, nonce); err == nil {
+		t.Error("expected signing key to be refused")
+	}
+
+	// OperatorOnly methods refuse verified requests from other nodes.
+	operatorMethod := signedMethod{Name: "vipnode_test", OperatorOnly: true}
+	sig, nonce = sign(nodeKey, "vipnode_test")
+	if _, err := operatorMethod.verify(context.Background(), pool, sig, nodeID, nonce); err != ErrNotOperator {
+		t.Errorf("expected ErrNotOperator, got: %v", err)
+	}
+	pool.Operators = []string{nodeID}
+	sig, nonce = sign(nodeKey, "vipnode_test")
+	if _, err := operatorMethod.verify(context.Background(), pool, sig, nodeID, nonce); err != nil {
+		t.Errorf("unexpected error for operator: %s", err)
+	}
+}
@@ -0,0 +1,112 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// PoolStats is the response type for Stats RPC calls. Credit amounts are
+// decimal strings in wei, like in store.Balance.
+type PoolStats struct {
+	NumHosts   int `json:"num_hosts"`
+	NumClients int `json:"num_clients"`
+	// Kinds breaks down the node counts by each node's kind.
+	Kinds map[string]KindStats `json:"kinds"`
+	// TotalCredit is the sum of the credit held by every balance in the
+	// store.
+	TotalCredit string `json:"total_credit"`
+}
+
+// KindStats counts the nodes of one kind. Nodes are active if they were seen
+// within store.ExpireInterval, otherwise they are stale.
+type KindStats struct {
+	ActiveHosts   int `json:"active_hosts"`
+	StaleHosts    int `json:"stale_hosts"`
+	ActiveClients int `json:"active_clients"`
+	StaleClients  int `json:"stale_clients"`
+}
+
+// countNodes aggregates the node counts of stats, relative to now.
+func (stats *PoolStats) countNodes(nodes []store.Node, now time.Time) {
+	if stats.Kinds == nil {
+		stats.Kinds = map[string]KindStats{}
+	}
+	activeSince := now.Add(-store.ExpireInterval)
+	for _, n := range nodes {
+		kind := stats.Kinds[n.Kind]
+		isActive := n.LastSeen.After(activeSince)
+		if n.IsHost {
+			stats.NumHosts += 1
+			if isActive {
+				kind.ActiveHosts += 1
+			} else {
+				kind.StaleHosts += 1
+			}
+		} else {
+			stats.NumClients += 1
+			if isActive {
+				kind.ActiveClients += 1
+			} else {
+				kind.StaleClients += 1
+			}
+		}
+		stats.Kinds[n.Kind] = kind
+	}
+}
+
+// isOperator returns whether the node is one of the pool's operators.
+func (p *VipnodePool) isOperator(nodeID string) bool {
+	for _, id := range p.Operators {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns aggregate statistics about the pool's nodes and balances. It
+// reveals the pool's internals, so it must be signed by one of the pool's
+// Operators.
+func (p *VipnodePool) Stats(ctx context.Context, sig string, nodeID string, nonce int64) (*PoolStats, error) {
+	ctx, err := methodStats.verify(ctx, p, sig, nodeID, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := p.Store.AllNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	storeStats, err := p.Store.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := PoolStats{TotalCredit: storeStats.TotalCredit.String()}
+	stats.countNodes(nodes, p.Clock.Now())
+	return &stats, nil
+}
+
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold, a decimal amount in wei, such as for telling hosts that they
+// have earnings to withdraw. It must be signed by one of the pool's
+// Operators.
+func (p *VipnodePool) AccountsAbove(ctx context.Context, sig string, nodeID string, nonce int64, threshold string) ([]store.Account, error) {
+	_, err := methodAccountsAbove.verify(ctx, p, sig, nodeID, nonce, threshold)
+	if err != nil {
+		return nil, err
+	}
+	scanner, ok := p.Store.(store.AccountScanStore)
+	if !ok {
+		return nil, ErrAccountScanUnsupported
+	}
+	amount, ok := new(big.Int).SetString(threshold, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid threshold amount: %q", threshold)
+	}
+	return scanner.AccountsAbove(ctx, amount)
+}
@@ -3,6 +3,7 @@ package status
 import (
 	"context"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
 
@@ -36,6 +37,30 @@ func nodeHost(n store.Node) Host {
 	}
 }
 
+// HostKind counts the active hosts of one kind, so that clients can tell which
+// kinds of nodes the pool can serve.
+type HostKind struct {
+	Kind     string `json:"kind"`
+	NumHosts int    `json:"num_hosts"`
+}
+
+// hostKinds returns the distinct kinds of the hosts with their counts, sorted
+// by kind.
+func hostKinds(hosts []Host) []HostKind {
+	counts := map[string]int{}
+	for _, h := range hosts {
+		counts[h.Kind] += 1
+	}
+	r := make([]HostKind, 0, len(counts))
+	for kind, num := range counts {
+		r = append(r, HostKind{Kind: kind, NumHosts: num})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Kind < r[j].Kind
+	})
+	return r
+}
+
 // StatusRequest is the response type for Status RPC calls.
 type StatusResponse struct {
 	// TimeUpdated is the time when the response was generated. Because the
@@ -51,6 +76,9 @@ type StatusResponse struct {
 	// ActiveHosts is a list of participating hosts who have been seen recently.
 	ActiveHosts []Host `json:"active_hosts"`
 
+	// Kinds lists each distinct kind of the active hosts with its count.
+	Kinds []HostKind `json:"kinds"`
+
 	// Stats contains aggregate statistics about the state of the store.
 	Stats *store.Stats `json:"stats"`
 
@@ -84,15 +112,19 @@ type PoolStatus struct {
 	cachedResp *StatusResponse
 }
 
-// getStatus is an uncached version of Status
+// getStatus is an uncached version of Status. The response is shared by
+// every request, so it uses its own timeout rather than a request's context.
 func (s *PoolStatus) getStatus() (*StatusResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
 	r := &StatusResponse{
 		TimeUpdated: time.Now(),
 		TimeStarted: s.TimeStarted,
 		Version:     s.Version,
 	}
 
-	stats, err := s.Store.Stats()
+	stats, err := s.Store.Stats(ctx)
 	if err != nil {
 		r.Error = err
 		return r, err
@@ -100,16 +132,14 @@ func (s *PoolStatus) getStatus() (*StatusResponse, error) {
 	r.Stats = stats
 
 	if s.GetTotalDeposit != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
 		totalDeposit, err := s.GetTotalDeposit(ctx)
-		cancel()
 		if err != nil {
 			return nil, err
 		}
 		r.Stats.TotalDeposit = *totalDeposit
 	}
 
-	nodes, err := s.Store.ActiveHosts("", 0)
+	nodes, err := s.Store.ActiveHosts(ctx, store.AnyKind, store.NoLimit, nil)
 	if err != nil {
 		r.Error = err
 		return r, err
@@ -119,6 +149,7 @@ func (s *PoolStatus) getStatus() (*StatusResponse, error) {
 	for _, n := range nodes {
 		r.ActiveHosts = append(r.ActiveHosts, nodeHost(n))
 	}
+	r.Kinds = hostKinds(r.ActiveHosts)
 
 	return r, nil
 }
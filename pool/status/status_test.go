@@ -30,6 +30,7 @@ func compareJSON(t *testing.T, got, want interface{}) {
 }
 
 func TestPoolStatus(t *testing.T) {
+	ctx := context.Background()
 	now := time.Now()
 	s := PoolStatus{
 		Store:         store.MemoryStore(),
@@ -49,13 +50,14 @@ func TestPoolStatus(t *testing.T) {
 		Version:     "foo",
 		Stats:       &store.Stats{},
 		ActiveHosts: []Host{},
+		Kinds:       []HostKind{},
 		Error:       nil,
 	}
 
 	compareJSON(t, r, expected)
 
 	hostNode := store.Node{ID: "12345678901234567890", IsHost: true, Kind: "geth", LastSeen: now}
-	if err := s.Store.SetNode(hostNode); err != nil {
+	if err := s.Store.SetNode(ctx, hostNode); err != nil {
 		t.Fatal(err)
 	}
 
@@ -90,8 +92,40 @@ func TestPoolStatus(t *testing.T) {
 				Kind:     "geth",
 			},
 		},
+		Kinds: []HostKind{
+			{Kind: "geth", NumHosts: 1},
+		},
 		Error: nil,
 	}
 
 	compareJSON(t, r, expected)
 }
+
+func TestPoolStatusKinds(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := PoolStatus{
+		Store: store.MemoryStore(),
+	}
+	nodes := []store.Node{
+		{ID: "a", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "b", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "c", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "d", IsHost: true, Kind: "geth", LastSeen: now.Add(-store.ExpireInterval * 2)},
+		{ID: "e", IsHost: false, Kind: "parity", LastSeen: now},
+	}
+	for _, n := range nodes {
+		if err := s.Store.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := s.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareJSON(t, r.Kinds, []HostKind{
+		{Kind: "geth", NumHosts: 2},
+		{Kind: "parity", NumHosts: 1},
+	})
+}
@@ -0,0 +1,16 @@
+package store
+
+import (
+	"context"
+	"math/big"
+)
+
+// AccountScanStore is implemented by stores that can find the accounts with
+// large balances, such as for telling hosts that they have earnings to
+// withdraw.
+type AccountScanStore interface {
+	// AccountsAbove returns the accounts whose balance credit is greater
+	// than threshold, sorted. Trial balances of nodes without an account
+	// are not included.
+	AccountsAbove(ctx context.Context, threshold *big.Int) ([]Account, error)
+}
@@ -0,0 +1,100 @@
+package badger
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// hostClients is the set of client node IDs that are assigned to a host,
+// stored under vip:clients:<hostID>. The host's Clients is its size.
+type hostClients map[store.NodeID]bool
+
+func hostClientsKey(hostID store.NodeID) []byte {
+	return []byte(fmt.Sprintf("vip:clients:%s", hostID))
+}
+
+// updateHostClients changes whether the client is assigned to the host, and
+// saves the host's Clients to match. It returns false if the host is not
+// registered or the assignment didn't change.
+func updateHostClients(txn *badger.Txn, hostID store.NodeID, clientID store.NodeID, assigned bool) (bool, error) {
+	hostKey := []byte(fmt.Sprintf("vip:node:%s", hostID))
+	var host store.Node
+	if err := getItem(txn, hostKey, &host); err == badger.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	key := hostClientsKey(hostID)
+	clients := hostClients{}
+	if err := getItem(txn, key, &clients); err != nil && err != badger.ErrKeyNotFound {
+		return false, err
+	}
+	if clients[clientID] == assigned {
+		return false, nil
+	}
+	if assigned {
+		clients[clientID] = true
+	} else {
+		delete(clients, clientID)
+	}
+	host.Clients = len(clients)
+	if err := setItem(txn, key, &clients); err != nil {
+		return false, err
+	}
+	return true, setItem(txn, hostKey, &host)
+}
+
+// assignHosts replaces the client's assigned hosts with hostIDs, and saves
+// the client.
+func assignHosts(txn *badger.Txn, client store.Node, hostIDs []store.NodeID) error {
+	for _, hostID := range client.AssignedHosts {
+		if _, err := updateHostClients(txn, hostID, client.ID, false); err != nil {
+			return err
+		}
+	}
+	client.AssignedHosts = nil
+	for _, hostID := range hostIDs {
+		ok, err := updateHostClients(txn, hostID, client.ID, true)
+		if err != nil {
+			return err
+		}
+		if ok {
+			client.AssignedHosts = append(client.AssignedHosts, hostID)
+		}
+	}
+	return setItem(txn, []byte(fmt.Sprintf("vip:node:%s", client.ID)), &client)
+}
+
+// unassignHost removes the host from the AssignedHosts of each of its
+// clients, and deletes its set of clients.
+func unassignHost(txn *badger.Txn, hostID store.NodeID) error {
+	key := hostClientsKey(hostID)
+	clients := hostClients{}
+	if err := getItem(txn, key, &clients); err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for clientID := range clients {
+		clientKey := []byte(fmt.Sprintf("vip:node:%s", clientID))
+		var client store.Node
+		if err := getItem(txn, clientKey, &client); err == badger.ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var assigned []store.NodeID
+		for _, id := range client.AssignedHosts {
+			if id != hostID {
+				assigned = append(assigned, id)
+			}
+		}
+		client.AssignedHosts = assigned
+		if err := setItem(txn, clientKey, &client); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(key)
+}
@@ -1,14 +1,13 @@
 package badger
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"math/big"
-	"math/rand"
 	"time"
 
 	"github.com/dgraph-io/badger"
+	"github.com/vipnode/vipnode/pool/clock"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
@@ -31,27 +30,45 @@ func Open(opts badger.Options) (*badgerStore, error) {
 	s := &badgerStore{
 		db:          db,
 		nonceExpire: store.ExpireNonce,
+		Clock:       clock.Real(),
 	}
 
 	return s, nil
 }
 
 var _ store.Store = &badgerStore{}
+var _ store.HostSlotStore = &badgerStore{}
+var _ store.SigningKeyStore = &badgerStore{}
+
+// maxConflictRetries is how many times a transaction is retried when it
+// conflicts with a concurrent transaction.
+const maxConflictRetries = 10
 
 type badgerStore struct {
 	db *badger.DB
 
 	nonceExpire time.Duration
+
+	// Clock is used for nonce expiry and node activity. It should not be
+	// changed after the store is in use.
+	Clock clock.Clock
 }
 
 func (s *badgerStore) Close() error {
 	return s.db.Close()
 }
 
-func (s *badgerStore) CheckAndSaveNonce(ID string, nonce int64) error {
+func (s *badgerStore) CheckAndSaveNonce(ctx context.Context, ID string, nonce int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := s.Clock.Now()
+	if nonce > now.Add(store.MaxNonceAhead).UnixNano() {
+		return store.ErrNonceOutOfRange
+	}
 	// If nonceExpire is set, nonce should be within nonceExpire of now.
 	if s.nonceExpire > 0 {
-		if nonce <= time.Now().Add(-s.nonceExpire).UnixNano() {
+		if nonce <= now.Add(-s.nonceExpire).UnixNano() {
 			// Nonce is too old
 			return store.ErrInvalidNonce
 		}
@@ -74,8 +91,46 @@ func (s *badgerStore) CheckAndSaveNonce(ID string, nonce int64) error {
 	})
 }
 
+// PurgeNonces removes saved nonces that are older than the cutoff. Nonces
+// also expire on their own if nonceExpire is set.
+func (s *badgerStore) PurgeNonces(ctx context.Context, olderThan time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	cutoff := olderThan.UnixNano()
+	n := 0
+	err := s.db.Update(func(txn *badger.Txn) error {
+		var keys [][]byte
+		var nonce int64
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := []byte("vip:nonce:")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if err := getItem(txn, item.Key(), &nonce); err != nil {
+				it.Close()
+				return err
+			}
+			if nonce < cutoff {
+				keys = append(keys, item.KeyCopy(nil))
+			}
+		}
+		it.Close()
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // GetNodeBalance returns the current account balance for a node.
-func (s *badgerStore) GetNodeBalance(nodeID store.NodeID) (store.Balance, error) {
+func (s *badgerStore) GetNodeBalance(ctx context.Context, nodeID store.NodeID) (store.Balance, error) {
 	accountKey := []byte(fmt.Sprintf("vip:account:%s", nodeID))
 	var account store.Account
 	var r store.Balance
@@ -107,36 +162,88 @@ func (s *badgerStore) GetNodeBalance(nodeID store.NodeID) (store.Balance, error)
 // If only a node is provided which doesn't have an account registered to
 // it, it should retain a balance, such as through temporary trial accounts
 // that get migrated later.
-func (s *badgerStore) AddNodeBalance(nodeID store.NodeID, credit *big.Int) error {
-	accountKey := []byte(fmt.Sprintf("vip:account:%s", nodeID))
+func (s *badgerStore) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		var account store.Account
-		balanceKey := []byte(fmt.Sprintf("vip:trial:%s", nodeID))
-		if err := getItem(txn, accountKey, &account); err == badger.ErrKeyNotFound {
-			// No spendable account, use the trial account
-		} else if err == nil {
-			balanceKey = []byte(fmt.Sprintf("vip:balance:%s", account))
-		} else {
-			return err
+		return addNodeBalance(txn, nodeID, credit)
+	})
+}
+
+// AddNodeBalances adds each credit to its node's balance in a single
+// transaction. If any of the credits fails, then none of them are added.
+func (s *badgerStore) AddNodeBalances(ctx context.Context, credits map[store.NodeID]*big.Int) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		for nodeID, credit := range credits {
+			if err := addNodeBalance(txn, nodeID, credit); err != nil {
+				return err
+			}
 		}
-		var balance store.Balance
-		if err := getItem(txn, balanceKey, &balance); err == badger.ErrKeyNotFound {
-			nodeKey := []byte(fmt.Sprintf("vip:node:%s", nodeID))
-			if !hasKey(txn, nodeKey) {
+		return nil
+	})
+}
+
+func addNodeBalance(txn *badger.Txn, nodeID store.NodeID, credit *big.Int) error {
+	accountKey := []byte(fmt.Sprintf("vip:account:%s", nodeID))
+	var account store.Account
+	balanceKey := []byte(fmt.Sprintf("vip:trial:%s", nodeID))
+	if err := getItem(txn, accountKey, &account); err == badger.ErrKeyNotFound {
+		// No spendable account, use the trial account
+	} else if err == nil {
+		balanceKey = []byte(fmt.Sprintf("vip:balance:%s", account))
+	} else {
+		return err
+	}
+	var balance store.Balance
+	if err := getItem(txn, balanceKey, &balance); err == badger.ErrKeyNotFound {
+		nodeKey := []byte(fmt.Sprintf("vip:node:%s", nodeID))
+		if !hasKey(txn, nodeKey) {
+			return store.ErrUnregisteredNode
+		}
+		// No balance = empty balance
+	} else if err != nil {
+		return err
+	}
+	balance.Credit.Add(&balance.Credit, credit)
+
+	return setItem(txn, balanceKey, &balance)
+}
+
+// GrantTrialCredit adds credit to the trial balance of a node without an
+// account, unless it was granted before.
+func (s *badgerStore) GrantTrialCredit(ctx context.Context, nodeID store.NodeID, credit *big.Int) (bool, error) {
+	accountKey := []byte(fmt.Sprintf("vip:account:%s", nodeID))
+	balanceKey := []byte(fmt.Sprintf("vip:trial:%s", nodeID))
+	var granted bool
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			granted = false
+			if !hasKey(txn, []byte(fmt.Sprintf("vip:node:%s", nodeID))) {
 				return store.ErrUnregisteredNode
 			}
-			// No balance = empty balance
-		} else if err != nil {
-			return err
+			if hasKey(txn, accountKey) {
+				return nil
+			}
+			var balance store.Balance
+			if err := getItem(txn, balanceKey, &balance); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			if balance.TrialGranted {
+				return nil
+			}
+			balance.Credit.Add(&balance.Credit, credit)
+			balance.TrialGranted = true
+			granted = true
+			return setItem(txn, balanceKey, &balance)
+		})
+		if err != badger.ErrConflict {
+			break
 		}
-		balance.Credit.Add(&balance.Credit, credit)
-
-		return setItem(txn, balanceKey, &balance)
-	})
+	}
+	return granted && err == nil, err
 }
 
 // GetAccountBalance returns an account's balance.
-func (s *badgerStore) GetAccountBalance(account store.Account) (store.Balance, error) {
+func (s *badgerStore) GetAccountBalance(ctx context.Context, account store.Account) (store.Balance, error) {
 	balanceKey := []byte(fmt.Sprintf("vip:balance:%s", account))
 	var r store.Balance
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -150,7 +257,7 @@ func (s *badgerStore) GetAccountBalance(account store.Account) (store.Balance, e
 }
 
 // AddNodeBalance adds credit to an account balance. (Can be negative)
-func (s *badgerStore) AddAccountBalance(account store.Account, credit *big.Int) error {
+func (s *badgerStore) AddAccountBalance(ctx context.Context, account store.Account, credit *big.Int) error {
 	return s.db.Update(func(txn *badger.Txn) error {
 		balanceKey := []byte(fmt.Sprintf("vip:balance:%s", account))
 		var balance store.Balance
@@ -166,10 +273,31 @@ func (s *badgerStore) AddAccountBalance(account store.Account, credit *big.Int)
 	})
 }
 
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold, sorted.
+func (s *badgerStore) AccountsAbove(ctx context.Context, threshold *big.Int) ([]store.Account, error) {
+	r := []store.Account{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		var b store.Balance
+		return loopKeyItem(txn, []byte("vip:balance:"), &b, func(key []byte) error {
+			if b.Credit.Cmp(threshold) > 0 {
+				r = append(r, store.Account(key))
+			}
+			b = store.Balance{}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Keys are iterated in order
+	return r, nil
+}
+
 // AddAccountNode authorizes a nodeID to be a spender of an account's
 // balance. This should migrate any existing node's balance credit to the
 // account.
-func (s *badgerStore) AddAccountNode(account store.Account, nodeID store.NodeID) error {
+func (s *badgerStore) AddAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
 	return s.db.Update(func(txn *badger.Txn) error {
 		// Check nodeID
 		nodeKey := []byte(fmt.Sprintf("vip:node:%s", nodeID))
@@ -212,7 +340,7 @@ func (s *badgerStore) AddAccountNode(account store.Account, nodeID store.NodeID)
 
 // IsAccountNode returns nil if node is a valid spender of the given
 // account.
-func (s *badgerStore) IsAccountNode(account store.Account, nodeID store.NodeID) error {
+func (s *badgerStore) IsAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
 	accountKey := []byte(fmt.Sprintf("vip:account:%s", nodeID))
 	var nodeAccount store.Account
 	return s.db.View(func(txn *badger.Txn) error {
@@ -230,7 +358,7 @@ func (s *badgerStore) IsAccountNode(account store.Account, nodeID store.NodeID)
 
 // GetSpenders returns the authorized nodeIDs for this account, these are
 // nodes that were added to accounts through AddAccountNode.
-func (s *badgerStore) GetAccountNodes(account store.Account) ([]store.NodeID, error) {
+func (s *badgerStore) GetAccountNodes(ctx context.Context, account store.Account) ([]store.NodeID, error) {
 	// FIXME: This could be more efficient if we had an account -> nodeID index
 	var r []store.NodeID
 	if err := s.db.View(func(txn *badger.Txn) error {
@@ -250,27 +378,33 @@ func (s *badgerStore) GetAccountNodes(account store.Account) ([]store.NodeID, er
 	return r, nil
 }
 
-// ActiveHosts loads all nodes, then return a valid shuffled subset of size limit.
-func (s *badgerStore) ActiveHosts(kind string, limit int) ([]store.Node, error) {
-	seenSince := time.Now().Add(-store.ExpireInterval)
+// ActiveHosts loads the indexed hosts of kind, then return a valid subset of
+// size limit chosen by the selector.
+func (s *badgerStore) ActiveHosts(ctx context.Context, kind string, limit int, selector store.HostSelector) ([]store.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := store.CheckHostQuery(kind, limit); err != nil {
+		return nil, err
+	}
+	seenSince := s.Clock.Now().Add(-store.ExpireInterval)
 	var r []store.Node
 	err := s.db.View(func(txn *badger.Txn) error {
-		prefix := []byte("vip:node:")
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		hostIDs, err := indexedHosts(txn, kind)
+		if err != nil {
+			return err
+		}
+		for _, hostID := range hostIDs {
 			var n store.Node
-			var err error
-			err = it.Item().Value(func(val []byte) error {
-				return gob.NewDecoder(bytes.NewReader(val)).Decode(&n)
-			})
-			if err != nil {
+			if err := getItem(txn, []byte(fmt.Sprintf("vip:node:%s", hostID)), &n); err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
 				return err
 			}
 			if !n.IsHost {
 				continue
 			}
-			if kind != "" && n.Kind != kind {
+			if kind != store.AnyKind && n.Kind != kind {
 				continue
 			}
 			if !n.LastSeen.After(seenSince) {
@@ -283,20 +417,13 @@ func (s *badgerStore) ActiveHosts(kind string, limit int) ([]store.Node, error)
 	if err != nil {
 		return nil, err
 	}
-	if limit <= 0 || len(r) < limit {
-		// Skip shuffle since it's a subset
-		return r, nil
-	}
-
-	// FIXME: Is there a different sorting method we want to use? Maybe sort by last seen?
-	rand.Shuffle(len(r), func(i, j int) {
-		r[i], r[j] = r[j], r[i]
-	})
-
-	return r[:limit], nil
+	return store.SelectHosts(ctx, selector, r, limit), nil
 }
 
-func (s *badgerStore) GetNode(nodeID store.NodeID) (*store.Node, error) {
+func (s *badgerStore) GetNode(ctx context.Context, nodeID store.NodeID) (*store.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	key := []byte(fmt.Sprintf("vip:node:%s", nodeID))
 	var r store.Node
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -310,17 +437,167 @@ func (s *badgerStore) GetNode(nodeID store.NodeID) (*store.Node, error) {
 	return &r, nil
 }
 
-func (s *badgerStore) SetNode(n store.Node) error {
+func (s *badgerStore) SetNode(ctx context.Context, n store.Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if n.ID == "" {
 		return store.ErrMalformedNode
 	}
 	key := []byte(fmt.Sprintf("vip:node:%s", n.ID))
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			var prev *store.Node
+			var prevNode store.Node
+			if err := getItem(txn, key, &prevNode); err == nil {
+				prev = &prevNode
+			} else if err != badger.ErrKeyNotFound {
+				return err
+			}
+			if err := reindexHost(txn, n, prev); err != nil {
+				return err
+			}
+			// Assignments are only changed by AssignHosts.
+			node := n
+			node.Clients, node.AssignedHosts = 0, nil
+			if prev != nil {
+				node.Clients, node.AssignedHosts = prev.Clients, prev.AssignedHosts
+			}
+			return setItem(txn, key, &node)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	return err
+}
+
+// AssignHosts replaces the client's assigned hosts, and updates the Clients
+// of the hosts that were added or removed. Conflicting concurrent changes
+// are retried.
+func (s *badgerStore) AssignHosts(ctx context.Context, clientID store.NodeID, hostIDs []store.NodeID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	clientKey := []byte(fmt.Sprintf("vip:node:%s", clientID))
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			var client store.Node
+			if err := getItem(txn, clientKey, &client); err == badger.ErrKeyNotFound {
+				return store.ErrUnregisteredNode
+			} else if err != nil {
+				return err
+			}
+			return assignHosts(txn, client, hostIDs)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	return err
+}
+
+// RemoveNode removes a node and its peers. Balances are retained.
+func (s *badgerStore) RemoveNode(ctx context.Context, nodeID store.NodeID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	nodeKey := []byte(fmt.Sprintf("vip:node:%s", nodeID))
 	return s.db.Update(func(txn *badger.Txn) error {
-		return setItem(txn, key, &n)
+		var node store.Node
+		if err := getItem(txn, nodeKey, &node); err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return removeNode(txn, node)
 	})
 }
 
-func (s *badgerStore) NodePeers(nodeID store.NodeID) ([]store.Node, error) {
+// ExpireNodes removes the nodes that haven't been seen since before.
+func (s *badgerStore) ExpireNodes(ctx context.Context, before time.Time) ([]store.NodeID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var expired []store.Node
+	err := s.db.Update(func(txn *badger.Txn) error {
+		expired = nil
+		var n store.Node
+		if err := loopItem(txn, []byte("vip:node:"), &n, func() error {
+			if n.LastSeen.Before(before) {
+				expired = append(expired, n)
+			}
+			n = store.Node{}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, node := range expired {
+			if err := removeNode(txn, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]store.NodeID, 0, len(expired))
+	for _, node := range expired {
+		removed = append(removed, node.ID)
+	}
+	return removed, nil
+}
+
+// removeNode deletes the node with its peers, claims, assignments and host
+// index entry.
+func removeNode(txn *badger.Txn, node store.Node) error {
+	if err := assignHosts(txn, node, nil); err != nil {
+		return err
+	}
+	if err := unassignHost(txn, node.ID); err != nil {
+		return err
+	}
+	if node.IsHost {
+		if err := unindexHost(txn, node.Kind, node.ID); err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete([]byte(fmt.Sprintf("vip:node:%s", node.ID))); err != nil {
+		return err
+	}
+	if err := txn.Delete([]byte(fmt.Sprintf("vip:claims:%s", node.ID))); err != nil {
+		return err
+	}
+	return txn.Delete([]byte(fmt.Sprintf("vip:peers:%s", node.ID)))
+}
+
+// AllNodes returns every node that the store knows about.
+func (s *badgerStore) AllNodes(ctx context.Context) ([]store.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var r []store.Node
+	err := s.db.View(func(txn *badger.Txn) error {
+		var n store.Node
+		return loopItem(txn, []byte("vip:node:"), &n, func() error {
+			r = append(r, n)
+			n = store.Node{}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *badgerStore) NodePeers(ctx context.Context, nodeID store.NodeID) ([]store.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	peersKey := []byte(fmt.Sprintf("vip:peers:%s", nodeID))
 	var r []store.Node
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -351,12 +628,17 @@ func (s *badgerStore) NodePeers(nodeID store.NodeID) ([]store.Node, error) {
 	return r, err
 }
 
-func (s *badgerStore) UpdateNodePeers(nodeID store.NodeID, peers []string, blockNumber uint64) (inactive []store.NodeID, err error) {
+func (s *badgerStore) UpdateNodePeers(ctx context.Context, nodeID store.NodeID, peers []string, blockNumber uint64) (inactive []store.NodeID, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	nodeKey := []byte(fmt.Sprintf("vip:node:%s", nodeID))
 	peersKey := []byte(fmt.Sprintf("vip:peers:%s", nodeID))
-	now := time.Now()
+	claimsKey := []byte(fmt.Sprintf("vip:claims:%s", nodeID))
+	now := s.Clock.Now()
 	var node store.Node
 	nodePeers := map[store.NodeID]time.Time{}
+	claims := map[store.NodeID]time.Time{}
 	err = s.db.Update(func(txn *badger.Txn) error {
 		// Update this node's LastSeen
 		if err := getItem(txn, nodeKey, &node); err == badger.ErrKeyNotFound {
@@ -367,8 +649,12 @@ func (s *badgerStore) UpdateNodePeers(nodeID store.NodeID, peers []string, block
 
 		node.LastSeen = now
 		node.BlockNumber = blockNumber
-		if err := setItem(txn, nodeKey, &node); err != nil {
-			return err
+		if node.IsHost {
+			// Keep the index consistent in case the host was registered
+			// before it existed.
+			if err := indexHost(txn, node.Kind, node.ID); err != nil {
+				return err
+			}
 		}
 
 		// Update peers
@@ -376,34 +662,142 @@ func (s *badgerStore) UpdateNodePeers(nodeID store.NodeID, peers []string, block
 			return err
 		}
 
+		if err := getItem(txn, claimsKey, &claims); err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		numClaims := len(claims)
+
 		numUpdated := 0
 		for _, peerID := range peers {
 			// Only update peers we already know about
 			if hasKey(txn, []byte(fmt.Sprintf("vip:node:%s", peerID))) {
 				nodePeers[store.NodeID(peerID)] = now
+				delete(claims, store.NodeID(peerID))
 				numUpdated += 1
 			}
 		}
-
-		if numUpdated == len(nodePeers) {
-			return setItem(txn, peersKey, &nodePeers)
+		if len(claims) != numClaims {
+			if err := setItem(txn, claimsKey, &claims); err != nil {
+				return err
+			}
 		}
 
-		inactiveDeadline := now.Add(-store.ExpireInterval)
-		for nodeID, timestamp := range nodePeers {
-			if timestamp.Before(inactiveDeadline) {
-				continue
+		if numUpdated != len(nodePeers) {
+			inactiveDeadline := now.Add(-store.ExpireInterval)
+			for nodeID, timestamp := range nodePeers {
+				if !timestamp.Before(inactiveDeadline) {
+					// Still active
+					continue
+				}
+				delete(nodePeers, nodeID)
+				inactive = append(inactive, nodeID)
 			}
-			delete(nodePeers, nodeID)
-			inactive = append(inactive, nodeID)
+		}
+
+		node.PeerCount = len(nodePeers)
+		if err := setItem(txn, nodeKey, &node); err != nil {
+			return err
 		}
 		return setItem(txn, peersKey, &nodePeers)
 	})
 	return
 }
 
+// ClaimHostSlot reserves one of the host's slots for the client, if the host
+// has capacity remaining. Conflicting concurrent claims are retried, so that
+// they see each other's claims.
+func (s *badgerStore) ClaimHostSlot(hostID store.NodeID, clientID store.NodeID) (ok bool, err error) {
+	nodeKey := []byte(fmt.Sprintf("vip:node:%s", hostID))
+	peersKey := []byte(fmt.Sprintf("vip:peers:%s", hostID))
+	claimsKey := []byte(fmt.Sprintf("vip:claims:%s", hostID))
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			var host store.Node
+			if err := getItem(txn, nodeKey, &host); err == badger.ErrKeyNotFound {
+				return store.ErrUnregisteredNode
+			} else if err != nil {
+				return err
+			}
+			hostPeers := peers{}
+			if err := getItem(txn, peersKey, &hostPeers); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			if _, isPeer := hostPeers[clientID]; isPeer {
+				ok = true
+				return nil
+			}
+			claims := peers{}
+			if err := getItem(txn, claimsKey, &claims); err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+			ok = store.ClaimSlot(host, claims, clientID, s.Clock.Now())
+			if !ok {
+				return nil
+			}
+			return setItem(txn, claimsKey, &claims)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// ReleaseHostSlot releases the client's claim on the host.
+func (s *badgerStore) ReleaseHostSlot(hostID store.NodeID, clientID store.NodeID) (err error) {
+	claimsKey := []byte(fmt.Sprintf("vip:claims:%s", hostID))
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(func(txn *badger.Txn) error {
+			claims := peers{}
+			if err := getItem(txn, claimsKey, &claims); err == badger.ErrKeyNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if _, ok := claims[clientID]; !ok {
+				return nil
+			}
+			delete(claims, clientID)
+			return setItem(txn, claimsKey, &claims)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+	return err
+}
+
+// SetSigningKey binds the signing key to the node.
+func (s *badgerStore) SetSigningKey(ctx context.Context, nodeID store.NodeID, signerID store.NodeID) error {
+	key := []byte(fmt.Sprintf("vip:signer:%s", nodeID))
+	return s.db.Update(func(txn *badger.Txn) error {
+		if signerID.IsZero() {
+			return txn.Delete(key)
+		}
+		return setItem(txn, key, &signerID)
+	})
+}
+
+// GetSigningKey returns the signing key that is bound to the node.
+func (s *badgerStore) GetSigningKey(ctx context.Context, nodeID store.NodeID) (signerID store.NodeID, err error) {
+	key := []byte(fmt.Sprintf("vip:signer:%s", nodeID))
+	err = s.db.View(func(txn *badger.Txn) error {
+		if err := getItem(txn, key, &signerID); err != badger.ErrKeyNotFound {
+			return err
+		}
+		return nil
+	})
+	return signerID, err
+}
+
 // Stats returns aggregate statistics about the store state.
-func (s *badgerStore) Stats() (*store.Stats, error) {
+func (s *badgerStore) Stats(ctx context.Context) (*store.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	stats := store.Stats{}
 
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -1,14 +1,18 @@
 package badger
 
 import (
+	"context"
 	"io/ioutil"
 	"math/big"
 	"os"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/dgraph-io/badger/options"
+	"github.com/vipnode/vipnode/pool/clock"
 	"github.com/vipnode/vipnode/pool/store"
 )
 
@@ -125,3 +129,228 @@ func TestBadgerStore(t *testing.T) {
 		})
 	})
 }
+
+func TestBadgerActiveHosts(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	nodes := []store.Node{
+		{ID: "geth1", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "geth2", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "geth3", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "parity1", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "stale", IsHost: true, Kind: "geth", LastSeen: now.Add(-store.ExpireInterval * 2)},
+		{ID: "client1", IsHost: false, Kind: "geth", LastSeen: now},
+		{ID: "client2", IsHost: false, Kind: "parity", LastSeen: now},
+	}
+	for _, n := range nodes {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activeIDs := func(kind string, limit int) []string {
+		t.Helper()
+		hosts, err := s.ActiveHosts(ctx, kind, limit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := []string{}
+		for _, h := range hosts {
+			r = append(r, string(h.ID))
+		}
+		sort.Strings(r)
+		return r
+	}
+
+	if got, want := activeIDs(store.AnyKind, store.NoLimit), []string{"geth1", "geth2", "geth3", "parity1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all kinds: got %q; want %q", got, want)
+	}
+	if got, want := activeIDs("geth", store.NoLimit), []string{"geth1", "geth2", "geth3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("geth: got %q; want %q", got, want)
+	}
+	if got, want := activeIDs("parity", store.NoLimit), []string{"parity1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parity: got %q; want %q", got, want)
+	}
+	if got := activeIDs("geth", 2); len(got) != 2 {
+		t.Errorf("limit: got %d hosts; want 2", len(got))
+	}
+	if got := activeIDs("unknown", store.NoLimit); len(got) != 0 {
+		t.Errorf("unknown kind: got %q", got)
+	}
+
+	// Changing a host's kind or role moves it out of the old index.
+	if err := s.SetNode(ctx, store.Node{ID: "geth3", IsHost: true, Kind: "parity", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetNode(ctx, store.Node{ID: "geth2", IsHost: false, Kind: "geth", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveNode(ctx, "parity1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := activeIDs("geth", store.NoLimit), []string{"geth1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("geth after reindex: got %q; want %q", got, want)
+	}
+	if got, want := activeIDs("parity", store.NoLimit), []string{"geth3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parity after reindex: got %q; want %q", got, want)
+	}
+}
+
+func TestBadgerTrialMigration(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	node := store.Node{ID: "client1", Kind: "geth", LastSeen: time.Now()}
+	if err := s.SetNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	account := store.Account("0xabc")
+	if err := s.AddAccountBalance(ctx, account, big.NewInt(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Credit accrues to the trial balance until an account is added
+	if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(42)); err != nil {
+		t.Fatal(err)
+	}
+	trialKey := []byte("vip:trial:client1")
+	hasTrial := func() bool {
+		var ok bool
+		s.db.View(func(txn *badger.Txn) error {
+			ok = hasKey(txn, trialKey)
+			return nil
+		})
+		return ok
+	}
+	if !hasTrial() {
+		t.Fatal("missing trial balance")
+	}
+
+	checkBalance := func(want int64) {
+		t.Helper()
+		b, err := s.GetNodeBalance(ctx, node.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Account != account || b.Credit.Int64() != want {
+			t.Errorf("node balance: got %s; want %d credit for %q", &b, want, account)
+		}
+		if b, err := s.GetAccountBalance(ctx, account); err != nil {
+			t.Fatal(err)
+		} else if b.Credit.Int64() != want {
+			t.Errorf("account balance: got %d; want %d", &b.Credit, want)
+		}
+	}
+
+	// Migrate the trial balance into the account
+	if err := s.AddAccountNode(ctx, account, node.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(142)
+	if hasTrial() {
+		t.Error("trial balance was not removed after migration")
+	}
+
+	// Already migrated, so adding the account again is a no-op
+	if err := s.AddAccountNode(ctx, account, node.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(142)
+
+	// New credit goes straight to the account
+	if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(8)); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(150)
+	if hasTrial() {
+		t.Error("trial balance was created after migration")
+	}
+}
+
+func TestBadgerNonceReplay(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	nonce := time.Now().UnixNano()
+	if err := s.CheckAndSaveNonce(ctx, "abc", nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckAndSaveNonce(ctx, "abc", nonce); err != store.ErrInvalidNonce {
+		t.Errorf("replayed nonce: got %v; want ErrInvalidNonce", err)
+	}
+	// Other nodes have their own nonces
+	if err := s.CheckAndSaveNonce(ctx, "def", nonce); err != nil {
+		t.Errorf("nonce for another node: %s", err)
+	}
+}
+
+func TestBadgerCanceledContext(t *testing.T) {
+	s, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.SetNode(ctx, store.Node{ID: "abc"}); err != context.Canceled {
+		t.Errorf("expected canceled error, got: %v", err)
+	}
+	if _, err := s.GetNode(context.Background(), "abc"); err != store.ErrUnregisteredNode {
+		t.Errorf("node was saved despite canceled context: %v", err)
+	}
+}
+
+func TestBadgerInactivePeers(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fakeClock := clock.NewFake(time.Now())
+	s.Clock = fakeClock
+
+	for _, n := range []store.Node{
+		{ID: "a", IsHost: true, LastSeen: fakeClock.Now()},
+		{ID: "b", LastSeen: fakeClock.Now()},
+		{ID: "c", LastSeen: fakeClock.Now()},
+	} {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.UpdateNodePeers(ctx, "a", []string{"b", "c"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeClock.Add(store.ExpireInterval / 2)
+	if inactive, err := s.UpdateNodePeers(ctx, "a", []string{"b"}, 0); err != nil {
+		t.Fatal(err)
+	} else if len(inactive) != 0 {
+		t.Errorf("expected no inactive peers, got: %v", inactive)
+	}
+
+	fakeClock.Add(store.ExpireInterval/2 + time.Second)
+	inactive, err := s.UpdateNodePeers(ctx, "a", []string{"b"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inactive) != 1 || inactive[0] != "c" {
+		t.Errorf("wrong inactive peers: got %v; want [c]", inactive)
+	}
+}
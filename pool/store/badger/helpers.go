@@ -40,15 +40,24 @@ func setExpiringItem(txn *badger.Txn, key []byte, val interface{}, expire time.D
 }
 
 func loopItem(txn *badger.Txn, prefix []byte, into interface{}, callback func() error) error {
+	return loopKeyItem(txn, prefix, into, func([]byte) error {
+		return callback()
+	})
+}
+
+// loopKeyItem is like loopItem, but also passes each item's key without the
+// prefix to the callback.
+func loopKeyItem(txn *badger.Txn, prefix []byte, into interface{}, callback func(key []byte) error) error {
 	it := txn.NewIterator(badger.DefaultIteratorOptions)
 	defer it.Close()
 	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		if err := it.Item().Value(func(val []byte) error {
+		item := it.Item()
+		if err := item.Value(func(val []byte) error {
 			return gob.NewDecoder(bytes.NewReader(val)).Decode(into)
 		}); err != nil {
 			return err
 		}
-		if err := callback(); err != nil {
+		if err := callback(item.Key()[len(prefix):]); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,91 @@
+package badger
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// hostIndex is the set of host node IDs for a kind, stored under
+// vip:hosts:<kind> so that ActiveHosts does not need to scan every node.
+type hostIndex map[store.NodeID]bool
+
+func hostIndexKey(kind string) []byte {
+	return []byte(fmt.Sprintf("vip:hosts:%s", kind))
+}
+
+// indexHost adds nodeID to the kind's host index.
+func indexHost(txn *badger.Txn, kind string, nodeID store.NodeID) error {
+	key := hostIndexKey(kind)
+	index := hostIndex{}
+	if err := getItem(txn, key, &index); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if index[nodeID] {
+		return nil
+	}
+	index[nodeID] = true
+	return setItem(txn, key, &index)
+}
+
+// unindexHost removes nodeID from the kind's host index.
+func unindexHost(txn *badger.Txn, kind string, nodeID store.NodeID) error {
+	key := hostIndexKey(kind)
+	index := hostIndex{}
+	if err := getItem(txn, key, &index); err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !index[nodeID] {
+		return nil
+	}
+	delete(index, nodeID)
+	if len(index) == 0 {
+		return txn.Delete(key)
+	}
+	return setItem(txn, key, &index)
+}
+
+// reindexHost updates the host indexes for a node that is replacing prev,
+// which can be nil if the node is new.
+func reindexHost(txn *badger.Txn, n store.Node, prev *store.Node) error {
+	if prev != nil && prev.IsHost && (!n.IsHost || prev.Kind != n.Kind) {
+		if err := unindexHost(txn, prev.Kind, prev.ID); err != nil {
+			return err
+		}
+	}
+	if !n.IsHost {
+		return nil
+	}
+	return indexHost(txn, n.Kind, n.ID)
+}
+
+// indexedHosts returns the IDs of hosts indexed for kind. If kind is AnyKind,
+// then hosts of every kind are returned.
+func indexedHosts(txn *badger.Txn, kind string) ([]store.NodeID, error) {
+	var r []store.NodeID
+	if kind != store.AnyKind {
+		index := hostIndex{}
+		if err := getItem(txn, hostIndexKey(kind), &index); err == badger.ErrKeyNotFound {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for nodeID := range index {
+			r = append(r, nodeID)
+		}
+		return r, nil
+	}
+
+	var index hostIndex
+	err := loopItem(txn, hostIndexKey(""), &index, func() error {
+		for nodeID := range index {
+			r = append(r, nodeID)
+		}
+		index = nil
+		return nil
+	})
+	return r, err
+}
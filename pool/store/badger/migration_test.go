@@ -4,15 +4,16 @@ import (
 	"testing"
 
 	"github.com/dgraph-io/badger"
+	"github.com/vipnode/vipnode/pool/store"
 )
 
 func TestMigration(t *testing.T) {
-	store, err := OpenTemp()
+	s, err := OpenTemp()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer store.Close()
-	db := store.db
+	defer s.Close()
+	db := s.db
 
 	if err = db.View(func(txn *badger.Txn) error {
 		return checkVersion(txn, dbVersion)
@@ -39,7 +40,7 @@ func TestMigration(t *testing.T) {
 	}
 
 	if err = db.View(func(txn *badger.Txn) error {
-		if err := checkVersion(txn, 2); err != nil {
+		if err := checkVersion(txn, dbVersion); err != nil {
 			t.Error(err)
 		}
 		if hasKey(txn, testNonceKey) {
@@ -49,4 +50,34 @@ func TestMigration(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
+
+	// Confirm that migration indexes existing hosts
+	if err = db.Update(func(txn *badger.Txn) error {
+		if err := setVersion(txn, 2); err != nil {
+			return err
+		}
+		if err := setItem(txn, []byte("vip:node:host1"), &store.Node{ID: "host1", IsHost: true, Kind: "geth"}); err != nil {
+			return err
+		}
+		return setItem(txn, []byte("vip:node:client1"), &store.Node{ID: "client1", Kind: "geth"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateLatest(db, "testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.View(func(txn *badger.Txn) error {
+		hosts, err := indexedHosts(txn, "geth")
+		if err != nil {
+			return err
+		}
+		if len(hosts) != 1 || hosts[0] != "host1" {
+			t.Errorf("wrong indexed hosts after migration: %q", hosts)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
 }
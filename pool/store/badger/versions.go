@@ -2,9 +2,10 @@ package badger
 
 import (
 	"github.com/dgraph-io/badger"
+	"github.com/vipnode/vipnode/pool/store"
 )
 
-const dbVersion = 2
+const dbVersion = 3
 
 var migrations = [dbVersion]MigrationStep{
 	// Version 0 -> 1
@@ -31,4 +32,26 @@ var migrations = [dbVersion]MigrationStep{
 
 		return setVersion(txn, 2)
 	},
+
+	// Version 2 -> 3 (added vip:hosts:<kind> index of host nodes)
+	func(txn *badger.Txn) error {
+		if err := checkVersion(txn, 2); err != nil {
+			return err
+		}
+
+		var node store.Node
+		if err := loopItem(txn, []byte("vip:node:"), &node, func() error {
+			// Reset so that zero-value fields are not carried over by gob.
+			n := node
+			node = store.Node{}
+			if !n.IsHost {
+				return nil
+			}
+			return indexHost(txn, n.Kind, n.ID)
+		}); err != nil {
+			return err
+		}
+
+		return setVersion(txn, 3)
+	},
 }
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"math/big"
+)
+
+// NodeCredit is an amount to add to a node's balance.
+type NodeCredit struct {
+	NodeID NodeID
+	Credit *big.Int
+}
+
+// BatchBalanceStore is implemented by stores that can add many node balances
+// at once, such as in a single transaction.
+type BatchBalanceStore interface {
+	// AddNodeBalances adds each credit to its node's balance, like
+	// AddNodeBalance. Either all of the credits are added, or none of them
+	// are and an error is returned.
+	AddNodeBalances(ctx context.Context, credits map[NodeID]*big.Int) error
+}
+
+// AddNodeBalances adds each credit to its node's balance. A node that fails
+// to be credited doesn't stop the rest. The result for each credit is
+// returned in order, nil if it was added, along with a BatchError listing the
+// failures if there were any.
+//
+// If s implements BatchBalanceStore, then the credits are added in a single
+// batch, and only if the batch fails are they retried one by one to find the
+// failures.
+func AddNodeBalances(ctx context.Context, s BalanceStore, credits []NodeCredit) ([]error, error) {
+	results := make([]error, len(credits))
+	if batcher, ok := s.(BatchBalanceStore); ok && len(credits) > 0 {
+		batch := make(map[NodeID]*big.Int, len(credits))
+		for _, c := range credits {
+			if sum, ok := batch[c.NodeID]; ok {
+				batch[c.NodeID] = new(big.Int).Add(sum, c.Credit)
+			} else {
+				batch[c.NodeID] = c.Credit
+			}
+		}
+		if err := batcher.AddNodeBalances(ctx, batch); err == nil {
+			return results, nil
+		}
+	}
+	var failed []ItemError
+	for i, c := range credits {
+		if err := s.AddNodeBalance(ctx, c.NodeID, c.Credit); err != nil {
+			results[i] = err
+			failed = append(failed, ItemError{ID: string(c.NodeID), Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return results, BatchError{Op: "add node balances", Total: len(credits), Failed: failed}
+	}
+	return results, nil
+}
@@ -1,15 +1,61 @@
 package store
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // ErrInvalidNonce is returned when a signed request contains an invalid nonce.d
 var ErrInvalidNonce = errors.New("invalid nonce")
 
+// ErrNonceOutOfRange is returned when a signed request contains a nonce that
+// is more than MaxNonceAhead ahead of the store's clock.
+var ErrNonceOutOfRange = errors.New("nonce out of range")
+
 // ErrUnregisteredNode is returned when an update is received for an unregistered node.
 var ErrUnregisteredNode = errors.New("unregistered node")
 
 // ErrMalformedNode is returned when the Node struct is incomplete or field values are invalid.
 var ErrMalformedNode = errors.New("malformed node")
 
+// ErrEmptyKind is returned when hosts are queried with an empty kind. Use
+// AnyKind to match hosts of any kind.
+var ErrEmptyKind = errors.New("empty host kind, use AnyKind to match any kind")
+
+// ErrInvalidLimit is returned when hosts are queried with a limit that is
+// not positive. Use NoLimit to return all matching hosts.
+var ErrInvalidLimit = errors.New("invalid host limit, use NoLimit to return all hosts")
+
 // ErrNotAuthorized is returned when a node is not an authorized spender of an account's balance.
 var ErrNotAuthorized = errors.New("node is not an authorized spender")
+
+// ItemError is the failure of a single item in a batch operation.
+type ItemError struct {
+	ID  string
+	Err error
+}
+
+func (err ItemError) Error() string {
+	return fmt.Sprintf("%s: %s", err.ID, err.Err)
+}
+
+// BatchError is returned by a batch operation when some of its items failed.
+// The other items succeeded.
+type BatchError struct {
+	Op     string
+	Total  int
+	Failed []ItemError
+}
+
+func (err BatchError) Error() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s: %d of %d items failed: ", err.Op, len(err.Failed), err.Total)
+	for i, e := range err.Failed {
+		s.WriteString(e.Error())
+		if i != len(err.Failed)-1 {
+			s.WriteString("; ")
+		}
+	}
+	return s.String()
+}
@@ -1,9 +1,13 @@
 package store
 
 import (
+	"context"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/vipnode/vipnode/pool/clock"
 )
 
 // MemoryStore implements an ephemeral in-memory store. It may not be a
@@ -12,20 +16,27 @@ func MemoryStore() *memoryStore {
 	return &memoryStore{
 		balances: map[Account]Balance{},
 		nodes:    map[NodeID]memNode{},
+		hosts:    map[string]map[NodeID]struct{}{},
 		accounts: map[NodeID]Account{},
 		trials:   map[NodeID]Balance{},
 		nonces:   map[string]int64{},
+		signers:  map[NodeID]NodeID{},
+		Clock:    clock.Real(),
 	}
 }
 
 type memNode struct {
 	Node
 
-	peers map[NodeID]time.Time // Last seen (only for vipnode-registered peers)
+	peers   map[NodeID]time.Time // Last seen (only for vipnode-registered peers)
+	claims  map[NodeID]time.Time // Claimed host slots by client
+	clients map[NodeID]struct{}  // Assigned clients, counted by Clients
 }
 
 // Assert Store implementation
 var _ Store = &memoryStore{}
+var _ HostSlotStore = &memoryStore{}
+var _ SigningKeyStore = &memoryStore{}
 
 type memoryStore struct {
 	mu sync.Mutex
@@ -36,6 +47,10 @@ type memoryStore struct {
 	// Connected nodes
 	nodes map[NodeID]memNode
 
+	// Host node IDs indexed by kind, so that ActiveHosts doesn't need to
+	// scan every node.
+	hosts map[string]map[NodeID]struct{}
+
 	// Node to balance mapping
 	accounts map[NodeID]Account
 
@@ -43,11 +58,22 @@ type memoryStore struct {
 	trials map[NodeID]Balance
 
 	nonces map[string]int64
+
+	// Signing keys bound to nodes
+	signers map[NodeID]NodeID
+
+	// Clock is used for nonce expiry and node activity. It should not be
+	// changed after the store is in use.
+	Clock clock.Clock
 }
 
 // CheckAndSaveNonce asserts that this is the highest nonce seen for this NodeID.
-func (s *memoryStore) CheckAndSaveNonce(ID string, nonce int64) error {
-	if ExpireNonce > 0 && nonce <= time.Now().Add(-ExpireNonce).UnixNano() {
+func (s *memoryStore) CheckAndSaveNonce(ctx context.Context, ID string, nonce int64) error {
+	now := s.Clock.Now()
+	if nonce > now.Add(MaxNonceAhead).UnixNano() {
+		return ErrNonceOutOfRange
+	}
+	if ExpireNonce > 0 && nonce <= now.Add(-ExpireNonce).UnixNano() {
 		// Nonce is too old
 		return ErrInvalidNonce
 	}
@@ -61,8 +87,23 @@ func (s *memoryStore) CheckAndSaveNonce(ID string, nonce int64) error {
 	return nil
 }
 
+// PurgeNonces removes saved nonces that are older than the cutoff.
+func (s *memoryStore) PurgeNonces(ctx context.Context, olderThan time.Time) (int, error) {
+	cutoff := olderThan.UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for ID, nonce := range s.nonces {
+		if nonce < cutoff {
+			delete(s.nonces, ID)
+			n += 1
+		}
+	}
+	return n, nil
+}
+
 // GetNodeBalance returns the current account balance for a node.
-func (s *memoryStore) GetNodeBalance(nodeID NodeID) (Balance, error) {
+func (s *memoryStore) GetNodeBalance(ctx context.Context, nodeID NodeID) (Balance, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -73,9 +114,9 @@ func (s *memoryStore) GetNodeBalance(nodeID NodeID) (Balance, error) {
 
 	account, ok := s.accounts[nodeID]
 	if !ok {
-		return s.trials[nodeID], nil
+		return copyBalance(s.trials[nodeID]), nil
 	}
-	return s.balances[account], nil
+	return copyBalance(s.balances[account]), nil
 }
 
 // AddNodeBalance adds some credit amount to a node's account balance. (Can be negative)
@@ -85,7 +126,7 @@ func (s *memoryStore) GetNodeBalance(nodeID NodeID) (Balance, error) {
 //
 // This driver only supports mapping a nodeID to one account, so remapping it
 // will move the nodeID to the other account.
-func (s *memoryStore) AddNodeBalance(nodeID NodeID, credit *big.Int) error {
+func (s *memoryStore) AddNodeBalance(ctx context.Context, nodeID NodeID, credit *big.Int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -93,6 +134,30 @@ func (s *memoryStore) AddNodeBalance(nodeID NodeID, credit *big.Int) error {
 	if !ok {
 		return ErrUnregisteredNode
 	}
+	s.addNodeBalance(nodeID, credit)
+	return nil
+}
+
+// AddNodeBalances adds each credit to its node's balance at once. If any of
+// the nodes is unregistered, then none of the credits are added.
+func (s *memoryStore) AddNodeBalances(ctx context.Context, credits map[NodeID]*big.Int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for nodeID := range credits {
+		if _, ok := s.nodes[nodeID]; !ok {
+			return ErrUnregisteredNode
+		}
+	}
+	for nodeID, credit := range credits {
+		s.addNodeBalance(nodeID, credit)
+	}
+	return nil
+}
+
+// addNodeBalance adds credit to a registered node's balance, must hold the
+// s.mu lock.
+func (s *memoryStore) addNodeBalance(nodeID NodeID, credit *big.Int) {
 	account, ok := s.accounts[nodeID]
 	if ok {
 		balance := s.balances[account]
@@ -103,18 +168,49 @@ func (s *memoryStore) AddNodeBalance(nodeID NodeID, credit *big.Int) error {
 		balance.Credit.Add(&balance.Credit, credit)
 		s.trials[nodeID] = balance
 	}
-	return nil
+}
+
+// GrantTrialCredit adds credit to the trial balance of a node without an
+// account, unless it was granted before.
+func (s *memoryStore) GrantTrialCredit(ctx context.Context, nodeID NodeID, credit *big.Int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodes[nodeID]; !ok {
+		return false, ErrUnregisteredNode
+	}
+	if _, ok := s.accounts[nodeID]; ok {
+		return false, nil
+	}
+	balance := s.trials[nodeID]
+	if balance.TrialGranted {
+		return false, nil
+	}
+	balance.Credit.Add(&balance.Credit, credit)
+	balance.TrialGranted = true
+	s.trials[nodeID] = balance
+	return true, nil
 }
 
 // GetAccountBalance returns an account's balance.
-func (s *memoryStore) GetAccountBalance(account Account) (Balance, error) {
+func (s *memoryStore) GetAccountBalance(ctx context.Context, account Account) (Balance, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.balances[account], nil
+	return copyBalance(s.balances[account]), nil
+}
+
+// copyBalance returns a deep copy of the balance. Copying a big.Int by value
+// shares its underlying digits, which are modified in place when credit is
+// added, so balances must be deep copied before they are returned outside of
+// the lock.
+func copyBalance(b Balance) Balance {
+	b.Credit = *new(big.Int).Set(&b.Credit)
+	b.Deposit = *new(big.Int).Set(&b.Deposit)
+	return b
 }
 
 // AddNodeBalance adds credit to an account balance. (Can be negative)
-func (s *memoryStore) AddAccountBalance(account Account, credit *big.Int) error {
+func (s *memoryStore) AddAccountBalance(ctx context.Context, account Account, credit *big.Int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -124,10 +220,26 @@ func (s *memoryStore) AddAccountBalance(account Account, credit *big.Int) error
 	return nil
 }
 
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold, sorted.
+func (s *memoryStore) AccountsAbove(ctx context.Context, threshold *big.Int) ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := []Account{}
+	for account, balance := range s.balances {
+		if balance.Credit.Cmp(threshold) > 0 {
+			r = append(r, account)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return r, nil
+}
+
 // AddAccountNode authorizes a nodeID to be a spender of an account's
 // balance. This should migrate any existing node's balance credit to the
 // account.
-func (s *memoryStore) AddAccountNode(account Account, nodeID NodeID) error {
+func (s *memoryStore) AddAccountNode(ctx context.Context, account Account, nodeID NodeID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -149,7 +261,7 @@ func (s *memoryStore) AddAccountNode(account Account, nodeID NodeID) error {
 
 // AddAccountNode authorizes a nodeID to be a spender of an account's
 // balance.
-func (s *memoryStore) IsAccountNode(account Account, nodeID NodeID) error {
+func (s *memoryStore) IsAccountNode(ctx context.Context, account Account, nodeID NodeID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -162,7 +274,7 @@ func (s *memoryStore) IsAccountNode(account Account, nodeID NodeID) error {
 
 // GetSpenders returns the authorized nodeIDs for this account, these are
 // nodes that were added to accounts through AddAccountNode.
-func (s *memoryStore) GetAccountNodes(account Account) ([]NodeID, error) {
+func (s *memoryStore) GetAccountNodes(ctx context.Context, account Account) ([]NodeID, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -176,7 +288,7 @@ func (s *memoryStore) GetAccountNodes(account Account) ([]NodeID, error) {
 }
 
 // GetNode returns the node with the given ID.
-func (s *memoryStore) GetNode(id NodeID) (*Node, error) {
+func (s *memoryStore) GetNode(ctx context.Context, id NodeID) (*Node, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	node, ok := s.nodes[id]
@@ -187,63 +299,157 @@ func (s *memoryStore) GetNode(id NodeID) (*Node, error) {
 }
 
 // SetNode saves a node.
-func (s *memoryStore) SetNode(n Node) error {
+func (s *memoryStore) SetNode(ctx context.Context, n Node) error {
 	if n.ID.IsZero() {
 		return ErrMalformedNode
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	node := memNode{Node: n}
+	node.Clients, node.AssignedHosts = 0, nil
+	if old, ok := s.nodes[n.ID]; ok {
+		// Keep the known peers, like the other stores which save them
+		// separately from the node, and the assignments which are only
+		// changed by AssignHosts.
+		node.peers, node.claims, node.clients = old.peers, old.claims, old.clients
+		node.Clients, node.AssignedHosts = old.Clients, old.AssignedHosts
+		s.unindexHost(old.Node)
+	}
 	if node.peers == nil {
 		node.peers = map[NodeID]time.Time{}
 	}
 	s.nodes[n.ID] = node
+	s.indexHost(n)
 	return nil
 }
 
-// RemoveNode removes a HostNode.
-func (s *memoryStore) RemoveNode(nodeID NodeID) error {
+// RemoveNode removes a node and its peers. Balances are retained.
+func (s *memoryStore) RemoveNode(ctx context.Context, nodeID NodeID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.nodes, nodeID)
+	if old, ok := s.nodes[nodeID]; ok {
+		s.removeNode(old)
+	}
 	return nil
 }
 
-// ActiveHosts returns `limit`-number of `kind` nodes. This could be an
-// empty list, if none are available.
-func (s *memoryStore) ActiveHosts(kind string, limit int) ([]Node, error) {
-	seenSince := time.Now().Add(-ExpireInterval)
-	r := make([]Node, 0, limit)
-
+// ExpireNodes removes the nodes that haven't been seen since before.
+func (s *memoryStore) ExpireNodes(ctx context.Context, before time.Time) ([]NodeID, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// TODO: Do something other than random, such as by availability?
-	for _, n := range s.nodes {
-		// Ranging over a map is implicitly random, so
-		// results are shuffled as is desireable.
-		if !n.IsHost {
+	var removed []NodeID
+	for nodeID, node := range s.nodes {
+		if !node.LastSeen.Before(before) {
 			continue
 		}
-		if kind != "" && n.Kind != kind {
+		s.removeNode(s.nodes[nodeID])
+		removed = append(removed, nodeID)
+	}
+	return removed, nil
+}
+
+// removeNode deletes the node and releases its assignments, both as a
+// client and as a host. Must be called with the lock held.
+func (s *memoryStore) removeNode(node memNode) {
+	s.releaseHosts(node.ID)
+	for clientID := range node.clients {
+		client, ok := s.nodes[clientID]
+		if !ok {
 			continue
 		}
-		if !n.LastSeen.After(seenSince) {
-			continue
+		client.AssignedHosts = withoutNodeID(client.AssignedHosts, node.ID)
+		s.nodes[clientID] = client
+	}
+	s.unindexHost(node.Node)
+	delete(s.nodes, node.ID)
+}
+
+// withoutNodeID returns ids without id, or nil if none are left.
+func withoutNodeID(ids []NodeID, id NodeID) []NodeID {
+	var r []NodeID
+	for _, other := range ids {
+		if other != id {
+			r = append(r, other)
 		}
-		r = append(r, n.Node)
-		limit -= 1
-		if limit == 0 {
-			// If limit is originally 0, then limit is effectively ignored
-			// since it will be <0.
-			break
+	}
+	return r
+}
+
+// indexHost adds a host node to the kind index. Must be called with the lock
+// held.
+func (s *memoryStore) indexHost(n Node) {
+	if !n.IsHost {
+		return
+	}
+	ids, ok := s.hosts[n.Kind]
+	if !ok {
+		ids = map[NodeID]struct{}{}
+		s.hosts[n.Kind] = ids
+	}
+	ids[n.ID] = struct{}{}
+}
+
+// unindexHost removes a node from the kind index. Must be called with the
+// lock held.
+func (s *memoryStore) unindexHost(n Node) {
+	ids, ok := s.hosts[n.Kind]
+	if !ok {
+		return
+	}
+	delete(ids, n.ID)
+	if len(ids) == 0 {
+		delete(s.hosts, n.Kind)
+	}
+}
+
+// ActiveHosts returns `limit`-number of `kind` nodes, chosen by the
+// selector. This could be an empty list, if none are available.
+func (s *memoryStore) ActiveHosts(ctx context.Context, kind string, limit int, selector HostSelector) ([]Node, error) {
+	if err := CheckHostQuery(kind, limit); err != nil {
+		return nil, err
+	}
+	seenSince := s.Clock.Now().Add(-ExpireInterval)
+	r := []Node{}
+
+	s.mu.Lock()
+	indexes := make([]map[NodeID]struct{}, 0, len(s.hosts))
+	if kind != AnyKind {
+		indexes = append(indexes, s.hosts[kind])
+	} else {
+		for _, ids := range s.hosts {
+			indexes = append(indexes, ids)
+		}
+	}
+	for _, ids := range indexes {
+		for id := range ids {
+			n := s.nodes[id]
+			if !n.LastSeen.After(seenSince) {
+				continue
+			}
+			r = append(r, n.Node)
 		}
 	}
+	s.mu.Unlock()
+
+	// The selector could call back into the store, such as for balances, so
+	// it's applied without the lock.
+	return SelectHosts(ctx, selector, r, limit), nil
+}
+
+// AllNodes returns every node that the store knows about.
+func (s *memoryStore) AllNodes(ctx context.Context) ([]Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := make([]Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		r = append(r, n.Node)
+	}
 	return r, nil
 }
 
 // NodePeers returns a list of active connected peers that this pool knows
 // about for this NodeID.
-func (s *memoryStore) NodePeers(nodeID NodeID) ([]Node, error) {
+func (s *memoryStore) NodePeers(ctx context.Context, nodeID NodeID) ([]Node, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	node, ok := s.nodes[nodeID]
@@ -262,14 +468,14 @@ func (s *memoryStore) NodePeers(nodeID NodeID) ([]Node, error) {
 // UpdateNodePeers updates the Node.peers lookup with the current timestamp
 // of nodes we know about. This is used as a keepalive, and to keep track of
 // which client is connected to which host.
-func (s *memoryStore) UpdateNodePeers(nodeID NodeID, peers []string, blockNumber uint64) ([]NodeID, error) {
+func (s *memoryStore) UpdateNodePeers(ctx context.Context, nodeID NodeID, peers []string, blockNumber uint64) ([]NodeID, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	node, ok := s.nodes[nodeID]
 	if !ok {
 		return nil, ErrUnregisteredNode
 	}
-	now := time.Now()
+	now := s.Clock.Now()
 	node.LastSeen = now
 	numUpdated := 0
 	for _, peer := range peers {
@@ -283,30 +489,132 @@ func (s *memoryStore) UpdateNodePeers(nodeID NodeID, peers []string, blockNumber
 		}
 		if _, ok := s.nodes[peerID]; ok {
 			node.peers[peerID] = now
+			delete(node.claims, peerID)
 			numUpdated += 1
 		}
 	}
 
 	if numUpdated == len(node.peers) {
+		node.PeerCount = len(node.peers)
 		s.nodes[nodeID] = node
 		return nil, nil
 	}
 	inactive := []NodeID{}
 	inactiveDeadline := now.Add(-ExpireInterval)
 	for nodeID, timestamp := range node.peers {
-		if timestamp.Before(inactiveDeadline) {
+		if !timestamp.Before(inactiveDeadline) {
+			// Still active
 			continue
 		}
 		delete(node.peers, nodeID)
 		inactive = append(inactive, nodeID)
 	}
 
+	node.PeerCount = len(node.peers)
 	s.nodes[nodeID] = node
 	return inactive, nil
 }
 
+// AssignHosts replaces the client's assigned hosts, and updates the Clients
+// of the hosts that were added or removed.
+func (s *memoryStore) AssignHosts(ctx context.Context, clientID NodeID, hostIDs []NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nodes[clientID]; !ok {
+		return ErrUnregisteredNode
+	}
+	s.releaseHosts(clientID)
+
+	var assigned []NodeID
+	for _, hostID := range hostIDs {
+		host, ok := s.nodes[hostID]
+		if !ok {
+			continue
+		}
+		if _, ok := host.clients[clientID]; ok {
+			continue
+		}
+		if host.clients == nil {
+			host.clients = map[NodeID]struct{}{}
+		}
+		host.clients[clientID] = struct{}{}
+		host.Clients = len(host.clients)
+		s.nodes[hostID] = host
+		assigned = append(assigned, hostID)
+	}
+	client := s.nodes[clientID]
+	client.AssignedHosts = assigned
+	s.nodes[clientID] = client
+	return nil
+}
+
+// releaseHosts removes the client from the Clients of its assigned hosts.
+// Must be called with the lock held.
+func (s *memoryStore) releaseHosts(clientID NodeID) {
+	client := s.nodes[clientID]
+	for _, hostID := range client.AssignedHosts {
+		host, ok := s.nodes[hostID]
+		if !ok {
+			continue
+		}
+		delete(host.clients, clientID)
+		host.Clients = len(host.clients)
+		s.nodes[hostID] = host
+	}
+	client.AssignedHosts = nil
+	s.nodes[clientID] = client
+}
+
+// ClaimHostSlot reserves one of the host's slots for the client, if the host
+// has capacity remaining.
+func (s *memoryStore) ClaimHostSlot(hostID NodeID, clientID NodeID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host, ok := s.nodes[hostID]
+	if !ok {
+		return false, ErrUnregisteredNode
+	}
+	if _, ok := host.peers[clientID]; ok {
+		return true, nil
+	}
+	if host.claims == nil {
+		host.claims = map[NodeID]time.Time{}
+		s.nodes[hostID] = host
+	}
+	return ClaimSlot(host.Node, host.claims, clientID, s.Clock.Now()), nil
+}
+
+// ReleaseHostSlot releases the client's claim on the host.
+func (s *memoryStore) ReleaseHostSlot(hostID NodeID, clientID NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if host, ok := s.nodes[hostID]; ok {
+		delete(host.claims, clientID)
+	}
+	return nil
+}
+
+// SetSigningKey binds the signing key to the node.
+func (s *memoryStore) SetSigningKey(ctx context.Context, nodeID NodeID, signerID NodeID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if signerID.IsZero() {
+		delete(s.signers, nodeID)
+	} else {
+		s.signers[nodeID] = signerID
+	}
+	return nil
+}
+
+// GetSigningKey returns the signing key that is bound to the node.
+func (s *memoryStore) GetSigningKey(ctx context.Context, nodeID NodeID) (NodeID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signers[nodeID], nil
+}
+
 // Stats returns aggregate statistics about the store state.
-func (s *memoryStore) Stats() (*Stats, error) {
+func (s *memoryStore) Stats(ctx context.Context) (*Stats, error) {
 	stats := Stats{}
 
 	s.mu.Lock()
@@ -1,7 +1,16 @@
 package store
 
 import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/clock"
 )
 
 func TestMemoryStore(t *testing.T) {
@@ -11,3 +20,355 @@ func TestMemoryStore(t *testing.T) {
 		})
 	})
 }
+
+func TestMemoryStoreClock(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	s := MemoryStore()
+	s.Clock = fakeClock
+
+	host := Node{ID: "a", URI: "enode://a", IsHost: true, Kind: "geth", LastSeen: fakeClock.Now()}
+	if err := s.SetNode(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+		t.Fatal(err)
+	} else if len(hosts) != 1 {
+		t.Errorf("expected 1 active host, got %d", len(hosts))
+	}
+
+	// Host goes stale without an update
+	fakeClock.Add(ExpireInterval)
+	if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+		t.Fatal(err)
+	} else if len(hosts) != 0 {
+		t.Errorf("expected expired host to be evicted, got %d active hosts", len(hosts))
+	}
+
+	// Update refreshes LastSeen using the clock
+	if _, err := s.UpdateNodePeers(ctx, host.ID, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+		t.Fatal(err)
+	} else if len(hosts) != 1 {
+		t.Errorf("expected updated host to be active, got %d active hosts", len(hosts))
+	} else if !hosts[0].LastSeen.Equal(fakeClock.Now()) {
+		t.Errorf("wrong LastSeen: got %s; want %s", hosts[0].LastSeen, fakeClock.Now())
+	}
+
+	// Nonce expiry follows the clock
+	nonce := fakeClock.Now().UnixNano()
+	fakeClock.Add(ExpireNonce + time.Second)
+	if err := s.CheckAndSaveNonce(ctx, "abc", nonce); err != ErrInvalidNonce {
+		t.Errorf("expected expired nonce to be invalid, got: %v", err)
+	}
+}
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := MemoryStore()
+	nodes := []Node{
+		{ID: "host", URI: "enode://host", IsHost: true, Kind: "geth", LastSeen: time.Now()},
+		{ID: "client", Kind: "geth", LastSeen: time.Now()},
+	}
+	for _, n := range nodes {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.UpdateNodePeers(ctx, "client", []string{"host"}, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssignHosts(ctx, "client", []NodeID{"host"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddNodeBalance(ctx, "client", big.NewInt(-1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAccountNode(ctx, "account", "host"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAccountBalance(ctx, "account", big.NewInt(5000)); err != nil {
+		t.Fatal(err)
+	}
+	nonce := time.Now().UnixNano()
+	if err := s.CheckAndSaveNonce(ctx, "client", nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	if err := s.SnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := MemoryStore()
+	if err := restored.RestoreFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if peers, err := restored.NodePeers(ctx, "client"); err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 1 || peers[0].ID != "host" {
+		t.Errorf("wrong restored peers: %v", peers)
+	}
+	if b, err := restored.GetNodeBalance(ctx, "client"); err != nil {
+		t.Fatal(err)
+	} else if b.Credit.Int64() != -1000 {
+		t.Errorf("wrong restored trial balance: %s", &b.Credit)
+	}
+	if b, err := restored.GetNodeBalance(ctx, "host"); err != nil {
+		t.Fatal(err)
+	} else if b.Account != "account" || b.Credit.Int64() != 5000 {
+		t.Errorf("wrong restored account balance: %v", b)
+	}
+	if err := restored.CheckAndSaveNonce(ctx, "client", nonce); err != ErrInvalidNonce {
+		t.Errorf("expected restored nonce to be checked, got: %v", err)
+	}
+
+	// Restored assignments can be released
+	if err := restored.RemoveNode(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if host, err := restored.GetNode(ctx, "host"); err != nil {
+		t.Fatal(err)
+	} else if host.Clients != 0 {
+		t.Errorf("restored host has %d clients after its client was removed; want 0", host.Clients)
+	}
+
+	// Missing snapshot is not an error
+	if err := MemoryStore().RestoreFile(filepath.Join(t.TempDir(), "missing.gob")); err != nil {
+		t.Errorf("unexpected error restoring missing snapshot: %s", err)
+	}
+}
+
+func TestMemoryStoreHostIndex(t *testing.T) {
+	ctx := context.Background()
+	s := MemoryStore()
+	now := time.Now()
+
+	assertHosts := func(kind string, want ...NodeID) {
+		t.Helper()
+		hosts, err := s.ActiveHosts(ctx, kind, NoLimit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[NodeID]bool{}
+		for _, h := range hosts {
+			got[h.ID] = true
+		}
+		if len(got) != len(want) || len(hosts) != len(want) {
+			t.Errorf("ActiveHosts(%q): got %v; want %v", kind, hosts, want)
+			return
+		}
+		for _, id := range want {
+			if !got[id] {
+				t.Errorf("ActiveHosts(%q): missing %q in %v", kind, id, hosts)
+			}
+		}
+	}
+
+	for _, n := range []Node{
+		{ID: "a", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "b", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "c", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "d", IsHost: false, Kind: "geth", LastSeen: now},
+	} {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertHosts("geth", "a", "b")
+	assertHosts("parity", "c")
+	assertHosts(AnyKind, "a", "b", "c")
+
+	// Kind changes
+	if err := s.SetNode(ctx, Node{ID: "b", IsHost: true, Kind: "parity", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts("geth", "a")
+	assertHosts("parity", "b", "c")
+
+	// Host becomes a client, client becomes a host
+	if err := s.SetNode(ctx, Node{ID: "a", IsHost: false, Kind: "geth", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetNode(ctx, Node{ID: "d", IsHost: true, Kind: "geth", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts("geth", "d")
+
+	// Remove
+	if err := s.RemoveNode(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveNode(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts("geth")
+	assertHosts("parity", "b")
+	assertHosts(AnyKind, "b")
+	if len(s.hosts) != 1 {
+		t.Errorf("empty kinds were not removed from the index: %v", s.hosts)
+	}
+
+	// Stale hosts stay indexed but are not active
+	if err := s.SetNode(ctx, Node{ID: "b", IsHost: true, Kind: "parity", LastSeen: now.Add(-ExpireInterval)}); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts("parity")
+	if _, err := s.UpdateNodePeers(ctx, "b", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts("parity", "b")
+}
+
+func BenchmarkMemoryStoreActiveHosts(b *testing.B) {
+	ctx := context.Background()
+	s := MemoryStore()
+	now := time.Now()
+	kinds := []string{"geth", "parity", "lightgeth", "lightparity"}
+	for i := 0; i < 10000; i++ {
+		id := NodeID(fmt.Sprintf("node%d", i))
+		// Mostly clients, like a real pool
+		node := Node{ID: id, Kind: kinds[i%len(kinds)], IsHost: i%5 == 0, LastSeen: now}
+		if err := s.SetNode(ctx, node); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hosts, err := s.ActiveHosts(ctx, "parity", 3, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(hosts) != 3 {
+			b.Fatalf("wrong number of hosts: %d", len(hosts))
+		}
+	}
+}
+
+func TestMemoryStoreConcurrentBalance(t *testing.T) {
+	ctx := context.Background()
+	s := MemoryStore()
+	trialNode := Node{ID: "a"}
+	accountNode := Node{ID: "b"}
+	for _, n := range []Node{trialNode, accountNode} {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	account := Account("0xabc")
+	if err := s.AddAccountNode(ctx, account, accountNode.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Start with a large value so that additions modify multi-word digits.
+	large, _ := new(big.Int).SetString("100000000000000000000000000000", 10)
+	for _, n := range []Node{trialNode, accountNode} {
+		if err := s.AddNodeBalance(ctx, n.ID, large); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const numWriters, numReaders, numOps = 4, 4, 200
+	credit := big.NewInt(1000000007)
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numOps; j++ {
+				for _, n := range []Node{trialNode, accountNode} {
+					if err := s.AddNodeBalance(ctx, n.ID, credit); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < numReaders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numOps; j++ {
+				for _, read := range []func() (Balance, error){
+					func() (Balance, error) { return s.GetNodeBalance(ctx, trialNode.ID) },
+					func() (Balance, error) { return s.GetNodeBalance(ctx, accountNode.ID) },
+					func() (Balance, error) { return s.GetAccountBalance(ctx, account) },
+				} {
+					b, err := read()
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					// Balances are always the initial value plus whole credits
+					delta := new(big.Int).Sub(&b.Credit, large)
+					if new(big.Int).Mod(delta, credit).Sign() != 0 {
+						t.Errorf("inconsistent balance: %s", &b.Credit)
+					}
+					// Modifying the returned balance must not affect the store
+					b.Credit.Neg(&b.Credit)
+					b.Credit.Add(&b.Credit, big.NewInt(1))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := new(big.Int).Mul(credit, big.NewInt(numWriters*numOps))
+	want.Add(want, large)
+	for _, n := range []Node{trialNode, accountNode} {
+		b, err := s.GetNodeBalance(ctx, n.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Credit.Cmp(want) != 0 {
+			t.Errorf("node %s: got credit %s; want %s", n.ID, &b.Credit, want)
+		}
+	}
+}
+
+func TestMemoryStoreInactivePeers(t *testing.T) {
+	ctx := context.Background()
+	fakeClock := clock.NewFake(time.Now())
+	s := MemoryStore()
+	s.Clock = fakeClock
+
+	for _, n := range []Node{
+		{ID: "a", IsHost: true, LastSeen: fakeClock.Now()},
+		{ID: "b", LastSeen: fakeClock.Now()},
+		{ID: "c", LastSeen: fakeClock.Now()},
+	} {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.UpdateNodePeers(ctx, "a", []string{"b", "c"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// c is missing from the update, but it was seen recently
+	fakeClock.Add(ExpireInterval / 2)
+	if inactive, err := s.UpdateNodePeers(ctx, "a", []string{"b"}, 0); err != nil {
+		t.Fatal(err)
+	} else if len(inactive) != 0 {
+		t.Errorf("expected no inactive peers, got: %v", inactive)
+	}
+
+	// Now c is stale, but b is not
+	fakeClock.Add(ExpireInterval/2 + time.Second)
+	inactive, err := s.UpdateNodePeers(ctx, "a", []string{"b"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []NodeID{"c"}; !reflect.DeepEqual(inactive, want) {
+		t.Errorf("wrong inactive peers: got %v; want %v", inactive, want)
+	}
+	if peers, err := s.NodePeers(ctx, "a"); err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 1 || peers[0].ID != "b" {
+		t.Errorf("wrong remaining peers: %v", peers)
+	}
+}
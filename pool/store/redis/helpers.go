@@ -0,0 +1,238 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// maxRetries is the number of times a transaction is attempted when a
+// watched key is modified by another client.
+const maxRetries = 10
+
+var errTooManyRetries = errors.New("redis: transaction aborted too many times due to conflicting writes")
+
+type command struct {
+	name string
+	args redis.Args
+}
+
+func cmd(name string, args ...interface{}) command {
+	return command{name, redis.Args{}.Add(args...)}
+}
+
+// transaction runs fn with optimistic locking. fn should WATCH any keys that
+// it reads, and return the commands to run within MULTI/EXEC. If a watched
+// key changes before the commands are executed, fn is retried.
+func transaction(conn redis.Conn, fn func(conn redis.Conn) ([]command, error)) error {
+	for i := 0; i < maxRetries; i++ {
+		cmds, err := fn(conn)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		for _, c := range cmds {
+			if err := conn.Send(c.name, c.args...); err != nil {
+				conn.Do("DISCARD")
+				return err
+			}
+		}
+		replies, err := redis.Values(conn.Do("EXEC"))
+		if err == redis.ErrNil {
+			// Watched key changed, try again
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			if err, ok := reply.(redis.Error); ok {
+				return err
+			}
+		}
+		return nil
+	}
+	return errTooManyRetries
+}
+
+// scanKeys returns all the keys that match pattern.
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// unixNano returns the timestamp as nanoseconds, with zero for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// unixMilli returns the timestamp as milliseconds, with zero for the zero
+// time. Sorted set scores are float64, so nanoseconds would lose precision.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" || s == "0" {
+		return time.Time{}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
+
+func parseBig(s string, into *big.Int) error {
+	if s == "" || s == "0" {
+		// Leave the zero value as-is
+		return nil
+	}
+	if _, ok := into.SetString(s, 10); !ok {
+		return fmt.Errorf("redis: invalid integer value: %q", s)
+	}
+	return nil
+}
+
+// nodeFields returns the hash fields that SetNode saves. The clients and
+// assigned_hosts fields are left out, since they're only changed by
+// AssignHosts.
+func nodeFields(n store.Node) []interface{} {
+	return []interface{}{
+		"id", string(n.ID),
+		"uri", n.URI,
+		"last_seen", unixNano(n.LastSeen),
+		"kind", n.Kind,
+		"is_host", n.IsHost,
+		"payout", string(n.Payout),
+		"block_number", n.BlockNumber,
+		"alt_uris", strings.Join(n.AltURIs, " "),
+		"instance", n.Instance,
+		"peer_count", n.PeerCount,
+		"max_peers", n.MaxPeers,
+		"syncing", n.Syncing,
+		"version", n.Version,
+		"draining", n.Draining,
+	}
+}
+
+func parseNode(fields map[string]string) (store.Node, error) {
+	var err error
+	n := store.Node{
+		ID:       store.NodeID(fields["id"]),
+		URI:      fields["uri"],
+		Kind:     fields["kind"],
+		IsHost:   fields["is_host"] == "1",
+		Payout:   store.Account(fields["payout"]),
+		Instance: fields["instance"],
+		Syncing:  fields["syncing"] == "1",
+		Version:  fields["version"],
+		Draining: fields["draining"] == "1",
+	}
+	if n.LastSeen, err = parseTime(fields["last_seen"]); err != nil {
+		return n, err
+	}
+	if s := fields["block_number"]; s != "" {
+		if n.BlockNumber, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return n, err
+		}
+	}
+	if s := fields["alt_uris"]; s != "" {
+		n.AltURIs = strings.Fields(s)
+	}
+	if s := fields["peer_count"]; s != "" {
+		if n.PeerCount, err = strconv.Atoi(s); err != nil {
+			return n, err
+		}
+	}
+	if s := fields["max_peers"]; s != "" {
+		if n.MaxPeers, err = strconv.Atoi(s); err != nil {
+			return n, err
+		}
+	}
+	if s := fields["clients"]; s != "" {
+		if n.Clients, err = strconv.Atoi(s); err != nil {
+			return n, err
+		}
+	}
+	n.AssignedHosts = splitNodeIDs(fields["assigned_hosts"])
+	return n, nil
+}
+
+// joinNodeIDs returns the IDs separated by spaces.
+func joinNodeIDs(ids []store.NodeID) string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, string(id))
+	}
+	return strings.Join(s, " ")
+}
+
+// splitNodeIDs parses the IDs of joinNodeIDs, returning nil if there are
+// none.
+func splitNodeIDs(s string) []store.NodeID {
+	var ids []store.NodeID
+	for _, id := range strings.Fields(s) {
+		ids = append(ids, store.NodeID(id))
+	}
+	return ids
+}
+
+func balanceFields(b store.Balance) []interface{} {
+	return []interface{}{
+		"account", string(b.Account),
+		"credit", b.Credit.String(),
+		"deposit", b.Deposit.String(),
+		"next_withdraw", unixNano(b.NextWithdraw),
+		"trial_granted", b.TrialGranted,
+	}
+}
+
+func parseBalance(fields map[string]string) (store.Balance, error) {
+	var err error
+	b := store.Balance{
+		Account:      store.Account(fields["account"]),
+		TrialGranted: fields["trial_granted"] == "1",
+	}
+	if err = parseBig(fields["credit"], &b.Credit); err != nil {
+		return b, err
+	}
+	if err = parseBig(fields["deposit"], &b.Deposit); err != nil {
+		return b, err
+	}
+	if b.NextWithdraw, err = parseTime(fields["next_withdraw"]); err != nil {
+		return b, err
+	}
+	return b, nil
+}
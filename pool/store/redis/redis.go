@@ -0,0 +1,1024 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// Keys are prefixed and laid out like the badger store:
+//
+//   vip:nonce:<ID>          nonce string, expires with nonceExpire
+//   vip:node:<nodeID>       node hash
+//   vip:peers:<nodeID>      hash of peer nodeID to last seen (unix nanoseconds)
+//   vip:clients:<nodeID>    set of client nodeIDs assigned to the host
+//   vip:hosts               sorted set of all host nodeIDs by last seen (unix milliseconds)
+//   vip:hosts:<kind>        sorted set of host nodeIDs of kind by last seen
+//   vip:account:<nodeID>    account string that the node spends from
+//   vip:spenders:<account>  set of nodeIDs that spend from the account
+//   vip:balance:<account>   balance hash of an account
+//   vip:trial:<nodeID>      balance hash of a node without an account
+
+// nonceScript atomically saves the nonce if it's higher than the current
+// nonce. Lua numbers are doubles which can't represent nanosecond nonces, so
+// the nonces are compared as decimal strings.
+var nonceScript = redis.NewScript(1, `
+local last = redis.call('GET', KEYS[1])
+local nonce = ARGV[1]
+if last and (#last > #nonce or (#last == #nonce and last >= nonce)) then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], nonce, 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], nonce)
+end
+return 1
+`)
+
+// purgeNonceScript atomically deletes the nonce if it's lower than the cutoff.
+var purgeNonceScript = redis.NewScript(1, `
+local nonce = redis.call('GET', KEYS[1])
+local cutoff = ARGV[1]
+if nonce and (#nonce < #cutoff or (#nonce == #cutoff and nonce < cutoff)) then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Open returns a store.Store implementation using Redis as the storage
+// driver. The url is a redis:// URL, such as "redis://localhost:6379/0". The
+// store should be (*redisStore).Close()'d after use.
+func Open(url string) (*redisStore, error) {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url)
+		},
+	}
+
+	// Confirm that we can connect
+	conn := pool.Get()
+	_, err := conn.Do("PING")
+	conn.Close()
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	s := &redisStore{
+		pool:        pool,
+		prefix:      "vip:",
+		nonceExpire: store.ExpireNonce,
+		Clock:       clock.Real(),
+	}
+	return s, nil
+}
+
+var _ store.Store = &redisStore{}
+var _ store.SigningKeyStore = &redisStore{}
+
+type redisStore struct {
+	pool   *redis.Pool
+	prefix string
+
+	nonceExpire time.Duration
+
+	// Clock is used for nonce expiry and node activity. It should not be
+	// changed after the store is in use.
+	Clock clock.Clock
+}
+
+func (s *redisStore) key(format string, args ...interface{}) string {
+	return s.prefix + fmt.Sprintf(format, args...)
+}
+
+func (s *redisStore) Close() error {
+	return s.pool.Close()
+}
+
+// CheckAndSaveNonce asserts that this is the highest nonce seen for this ID.
+func (s *redisStore) CheckAndSaveNonce(ctx context.Context, ID string, nonce int64) error {
+	now := s.Clock.Now()
+	if nonce > now.Add(store.MaxNonceAhead).UnixNano() {
+		return store.ErrNonceOutOfRange
+	}
+	// If nonceExpire is set, nonce should be within nonceExpire of now.
+	if s.nonceExpire > 0 {
+		if nonce <= now.Add(-s.nonceExpire).UnixNano() {
+			// Nonce is too old
+			return store.ErrInvalidNonce
+		}
+	} else if nonce < 0 {
+		// Nonces are compared as unsigned decimal strings
+		return store.ErrInvalidNonce
+	}
+
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ok, err := redis.Bool(nonceScript.Do(conn, s.key("nonce:%s", ID), nonce, int64(s.nonceExpire/time.Millisecond)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return store.ErrInvalidNonce
+	}
+	return nil
+}
+
+// PurgeNonces removes saved nonces that are older than the cutoff. Nonces
+// also expire on their own if nonceExpire is set.
+func (s *redisStore) PurgeNonces(ctx context.Context, olderThan time.Time) (int, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	keys, err := scanKeys(conn, s.key("nonce:*"))
+	if err != nil {
+		return 0, err
+	}
+	cutoff := olderThan.UnixNano()
+	n := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		deleted, err := redis.Int(purgeNonceScript.Do(conn, key, cutoff))
+		if err != nil {
+			return n, err
+		}
+		n += deleted
+	}
+	return n, nil
+}
+
+// balanceKey returns the key of the balance that the node spends from.
+func (s *redisStore) balanceKey(conn redis.Conn, nodeID store.NodeID) (string, error) {
+	account, err := redis.String(conn.Do("GET", s.key("account:%s", nodeID)))
+	if err == redis.ErrNil {
+		// No spendable account, use the trial account
+		return s.key("trial:%s", nodeID), nil
+	} else if err != nil {
+		return "", err
+	}
+	return s.key("balance:%s", account), nil
+}
+
+func (s *redisStore) getBalance(conn redis.Conn, key string) (store.Balance, bool, error) {
+	fields, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return store.Balance{}, false, err
+	}
+	if len(fields) == 0 {
+		return store.Balance{}, false, nil
+	}
+	b, err := parseBalance(fields)
+	return b, true, err
+}
+
+func (s *redisStore) hasNode(conn redis.Conn, nodeID store.NodeID) (bool, error) {
+	return redis.Bool(conn.Do("EXISTS", s.key("node:%s", nodeID)))
+}
+
+// GetNodeBalance returns the current account balance for a node.
+func (s *redisStore) GetNodeBalance(ctx context.Context, nodeID store.NodeID) (store.Balance, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return store.Balance{}, err
+	}
+	defer conn.Close()
+
+	balanceKey, err := s.balanceKey(conn, nodeID)
+	if err != nil {
+		return store.Balance{}, err
+	}
+	r, ok, err := s.getBalance(conn, balanceKey)
+	if err != nil || ok {
+		return r, err
+	}
+	if exists, err := s.hasNode(conn, nodeID); err != nil {
+		return r, err
+	} else if !exists {
+		return r, store.ErrUnregisteredNode
+	}
+	return r, nil
+}
+
+// AddNodeBalance adds some credit amount to a node's account balance. (Can be negative)
+// If only a node is provided which doesn't have an account registered to
+// it, it should retain a balance, such as through temporary trial accounts
+// that get migrated later.
+func (s *redisStore) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return transaction(conn, func(conn redis.Conn) ([]command, error) {
+		if _, err := conn.Do("WATCH", s.key("account:%s", nodeID)); err != nil {
+			return nil, err
+		}
+		balanceKey, err := s.balanceKey(conn, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Do("WATCH", balanceKey); err != nil {
+			return nil, err
+		}
+		balance, ok, err := s.getBalance(conn, balanceKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if exists, err := s.hasNode(conn, nodeID); err != nil {
+				return nil, err
+			} else if !exists {
+				return nil, store.ErrUnregisteredNode
+			}
+			// No balance = empty balance
+		}
+		balance.Credit.Add(&balance.Credit, credit)
+
+		return []command{
+			cmd("HSET", append([]interface{}{balanceKey}, balanceFields(balance)...)...),
+		}, nil
+	})
+}
+
+// GrantTrialCredit adds credit to the trial balance of a node without an
+// account, unless it was granted before.
+func (s *redisStore) GrantTrialCredit(ctx context.Context, nodeID store.NodeID, credit *big.Int) (bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	accountKey := s.key("account:%s", nodeID)
+	trialKey := s.key("trial:%s", nodeID)
+	granted := false
+	err = transaction(conn, func(conn redis.Conn) ([]command, error) {
+		granted = false
+		if _, err := conn.Do("WATCH", s.key("node:%s", nodeID), accountKey, trialKey); err != nil {
+			return nil, err
+		}
+		if exists, err := s.hasNode(conn, nodeID); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, store.ErrUnregisteredNode
+		}
+		if hasAccount, err := redis.Bool(conn.Do("EXISTS", accountKey)); err != nil || hasAccount {
+			return nil, err
+		}
+		balance, _, err := s.getBalance(conn, trialKey)
+		if err != nil || balance.TrialGranted {
+			return nil, err
+		}
+		balance.Credit.Add(&balance.Credit, credit)
+		balance.TrialGranted = true
+		granted = true
+
+		return []command{
+			cmd("HSET", append([]interface{}{trialKey}, balanceFields(balance)...)...),
+		}, nil
+	})
+	return granted, err
+}
+
+// GetAccountBalance returns an account's balance.
+func (s *redisStore) GetAccountBalance(ctx context.Context, account store.Account) (store.Balance, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return store.Balance{}, err
+	}
+	defer conn.Close()
+
+	// Default to empty balance
+	r, _, err := s.getBalance(conn, s.key("balance:%s", account))
+	return r, err
+}
+
+// AddNodeBalance adds credit to an account balance. (Can be negative)
+func (s *redisStore) AddAccountBalance(ctx context.Context, account store.Account, credit *big.Int) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	balanceKey := s.key("balance:%s", account)
+	return transaction(conn, func(conn redis.Conn) ([]command, error) {
+		if _, err := conn.Do("WATCH", balanceKey); err != nil {
+			return nil, err
+		}
+		balance, _, err := s.getBalance(conn, balanceKey)
+		if err != nil {
+			return nil, err
+		}
+		balance.Credit.Add(&balance.Credit, credit)
+		balance.Account = account
+
+		return []command{
+			cmd("HSET", append([]interface{}{balanceKey}, balanceFields(balance)...)...),
+		}, nil
+	})
+}
+
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold, sorted.
+func (s *redisStore) AccountsAbove(ctx context.Context, threshold *big.Int) ([]store.Account, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	prefix := s.key("balance:")
+	keys, err := scanKeys(conn, prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	r := []store.Account{}
+	for _, key := range keys {
+		b, _, err := s.getBalance(conn, key)
+		if err != nil {
+			return nil, err
+		}
+		if b.Credit.Cmp(threshold) > 0 {
+			r = append(r, store.Account(key[len(prefix):]))
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return r, nil
+}
+
+// AddAccountNode authorizes a nodeID to be a spender of an account's
+// balance. This should migrate any existing node's balance credit to the
+// account.
+func (s *redisStore) AddAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodeKey := s.key("node:%s", nodeID)
+	accountKey := s.key("account:%s", nodeID)
+	trialKey := s.key("trial:%s", nodeID)
+	balanceKey := s.key("balance:%s", account)
+	return transaction(conn, func(conn redis.Conn) ([]command, error) {
+		if _, err := conn.Do("WATCH", nodeKey, accountKey, trialKey, balanceKey); err != nil {
+			return nil, err
+		}
+
+		// Check nodeID
+		if exists, err := s.hasNode(conn, nodeID); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, store.ErrUnregisteredNode
+		}
+
+		// Load trial balance to migrate
+		trialBalance, _, err := s.getBalance(conn, trialKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// Load existing balance
+		balance, _, err := s.getBalance(conn, balanceKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var cmds []command
+		oldAccount, err := redis.String(conn.Do("GET", accountKey))
+		if err == nil && store.Account(oldAccount) != account {
+			cmds = append(cmds, cmd("SREM", s.key("spenders:%s", oldAccount), string(nodeID)))
+		} else if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+
+		// Authorize node, merge trial and save
+		balance.Credit.Add(&balance.Credit, &trialBalance.Credit)
+		balance.Account = account
+		cmds = append(cmds,
+			cmd("SET", accountKey, string(account)),
+			cmd("SADD", s.key("spenders:%s", account), string(nodeID)),
+			cmd("HSET", append([]interface{}{balanceKey}, balanceFields(balance)...)...),
+			cmd("DEL", trialKey),
+		)
+		return cmds, nil
+	})
+}
+
+// IsAccountNode returns nil if node is a valid spender of the given
+// account.
+func (s *redisStore) IsAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodeAccount, err := redis.String(conn.Do("GET", s.key("account:%s", nodeID)))
+	if err == redis.ErrNil {
+		return store.ErrNotAuthorized
+	} else if err != nil {
+		return err
+	}
+	if store.Account(nodeAccount) != account {
+		return store.ErrNotAuthorized
+	}
+	return nil
+}
+
+// GetAccountNodes returns the authorized nodeIDs for this account, these are
+// nodes that were added to accounts through AddAccountNode.
+func (s *redisStore) GetAccountNodes(ctx context.Context, account store.Account) ([]store.NodeID, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	members, err := redis.Strings(conn.Do("SMEMBERS", s.key("spenders:%s", account)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(members)
+	var r []store.NodeID
+	for _, nodeID := range members {
+		r = append(r, store.NodeID(nodeID))
+	}
+	return r, nil
+}
+
+func (s *redisStore) getNode(conn redis.Conn, nodeID store.NodeID) (*store.Node, error) {
+	fields, err := redis.StringMap(conn.Do("HGETALL", s.key("node:%s", nodeID)))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, store.ErrUnregisteredNode
+	}
+	n, err := parseNode(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// getNodes loads the nodes in a single round trip, skipping any that are no
+// longer registered.
+func (s *redisStore) getNodes(conn redis.Conn, nodeIDs []string) ([]store.Node, error) {
+	for _, nodeID := range nodeIDs {
+		if err := conn.Send("HGETALL", s.key("node:%s", nodeID)); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	r := make([]store.Node, 0, len(nodeIDs))
+	for range nodeIDs {
+		fields, err := redis.StringMap(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// Skip nodes we no longer know about.
+			continue
+		}
+		n, err := parseNode(fields)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, n)
+	}
+	return r, nil
+}
+
+// hostCommands returns the commands to update the host indexes for a node
+// that is replacing prev, which can be nil if the node is new.
+func (s *redisStore) hostCommands(n store.Node, prev *store.Node) []command {
+	var cmds []command
+	if prev != nil && prev.IsHost && (!n.IsHost || prev.Kind != n.Kind) {
+		cmds = append(cmds, cmd("ZREM", s.key("hosts:%s", prev.Kind), string(prev.ID)))
+		if !n.IsHost {
+			cmds = append(cmds, cmd("ZREM", s.key("hosts"), string(prev.ID)))
+		}
+	}
+	if n.IsHost {
+		score := unixMilli(n.LastSeen)
+		cmds = append(cmds,
+			cmd("ZADD", s.key("hosts:%s", n.Kind), score, string(n.ID)),
+			cmd("ZADD", s.key("hosts"), score, string(n.ID)),
+		)
+	}
+	return cmds
+}
+
+// GetNode returns the node from the set of active nodes.
+func (s *redisStore) GetNode(ctx context.Context, nodeID store.NodeID) (*store.Node, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return s.getNode(conn, nodeID)
+}
+
+// SetNode saves a node.
+func (s *redisStore) SetNode(ctx context.Context, n store.Node) error {
+	if n.ID == "" {
+		return store.ErrMalformedNode
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodeKey := s.key("node:%s", n.ID)
+	return transaction(conn, func(conn redis.Conn) ([]command, error) {
+		if _, err := conn.Do("WATCH", nodeKey); err != nil {
+			return nil, err
+		}
+		prev, err := s.getNode(conn, n.ID)
+		if err == store.ErrUnregisteredNode {
+			prev = nil
+		} else if err != nil {
+			return nil, err
+		}
+		cmds := []command{
+			cmd("HSET", append([]interface{}{nodeKey}, nodeFields(n)...)...),
+		}
+		return append(cmds, s.hostCommands(n, prev)...), nil
+	})
+}
+
+// RemoveNode removes a node and its peers. Balances are retained.
+func (s *redisStore) RemoveNode(ctx context.Context, nodeID store.NodeID) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = s.removeNode(conn, nodeID, nil)
+	return err
+}
+
+// ExpireNodes removes the nodes that haven't been seen since before.
+func (s *redisStore) ExpireNodes(ctx context.Context, before time.Time) ([]store.NodeID, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	nodeKeys, err := scanKeys(conn, s.key("node:*"))
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.key("node:")
+	expired := func(n *store.Node) bool { return n.LastSeen.Before(before) }
+	var removed []store.NodeID
+	for _, key := range nodeKeys {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		nodeID := store.NodeID(key[len(prefix):])
+		ok, err := s.removeNode(conn, nodeID, expired)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, nodeID)
+		}
+	}
+	return removed, nil
+}
+
+// removeNode removes the node and its peers if it matches, or always if
+// match is nil. The node is checked within the transaction, so a node that
+// is updated concurrently isn't removed based on stale state.
+func (s *redisStore) removeNode(conn redis.Conn, nodeID store.NodeID, match func(*store.Node) bool) (removed bool, err error) {
+	nodeKey := s.key("node:%s", nodeID)
+	err = transaction(conn, func(conn redis.Conn) ([]command, error) {
+		removed = false
+		if _, err := conn.Do("WATCH", nodeKey); err != nil {
+			return nil, err
+		}
+		node, err := s.getNode(conn, nodeID)
+		if err == store.ErrUnregisteredNode {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if match != nil && !match(node) {
+			return nil, nil
+		}
+		removed = true
+		cmds, err := s.assignCommands(conn, node, nil)
+		if err != nil {
+			return nil, err
+		}
+		unassignCmds, err := s.unassignCommands(conn, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, unassignCmds...)
+		cmds = append(cmds, cmd("DEL", nodeKey, s.key("peers:%s", nodeID), s.key("clients:%s", nodeID)))
+		if node.IsHost {
+			cmds = append(cmds,
+				cmd("ZREM", s.key("hosts:%s", node.Kind), string(nodeID)),
+				cmd("ZREM", s.key("hosts"), string(nodeID)),
+			)
+		}
+		return cmds, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+// AssignHosts replaces the client's assigned hosts, and updates the Clients
+// of the hosts that were added or removed.
+func (s *redisStore) AssignHosts(ctx context.Context, clientID store.NodeID, hostIDs []store.NodeID) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	clientKey := s.key("node:%s", clientID)
+	return transaction(conn, func(conn redis.Conn) ([]command, error) {
+		if _, err := conn.Do("WATCH", clientKey); err != nil {
+			return nil, err
+		}
+		client, err := s.getNode(conn, clientID)
+		if err != nil {
+			return nil, err
+		}
+		return s.assignCommands(conn, client, hostIDs)
+	})
+}
+
+// assignCommands returns the commands that replace the client's assigned
+// hosts with hostIDs, watching the keys of the hosts that it reads. The
+// client's node key must already be watched.
+func (s *redisStore) assignCommands(conn redis.Conn, client *store.Node, hostIDs []store.NodeID) ([]command, error) {
+	want := make(map[store.NodeID]bool, len(hostIDs))
+	for _, hostID := range hostIDs {
+		want[hostID] = true
+	}
+	var cmds []command
+	var assigned []store.NodeID
+	seen := map[store.NodeID]bool{}
+	// Each host is visited once, since the queued commands aren't visible
+	// to the reads of later hosts.
+	for _, hostID := range append(append([]store.NodeID{}, hostIDs...), client.AssignedHosts...) {
+		if seen[hostID] {
+			continue
+		}
+		seen[hostID] = true
+
+		hostKey := s.key("node:%s", hostID)
+		clientsKey := s.key("clients:%s", hostID)
+		if _, err := conn.Do("WATCH", hostKey, clientsKey); err != nil {
+			return nil, err
+		}
+		if exists, err := s.hasNode(conn, hostID); err != nil {
+			return nil, err
+		} else if !exists {
+			continue
+		}
+		isAssigned, err := redis.Bool(conn.Do("SISMEMBER", clientsKey, string(client.ID)))
+		if err != nil {
+			return nil, err
+		}
+		if want[hostID] {
+			assigned = append(assigned, hostID)
+		}
+		if isAssigned == want[hostID] {
+			continue
+		}
+		numClients, err := redis.Int(conn.Do("SCARD", clientsKey))
+		if err != nil {
+			return nil, err
+		}
+		if want[hostID] {
+			cmds = append(cmds, cmd("SADD", clientsKey, string(client.ID)))
+			numClients += 1
+		} else {
+			cmds = append(cmds, cmd("SREM", clientsKey, string(client.ID)))
+			numClients -= 1
+		}
+		cmds = append(cmds, cmd("HSET", hostKey, "clients", numClients))
+	}
+	cmds = append(cmds, cmd("HSET", s.key("node:%s", client.ID), "assigned_hosts", joinNodeIDs(assigned)))
+	return cmds, nil
+}
+
+// unassignCommands returns the commands that remove the host from the
+// assigned hosts of its clients, watching the keys that it reads.
+func (s *redisStore) unassignCommands(conn redis.Conn, hostID store.NodeID) ([]command, error) {
+	clientsKey := s.key("clients:%s", hostID)
+	if _, err := conn.Do("WATCH", clientsKey); err != nil {
+		return nil, err
+	}
+	clientIDs, err := redis.Strings(conn.Do("SMEMBERS", clientsKey))
+	if err != nil {
+		return nil, err
+	}
+	var cmds []command
+	for _, clientID := range clientIDs {
+		clientKey := s.key("node:%s", clientID)
+		if _, err := conn.Do("WATCH", clientKey); err != nil {
+			return nil, err
+		}
+		client, err := s.getNode(conn, store.NodeID(clientID))
+		if err == store.ErrUnregisteredNode {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var assigned []store.NodeID
+		for _, id := range client.AssignedHosts {
+			if id != hostID {
+				assigned = append(assigned, id)
+			}
+		}
+		cmds = append(cmds, cmd("HSET", clientKey, "assigned_hosts", joinNodeIDs(assigned)))
+	}
+	return cmds, nil
+}
+
+// ActiveHosts loads the hosts of kind that were seen recently, then return a
+// valid subset of size limit chosen by the selector.
+func (s *redisStore) ActiveHosts(ctx context.Context, kind string, limit int, selector store.HostSelector) ([]store.Node, error) {
+	if err := store.CheckHostQuery(kind, limit); err != nil {
+		return nil, err
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	seenSince := s.Clock.Now().Add(-store.ExpireInterval)
+	hostsKey := s.key("hosts")
+	if kind != store.AnyKind {
+		hostsKey = s.key("hosts:%s", kind)
+	}
+	hostIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", hostsKey, fmt.Sprintf("(%d", unixMilli(seenSince)), "+inf"))
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := s.getNodes(conn, hostIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]store.Node, 0, len(hosts))
+	for _, n := range hosts {
+		if !n.IsHost {
+			continue
+		}
+		if kind != store.AnyKind && n.Kind != kind {
+			continue
+		}
+		if !n.LastSeen.After(seenSince) {
+			continue
+		}
+		r = append(r, n)
+	}
+	return store.SelectHosts(ctx, selector, r, limit), nil
+}
+
+// AllNodes returns every node that the store knows about.
+func (s *redisStore) AllNodes(ctx context.Context) ([]store.Node, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	nodeKeys, err := scanKeys(conn, s.key("node:*"))
+	if err != nil {
+		return nil, err
+	}
+	r := make([]store.Node, 0, len(nodeKeys))
+	for _, key := range nodeKeys {
+		fields, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// Removed since the scan
+			continue
+		}
+		n, err := parseNode(fields)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, n)
+	}
+	return r, nil
+}
+
+// NodePeers returns a list of active connected peers that this pool knows
+// about for this NodeID.
+func (s *redisStore) NodePeers(ctx context.Context, nodeID store.NodeID) ([]store.Node, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if exists, err := s.hasNode(conn, nodeID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, store.ErrUnregisteredNode
+	}
+	peerIDs, err := redis.Strings(conn.Do("HKEYS", s.key("peers:%s", nodeID)))
+	if err != nil {
+		return nil, err
+	}
+	return s.getNodes(conn, peerIDs)
+}
+
+// UpdateNodePeers updates the peers lookup with the current timestamp of
+// nodes we know about. This is used as a keepalive, and to keep track of
+// which client is connected to which host.
+func (s *redisStore) UpdateNodePeers(ctx context.Context, nodeID store.NodeID, peers []string, blockNumber uint64) (inactive []store.NodeID, err error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	nodeKey := s.key("node:%s", nodeID)
+	peersKey := s.key("peers:%s", nodeID)
+	now := s.Clock.Now()
+	err = transaction(conn, func(conn redis.Conn) ([]command, error) {
+		inactive = nil
+		if _, err := conn.Do("WATCH", nodeKey, peersKey); err != nil {
+			return nil, err
+		}
+
+		// Update this node's LastSeen
+		node, err := s.getNode(conn, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		node.LastSeen = now
+		node.BlockNumber = blockNumber
+		cmds := []command{
+			cmd("HSET", nodeKey, "last_seen", unixNano(now), "block_number", blockNumber),
+		}
+		cmds = append(cmds, s.hostCommands(*node, node)...)
+
+		// Update peers
+		rawPeers, err := redis.StringMap(conn.Do("HGETALL", peersKey))
+		if err != nil {
+			return nil, err
+		}
+		nodePeers := make(map[store.NodeID]time.Time, len(rawPeers))
+		for peerID, timestamp := range rawPeers {
+			if nodePeers[store.NodeID(peerID)], err = parseTime(timestamp); err != nil {
+				return nil, err
+			}
+		}
+
+		numUpdated := 0
+		for _, peerID := range peers {
+			// Only update peers we already know about
+			if exists, err := s.hasNode(conn, store.NodeID(peerID)); err != nil {
+				return nil, err
+			} else if exists {
+				nodePeers[store.NodeID(peerID)] = now
+				numUpdated += 1
+			}
+		}
+
+		if numUpdated != len(nodePeers) {
+			inactiveDeadline := now.Add(-store.ExpireInterval)
+			for peerID, timestamp := range nodePeers {
+				if !timestamp.Before(inactiveDeadline) {
+					// Still active
+					continue
+				}
+				delete(nodePeers, peerID)
+				inactive = append(inactive, peerID)
+			}
+		}
+
+		cmds = append(cmds, cmd("HSET", nodeKey, "peer_count", len(nodePeers)))
+		cmds = append(cmds, cmd("DEL", peersKey))
+		if len(nodePeers) > 0 {
+			args := []interface{}{peersKey}
+			for peerID, timestamp := range nodePeers {
+				args = append(args, string(peerID), unixNano(timestamp))
+			}
+			cmds = append(cmds, cmd("HSET", args...))
+		}
+		return cmds, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inactive, nil
+}
+
+// SetSigningKey binds the signing key to the node.
+func (s *redisStore) SetSigningKey(ctx context.Context, nodeID store.NodeID, signerID store.NodeID) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if signerID.IsZero() {
+		_, err = conn.Do("DEL", s.key("signer:%s", nodeID))
+	} else {
+		_, err = conn.Do("SET", s.key("signer:%s", nodeID), string(signerID))
+	}
+	return err
+}
+
+// GetSigningKey returns the signing key that is bound to the node.
+func (s *redisStore) GetSigningKey(ctx context.Context, nodeID store.NodeID) (store.NodeID, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	signerID, err := redis.String(conn.Do("GET", s.key("signer:%s", nodeID)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return store.NodeID(signerID), err
+}
+
+// Stats returns aggregate statistics about the store state.
+func (s *redisStore) Stats(ctx context.Context) (*store.Stats, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stats := store.Stats{}
+	nodeKeys, err := scanKeys(conn, s.key("node:*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range nodeKeys {
+		fields, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// Removed since the scan
+			continue
+		}
+		n, err := parseNode(fields)
+		if err != nil {
+			return nil, err
+		}
+		stats.CountNode(n)
+	}
+
+	for _, pattern := range []string{s.key("balance:*"), s.key("trial:*")} {
+		balanceKeys, err := scanKeys(conn, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range balanceKeys {
+			b, ok, err := s.getBalance(conn, key)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			stats.CountBalance(b)
+		}
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// redisTesting is a wrapper that deletes all of its prefixed keys on Close().
+type redisTesting struct {
+	*redisStore
+}
+
+func (s redisTesting) Close() error {
+	defer s.redisStore.Close()
+	conn := s.pool.Get()
+	defer conn.Close()
+	keys, err := scanKeys(conn, s.prefix+"*")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := conn.Do("DEL", key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisURL returns REDIS_URL, or skips the test if it's not set.
+func redisURL(t *testing.T) string {
+	t.Helper()
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL is not set, skipping redis store tests")
+	}
+	return url
+}
+
+// OpenTest opens a store on REDIS_URL with a unique key prefix.
+func OpenTest(url string) (redisTesting, error) {
+	s, err := Open(url)
+	if err != nil {
+		return redisTesting{}, err
+	}
+	s.prefix = fmt.Sprintf("viptest:%d:", rand.Int63())
+	return redisTesting{s}, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	url := redisURL(t)
+	// Fail early if the server is unreachable
+	if s, err := Open(url); err != nil {
+		t.Fatal(err)
+	} else {
+		s.Close()
+	}
+
+	t.Run("RedisStore", func(t *testing.T) {
+		store.TestSuite(t, func() store.Store {
+			s, err := OpenTest(url)
+			if err != nil {
+				panic(err)
+			}
+			return s
+		})
+	})
+}
+
+func TestRedisNonceAtomic(t *testing.T) {
+	ctx := context.Background()
+	url := redisURL(t)
+	s, err := OpenTest(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A second store sharing the same keys, as if it was another process.
+	other, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.prefix = s.prefix
+
+	nonce := time.Now().UnixNano()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s store.NonceStore) {
+			defer wg.Done()
+			if err := s.CheckAndSaveNonce(ctx, "abc", nonce); err == nil {
+				mu.Lock()
+				accepted += 1
+				mu.Unlock()
+			} else if err != store.ErrInvalidNonce {
+				t.Error(err)
+			}
+		}([]store.NonceStore{s, other}[i%2])
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("nonce accepted %d times; want 1", accepted)
+	}
+}
+
+func TestRedisActiveHosts(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTest(redisURL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	nodes := []store.Node{
+		{ID: "geth1", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "geth2", IsHost: true, Kind: "geth", LastSeen: now},
+		{ID: "parity1", IsHost: true, Kind: "parity", LastSeen: now},
+		{ID: "stale", IsHost: true, Kind: "geth", LastSeen: now.Add(-store.ExpireInterval * 2)},
+		{ID: "client1", IsHost: false, Kind: "geth", LastSeen: now},
+	}
+	for _, n := range nodes {
+		if err := s.SetNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activeIDs := func(kind string, limit int) []string {
+		t.Helper()
+		hosts, err := s.ActiveHosts(ctx, kind, limit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := []string{}
+		for _, h := range hosts {
+			r = append(r, string(h.ID))
+		}
+		sort.Strings(r)
+		return r
+	}
+
+	if got, want := activeIDs(store.AnyKind, store.NoLimit), []string{"geth1", "geth2", "parity1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all kinds: got %q; want %q", got, want)
+	}
+	if got, want := activeIDs("geth", store.NoLimit), []string{"geth1", "geth2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("geth: got %q; want %q", got, want)
+	}
+	if got := activeIDs("geth", 1); len(got) != 1 {
+		t.Errorf("limit: got %d hosts; want 1", len(got))
+	}
+
+	// Changing a host's role moves it out of the index.
+	if err := s.SetNode(ctx, store.Node{ID: "geth2", IsHost: false, Kind: "geth", LastSeen: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveNode(ctx, "parity1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := activeIDs(store.AnyKind, store.NoLimit), []string{"geth1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reindex: got %q; want %q", got, want)
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	if n, err := redis.Int(conn.Do("ZCARD", s.key("hosts"))); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("wrong number of indexed hosts: %d", n)
+	}
+}
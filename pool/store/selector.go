@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+// HostSelector chooses which of the active candidate hosts are returned by
+// ActiveHosts, and in which order.
+type HostSelector interface {
+	// SelectHosts returns up to limit of the candidates, most preferred
+	// first. Limit is positive or NoLimit. The candidates slice can be
+	// reordered in place.
+	SelectHosts(ctx context.Context, candidates []Node, limit int) []Node
+}
+
+// SelectHosts applies the selector to the candidates, using RandomSelector if
+// selector is nil. Hosts that are full or draining are skipped, and hosts that are still
+// syncing are only selected after the hosts that are in sync. It's a helper
+// for Store implementations of ActiveHosts.
+func SelectHosts(ctx context.Context, selector HostSelector, candidates []Node, limit int) []Node {
+	if selector == nil {
+		selector = RandomSelector{}
+	}
+	synced := make([]Node, 0, len(candidates))
+	var syncing []Node
+	for _, n := range candidates {
+		if n.IsFull() || n.Draining {
+			continue
+		}
+		if n.Syncing {
+			syncing = append(syncing, n)
+		} else {
+			synced = append(synced, n)
+		}
+	}
+	r := selector.SelectHosts(ctx, synced, limit)
+	if len(syncing) == 0 || (limit != NoLimit && len(r) >= limit) {
+		return r
+	}
+	remaining := NoLimit
+	if limit != NoLimit {
+		remaining = limit - len(r)
+	}
+	return append(r, selector.SelectHosts(ctx, syncing, remaining)...)
+}
+
+// truncateHosts returns the first limit hosts.
+func truncateHosts(hosts []Node, limit int) []Node {
+	if limit == NoLimit || len(hosts) <= limit {
+		return hosts
+	}
+	return hosts[:limit]
+}
+
+// RandomSelector selects a uniformly random subset of the candidates. It's
+// the default HostSelector.
+type RandomSelector struct{}
+
+func (RandomSelector) SelectHosts(ctx context.Context, candidates []Node, limit int) []Node {
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return truncateHosts(candidates, limit)
+}
+
+// LeastPeersSelector prefers the hosts with the fewest peers, so that clients
+// are spread towards the least loaded hosts. Ties are broken by the most
+// recently seen host, then randomly.
+type LeastPeersSelector struct{}
+
+func (LeastPeersSelector) SelectHosts(ctx context.Context, candidates []Node, limit int) []Node {
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.PeerCount != b.PeerCount {
+			return a.PeerCount < b.PeerCount
+		}
+		return a.LastSeen.After(b.LastSeen)
+	})
+	return truncateHosts(candidates, limit)
+}
+
+// LeastClientsSelector prefers the hosts that the pool assigned the fewest
+// clients to, so that consecutive clients are spread round-robin across the
+// hosts. Ties are broken randomly.
+type LeastClientsSelector struct{}
+
+func (LeastClientsSelector) SelectHosts(ctx context.Context, candidates []Node, limit int) []Node {
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Clients < candidates[j].Clients
+	})
+	return truncateHosts(candidates, limit)
+}
+
+// BalanceWeightedSelector selects a random subset of the candidates, where
+// each host's chance of being chosen is weighted by the credit that it has
+// earned. Hosts without any credit still have a small chance of being
+// chosen, so that new hosts can start earning.
+type BalanceWeightedSelector struct {
+	// Balances is used to look up each host's earned credit.
+	Balances BalanceStore
+}
+
+func (s BalanceWeightedSelector) SelectHosts(ctx context.Context, candidates []Node, limit int) []Node {
+	// Weighted sampling without replacement (Efraimidis-Spirakis), using
+	// log(u)/w as the key so that large weights don't lose precision.
+	keys := make(map[NodeID]float64, len(candidates))
+	for _, n := range candidates {
+		keys[n.ID] = math.Log(rand.Float64()) / s.weight(ctx, n)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return keys[candidates[i].ID] > keys[candidates[j].ID]
+	})
+	return truncateHosts(candidates, limit)
+}
+
+// weight returns the host's earned credit, at least 1.
+func (s BalanceWeightedSelector) weight(ctx context.Context, n Node) float64 {
+	balance, err := s.Balances.GetNodeBalance(ctx, n.ID)
+	if err != nil || balance.Credit.Sign() <= 0 {
+		return 1
+	}
+	w, _ := new(big.Float).SetInt(&balance.Credit).Float64()
+	return math.Max(w, 1)
+}
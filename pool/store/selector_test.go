@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLeastPeersSelector(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	candidates := []Node{
+		{ID: "busy", PeerCount: 10, LastSeen: now},
+		{ID: "stale", PeerCount: 2, LastSeen: now.Add(-time.Minute)},
+		{ID: "fresh", PeerCount: 2, LastSeen: now},
+		{ID: "idle", PeerCount: 0, LastSeen: now},
+	}
+
+	got := nodeIDs(LeastPeersSelector{}.SelectHosts(ctx, candidates, 3))
+	if want := []string{"fresh", "idle", "stale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v; want: %v", got, want)
+	}
+
+	ordered := LeastPeersSelector{}.SelectHosts(ctx, candidates, NoLimit)
+	var order []string
+	for _, n := range ordered {
+		order = append(order, string(n.ID))
+	}
+	if want := []string{"idle", "fresh", "stale", "busy"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got order: %v; want: %v", order, want)
+	}
+}
+
+func TestLeastClientsSelector(t *testing.T) {
+	ctx := context.Background()
+	candidates := []Node{
+		{ID: "busy", Clients: 3},
+		{ID: "some", Clients: 1},
+		{ID: "idle"},
+	}
+
+	ordered := LeastClientsSelector{}.SelectHosts(ctx, candidates, 2)
+	var order []string
+	for _, n := range ordered {
+		order = append(order, string(n.ID))
+	}
+	if want := []string{"idle", "some"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got order: %v; want: %v", order, want)
+	}
+}
+
+func TestBalanceWeightedSelector(t *testing.T) {
+	ctx := context.Background()
+	s := MemoryStore()
+	for _, id := range []NodeID{"rich", "poor"} {
+		if err := s.SetNode(ctx, Node{ID: id, IsHost: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddNodeBalance(ctx, "rich", big.NewInt(1000000)); err != nil {
+		t.Fatal(err)
+	}
+
+	selector := BalanceWeightedSelector{Balances: s}
+	numRich := 0
+	for i := 0; i < 100; i++ {
+		hosts := selector.SelectHosts(ctx, []Node{{ID: "poor"}, {ID: "rich"}}, 1)
+		if len(hosts) != 1 {
+			t.Fatalf("got %d hosts; want 1", len(hosts))
+		}
+		if hosts[0].ID == "rich" {
+			numRich += 1
+		}
+	}
+	if numRich < 90 {
+		t.Errorf("rich host was selected %d/100 times; want nearly all", numRich)
+	}
+}
+
+func TestSelectHostsDefault(t *testing.T) {
+	ctx := context.Background()
+	candidates := []Node{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	if got := SelectHosts(ctx, nil, candidates, 2); len(got) != 2 {
+		t.Errorf("got %d hosts; want 2", len(got))
+	}
+	if got := SelectHosts(ctx, nil, candidates, NoLimit); len(got) != 3 {
+		t.Errorf("got %d hosts; want 3", len(got))
+	}
+}
+
+func TestSelectHostsSyncing(t *testing.T) {
+	ctx := context.Background()
+	candidates := []Node{{ID: "a", Syncing: true}, {ID: "b"}, {ID: "c", Syncing: true}, {ID: "d"}}
+	got := nodeIDs(SelectHosts(ctx, nil, candidates, 2))
+	if want := []string{"b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v; want: %v", got, want)
+	}
+
+	candidates = []Node{{ID: "a", Syncing: true}, {ID: "b"}, {ID: "c", Syncing: true}, {ID: "d"}}
+	hosts := SelectHosts(ctx, nil, candidates, 3)
+	if len(hosts) != 3 || hosts[0].Syncing || hosts[1].Syncing || !hosts[2].Syncing {
+		t.Errorf("expected syncing host last: %v", hosts)
+	}
+}
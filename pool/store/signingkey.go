@@ -0,0 +1,16 @@
+package store
+
+import "context"
+
+// SigningKeyStore is implemented by stores that can bind a separate signing
+// key to a node, so that the node's requests can be signed without access to
+// the node's p2p private key.
+type SigningKeyStore interface {
+	// SetSigningKey binds the signing key with the public key signerID to
+	// the node, replacing any previously bound signing key. An empty
+	// signerID removes the binding.
+	SetSigningKey(ctx context.Context, nodeID NodeID, signerID NodeID) error
+	// GetSigningKey returns the public key of the signing key that is bound
+	// to the node, or an empty NodeID if there is none.
+	GetSigningKey(ctx context.Context, nodeID NodeID) (NodeID, error)
+}
@@ -0,0 +1,39 @@
+package store
+
+import "time"
+
+// HostSlotStore is implemented by stores that can atomically reserve a
+// host's peer slots for clients, so that concurrent client requests don't
+// assign a host beyond its MaxPeers. Claims only hold the capacity until the
+// client shows up as a peer; the hosts that the client ends up with are
+// tracked by PoolStore.AssignHosts.
+type HostSlotStore interface {
+	// ClaimHostSlot reserves one of the host's slots for the client, if
+	// the host has capacity remaining. The host's capacity is used up by
+	// its peers and any other clients that hold a claim. Claiming again for
+	// a client that already holds a claim or is already a peer succeeds.
+	// Claims are released once the host reports the client as a peer, or
+	// after ExpireInterval.
+	ClaimHostSlot(hostID NodeID, clientID NodeID) (ok bool, err error)
+	// ReleaseHostSlot releases the client's claim on the host, such as when
+	// the host fails to whitelist the client. Releasing a claim that isn't
+	// held is not an error.
+	ReleaseHostSlot(hostID NodeID, clientID NodeID) error
+}
+
+// ClaimSlot is a helper for HostSlotStore implementations. It removes the
+// expired claims, then adds a claim for the client if the host has capacity
+// remaining. It returns whether the client holds a claim.
+func ClaimSlot(host Node, claims map[NodeID]time.Time, clientID NodeID, now time.Time) bool {
+	deadline := now.Add(-ExpireInterval)
+	for id, claimed := range claims {
+		if claimed.Before(deadline) {
+			delete(claims, id)
+		}
+	}
+	if _, ok := claims[clientID]; !ok && host.MaxPeers > 0 && host.PeerCount+len(claims) >= host.MaxPeers {
+		return false
+	}
+	claims[clientID] = now
+	return true
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// memorySnapshot is the serialized form of a memoryStore. Unexported fields
+// are skipped by gob, so everything is copied into exported fields. Balances
+// are stored by pointer because gob can't encode big.Int map values.
+type memorySnapshot struct {
+	Balances map[Account]*Balance
+	Nodes    map[NodeID]memNodeSnapshot
+	Accounts map[NodeID]Account
+	Trials   map[NodeID]*Balance
+	Nonces   map[string]int64
+	Signers  map[NodeID]NodeID
+}
+
+type memNodeSnapshot struct {
+	Node  Node
+	Peers map[NodeID]time.Time
+}
+
+// Snapshot writes the full state of the store to w.
+func (s *memoryStore) Snapshot(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := memorySnapshot{
+		Balances: make(map[Account]*Balance, len(s.balances)),
+		Nodes:    make(map[NodeID]memNodeSnapshot, len(s.nodes)),
+		Accounts: s.accounts,
+		Trials:   make(map[NodeID]*Balance, len(s.trials)),
+		Nonces:   s.nonces,
+		Signers:  s.signers,
+	}
+	for k, v := range s.balances {
+		b := v
+		snapshot.Balances[k] = &b
+	}
+	for k, v := range s.trials {
+		b := v
+		snapshot.Trials[k] = &b
+	}
+	for id, node := range s.nodes {
+		snapshot.Nodes[id] = memNodeSnapshot{
+			Node:  node.Node,
+			Peers: node.peers,
+		}
+	}
+	return gob.NewEncoder(w).Encode(&snapshot)
+}
+
+// Restore replaces the state of the store with a snapshot read from r.
+func (s *memoryStore) Restore(r io.Reader) error {
+	var snapshot memorySnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	restored := MemoryStore()
+	for k, v := range snapshot.Balances {
+		restored.balances[k] = *v
+	}
+	for k, v := range snapshot.Accounts {
+		restored.accounts[k] = v
+	}
+	for k, v := range snapshot.Trials {
+		restored.trials[k] = *v
+	}
+	for k, v := range snapshot.Nonces {
+		restored.nonces[k] = v
+	}
+	for k, v := range snapshot.Signers {
+		restored.signers[k] = v
+	}
+	for id, node := range snapshot.Nodes {
+		peers := node.Peers
+		if peers == nil {
+			peers = map[NodeID]time.Time{}
+		}
+		restored.nodes[id] = memNode{Node: node.Node, peers: peers}
+		restored.indexHost(node.Node)
+	}
+	// The sets of assigned clients are rebuilt from the clients' assigned
+	// hosts.
+	for id, node := range restored.nodes {
+		if node.IsHost {
+			node.Clients = 0
+			restored.nodes[id] = node
+		}
+	}
+	for id, node := range restored.nodes {
+		for _, hostID := range node.AssignedHosts {
+			host, ok := restored.nodes[hostID]
+			if !ok {
+				continue
+			}
+			if host.clients == nil {
+				host.clients = map[NodeID]struct{}{}
+			}
+			host.clients[id] = struct{}{}
+			host.Clients = len(host.clients)
+			restored.nodes[hostID] = host
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances = restored.balances
+	s.nodes = restored.nodes
+	s.hosts = restored.hosts
+	s.accounts = restored.accounts
+	s.trials = restored.trials
+	s.nonces = restored.nonces
+	s.signers = restored.signers
+	return nil
+}
+
+// SnapshotFile writes a snapshot of the store to path. The snapshot is
+// written to a temporary file first, so an interrupted write does not
+// clobber the previous snapshot.
+func (s *memoryStore) SnapshotFile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := s.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RestoreFile restores the store from a snapshot at path. It is not an error
+// if the snapshot does not exist yet.
+func (s *memoryStore) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
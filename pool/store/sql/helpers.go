@@ -0,0 +1,164 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// transaction runs fn within a transaction, which is committed if fn returns
+// nil and rolled back otherwise.
+func transaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// unixNano returns the timestamp as nanoseconds, with zero for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func parseTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func parseBig(s string, into *big.Int) error {
+	if s == "" || s == "0" {
+		// Leave the zero value as-is
+		return nil
+	}
+	if _, ok := into.SetString(s, 10); !ok {
+		return fmt.Errorf("sql: invalid integer value: %q", s)
+	}
+	return nil
+}
+
+// nodeColumns are the columns of the nodes table in the order that they are
+// scanned by scanNode.
+const nodeColumns = `id, uri, last_seen, kind, is_host, payout, block_number, alt_uris, instance, peer_count, max_peers, syncing, version, clients, assigned_hosts, draining`
+
+// nodeArgs returns the node's values in the order of nodeColumns.
+func nodeArgs(n store.Node) []interface{} {
+	return []interface{}{
+		string(n.ID),
+		n.URI,
+		unixNano(n.LastSeen),
+		n.Kind,
+		n.IsHost,
+		string(n.Payout),
+		int64(n.BlockNumber),
+		strings.Join(n.AltURIs, " "),
+		n.Instance,
+		n.PeerCount,
+		n.MaxPeers,
+		n.Syncing,
+		n.Version,
+		n.Clients,
+		joinNodeIDs(n.AssignedHosts),
+		n.Draining,
+	}
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNode scans a row of nodeColumns.
+func scanNode(row scanner) (store.Node, error) {
+	var n store.Node
+	var id, payout, altURIs, assignedHosts string
+	var lastSeen, blockNumber int64
+	err := row.Scan(&id, &n.URI, &lastSeen, &n.Kind, &n.IsHost, &payout, &blockNumber, &altURIs, &n.Instance, &n.PeerCount, &n.MaxPeers, &n.Syncing, &n.Version, &n.Clients, &assignedHosts, &n.Draining)
+	if err != nil {
+		return n, err
+	}
+	n.ID = store.NodeID(id)
+	n.Payout = store.Account(payout)
+	n.LastSeen = parseTime(lastSeen)
+	n.BlockNumber = uint64(blockNumber)
+	if altURIs != "" {
+		n.AltURIs = strings.Fields(altURIs)
+	}
+	n.AssignedHosts = splitNodeIDs(assignedHosts)
+	return n, nil
+}
+
+// joinNodeIDs returns the IDs separated by spaces.
+func joinNodeIDs(ids []store.NodeID) string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, string(id))
+	}
+	return strings.Join(s, " ")
+}
+
+// splitNodeIDs parses the IDs of joinNodeIDs, returning nil if there are
+// none.
+func splitNodeIDs(s string) []store.NodeID {
+	var ids []store.NodeID
+	for _, id := range strings.Fields(s) {
+		ids = append(ids, store.NodeID(id))
+	}
+	return ids
+}
+
+// queryNodeIDs returns the node IDs of the query's single column rows.
+func queryNodeIDs(ctx context.Context, q querier, query string, args ...interface{}) ([]store.NodeID, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var r []store.NodeID
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		r = append(r, store.NodeID(id))
+	}
+	return r, rows.Err()
+}
+
+// scanNodes scans every row of nodeColumns and closes the rows.
+func scanNodes(rows *sql.Rows, err error) ([]store.Node, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	r := []store.Node{}
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, n)
+	}
+	return r, rows.Err()
+}
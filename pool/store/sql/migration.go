@@ -0,0 +1,152 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// migrations are the statements that upgrade the schema from each version to
+// the next: migrations[0] upgrades an empty database to version 1. Applied
+// migrations must not be changed, add a new version instead.
+//
+// The schema sticks to plain Postgres types. Timestamps are unix nanoseconds
+// with zero for the zero time, and balances are NUMERIC so that wei-scale
+// credit can be added atomically in SQL.
+var migrations = [][]string{
+	// Version 0 -> 1
+	{
+		`CREATE TABLE nonces (
+			id TEXT PRIMARY KEY,
+			nonce BIGINT NOT NULL
+		)`,
+		`CREATE TABLE nodes (
+			id TEXT PRIMARY KEY,
+			uri TEXT NOT NULL DEFAULT '',
+			last_seen BIGINT NOT NULL DEFAULT 0,
+			kind TEXT NOT NULL DEFAULT '',
+			is_host BOOLEAN NOT NULL DEFAULT FALSE,
+			payout TEXT NOT NULL DEFAULT '',
+			block_number BIGINT NOT NULL DEFAULT 0,
+			alt_uris TEXT NOT NULL DEFAULT '',
+			instance TEXT NOT NULL DEFAULT '',
+			peer_count INTEGER NOT NULL DEFAULT 0,
+			max_peers INTEGER NOT NULL DEFAULT 0,
+			syncing BOOLEAN NOT NULL DEFAULT FALSE,
+			version TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX nodes_active_hosts ON nodes (is_host, kind, last_seen) WHERE is_host`,
+		`CREATE TABLE peers (
+			node_id TEXT NOT NULL,
+			peer_id TEXT NOT NULL,
+			last_seen BIGINT NOT NULL,
+			PRIMARY KEY (node_id, peer_id)
+		)`,
+		`CREATE TABLE spenders (
+			node_id TEXT PRIMARY KEY,
+			account TEXT NOT NULL
+		)`,
+		`CREATE INDEX spenders_account ON spenders (account)`,
+		`CREATE TABLE balances (
+			account TEXT PRIMARY KEY,
+			credit NUMERIC(78, 0) NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE trial_balances (
+			node_id TEXT PRIMARY KEY,
+			credit NUMERIC(78, 0) NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE signing_keys (
+			node_id TEXT PRIMARY KEY,
+			signer_id TEXT NOT NULL
+		)`,
+	},
+
+	// Version 1 -> 2 (added client assignments of hosts)
+	{
+		`ALTER TABLE nodes ADD COLUMN clients INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE nodes ADD COLUMN assigned_hosts TEXT NOT NULL DEFAULT ''`,
+	},
+
+	// Version 2 -> 3 (added draining hosts)
+	{
+		`ALTER TABLE nodes ADD COLUMN draining BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+
+	// Version 3 -> 4 (added the clients assigned to each host)
+	{
+		`CREATE TABLE host_clients (
+			host_id TEXT NOT NULL,
+			client_id TEXT NOT NULL,
+			PRIMARY KEY (host_id, client_id)
+		)`,
+		`CREATE INDEX host_clients_client ON host_clients (client_id)`,
+		// Counts from before the assignments were tracked can't be released.
+		`UPDATE nodes SET clients = 0, assigned_hosts = ''`,
+	},
+
+	// Version 4 -> 5 (added trial grants)
+	{
+		`ALTER TABLE trial_balances ADD COLUMN granted BOOLEAN NOT NULL DEFAULT FALSE`,
+		// Trial balances from before grants were tracked were most likely
+		// granted, don't grant them again.
+		`UPDATE trial_balances SET granted = TRUE`,
+	},
+}
+
+// dbVersion is the schema version after all of the migrations are applied.
+var dbVersion = len(migrations)
+
+// Migrate upgrades the database schema to the latest version that we know
+// of. The schema version is tracked in the schema_version table. All of the
+// pending migrations are applied in a single transaction.
+func Migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow(`SELECT version FROM schema_version`).Scan(&version)
+	if err == sql.ErrNoRows {
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (0)`); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if version == dbVersion {
+		// No need to migrate
+		return nil
+	}
+	if version > dbVersion {
+		return MigrationError{OldVersion: version, NewVersion: dbVersion, Cause: errors.New("database is newer than the supported version")}
+	}
+	for v := version; v < dbVersion; v++ {
+		for _, stmt := range migrations[v] {
+			if _, err := tx.Exec(stmt); err != nil {
+				return MigrationError{OldVersion: v, NewVersion: dbVersion, Cause: err}
+			}
+		}
+	}
+	if _, err := tx.Exec(`UPDATE schema_version SET version = $1`, dbVersion); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrationError is returned when the database schema fails to migrate to
+// the latest version.
+type MigrationError struct {
+	OldVersion int
+	NewVersion int
+	Cause      error
+}
+
+func (err MigrationError) Error() string {
+	return fmt.Sprintf("sql database migration error: Failed to migrate from version %d to %d: %s", err.OldVersion, err.NewVersion, err.Cause)
+}
@@ -0,0 +1,711 @@
+// Package sql implements store.Store on top of a Postgres database, using
+// the github.com/lib/pq driver.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/vipnode/vipnode/pool/clock"
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// Tables are laid out like the redis store's keys:
+//
+//   nonces          highest nonce of each ID
+//   nodes           node fields, with a partial index of the active hosts
+//   peers           last seen (unix nanoseconds) of each node's peers
+//   host_clients    clients assigned to each host
+//   spenders        account that each node spends from
+//   balances        balance of each account
+//   trial_balances  balance of each node without an account
+//   signing_keys    signing key that is bound to each node
+//
+// See migrations for the schema.
+
+// Open returns a store.Store implementation using a Postgres database as the
+// storage driver, migrating its schema to the latest version. The
+// dataSourceName is a postgres:// URL or lib/pq connection string. The store
+// should be (*sqlStore).Close()'d after use.
+func Open(dataSourceName string) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Confirm that we can connect
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &sqlStore{
+		db:          db,
+		nonceExpire: store.ExpireNonce,
+		Clock:       clock.Real(),
+	}
+	return s, nil
+}
+
+var _ store.Store = &sqlStore{}
+var _ store.SigningKeyStore = &sqlStore{}
+var _ store.BatchBalanceStore = &sqlStore{}
+var _ store.AccountScanStore = &sqlStore{}
+
+type sqlStore struct {
+	db *sql.DB
+
+	nonceExpire time.Duration
+
+	// Clock is used for nonce expiry and node activity. It should not be
+	// changed after the store is in use.
+	Clock clock.Clock
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// CheckAndSaveNonce asserts that this is the highest nonce seen for this ID.
+// The nonce is compared and saved in a single upsert, so concurrent requests
+// can't both succeed with the same nonce.
+func (s *sqlStore) CheckAndSaveNonce(ctx context.Context, ID string, nonce int64) error {
+	now := s.Clock.Now()
+	if nonce > now.Add(store.MaxNonceAhead).UnixNano() {
+		return store.ErrNonceOutOfRange
+	}
+	// If nonceExpire is set, nonce should be within nonceExpire of now.
+	if s.nonceExpire > 0 && nonce <= now.Add(-s.nonceExpire).UnixNano() {
+		// Nonce is too old
+		return store.ErrInvalidNonce
+	}
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO nonces (id, nonce) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET nonce = excluded.nonce
+		WHERE nonces.nonce < excluded.nonce`, ID, nonce)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrInvalidNonce
+	}
+	return nil
+}
+
+// PurgeNonces removes saved nonces that are older than the cutoff.
+func (s *sqlStore) PurgeNonces(ctx context.Context, olderThan time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM nonces WHERE nonce < $1`, olderThan.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func hasNode(ctx context.Context, q querier, nodeID store.NodeID) (bool, error) {
+	var id string
+	err := q.QueryRowContext(ctx, `SELECT id FROM nodes WHERE id = $1`, string(nodeID)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// spenderAccount returns the account that the node spends from, if any.
+func spenderAccount(ctx context.Context, q querier, nodeID store.NodeID) (store.Account, bool, error) {
+	var account string
+	err := q.QueryRowContext(ctx, `SELECT account FROM spenders WHERE node_id = $1`, string(nodeID)).Scan(&account)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return store.Account(account), true, nil
+}
+
+// getCredit scans a single credit value, returning false if there is none.
+func getCredit(ctx context.Context, q querier, query string, args ...interface{}) (*big.Int, bool, error) {
+	var credit string
+	err := q.QueryRowContext(ctx, query, args...).Scan(&credit)
+	if err == sql.ErrNoRows {
+		return new(big.Int), false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	r := new(big.Int)
+	return r, true, parseBig(credit, r)
+}
+
+// GetNodeBalance returns the current account balance for a node.
+func (s *sqlStore) GetNodeBalance(ctx context.Context, nodeID store.NodeID) (store.Balance, error) {
+	account, ok, err := spenderAccount(ctx, s.db, nodeID)
+	if err != nil {
+		return store.Balance{}, err
+	}
+	if ok {
+		return s.GetAccountBalance(ctx, account)
+	}
+
+	// No spendable account, use the trial balance
+	var r store.Balance
+	var credit string
+	err = s.db.QueryRowContext(ctx, `SELECT credit, granted FROM trial_balances WHERE node_id = $1`, string(nodeID)).Scan(&credit, &r.TrialGranted)
+	if err == nil {
+		return r, parseBig(credit, &r.Credit)
+	} else if err != sql.ErrNoRows {
+		return r, err
+	}
+	if exists, err := hasNode(ctx, s.db, nodeID); err != nil {
+		return r, err
+	} else if !exists {
+		return r, store.ErrUnregisteredNode
+	}
+	return r, nil
+}
+
+func addAccountBalance(ctx context.Context, q querier, account store.Account, credit *big.Int) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO balances (account, credit) VALUES ($1, $2)
+		ON CONFLICT (account) DO UPDATE SET credit = balances.credit + excluded.credit`,
+		string(account), credit.String())
+	return err
+}
+
+func addNodeBalance(ctx context.Context, q querier, nodeID store.NodeID, credit *big.Int) error {
+	account, ok, err := spenderAccount(ctx, q, nodeID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return addAccountBalance(ctx, q, account, credit)
+	}
+
+	res, err := q.ExecContext(ctx, `UPDATE trial_balances SET credit = credit + $2 WHERE node_id = $1`, string(nodeID), credit.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// No balance = empty balance, if the node is registered
+	if exists, err := hasNode(ctx, q, nodeID); err != nil {
+		return err
+	} else if !exists {
+		return store.ErrUnregisteredNode
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO trial_balances (node_id, credit) VALUES ($1, $2)
+		ON CONFLICT (node_id) DO UPDATE SET credit = trial_balances.credit + excluded.credit`,
+		string(nodeID), credit.String())
+	return err
+}
+
+// AddNodeBalance adds some credit amount to a node's account balance. (Can be negative)
+// If only a node is provided which doesn't have an account registered to
+// it, it should retain a balance, such as through temporary trial accounts
+// that get migrated later.
+func (s *sqlStore) AddNodeBalance(ctx context.Context, nodeID store.NodeID, credit *big.Int) error {
+	return transaction(ctx, s.db, func(tx *sql.Tx) error {
+		return addNodeBalance(ctx, tx, nodeID, credit)
+	})
+}
+
+// AddNodeBalances adds each credit to its node's balance in a single
+// transaction.
+func (s *sqlStore) AddNodeBalances(ctx context.Context, credits map[store.NodeID]*big.Int) error {
+	return transaction(ctx, s.db, func(tx *sql.Tx) error {
+		for nodeID, credit := range credits {
+			if err := addNodeBalance(ctx, tx, nodeID, credit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GrantTrialCredit adds credit to the trial balance of a node without an
+// account, unless it was granted before.
+func (s *sqlStore) GrantTrialCredit(ctx context.Context, nodeID store.NodeID, credit *big.Int) (bool, error) {
+	granted := false
+	err := transaction(ctx, s.db, func(tx *sql.Tx) error {
+		granted = false
+		if exists, err := hasNode(ctx, tx, nodeID); err != nil {
+			return err
+		} else if !exists {
+			return store.ErrUnregisteredNode
+		}
+		if _, ok, err := spenderAccount(ctx, tx, nodeID); err != nil || ok {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO trial_balances (node_id, credit, granted) VALUES ($1, $2, TRUE)
+			ON CONFLICT (node_id) DO UPDATE SET credit = trial_balances.credit + excluded.credit, granted = TRUE
+			WHERE NOT trial_balances.granted`,
+			string(nodeID), credit.String())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		granted = n > 0
+		return err
+	})
+	return granted, err
+}
+
+// GetAccountBalance returns an account's balance.
+func (s *sqlStore) GetAccountBalance(ctx context.Context, account store.Account) (store.Balance, error) {
+	// Default to empty balance
+	credit, _, err := getCredit(ctx, s.db, `SELECT credit FROM balances WHERE account = $1`, string(account))
+	if err != nil {
+		return store.Balance{}, err
+	}
+	return store.Balance{Account: account, Credit: *credit}, nil
+}
+
+// AddNodeBalance adds credit to an account balance. (Can be negative)
+func (s *sqlStore) AddAccountBalance(ctx context.Context, account store.Account, credit *big.Int) error {
+	return addAccountBalance(ctx, s.db, account, credit)
+}
+
+// AccountsAbove returns the accounts whose balance credit is greater than
+// threshold, sorted.
+func (s *sqlStore) AccountsAbove(ctx context.Context, threshold *big.Int) ([]store.Account, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT account FROM balances WHERE credit > $1 ORDER BY account`, threshold.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	r := []store.Account{}
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err != nil {
+			return nil, err
+		}
+		r = append(r, store.Account(account))
+	}
+	return r, rows.Err()
+}
+
+// AddAccountNode authorizes a nodeID to be a spender of an account's
+// balance. This should migrate any existing node's balance credit to the
+// account.
+func (s *sqlStore) AddAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
+	return transaction(ctx, s.db, func(tx *sql.Tx) error {
+		// Check nodeID
+		if exists, err := hasNode(ctx, tx, nodeID); err != nil {
+			return err
+		} else if !exists {
+			return store.ErrUnregisteredNode
+		}
+
+		// Load trial balance to migrate
+		trialCredit, _, err := getCredit(ctx, tx, `SELECT credit FROM trial_balances WHERE node_id = $1`, string(nodeID))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM trial_balances WHERE node_id = $1`, string(nodeID)); err != nil {
+			return err
+		}
+
+		// Authorize node, replacing any previous account, and merge trial
+		if _, err := tx.ExecContext(ctx, `INSERT INTO spenders (node_id, account) VALUES ($1, $2)
+			ON CONFLICT (node_id) DO UPDATE SET account = excluded.account`,
+			string(nodeID), string(account)); err != nil {
+			return err
+		}
+		return addAccountBalance(ctx, tx, account, trialCredit)
+	})
+}
+
+// IsAccountNode returns nil if node is a valid spender of the given
+// account.
+func (s *sqlStore) IsAccountNode(ctx context.Context, account store.Account, nodeID store.NodeID) error {
+	nodeAccount, ok, err := spenderAccount(ctx, s.db, nodeID)
+	if err != nil {
+		return err
+	}
+	if !ok || nodeAccount != account {
+		return store.ErrNotAuthorized
+	}
+	return nil
+}
+
+// GetAccountNodes returns the authorized nodeIDs for this account, these are
+// nodes that were added to accounts through AddAccountNode.
+func (s *sqlStore) GetAccountNodes(ctx context.Context, account store.Account) ([]store.NodeID, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT node_id FROM spenders WHERE account = $1 ORDER BY node_id`, string(account))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var r []store.NodeID
+	for rows.Next() {
+		var nodeID string
+		if err := rows.Scan(&nodeID); err != nil {
+			return nil, err
+		}
+		r = append(r, store.NodeID(nodeID))
+	}
+	return r, rows.Err()
+}
+
+// GetNode returns the node from the set of active nodes.
+func (s *sqlStore) GetNode(ctx context.Context, nodeID store.NodeID) (*store.Node, error) {
+	n, err := scanNode(s.db.QueryRowContext(ctx, `SELECT `+nodeColumns+` FROM nodes WHERE id = $1`, string(nodeID)))
+	if err == sql.ErrNoRows {
+		return nil, store.ErrUnregisteredNode
+	} else if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// SetNode saves a node.
+func (s *sqlStore) SetNode(ctx context.Context, n store.Node) error {
+	if n.ID == "" {
+		return store.ErrMalformedNode
+	}
+	// Assignments are only changed by AssignHosts, so they're left out of
+	// the update and start empty for a new node.
+	n.Clients, n.AssignedHosts = 0, nil
+	_, err := s.db.ExecContext(ctx, `INSERT INTO nodes (`+nodeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (id) DO UPDATE SET
+			uri = excluded.uri,
+			last_seen = excluded.last_seen,
+			kind = excluded.kind,
+			is_host = excluded.is_host,
+			payout = excluded.payout,
+			block_number = excluded.block_number,
+			alt_uris = excluded.alt_uris,
+			instance = excluded.instance,
+			peer_count = excluded.peer_count,
+			max_peers = excluded.max_peers,
+			syncing = excluded.syncing,
+			version = excluded.version,
+			draining = excluded.draining`,
+		nodeArgs(n)...)
+	return err
+}
+
+// RemoveNode removes a node and its peers. Balances are retained.
+func (s *sqlStore) RemoveNode(ctx context.Context, nodeID store.NodeID) error {
+	return transaction(ctx, s.db, func(tx *sql.Tx) error {
+		return removeNode(ctx, tx, nodeID)
+	})
+}
+
+// ExpireNodes removes the nodes that haven't been seen since before.
+func (s *sqlStore) ExpireNodes(ctx context.Context, before time.Time) ([]store.NodeID, error) {
+	var expired []store.NodeID
+	err := transaction(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		expired, err = queryNodeIDs(ctx, tx, `SELECT id FROM nodes WHERE last_seen < $1 FOR UPDATE`, unixNano(before))
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := removeNode(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// removeNode deletes the node with its peers and assignments.
+func removeNode(ctx context.Context, q querier, nodeID store.NodeID) error {
+	if err := assignHosts(ctx, q, nodeID, nil); err != nil {
+		return err
+	}
+	if err := unassignHost(ctx, q, nodeID); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM peers WHERE node_id = $1`, string(nodeID)); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `DELETE FROM nodes WHERE id = $1`, string(nodeID))
+	return err
+}
+
+// AssignHosts replaces the client's assigned hosts, and updates the Clients
+// of the hosts that were added or removed.
+func (s *sqlStore) AssignHosts(ctx context.Context, clientID store.NodeID, hostIDs []store.NodeID) error {
+	return transaction(ctx, s.db, func(tx *sql.Tx) error {
+		// Lock the client's row until the transaction is done, so that
+		// concurrent assignments of the same client don't interleave.
+		var id string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM nodes WHERE id = $1 FOR UPDATE`, string(clientID)).Scan(&id)
+		if err == sql.ErrNoRows {
+			return store.ErrUnregisteredNode
+		} else if err != nil {
+			return err
+		}
+		return assignHosts(ctx, tx, clientID, hostIDs)
+	})
+}
+
+// assignHosts replaces the client's rows in host_clients with hostIDs. The
+// hosts' clients are incremented and decremented in place, rather than
+// counted, so that concurrent assignments to the same host don't miss each
+// other.
+func assignHosts(ctx context.Context, q querier, clientID store.NodeID, hostIDs []store.NodeID) error {
+	released, err := queryNodeIDs(ctx, q, `DELETE FROM host_clients WHERE client_id = $1 RETURNING host_id`, string(clientID))
+	if err != nil {
+		return err
+	}
+	for _, hostID := range released {
+		if _, err := q.ExecContext(ctx, `UPDATE nodes SET clients = clients - 1 WHERE id = $1`, string(hostID)); err != nil {
+			return err
+		}
+	}
+
+	var assigned []store.NodeID
+	for _, hostID := range hostIDs {
+		res, err := q.ExecContext(ctx, `INSERT INTO host_clients (host_id, client_id)
+			SELECT id, $2 FROM nodes WHERE id = $1
+			ON CONFLICT DO NOTHING`, string(hostID), string(clientID))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			// Unregistered or already assigned
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `UPDATE nodes SET clients = clients + 1 WHERE id = $1`, string(hostID)); err != nil {
+			return err
+		}
+		assigned = append(assigned, hostID)
+	}
+	_, err = q.ExecContext(ctx, `UPDATE nodes SET assigned_hosts = $2 WHERE id = $1`, string(clientID), joinNodeIDs(assigned))
+	return err
+}
+
+// unassignHost removes the host from the assigned hosts of its clients.
+func unassignHost(ctx context.Context, q querier, hostID store.NodeID) error {
+	clientIDs, err := queryNodeIDs(ctx, q, `DELETE FROM host_clients WHERE host_id = $1 RETURNING client_id`, string(hostID))
+	if err != nil {
+		return err
+	}
+	for _, clientID := range clientIDs {
+		var assignedHosts string
+		err := q.QueryRowContext(ctx, `SELECT assigned_hosts FROM nodes WHERE id = $1 FOR UPDATE`, string(clientID)).Scan(&assignedHosts)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var assigned []store.NodeID
+		for _, id := range splitNodeIDs(assignedHosts) {
+			if id != hostID {
+				assigned = append(assigned, id)
+			}
+		}
+		if _, err := q.ExecContext(ctx, `UPDATE nodes SET assigned_hosts = $2 WHERE id = $1`, string(clientID), joinNodeIDs(assigned)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ActiveHosts loads the hosts of kind that were seen recently, then return a
+// valid subset of size limit chosen by the selector. The query is served by
+// the nodes_active_hosts partial index.
+func (s *sqlStore) ActiveHosts(ctx context.Context, kind string, limit int, selector store.HostSelector) ([]store.Node, error) {
+	if err := store.CheckHostQuery(kind, limit); err != nil {
+		return nil, err
+	}
+
+	seenSince := s.Clock.Now().Add(-store.ExpireInterval).UnixNano()
+	query := `SELECT ` + nodeColumns + ` FROM nodes WHERE is_host AND last_seen > $1`
+	args := []interface{}{seenSince}
+	if kind != store.AnyKind {
+		query += ` AND kind = $2`
+		args = append(args, kind)
+	}
+	hosts, err := scanNodes(s.db.QueryContext(ctx, query, args...))
+	if err != nil {
+		return nil, err
+	}
+	return store.SelectHosts(ctx, selector, hosts, limit), nil
+}
+
+// AllNodes returns every node that the store knows about.
+func (s *sqlStore) AllNodes(ctx context.Context) ([]store.Node, error) {
+	return scanNodes(s.db.QueryContext(ctx, `SELECT `+nodeColumns+` FROM nodes`))
+}
+
+// NodePeers returns a list of active connected peers that this pool knows
+// about for this NodeID.
+func (s *sqlStore) NodePeers(ctx context.Context, nodeID store.NodeID) ([]store.Node, error) {
+	if exists, err := hasNode(ctx, s.db, nodeID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, store.ErrUnregisteredNode
+	}
+	// Peers that are no longer registered are skipped.
+	return scanNodes(s.db.QueryContext(ctx, `SELECT `+nodeColumns+` FROM nodes
+		WHERE id IN (SELECT peer_id FROM peers WHERE node_id = $1)`, string(nodeID)))
+}
+
+// UpdateNodePeers updates the peers lookup with the current timestamp of
+// nodes we know about. This is used as a keepalive, and to keep track of
+// which client is connected to which host.
+func (s *sqlStore) UpdateNodePeers(ctx context.Context, nodeID store.NodeID, peers []string, blockNumber uint64) (inactive []store.NodeID, err error) {
+	now := s.Clock.Now()
+	err = transaction(ctx, s.db, func(tx *sql.Tx) error {
+		inactive = nil
+
+		// Update this node's LastSeen first, which also locks the node's row
+		// until the transaction is done so that concurrent updates of the
+		// same node don't interleave.
+		res, err := tx.ExecContext(ctx, `UPDATE nodes SET last_seen = $2, block_number = $3 WHERE id = $1`,
+			string(nodeID), unixNano(now), int64(blockNumber))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return store.ErrUnregisteredNode
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT peer_id, last_seen FROM peers WHERE node_id = $1`, string(nodeID))
+		if err != nil {
+			return err
+		}
+		nodePeers := map[store.NodeID]time.Time{}
+		for rows.Next() {
+			var peerID string
+			var lastSeen int64
+			if err := rows.Scan(&peerID, &lastSeen); err != nil {
+				rows.Close()
+				return err
+			}
+			nodePeers[store.NodeID(peerID)] = parseTime(lastSeen)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		numUpdated := 0
+		for _, peerID := range peers {
+			// Only update peers we already know about
+			res, err := tx.ExecContext(ctx, `INSERT INTO peers (node_id, peer_id, last_seen)
+				SELECT $1, id, $3 FROM nodes WHERE id = $2
+				ON CONFLICT (node_id, peer_id) DO UPDATE SET last_seen = excluded.last_seen`,
+				string(nodeID), peerID, unixNano(now))
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n > 0 {
+				nodePeers[store.NodeID(peerID)] = now
+				numUpdated += 1
+			}
+		}
+
+		if numUpdated != len(nodePeers) {
+			inactiveDeadline := now.Add(-store.ExpireInterval)
+			for peerID, timestamp := range nodePeers {
+				if !timestamp.Before(inactiveDeadline) {
+					// Still active
+					continue
+				}
+				if _, err := tx.ExecContext(ctx, `DELETE FROM peers WHERE node_id = $1 AND peer_id = $2`, string(nodeID), string(peerID)); err != nil {
+					return err
+				}
+				delete(nodePeers, peerID)
+				inactive = append(inactive, peerID)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE nodes SET peer_count = $2 WHERE id = $1`, string(nodeID), len(nodePeers))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inactive, nil
+}
+
+// SetSigningKey binds the signing key to the node.
+func (s *sqlStore) SetSigningKey(ctx context.Context, nodeID store.NodeID, signerID store.NodeID) error {
+	var err error
+	if signerID.IsZero() {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM signing_keys WHERE node_id = $1`, string(nodeID))
+	} else {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO signing_keys (node_id, signer_id) VALUES ($1, $2)
+			ON CONFLICT (node_id) DO UPDATE SET signer_id = excluded.signer_id`,
+			string(nodeID), string(signerID))
+	}
+	return err
+}
+
+// GetSigningKey returns the signing key that is bound to the node.
+func (s *sqlStore) GetSigningKey(ctx context.Context, nodeID store.NodeID) (store.NodeID, error) {
+	var signerID string
+	err := s.db.QueryRowContext(ctx, `SELECT signer_id FROM signing_keys WHERE node_id = $1`, string(nodeID)).Scan(&signerID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return store.NodeID(signerID), err
+}
+
+// Stats returns aggregate statistics about the store state.
+func (s *sqlStore) Stats(ctx context.Context) (*store.Stats, error) {
+	stats := store.Stats{}
+	nodes, err := s.AllNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		stats.CountNode(n)
+	}
+
+	for _, query := range []string{
+		`SELECT account, credit FROM balances`,
+		`SELECT '', credit FROM trial_balances`,
+	} {
+		if err := s.countBalances(ctx, &stats, query); err != nil {
+			return nil, err
+		}
+	}
+	return &stats, nil
+}
+
+// countBalances adds the balances of the query's (account, credit) rows to
+// the stats.
+func (s *sqlStore) countBalances(ctx context.Context, stats *store.Stats, query string) error {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account, credit string
+		if err := rows.Scan(&account, &credit); err != nil {
+			return err
+		}
+		b := store.Balance{Account: store.Account(account)}
+		if err := parseBig(credit, &b.Credit); err != nil {
+			return err
+		}
+		stats.CountBalance(b)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,111 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// sqlTesting is a wrapper that deletes all of its rows on Close().
+type sqlTesting struct {
+	*sqlStore
+}
+
+func (s sqlTesting) Close() error {
+	defer s.sqlStore.Close()
+	return clearTables(s.db)
+}
+
+func clearTables(db *sql.DB) error {
+	for _, table := range []string{"nonces", "nodes", "peers", "host_clients", "spenders", "balances", "trial_balances", "signing_keys"} {
+		if _, err := db.Exec(`DELETE FROM ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// databaseURL returns DATABASE_URL, or skips the test if it's not set. The
+// tests delete all of the rows in the database, so it should be a scratch
+// database.
+func databaseURL(t *testing.T) string {
+	t.Helper()
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL is not set, skipping sql store tests")
+	}
+	return url
+}
+
+// OpenTest opens a store on DATABASE_URL with empty tables.
+func OpenTest(url string) (sqlTesting, error) {
+	s, err := Open(url)
+	if err != nil {
+		return sqlTesting{}, err
+	}
+	if err := clearTables(s.db); err != nil {
+		s.Close()
+		return sqlTesting{}, err
+	}
+	return sqlTesting{s}, nil
+}
+
+func TestSQLStore(t *testing.T) {
+	url := databaseURL(t)
+	// Fail early if the server is unreachable, and check that migrating an
+	// up to date database is a no-op.
+	for i := 0; i < 2; i++ {
+		if s, err := Open(url); err != nil {
+			t.Fatal(err)
+		} else {
+			s.Close()
+		}
+	}
+
+	t.Run("SQLStore", func(t *testing.T) {
+		store.TestSuite(t, func() store.Store {
+			s, err := OpenTest(url)
+			if err != nil {
+				panic(err)
+			}
+			return s
+		})
+	})
+}
+
+func TestSQLNonceAtomic(t *testing.T) {
+	ctx := context.Background()
+	s, err := OpenTest(databaseURL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	nonce := time.Now().UnixNano()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.CheckAndSaveNonce(ctx, "abc", nonce); err == nil {
+				mu.Lock()
+				accepted += 1
+				mu.Unlock()
+			} else if err != store.ErrInvalidNonce {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("nonce accepted %d times; want 1", accepted)
+	}
+}
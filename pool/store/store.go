@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"time"
@@ -12,11 +14,20 @@ const KeepaliveInterval = 60 * time.Second
 
 const ExpireInterval = KeepaliveInterval * 2
 
+// ExpireNodeInterval is how long a node can go without updating before it's
+// removed from the store by ExpireNodes.
+const ExpireNodeInterval = 24 * time.Hour
+
 // ExpireNonce as non-zero forces nonces to be nanosecond unix timestamps
 // within 15 minutes of now. This allows us to discard old nonces more
 // aggressively. Skewed clocks will get invalid nonce errors.
 const ExpireNonce = 15 * time.Minute
 
+// MaxNonceAhead is how far ahead of the store's clock a nonce can be. Nonces
+// are nanosecond unix timestamps, so a nonce far in the future would lock
+// the node out until the clock caught up with it.
+const MaxNonceAhead = 5 * time.Minute
+
 // FIXME: placeholder types, replace with go-ethereum types
 
 type Account string // TODO: Switch to common.Address?
@@ -40,6 +51,9 @@ type Balance struct {
 	Deposit      big.Int   `json:"deposit"`
 	Credit       big.Int   `json:"credit"`
 	NextWithdraw time.Time `json:"next_withdraw,omitempty"`
+	// TrialGranted is set once a node without an account has been granted
+	// trial credit with GrantTrialCredit.
+	TrialGranted bool `json:"trial_granted,omitempty"`
 }
 
 func (b *Balance) String() string {
@@ -51,6 +65,54 @@ func (b *Balance) String() string {
 	return fmt.Sprintf("Balance(%q, %s)", account, total)
 }
 
+// MarshalJSON encodes the credit and deposit as decimal strings, since
+// wei-scale values overflow the float64 numbers that many JSON decoders use.
+func (b Balance) MarshalJSON() ([]byte, error) {
+	type plain Balance
+	return json.Marshal(struct {
+		plain
+		Deposit bigDecimal `json:"deposit"`
+		Credit  bigDecimal `json:"credit"`
+	}{plain(b), bigDecimal{&b.Deposit}, bigDecimal{&b.Credit}})
+}
+
+// UnmarshalJSON decodes the credit and deposit from either decimal strings
+// or numbers.
+func (b *Balance) UnmarshalJSON(data []byte) error {
+	type plain Balance
+	return json.Unmarshal(data, &struct {
+		*plain
+		Deposit *bigDecimal `json:"deposit"`
+		Credit  *bigDecimal `json:"credit"`
+	}{(*plain)(b), &bigDecimal{&b.Deposit}, &bigDecimal{&b.Credit}})
+}
+
+// bigDecimal encodes a big.Int as a JSON decimal string. It decodes from
+// either a string or a number.
+type bigDecimal struct {
+	*big.Int
+}
+
+func (d bigDecimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Int.String())
+}
+
+func (d *bigDecimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	s := string(data)
+	if len(data) >= 2 && data[0] == '"' && data[len(data)-1] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	if _, ok := d.Int.SetString(s, 10); !ok {
+		return fmt.Errorf("store: invalid integer value: %s", data)
+	}
+	return nil
+}
+
 // Node stores metadata requires for tracking full nodes.
 type Node struct {
 	ID          NodeID
@@ -60,6 +122,49 @@ type Node struct {
 	IsHost      bool
 	Payout      Account
 	BlockNumber uint64 `json:"block_number"`
+	// AltURIs are additional enode URIs that the node is reachable on, such
+	// as over IPv6 or a relay. They are tried in order after URI.
+	AltURIs []string `json:"alt_uris,omitempty"`
+	// Instance identifies the pool instance that holds the host's live
+	// connection, when multiple pool instances share a store.
+	Instance string `json:"instance,omitempty"`
+	// PeerCount is the number of vipnode-registered peers that the node
+	// reported in its last update.
+	PeerCount int `json:"peer_count"`
+	// MaxPeers is the number of peers that a host can accept. Zero means
+	// no limit.
+	MaxPeers int `json:"max_peers,omitempty"`
+	// Syncing is true if the host reported that its node is still syncing
+	// the chain.
+	Syncing bool `json:"syncing,omitempty"`
+	// Version is the version of the vipnode agent that the host reported
+	// when it registered.
+	Version string `json:"version,omitempty"`
+	// Clients is the number of clients that the pool assigned to the host
+	// which haven't disconnected yet. Unlike PeerCount, it changes as soon
+	// as a client is assigned, rather than on the host's next update. It's
+	// maintained by AssignHosts, SetNode doesn't change it.
+	Clients int `json:"clients,omitempty"`
+	// AssignedHosts are the hosts that the pool assigned to the client,
+	// which are released when the client is removed. It's maintained by
+	// AssignHosts, SetNode doesn't change it.
+	AssignedHosts []NodeID `json:"assigned_hosts,omitempty"`
+	// Draining is true if the host is going away for maintenance, so it's
+	// not offered to new clients while its current clients move to other
+	// hosts.
+	Draining bool `json:"draining,omitempty"`
+}
+
+// IsFull returns true if the host has as many peers as its MaxPeers, so it
+// can't accept more clients.
+func (n Node) IsFull() bool {
+	return n.MaxPeers > 0 && n.PeerCount >= n.MaxPeers
+}
+
+// URIs returns all of the node's URIs in the order that they should be
+// tried.
+func (n Node) URIs() []string {
+	return append([]string{n.URI}, n.AltURIs...)
 }
 
 // Stats contains various aggregate stats of the store state, used for
@@ -77,6 +182,17 @@ type Stats struct {
 	activeSince time.Time
 }
 
+// MarshalJSON encodes the total credit and deposit as decimal strings, like
+// Balance.
+func (stats Stats) MarshalJSON() ([]byte, error) {
+	type plain Stats
+	return json.Marshal(struct {
+		plain
+		TotalCredit  bigDecimal `json:"total_credit"`
+		TotalDeposit bigDecimal `json:"total_deposit"`
+	}{plain(stats), bigDecimal{&stats.TotalCredit}, bigDecimal{&stats.TotalDeposit}})
+}
+
 // CountNode is a helper for aggregating node-related stats. It is not
 // goroutine-safe.
 func (stats *Stats) CountNode(n Node) {
@@ -111,13 +227,16 @@ func (s *Stats) CountBalance(b Balance) {
 }
 
 // Store is the storage interface used by VipnodePool. It should be goroutine-safe.
+//
+// Methods should give up and return the context's error once it's done, so
+// that a slow backend doesn't block the request that's waiting on it.
 type Store interface {
 	NonceStore
 	PoolStore
 	AccountStore
 
 	// Stats returns aggregate statistics about the store state.
-	Stats() (*Stats, error)
+	Stats(ctx context.Context) (*Stats, error)
 
 	// Close shuts down or disconnects from the storage driver.
 	Close() error
@@ -125,30 +244,79 @@ type Store interface {
 
 type NonceStore interface {
 	// CheckAndSaveNonce asserts that this is the highest nonce seen for this ID (typically nodeID or wallet address).
-	CheckAndSaveNonce(ID string, nonce int64) error
+	// Nonces larger than MaxNonce are rejected with ErrNonceOutOfRange.
+	CheckAndSaveNonce(ctx context.Context, ID string, nonce int64) error
+	// PurgeNonces removes saved nonces that are older than the cutoff,
+	// returning the number of nonces removed. Nonces are treated as
+	// nanosecond unix timestamps.
+	PurgeNonces(ctx context.Context, olderThan time.Time) (int, error)
 }
 
 // TODO: Replace ActiveHosts params with HostQuery type?
 
+const (
+	// AnyKind is the kind that matches hosts of every kind in ActiveHosts.
+	AnyKind = "*"
+	// NoLimit is the limit that returns all matching hosts in ActiveHosts.
+	NoLimit = -1
+)
+
+// CheckHostQuery returns an error if the ActiveHosts arguments are invalid:
+// ErrEmptyKind for an empty kind, or ErrInvalidLimit for a limit that is
+// neither positive nor NoLimit.
+func CheckHostQuery(kind string, limit int) error {
+	if kind == "" {
+		return ErrEmptyKind
+	}
+	if limit <= 0 && limit != NoLimit {
+		return ErrInvalidLimit
+	}
+	return nil
+}
+
 type PoolStore interface {
 	// GetNode returns the node from the set of active nods.
-	GetNode(NodeID) (*Node, error)
-	// SetNode adds a Node to the set of active nodes.
-	SetNode(Node) error
+	GetNode(ctx context.Context, nodeID NodeID) (*Node, error)
+	// SetNode adds a Node to the set of active nodes. The node's Clients
+	// and AssignedHosts are ignored, the saved values are kept instead.
+	SetNode(ctx context.Context, node Node) error
+	// RemoveNode removes a Node and its peers from the set of active nodes,
+	// and releases its host assignments. Balances are retained. Removing an
+	// unknown node is not an error.
+	RemoveNode(ctx context.Context, nodeID NodeID) error
+	// ExpireNodes removes every node whose LastSeen is older than before,
+	// along with its peers and assignments, like RemoveNode. It returns the
+	// IDs of the nodes that were removed.
+	ExpireNodes(ctx context.Context, before time.Time) (removed []NodeID, err error)
 
-	// ActiveHosts returns `limit`-number of `kind` nodes. This could be an
+	// ActiveHosts returns `limit`-number of `kind` nodes, chosen and ordered
+	// by the selector. Hosts that are full are skipped. This could be an
 	// empty list, if none are available.
-	ActiveHosts(kind string, limit int) ([]Node, error)
+	// Use AnyKind to match every kind and NoLimit to return all of the
+	// matching nodes. Other arguments are validated with CheckHostQuery. If
+	// selector is nil, then RandomSelector is used.
+	ActiveHosts(ctx context.Context, kind string, limit int, selector HostSelector) ([]Node, error)
+
+	// AllNodes returns every node that the store knows about, hosts and
+	// clients, including inactive nodes that haven't been removed yet.
+	AllNodes(ctx context.Context) ([]Node, error)
 
 	// NodePeers returns a list of active connected peers that this pool knows
 	// about for this NodeID.
-	NodePeers(nodeID NodeID) ([]Node, error)
+	NodePeers(ctx context.Context, nodeID NodeID) ([]Node, error)
 	// UpdateNodePeers updates the Node.peers lookup with the current timestamp
 	// of nodes we know about. This is used as a keepalive, and to keep track
 	// of which client is connected to which host. Any missing peer is removed
 	// from the known peers and returned. It also updates nodeID's
-	// LastSeen.
-	UpdateNodePeers(nodeID NodeID, peers []string, blockNumber uint64) (inactive []NodeID, err error)
+	// LastSeen and PeerCount.
+	UpdateNodePeers(ctx context.Context, nodeID NodeID, peers []string, blockNumber uint64) (inactive []NodeID, err error)
+
+	// AssignHosts replaces the client's AssignedHosts with hostIDs, and
+	// updates the Clients of each host that was added or removed, all at
+	// once. Hosts that aren't registered are skipped, so an empty hostIDs
+	// releases all of the client's hosts. Assigning a host that the client
+	// already has doesn't count it twice.
+	AssignHosts(ctx context.Context, clientID NodeID, hostIDs []NodeID) error
 }
 
 // AccountStore manages the accounts associated with nodes and their balances.
@@ -158,27 +326,33 @@ type AccountStore interface {
 	// AddAccountNode authorizes a nodeID to be a spender of an account's
 	// balance. This should migrate any existing node's balance credit to the
 	// account.
-	AddAccountNode(account Account, nodeID NodeID) error
+	AddAccountNode(ctx context.Context, account Account, nodeID NodeID) error
 	// IsAccountNode returns nil if node is a valid spender of the given
 	// account.
-	IsAccountNode(account Account, nodeID NodeID) error
+	IsAccountNode(ctx context.Context, account Account, nodeID NodeID) error
 	// GetSpenders returns the authorized nodeIDs for this account, these are
 	// nodes that were added to accounts through AddAccountNode.
-	GetAccountNodes(account Account) ([]NodeID, error)
+	GetAccountNodes(ctx context.Context, account Account) ([]NodeID, error)
 }
 
 // BalanceStore is a store subset required for the balance manager.
 type BalanceStore interface {
 	// GetNodeBalance returns the current account balance for a node.
-	GetNodeBalance(nodeID NodeID) (Balance, error)
+	GetNodeBalance(ctx context.Context, nodeID NodeID) (Balance, error)
 	// AddNodeBalance adds some credit amount to a node's account balance. (Can be negative)
 	// If only a node is provided which doesn't have an account registered to
 	// it, it should retain a balance, such as through temporary trial accounts
 	// that get migrated later.
-	AddNodeBalance(nodeID NodeID, credit *big.Int) error
+	AddNodeBalance(ctx context.Context, nodeID NodeID, credit *big.Int) error
 
 	// GetAccountBalance returns an account's balance.
-	GetAccountBalance(account Account) (Balance, error)
+	GetAccountBalance(ctx context.Context, account Account) (Balance, error)
 	// AddNodeBalance adds credit to an account balance. (Can be negative)
-	AddAccountBalance(account Account, credit *big.Int) error
+	AddAccountBalance(ctx context.Context, account Account, credit *big.Int) error
+
+	// GrantTrialCredit adds credit to the balance of a node without an
+	// account and sets its TrialGranted, unless it was set already. It
+	// returns whether the credit was granted, so each node is granted trial
+	// credit at most once, even after spending it.
+	GrantTrialCredit(ctx context.Context, nodeID NodeID, credit *big.Int) (granted bool, err error)
 }
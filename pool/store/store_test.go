@@ -0,0 +1,73 @@
+package store
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestBalanceJSON(t *testing.T) {
+	credit, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	b := Balance{Account: "0xabc"}
+	b.Credit.Set(credit)
+	b.Deposit.SetInt64(42)
+
+	out, err := json.Marshal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `{"account":"0xabc","next_withdraw":"0001-01-01T00:00:00Z","deposit":"42","credit":"-123456789012345678901234567890"}`; got != want {
+		t.Errorf("wrong encoding:\n got: %s\nwant: %s", got, want)
+	}
+
+	var decoded Balance
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Account != b.Account || decoded.Credit.Cmp(&b.Credit) != 0 || decoded.Deposit.Cmp(&b.Deposit) != 0 {
+		t.Errorf("round trip mismatch: got %+v; want %+v", decoded, b)
+	}
+
+	// Numbers are still accepted, such as from older snapshots
+	var legacy Balance
+	if err := json.Unmarshal([]byte(`{"account":"0xabc","deposit":42,"credit":-1000000000000000000000}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Deposit.Int64() != 42 || legacy.Credit.String() != "-1000000000000000000000" {
+		t.Errorf("wrong legacy decoding: %+v", legacy)
+	}
+
+	if err := json.Unmarshal([]byte(`{"credit":"12abc"}`), &legacy); err == nil {
+		t.Error("expected error for invalid credit")
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	stats := Stats{NumActiveHosts: 2}
+	stats.TotalCredit.SetInt64(1000)
+	out, err := json.Marshal(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["total_credit"] != "1000" || decoded["total_deposit"] != "0" || decoded["num_active_hosts"] != 2.0 {
+		t.Errorf("wrong encoding: %s", out)
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	err := BatchError{
+		Op:    "add node balances",
+		Total: 3,
+		Failed: []ItemError{
+			{ID: "a", Err: ErrUnregisteredNode},
+			{ID: "c", Err: ErrNotAuthorized},
+		},
+	}
+	if got, want := err.Error(), "add node balances: 2 of 3 items failed: a: unregistered node; c: node is not an authorized spender"; got != want {
+		t.Errorf("got: %q; want %q", got, want)
+	}
+}
@@ -1,15 +1,19 @@
 package store
 
 import (
+	"context"
+	"math"
 	"math/big"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 )
 
 // TestSuite runs a suite of tests against a store implementation.
 func TestSuite(t *testing.T, newStore func() Store) {
+	ctx := context.Background()
 	nodes := []Node{}
 	{
 		ids := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
@@ -34,26 +38,69 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		nodeID := "abc"
 
 		oldNonce := time.Now().Add(-2 * time.Hour).UnixNano()
-		if err := s.CheckAndSaveNonce(nodeID, oldNonce); err != ErrInvalidNonce {
+		if err := s.CheckAndSaveNonce(ctx, nodeID, oldNonce); err != ErrInvalidNonce {
 			t.Errorf("missing invalid nonce error: %s", err)
 		}
 
 		nonce := time.Now().UnixNano()
-		if err := s.CheckAndSaveNonce(nodeID, nonce); err != nil {
+		if err := s.CheckAndSaveNonce(ctx, nodeID, nonce); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if err := s.CheckAndSaveNonce(nodeID, nonce+1); err != nil {
+		if err := s.CheckAndSaveNonce(ctx, nodeID, nonce+1); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if err := s.CheckAndSaveNonce(nodeID, nonce-1); err != ErrInvalidNonce {
+		if err := s.CheckAndSaveNonce(ctx, nodeID, nonce-1); err != ErrInvalidNonce {
 			t.Errorf("missing invalid nonce error: %s", err)
 		}
-		if err := s.CheckAndSaveNonce(nodeID, nonce); err != ErrInvalidNonce {
+		if err := s.CheckAndSaveNonce(ctx, nodeID, nonce); err != ErrInvalidNonce {
 			t.Errorf("missing invalid nonce error: %s", err)
 		}
-		if err := s.CheckAndSaveNonce("def", nonce+100); err != nil {
+		if err := s.CheckAndSaveNonce(ctx, "def", nonce+100); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
+
+		// Nonces far in the future are rejected without bricking the node
+		if err := s.CheckAndSaveNonce(ctx, nodeID, time.Now().Add(MaxNonceAhead+time.Minute).UnixNano()); err != ErrNonceOutOfRange {
+			t.Errorf("missing nonce out of range error: %s", err)
+		}
+		if err := s.CheckAndSaveNonce(ctx, nodeID, math.MaxInt64); err != ErrNonceOutOfRange {
+			t.Errorf("missing nonce out of range error: %s", err)
+		}
+		if err := s.CheckAndSaveNonce(ctx, nodeID, nonce+2); err != nil {
+			t.Errorf("unexpected error after out of range nonce: %s", err)
+		}
+	})
+
+	t.Run("PurgeNonces", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		ages := map[string]time.Duration{
+			"new":    0,
+			"recent": 2 * time.Minute,
+			"old":    10 * time.Minute,
+			"older":  12 * time.Minute,
+		}
+		for ID, age := range ages {
+			if err := s.CheckAndSaveNonce(ctx, ID, now.Add(-age).UnixNano()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if n, err := s.PurgeNonces(ctx, now.Add(-5*time.Minute)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if n != 2 {
+			t.Errorf("wrong number of nonces purged: got %d; want 2", n)
+		}
+
+		// Purged nonces can be reused, retained nonces are still checked.
+		if err := s.CheckAndSaveNonce(ctx, "old", now.Add(-11*time.Minute).UnixNano()); err != nil {
+			t.Errorf("unexpected error for purged nonce: %s", err)
+		}
+		if err := s.CheckAndSaveNonce(ctx, "recent", now.Add(-3*time.Minute).UnixNano()); err != ErrInvalidNonce {
+			t.Errorf("missing invalid nonce error for retained nonce: %s", err)
+		}
 	})
 
 	t.Run("Node", func(t *testing.T) {
@@ -62,19 +109,58 @@ func TestSuite(t *testing.T, newStore func() Store) {
 
 		node := nodes[0]
 		emptynode := Node{}
-		if _, err := s.GetNode(node.ID); err != ErrUnregisteredNode {
+		if _, err := s.GetNode(ctx, node.ID); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		}
-		if err := s.SetNode(emptynode); err != ErrMalformedNode {
+		if err := s.SetNode(ctx, emptynode); err != ErrMalformedNode {
 			t.Errorf("expected malformed error, got: %s", err)
 		}
-		if err := s.SetNode(node); err != nil {
+		if err := s.SetNode(ctx, node); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if r, err := s.GetNode(node.ID); err != nil {
+		if r, err := s.GetNode(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if r.ID != node.ID {
 			t.Errorf("returned wrong node: %v", r)
+		} else if len(r.AltURIs) != 0 {
+			t.Errorf("unexpected alt URIs: %v", r.AltURIs)
+		}
+
+		// Alternative URIs are kept in order
+		altNode := node
+		altNode.AltURIs = []string{"enode://foo@[::1]:30303", "enode://foo@10.0.0.1:30303"}
+		altNode.Instance = "pool-a"
+		if err := s.SetNode(ctx, altNode); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if r, err := s.GetNode(ctx, node.ID); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if !reflect.DeepEqual(r.AltURIs, altNode.AltURIs) {
+			t.Errorf("wrong alt URIs: got %v; want %v", r.AltURIs, altNode.AltURIs)
+		} else if r.Instance != altNode.Instance {
+			t.Errorf("wrong instance: got %q; want %q", r.Instance, altNode.Instance)
+		}
+
+		// Assigned clients and hosts are only changed by AssignHosts
+		assignedNode := node
+		assignedNode.Clients = 2
+		assignedNode.AssignedHosts = []NodeID{nodes[1].ID, nodes[2].ID}
+		if err := s.SetNode(ctx, assignedNode); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if r, err := s.GetNode(ctx, node.ID); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if r.Clients != 0 || len(r.AssignedHosts) != 0 {
+			t.Errorf("SetNode changed the assignments: %d clients, assigned hosts %v", r.Clients, r.AssignedHosts)
+		}
+		if err := s.RemoveNode(ctx, node.ID); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if _, err := s.GetNode(ctx, node.ID); err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error after removal, got: %s", err)
+		}
+		if err := s.RemoveNode(ctx, node.ID); err != nil {
+			t.Errorf("unexpected error removing unknown node: %s", err)
 		}
 	})
 
@@ -86,48 +172,48 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		othernode := nodes[1]
 
 		// Unregistered
-		if err := s.AddNodeBalance(node.ID, big.NewInt(42)); err != ErrUnregisteredNode {
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(42)); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		}
-		if _, err := s.GetNodeBalance(node.ID); err != ErrUnregisteredNode {
+		if _, err := s.GetNodeBalance(ctx, node.ID); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		}
 
 		// Init node
-		if err := s.SetNode(node); err != nil {
+		if err := s.SetNode(ctx, node); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
 		// Test balance adding
-		if err := s.AddNodeBalance(node.ID, big.NewInt(42)); err != nil {
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(42)); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if err := s.AddNodeBalance(node.ID, big.NewInt(3)); err != nil {
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(3)); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if b, err := s.GetNodeBalance(node.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if b.Credit.Cmp(big.NewInt(45)) != 0 {
 			t.Errorf("wrong balance: %v", b)
 		}
 
 		// Test subtracting and negative
-		if err := s.AddNodeBalance(node.ID, big.NewInt(-50)); err != nil {
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(-50)); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if b, err := s.GetNodeBalance(node.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if b.Credit.Cmp(big.NewInt(-5)) != 0 {
 			t.Errorf("wrong balance: %v", b)
 		}
 
-		if b, err := s.GetNodeBalance(othernode.ID); err != ErrUnregisteredNode {
+		if b, err := s.GetNodeBalance(ctx, othernode.ID); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		} else if b.Credit.Cmp(big.NewInt(0)) != 0 {
 			t.Errorf("returned non-empty balance: %v", b)
 		}
 
-		gotStats, err := s.Stats()
+		gotStats, err := s.Stats(ctx)
 		if err != nil {
 			t.Error(err)
 		}
@@ -142,6 +228,102 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		}
 	})
 
+	t.Run("GrantTrialCredit", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		node := nodes[0]
+		if _, err := s.GrantTrialCredit(ctx, node.ID, big.NewInt(100)); err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error, got: %v", err)
+		}
+		if err := s.SetNode(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+
+		if granted, err := s.GrantTrialCredit(ctx, node.ID, big.NewInt(100)); err != nil {
+			t.Fatal(err)
+		} else if !granted {
+			t.Error("trial credit was not granted")
+		}
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
+			t.Fatal(err)
+		} else if b.Credit.Cmp(big.NewInt(100)) != 0 || !b.TrialGranted {
+			t.Errorf("wrong balance after grant: %+v", b)
+		}
+
+		// Spending the trial doesn't allow another grant
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(-100)); err != nil {
+			t.Fatal(err)
+		}
+		if granted, err := s.GrantTrialCredit(ctx, node.ID, big.NewInt(100)); err != nil {
+			t.Fatal(err)
+		} else if granted {
+			t.Error("trial credit was granted twice")
+		}
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
+			t.Fatal(err)
+		} else if b.Credit.Sign() != 0 || !b.TrialGranted {
+			t.Errorf("wrong balance after second grant: %+v", b)
+		}
+
+		// Nodes with an account aren't granted trial credit
+		other := nodes[1]
+		if err := s.SetNode(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddAccountNode(ctx, accounts[0], other.ID); err != nil {
+			t.Fatal(err)
+		}
+		if granted, err := s.GrantTrialCredit(ctx, other.ID, big.NewInt(100)); err != nil {
+			t.Fatal(err)
+		} else if granted {
+			t.Error("trial credit was granted to a node with an account")
+		}
+		if b, err := s.GetAccountBalance(ctx, accounts[0]); err != nil {
+			t.Fatal(err)
+		} else if b.Credit.Sign() != 0 {
+			t.Errorf("account was credited: %+v", b)
+		}
+	})
+
+	t.Run("HostQuery", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		for i, kind := range []string{"geth", "geth", "parity"} {
+			if err := s.SetNode(ctx, Node{ID: nodes[i].ID, IsHost: true, Kind: kind, LastSeen: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 3 {
+			t.Errorf("any kind: got %d hosts; want 3", len(hosts))
+		}
+		if hosts, err := s.ActiveHosts(ctx, "geth", NoLimit, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 2 {
+			t.Errorf("geth: got %d hosts; want 2", len(hosts))
+		}
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, 1, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 1 {
+			t.Errorf("bounded limit: got %d hosts; want 1", len(hosts))
+		}
+
+		// An empty kind is a mistake rather than a wildcard
+		if hosts, err := s.ActiveHosts(ctx, "", NoLimit, nil); err != ErrEmptyKind {
+			t.Errorf("empty kind: expected ErrEmptyKind, got %v (%d hosts)", err, len(hosts))
+		}
+		for _, limit := range []int{0, -2} {
+			if _, err := s.ActiveHosts(ctx, AnyKind, limit, nil); err != ErrInvalidLimit {
+				t.Errorf("limit %d: expected ErrInvalidLimit, got %v", limit, err)
+			}
+		}
+	})
+
 	t.Run("NodePeers", func(t *testing.T) {
 		s := newStore()
 		defer s.Close()
@@ -149,20 +331,20 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		node := nodes[0]
 
 		// Unregistered
-		if _, err := s.NodePeers(node.ID); err != ErrUnregisteredNode {
+		if _, err := s.NodePeers(ctx, node.ID); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		}
-		if _, err := s.UpdateNodePeers(node.ID, []string{"def"}, 0); err != ErrUnregisteredNode {
+		if _, err := s.UpdateNodePeers(ctx, node.ID, []string{"def"}, 0); err != ErrUnregisteredNode {
 			t.Errorf("expected unregistered error, got: %s", err)
 		}
 
 		// Init node
-		if err := s.SetNode(node); err != nil {
+		if err := s.SetNode(ctx, node); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
 		// Test peers
-		if peers, err := s.NodePeers(node.ID); err != nil {
+		if peers, err := s.NodePeers(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if len(peers) != 0 {
 			t.Errorf("unexpected peers: %v", peers)
@@ -170,7 +352,7 @@ func TestSuite(t *testing.T, newStore func() Store) {
 
 		// peer1 is not a known node, so it will be ignored
 		peers := []string{nodes[1].ID.String()}
-		if inactive, err := s.UpdateNodePeers(node.ID, peers, 0); err != nil {
+		if inactive, err := s.UpdateNodePeers(ctx, node.ID, peers, 0); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if len(inactive) != 0 {
 			t.Errorf("unexpected peers: %v", inactive)
@@ -178,19 +360,19 @@ func TestSuite(t *testing.T, newStore func() Store) {
 
 		// Inactives only qualify after ExpireInterval
 		newPeers := []string{nodes[2].ID.String(), nodes[3].ID.String()}
-		if err := s.SetNode(nodes[2]); err != nil {
+		if err := s.SetNode(ctx, nodes[2]); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		if err := s.SetNode(nodes[3]); err != nil {
+		if err := s.SetNode(ctx, nodes[3]); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
-		if inactive, err := s.UpdateNodePeers(node.ID, newPeers, 0); err != nil {
+		if inactive, err := s.UpdateNodePeers(ctx, node.ID, newPeers, 0); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if len(inactive) != 0 {
 			t.Errorf("unexpected peers: %v", inactive)
 		}
-		if peers, err := s.NodePeers(node.ID); err != nil {
+		if peers, err := s.NodePeers(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if peerIDs := nodeIDs(peers); !reflect.DeepEqual(peerIDs, newPeers) {
 			t.Errorf("got: %+v; want: %+v", peerIDs, newPeers)
@@ -201,7 +383,7 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		s := newStore()
 		defer s.Close()
 
-		if hosts, err := s.ActiveHosts("", 3); err != nil {
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, 3, nil); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if len(hosts) != 0 {
 			t.Errorf("unexpected hosts: %v", hosts)
@@ -217,23 +399,23 @@ func TestSuite(t *testing.T, newStore func() Store) {
 			if i > 5 {
 				node.LastSeen = now
 			}
-			if err := s.SetNode(node); err != nil {
+			if err := s.SetNode(ctx, node); err != nil {
 				t.Error(err)
 			}
 		}
-		if hosts, err := s.ActiveHosts("", 10); err != nil {
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, 10, nil); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if got, want := nodeIDs(hosts), []string{nodes[6].ID.String(), nodes[7].ID.String(), nodes[8].ID.String(), nodes[9].ID.String()}; !reflect.DeepEqual(got, want) {
 			t.Errorf("got: %v; want: %v", got, want)
 		}
 
-		if hosts, err := s.ActiveHosts("", 2); err != nil {
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, 2, nil); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if len(hosts) != 2 {
 			t.Errorf("wrong number of hosts: %d", len(hosts))
 		}
 
-		gotStats, err := s.Stats()
+		gotStats, err := s.Stats(ctx)
 		if err != nil {
 			t.Error(err)
 		}
@@ -249,37 +431,546 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		}
 	})
 
+	t.Run("HostQuery", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		for i, kind := range []string{"geth", "geth", "parity"} {
+			if err := s.SetNode(ctx, Node{ID: nodes[i].ID, IsHost: true, Kind: kind, LastSeen: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 3 {
+			t.Errorf("any kind: got %d hosts; want 3", len(hosts))
+		}
+		if hosts, err := s.ActiveHosts(ctx, "geth", NoLimit, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 2 {
+			t.Errorf("geth: got %d hosts; want 2", len(hosts))
+		}
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, 1, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if len(hosts) != 1 {
+			t.Errorf("bounded limit: got %d hosts; want 1", len(hosts))
+		}
+
+		// An empty kind is a mistake rather than a wildcard
+		if hosts, err := s.ActiveHosts(ctx, "", NoLimit, nil); err != ErrEmptyKind {
+			t.Errorf("empty kind: expected ErrEmptyKind, got %v (%d hosts)", err, len(hosts))
+		}
+		for _, limit := range []int{0, -2} {
+			if _, err := s.ActiveHosts(ctx, AnyKind, limit, nil); err != ErrInvalidLimit {
+				t.Errorf("limit %d: expected ErrInvalidLimit, got %v", limit, err)
+			}
+		}
+	})
+
+	t.Run("PeerCount", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		if all, err := s.AllNodes(ctx); err != nil {
+			t.Fatal(err)
+		} else if len(all) != 0 {
+			t.Errorf("unexpected nodes: %v", all)
+		}
+
+		host := nodes[0]
+		host.IsHost = true
+		for _, n := range []Node{host, nodes[1], nodes[2]} {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		peerCount := func() int {
+			t.Helper()
+			all, err := s.AllNodes(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := nodeIDs(all), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("wrong nodes: got %v; want %v", got, want)
+			}
+			for _, n := range all {
+				if n.ID == host.ID {
+					if n.LastSeen.IsZero() {
+						t.Error("missing LastSeen after update")
+					}
+					return n.PeerCount
+				}
+			}
+			return -1
+		}
+
+		if _, err := s.UpdateNodePeers(ctx, host.ID, []string{"b", "c", "unknown"}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got := peerCount(); got != 2 {
+			t.Errorf("got peer count %d; want 2", got)
+		}
+		if _, err := s.UpdateNodePeers(ctx, host.ID, []string{"b"}, 0); err != nil {
+			t.Fatal(err)
+		}
+		// Missing peers are kept until they expire
+		if got := peerCount(); got != 2 {
+			t.Errorf("got peer count %d; want 2", got)
+		}
+		if node, err := s.GetNode(ctx, host.ID); err != nil {
+			t.Fatal(err)
+		} else if node.PeerCount != 2 {
+			t.Errorf("got peer count %d from GetNode; want 2", node.PeerCount)
+		}
+	})
+
+	t.Run("ExpireNodes", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		seen := []time.Time{
+			now,
+			now.Add(-time.Minute),
+			now.Add(-2 * time.Hour),
+			now.Add(-48 * time.Hour),
+			{}, // Never seen
+		}
+		for i, lastSeen := range seen {
+			n := Node{ID: nodes[i].ID, IsHost: i%2 == 0, Kind: "geth", LastSeen: lastSeen}
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.UpdateNodePeers(ctx, nodes[0].ID, []string{nodes[1].ID.String(), nodes[3].ID.String()}, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if removed, err := s.ExpireNodes(ctx, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if got, want := sortedNodeIDs(removed), []string{"c", "d", "e"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("removed nodes %v; want %v", got, want)
+		}
+		if all, err := s.AllNodes(ctx); err != nil {
+			t.Fatal(err)
+		} else if got, want := nodeIDs(all), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got nodes %v; want %v", got, want)
+		}
+		if _, err := s.GetNode(ctx, nodes[2].ID); err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error for expired node, got: %v", err)
+		}
+		if peers, err := s.NodePeers(ctx, nodes[0].ID); err != nil {
+			t.Fatal(err)
+		} else if got, want := nodeIDs(peers), []string{"b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got peers %v; want %v", got, want)
+		}
+		if hosts, err := s.ActiveHosts(ctx, AnyKind, NoLimit, nil); err != nil {
+			t.Fatal(err)
+		} else if got, want := nodeIDs(hosts), []string{"a"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got hosts %v; want %v", got, want)
+		}
+
+		// Nothing left to expire
+		if removed, err := s.ExpireNodes(ctx, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if len(removed) != 0 {
+			t.Errorf("removed nodes %v again; want none", removed)
+		}
+	})
+
+	t.Run("FullHosts", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		full := Node{ID: nodes[0].ID, IsHost: true, Kind: "geth", LastSeen: now, MaxPeers: 1}
+		open := Node{ID: nodes[1].ID, IsHost: true, Kind: "geth", LastSeen: now, MaxPeers: 2}
+		unlimited := Node{ID: nodes[2].ID, IsHost: true, Kind: "geth", LastSeen: now}
+		for _, n := range []Node{full, open, unlimited, nodes[3]} {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, host := range []Node{full, open, unlimited} {
+			if _, err := s.UpdateNodePeers(ctx, host.ID, []string{nodes[3].ID.String()}, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hosts, err := s.ActiveHosts(ctx, "geth", NoLimit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := nodeIDs(hosts), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got hosts %v; want %v", got, want)
+		}
+	})
+
+	t.Run("DrainingHosts", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		now := time.Now()
+		draining := Node{ID: nodes[0].ID, IsHost: true, Kind: "geth", LastSeen: now, Draining: true}
+		host := Node{ID: nodes[1].ID, IsHost: true, Kind: "geth", LastSeen: now}
+		for _, n := range []Node{draining, host} {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hosts, err := s.ActiveHosts(ctx, "geth", NoLimit, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := nodeIDs(hosts), []string{"b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got hosts %v; want %v", got, want)
+		}
+		if r, err := s.GetNode(ctx, draining.ID); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if !r.Draining {
+			t.Errorf("draining host was not saved as draining: %v", r)
+		}
+	})
+
+	t.Run("HostSlot", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		slots, ok := s.(HostSlotStore)
+		if !ok {
+			t.Skip("store does not implement HostSlotStore")
+		}
+
+		host, peer := nodes[0], nodes[1]
+		host.IsHost = true
+		host.MaxPeers = 2
+		for _, n := range []Node{host, peer, nodes[2], nodes[3]} {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := slots.ClaimHostSlot("unknown", peer.ID); err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error, got: %v", err)
+		}
+
+		// Host is at capacity minus one
+		if _, err := s.UpdateNodePeers(ctx, host.ID, []string{peer.ID.String()}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := slots.ClaimHostSlot(host.ID, peer.ID); err != nil || !ok {
+			t.Errorf("existing peer should hold a slot: %v, %v", ok, err)
+		}
+
+		var wg sync.WaitGroup
+		results := make(chan bool, 2)
+		for _, client := range []Node{nodes[2], nodes[3]} {
+			wg.Add(1)
+			go func(clientID NodeID) {
+				defer wg.Done()
+				ok, err := slots.ClaimHostSlot(host.ID, clientID)
+				if err != nil {
+					t.Error(err)
+				}
+				results <- ok
+			}(client.ID)
+		}
+		wg.Wait()
+		close(results)
+		numClaimed := 0
+		for ok := range results {
+			if ok {
+				numClaimed += 1
+			}
+		}
+		if numClaimed != 1 {
+			t.Fatalf("racing clients claimed %d slots; want 1", numClaimed)
+		}
+
+		// Releasing both frees the slot, releasing twice is harmless
+		for _, client := range []Node{nodes[2], nodes[3]} {
+			if err := slots.ReleaseHostSlot(host.ID, client.ID); err != nil {
+				t.Error(err)
+			}
+		}
+		if ok, err := slots.ClaimHostSlot(host.ID, nodes[3].ID); err != nil || !ok {
+			t.Errorf("expected released slot to be claimed: %v, %v", ok, err)
+		}
+		if ok, err := slots.ClaimHostSlot(host.ID, nodes[2].ID); err != nil || ok {
+			t.Errorf("expected full host to refuse claim: %v, %v", ok, err)
+		}
+
+		// Claims are released once the client is reported as a peer, which
+		// then counts towards the capacity instead.
+		if _, err := s.UpdateNodePeers(ctx, host.ID, []string{peer.ID.String(), nodes[3].ID.String()}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := slots.ClaimHostSlot(host.ID, nodes[2].ID); err != nil || ok {
+			t.Errorf("expected full host to refuse claim: %v, %v", ok, err)
+		}
+	})
+
+	t.Run("AssignHosts", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		hosts := []Node{nodes[0], nodes[1], nodes[2]}
+		for i := range hosts {
+			hosts[i].IsHost = true
+			hosts[i].Kind = "geth"
+			hosts[i].LastSeen = time.Now()
+		}
+		client, otherClient := nodes[3], nodes[4]
+		for _, n := range append([]Node{client, otherClient}, hosts...) {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.AssignHosts(ctx, "unknown", []NodeID{hosts[0].ID}); err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error, got: %v", err)
+		}
+
+		checkClients := func(t *testing.T, want ...int) {
+			t.Helper()
+			for i, host := range hosts {
+				r, err := s.GetNode(ctx, host.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Clients != want[i] {
+					t.Errorf("host %q: wrong clients: got %d; want %d", host.ID, r.Clients, want[i])
+				}
+			}
+		}
+		checkAssigned := func(t *testing.T, clientID NodeID, want ...NodeID) {
+			t.Helper()
+			r, err := s.GetNode(ctx, clientID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.AssignedHosts) != len(want) || (len(want) > 0 && !reflect.DeepEqual(r.AssignedHosts, want)) {
+				t.Errorf("client %q: wrong assigned hosts: got %v; want %v", clientID, r.AssignedHosts, want)
+			}
+		}
+
+		// Unregistered hosts are skipped, and repeated hosts count once
+		if err := s.AssignHosts(ctx, client.ID, []NodeID{hosts[0].ID, hosts[1].ID, "unknown", hosts[0].ID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AssignHosts(ctx, otherClient.ID, []NodeID{hosts[1].ID}); err != nil {
+			t.Fatal(err)
+		}
+		checkClients(t, 1, 2, 0)
+		checkAssigned(t, client.ID, hosts[0].ID, hosts[1].ID)
+
+		// Saving the host again doesn't reset its clients
+		if err := s.SetNode(ctx, hosts[1]); err != nil {
+			t.Fatal(err)
+		}
+		checkClients(t, 1, 2, 0)
+
+		// Assigning again replaces the client's hosts
+		if err := s.AssignHosts(ctx, client.ID, []NodeID{hosts[1].ID, hosts[2].ID}); err != nil {
+			t.Fatal(err)
+		}
+		checkClients(t, 0, 2, 1)
+		checkAssigned(t, client.ID, hosts[1].ID, hosts[2].ID)
+
+		// Removing a client releases its hosts, and removing a host drops it
+		// from its clients
+		if err := s.RemoveNode(ctx, otherClient.ID); err != nil {
+			t.Fatal(err)
+		}
+		checkClients(t, 0, 1, 1)
+		if err := s.RemoveNode(ctx, hosts[2].ID); err != nil {
+			t.Fatal(err)
+		}
+		hosts = hosts[:2]
+		checkAssigned(t, client.ID, hosts[1].ID)
+
+		// Assigning no hosts releases them all
+		if err := s.AssignHosts(ctx, client.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		checkClients(t, 0, 0)
+		checkAssigned(t, client.ID)
+
+		// Concurrent assignments to the same host are all counted
+		clients := nodes[5:]
+		for _, n := range clients {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var wg sync.WaitGroup
+		for _, n := range clients {
+			wg.Add(1)
+			go func(clientID NodeID) {
+				defer wg.Done()
+				if err := s.AssignHosts(ctx, clientID, []NodeID{hosts[0].ID}); err != nil {
+					t.Error(err)
+				}
+			}(n.ID)
+		}
+		wg.Wait()
+		checkClients(t, len(clients), 0)
+	})
+
+	t.Run("SigningKey", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		signers, ok := s.(SigningKeyStore)
+		if !ok {
+			t.Skip("store does not implement SigningKeyStore")
+		}
+
+		node, signer := nodes[0], nodes[1]
+		if got, err := signers.GetSigningKey(ctx, node.ID); err != nil || !got.IsZero() {
+			t.Errorf("expected no signing key: %q, %v", got, err)
+		}
+		if err := signers.SetSigningKey(ctx, node.ID, signer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := signers.GetSigningKey(ctx, node.ID); err != nil || got != signer.ID {
+			t.Errorf("got signing key %q, %v; want %q", got, err, signer.ID)
+		}
+		if err := signers.SetSigningKey(ctx, node.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := signers.GetSigningKey(ctx, node.ID); err != nil || !got.IsZero() {
+			t.Errorf("expected removed signing key: %q, %v", got, err)
+		}
+	})
+
+	t.Run("BatchBalance", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		batcher, ok := s.(BatchBalanceStore)
+		if !ok {
+			t.Skip("store does not implement BatchBalanceStore")
+		}
+
+		trialNode, accountNode, spender := nodes[0], nodes[1], nodes[2]
+		for _, n := range []Node{trialNode, accountNode, spender} {
+			if err := s.SetNode(ctx, n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, n := range []Node{accountNode, spender} {
+			if err := s.AddAccountNode(ctx, accounts[0], n.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Nodes that share an account are both added to it
+		err := batcher.AddNodeBalances(ctx, map[NodeID]*big.Int{
+			trialNode.ID:   big.NewInt(42),
+			accountNode.ID: big.NewInt(10),
+			spender.ID:     big.NewInt(-3),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := s.GetNodeBalance(ctx, trialNode.ID); err != nil {
+			t.Error(err)
+		} else if b.Credit.Cmp(big.NewInt(42)) != 0 {
+			t.Errorf("wrong trial balance: %v", b)
+		}
+		if b, err := s.GetAccountBalance(ctx, accounts[0]); err != nil {
+			t.Error(err)
+		} else if b.Credit.Cmp(big.NewInt(7)) != 0 {
+			t.Errorf("wrong account balance: %v", b)
+		}
+
+		// An unregistered node fails the whole batch
+		err = batcher.AddNodeBalances(ctx, map[NodeID]*big.Int{
+			trialNode.ID: big.NewInt(1),
+			nodes[3].ID:  big.NewInt(1),
+		})
+		if err != ErrUnregisteredNode {
+			t.Errorf("expected unregistered error, got: %v", err)
+		}
+		if b, err := s.GetNodeBalance(ctx, trialNode.ID); err != nil {
+			t.Error(err)
+		} else if b.Credit.Cmp(big.NewInt(42)) != 0 {
+			t.Errorf("failed batch changed the balance: %v", b)
+		}
+	})
+
+	t.Run("AccountsAbove", func(t *testing.T) {
+		s := newStore()
+		defer s.Close()
+
+		scanner, ok := s.(AccountScanStore)
+		if !ok {
+			t.Skip("store does not implement AccountScanStore")
+		}
+
+		credits := map[Account]int64{
+			"above": 1001,
+			"equal": 1000,
+			"below": 10,
+			"debt":  -5000,
+			"rich":  1000000,
+		}
+		for account, credit := range credits {
+			if err := s.AddAccountBalance(ctx, account, big.NewInt(credit)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Trial balances don't have an account to withdraw to
+		if err := s.SetNode(ctx, nodes[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddNodeBalance(ctx, nodes[0].ID, big.NewInt(5000)); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := scanner.AccountsAbove(ctx, big.NewInt(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []Account{"above", "rich"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v; want: %v", got, want)
+		}
+	})
+
 	t.Run("Spender", func(t *testing.T) {
 		s := newStore()
 		defer s.Close()
 
 		node := nodes[0]
-		if err := s.SetNode(node); err != nil {
+		if err := s.SetNode(ctx, node); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
 		account := accounts[0]
 
-		if err := s.IsAccountNode(account, node.ID); err != ErrNotAuthorized {
+		if err := s.IsAccountNode(ctx, account, node.ID); err != ErrNotAuthorized {
 			t.Errorf("expected ErrNotAuthorized, got: %s", err)
 		}
 
-		if err := s.AddAccountNode(account, node.ID); err != nil {
+		if err := s.AddAccountNode(ctx, account, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
-		if b, err := s.GetNodeBalance(node.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if b.Account != account {
 			t.Errorf("invalid balance account: %q", b.Account)
 		}
 
 		// Adding again should have no effect
-		if err := s.AddAccountNode(account, node.ID); err != nil {
+		if err := s.AddAccountNode(ctx, account, node.ID); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
 
-		if spenders, err := s.GetAccountNodes(account); err != nil {
+		if spenders, err := s.GetAccountNodes(ctx, account); err != nil {
 			t.Errorf("unexpected error: %s", err)
 		} else if !reflect.DeepEqual(spenders, []NodeID{node.ID}) {
 			t.Errorf("invalid spenders: %q", spenders)
@@ -291,47 +982,47 @@ func TestSuite(t *testing.T, newStore func() Store) {
 		defer s.Close()
 
 		node := nodes[0]
-		if err := s.SetNode(node); err != nil {
+		if err := s.SetNode(ctx, node); err != nil {
 			t.Error(err)
 		}
 
-		if err := s.AddNodeBalance(node.ID, big.NewInt(42)); err != nil {
+		if err := s.AddNodeBalance(ctx, node.ID, big.NewInt(42)); err != nil {
 			t.Error(err)
 		}
-		if b, err := s.GetNodeBalance(node.ID); err != err {
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != err {
 			t.Error(err)
 		} else if b.Credit.Cmp(big.NewInt(42)) != 0 {
 			t.Errorf("invalid balance credit: %d", &b.Credit)
 		}
 
 		node2 := nodes[1]
-		if err := s.SetNode(node2); err != nil {
+		if err := s.SetNode(ctx, node2); err != nil {
 			t.Error(err)
 		}
 		account := accounts[0]
-		if err := s.AddAccountNode(account, node2.ID); err != nil {
+		if err := s.AddAccountNode(ctx, account, node2.ID); err != nil {
 			t.Error(err)
 		}
-		if err := s.AddNodeBalance(node2.ID, big.NewInt(69)); err != nil {
+		if err := s.AddNodeBalance(ctx, node2.ID, big.NewInt(69)); err != nil {
 			t.Error(err)
 		}
-		if b, err := s.GetNodeBalance(node2.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node2.ID); err != nil {
 			t.Error(err)
 		} else if b.Credit.Cmp(big.NewInt(69)) != 0 {
 			t.Errorf("invalid balance credit: %d", &b.Credit)
 		}
 
-		if err := s.AddAccountNode(account, node.ID); err != nil {
+		if err := s.AddAccountNode(ctx, account, node.ID); err != nil {
 			t.Error(err)
 		}
-		if b, err := s.GetNodeBalance(node2.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node2.ID); err != nil {
 			t.Error(err)
 		} else if b.Credit.Cmp(big.NewInt(42+69)) != 0 {
 			t.Errorf("invalid balance credit: %d", &b.Credit)
 		} else if b.Account != account {
 			t.Errorf("invalid account: %s", b.Account)
 		}
-		if b, err := s.GetNodeBalance(node.ID); err != nil {
+		if b, err := s.GetNodeBalance(ctx, node.ID); err != nil {
 			t.Error(err)
 		} else if b.Credit.Cmp(big.NewInt(42+69)) != 0 {
 			t.Errorf("invalid balance credit: %d", &b.Credit)
@@ -342,6 +1033,15 @@ func TestSuite(t *testing.T, newStore func() Store) {
 	})
 }
 
+func sortedNodeIDs(ids []NodeID) []string {
+	r := make([]string, 0, len(ids))
+	for _, id := range ids {
+		r = append(r, id.String())
+	}
+	sort.Strings(r)
+	return r
+}
+
 func nodeIDs(nodes []Node) []string {
 	r := make([]string, 0, len(nodes))
 	for _, n := range nodes {
@@ -0,0 +1,81 @@
+package pool
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/vipnode/vipnode/pool/store"
+)
+
+// parseVersion parses a dotted version string, such as "v2.3.1", into its
+// numeric components. A leading "v" and any pre-release or build suffix,
+// such as "-rc1", are ignored.
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return nil, false
+	}
+	parts := strings.Split(s, ".")
+	r := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		r = append(r, n)
+	}
+	return r, true
+}
+
+// versionAtLeast returns whether version is the same or newer than
+// minVersion. Missing components count as zero, so "v2" is the same as
+// "v2.0.0". Versions that can't be parsed, such as the empty version of
+// hosts that predate version reporting, are never at least minVersion.
+func versionAtLeast(version, minVersion string) bool {
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	min, ok := parseVersion(minVersion)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(v) || i < len(min); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(min) {
+			b = min[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
+
+// minVersionSelector is a store.HostSelector that excludes the hosts whose
+// version is below MinVersion before selecting from the rest.
+type minVersionSelector struct {
+	MinVersion string
+	Selector   store.HostSelector
+}
+
+func (s minVersionSelector) SelectHosts(ctx context.Context, candidates []store.Node, limit int) []store.Node {
+	r := candidates[:0]
+	for _, n := range candidates {
+		if versionAtLeast(n.Version, s.MinVersion) {
+			r = append(r, n)
+		}
+	}
+	selector := s.Selector
+	if selector == nil {
+		selector = store.RandomSelector{}
+	}
+	return selector.SelectHosts(ctx, r, limit)
+}
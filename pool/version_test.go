@@ -0,0 +1,29 @@
+package pool
+
+import "testing"
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		Version    string
+		MinVersion string
+		Want       bool
+	}{
+		{"v2.3.1", "v2.3.0", true},
+		{"v2.3.0", "v2.3.0", true},
+		{"2.3.0", "v2.3.0", true},
+		{"v2.3", "v2.3.0", true},
+		{"v2.10.0", "v2.9.0", true},
+		{"v3.0.0", "v2.9.9", true},
+		{"v2.3.0-rc1", "v2.3.0", true},
+		{"v2.2.9", "v2.3.0", false},
+		{"v1.9.0", "v2", false},
+		{"", "v2.3.0", false},
+		{"dev", "v2.3.0", false},
+		{"v2.3.0", "latest", false},
+	}
+	for _, tc := range tests {
+		if got := versionAtLeast(tc.Version, tc.MinVersion); got != tc.Want {
+			t.Errorf("versionAtLeast(%q, %q): got %v; want %v", tc.Version, tc.MinVersion, got, tc.Want)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/vipnode/vipnode/client"
@@ -36,6 +38,9 @@ func TestPoolHostClient(t *testing.T) {
 	if err := rpcHost2Pool.Server.RegisterMethod("vipnode_whitelist", h, "Whitelist"); err != nil {
 		t.Fatalf("failed to register vipnode_ rpc for host: %s", err)
 	}
+	if err := rpcHost2Pool.Server.RegisterMethod("vipnode_capabilities", h, "Capabilities"); err != nil {
+		t.Fatalf("failed to register vipnode_ rpc for host: %s", err)
+	}
 	h.NodeURI = hostNodeURI
 	hostPool := pool.Remote(rpcHost2Pool, privkey)
 
@@ -139,6 +144,9 @@ func TestCloseHost(t *testing.T) {
 	if err := rpcHost2Pool.Server.RegisterMethod("vipnode_whitelist", h, "Whitelist"); err != nil {
 		t.Fatalf("failed to register vipnode_ rpc for host: %s", err)
 	}
+	if err := rpcHost2Pool.Server.RegisterMethod("vipnode_capabilities", h, "Capabilities"); err != nil {
+		t.Fatalf("failed to register vipnode_ rpc for host: %s", err)
+	}
 	h.NodeURI = hostNodeURI
 	hostPool := pool.Remote(rpcHost2Pool, privkey)
 
@@ -154,3 +162,66 @@ func TestCloseHost(t *testing.T) {
 	h.Stop()
 	h.Wait()
 }
+
+func TestVerifyHostPeers(t *testing.T) {
+	privkey := keygen.HardcodedKeyIdx(t, 0)
+
+	p := pool.New(store.MemoryStore(), nil)
+	p.VerifyHostPeers = true
+	rpcPool2Host, rpcHost2Pool := jsonrpc2.ServePipe()
+	defer rpcPool2Host.Close()
+	defer rpcHost2Pool.Close()
+	if err := rpcPool2Host.Server.Register("vipnode_", p); err != nil {
+		t.Fatalf("failed to register vipnode_ rpc for pool: %s", err)
+	}
+
+	// The host's node is only connected to the first client
+	clients := fakenode.FakePeers(2)
+	hostNodeID := discv5.PubkeyID(&privkey.PublicKey).String()
+	hostNode := fakenode.Node(hostNodeID)
+	hostNode.FakePeers = clients[:1]
+	h := host.New(hostNode, "")
+	for method, name := range map[string]string{"vipnode_capabilities": "Capabilities", "vipnode_peers": "Peers"} {
+		if err := rpcHost2Pool.Server.RegisterMethod(method, h, name); err != nil {
+			t.Fatalf("failed to register vipnode_ rpc for host: %s", err)
+		}
+	}
+	hostPool := pool.Remote(rpcHost2Pool, privkey)
+	ctx := context.Background()
+	if _, err := hostPool.Host(ctx, pool.HostRequest{Kind: "geth", NodeURI: fmt.Sprintf("enode://%s@127.0.0.1:30303", hostNodeID)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range clients {
+		if err := p.Store.SetNode(ctx, store.Node{ID: store.NodeID(client.ID), LastSeen: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Claiming both clients freezes the host's credit
+	resp, err := hostPool.Update(ctx, pool.UpdateRequest{Role: pool.RoleHost, Peers: []string{clients[0].ID, clients[1].ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{clients[1].ID}; !reflect.DeepEqual(resp.UnconfirmedPeers, want) {
+		t.Errorf("wrong unconfirmed peers: got %q; want %q", resp.UnconfirmedPeers, want)
+	}
+	if resp.Balance == nil {
+		t.Error("missing balance of frozen update")
+	}
+	peers, err := p.Store.NodePeers(ctx, store.NodeID(hostNodeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || string(peers[0].ID) != clients[0].ID {
+		t.Errorf("unconfirmed peer was recorded: %v", peers)
+	}
+
+	// Claiming only the connected client is accepted
+	resp, err = hostPool.Update(ctx, pool.UpdateRequest{Role: pool.RoleHost, Peers: []string{clients[0].ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.UnconfirmedPeers) != 0 {
+		t.Errorf("unexpected unconfirmed peers: %q", resp.UnconfirmedPeers)
+	}
+}
@@ -60,8 +60,16 @@ func (r NodeRequest) Sign(privkey *ecdsa.PrivateKey) (string, error) {
 // Verify validates this request against a base64-encoded signature (presumably
 // produced by NodeRequest.Sign).
 func (r NodeRequest) Verify(sig string) error {
+	return r.VerifyKey(sig, r.NodeID)
+}
+
+// VerifyKey validates this request against a base64-encoded signature that
+// was produced by the key with the hex-encoded public key keyID, rather than
+// by the key of the request's NodeID. It's used for requests that are signed
+// by a separate signing key on behalf of the node.
+func (r NodeRequest) VerifyKey(sig string, keyID string) error {
 	// Convert pubkey to public key
-	node, err := discv5.HexID(r.NodeID)
+	node, err := discv5.HexID(keyID)
 	if err != nil {
 		return err
 	}
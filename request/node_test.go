@@ -54,3 +54,30 @@ func TestNodeRequest(t *testing.T) {
 		t.Errorf("expected bad signature, got: %s", err)
 	}
 }
+
+func TestNodeRequestVerifyKey(t *testing.T) {
+	nodeKey := keygen.HardcodedKeyIdx(t, 0)
+	signingKey := keygen.HardcodedKeyIdx(t, 1)
+	nodeID := discv5.PubkeyID(&nodeKey.PublicKey).String()
+	signerID := discv5.PubkeyID(&signingKey.PublicKey).String()
+
+	req := NodeRequest{
+		Method:    "somemethod",
+		NodeID:    nodeID,
+		Nonce:     42,
+		ExtraArgs: []interface{}{"foo"},
+	}
+	sig, err := req.Sign(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Verify(sig); err != ErrBadSignature {
+		t.Errorf("expected bad signature for the node key, got: %v", err)
+	}
+	if err := req.VerifyKey(sig, signerID); err != nil {
+		t.Errorf("failed to verify with the signing key: %s", err)
+	}
+	if err := VerifyKey(sig, signerID, "newmethod", nodeID, 42, "foo"); err != ErrBadSignature {
+		t.Errorf("expected bad signature, got: %v", err)
+	}
+}
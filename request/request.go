@@ -4,11 +4,28 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
+	"time"
 )
 
 // ErrBadSignature is returned when the signature does not verify the payload.
 var ErrBadSignature = errors.New("bad signature")
 
+// ErrRequestExpired is returned when the timestamp of a signed request is too
+// far from the current time.
+var ErrRequestExpired = errors.New("request timestamp is outside of the allowed clock skew")
+
+// VerifyTimestamp checks that a request's nonce, as a unix timestamp in
+// nanoseconds, is within skew of now. Requests are signed with the current
+// time as the nonce, so this bounds how long a captured request can be
+// replayed, regardless of which nonces have been seen before.
+func VerifyTimestamp(nonce int64, now time.Time, skew time.Duration) error {
+	diff := now.Sub(time.Unix(0, nonce))
+	if diff > skew || diff < -skew {
+		return ErrRequestExpired
+	}
+	return nil
+}
+
 // assemble encodes an RPC request for signing or verifying.
 func assemble(method string, pubkey string, nonce int64, args ...interface{}) ([]byte, error) {
 	// The signed payload is the method concatenated with the JSON-encoded arg array.
@@ -65,3 +82,15 @@ func Verify(sig string, method string, pubkey string, nonce int64, args ...inter
 		ExtraArgs: args,
 	}.Verify(sig)
 }
+
+// VerifyKey checks a base64-encoded signature of an RPC request for the
+// pubkey node ID that was signed by a separate key, identified by the
+// hex-encoded public key keyID.
+func VerifyKey(sig string, keyID string, method string, pubkey string, nonce int64, args ...interface{}) error {
+	return NodeRequest{
+		Method:    method,
+		NodeID:    pubkey,
+		Nonce:     nonce,
+		ExtraArgs: args,
+	}.VerifyKey(sig, keyID)
+}
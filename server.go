@@ -12,11 +12,21 @@ type wsHandler interface {
 	Upgrade(*http.Request, http.ResponseWriter, http.Header) (jsonrpc2.Codec, error)
 }
 
+// Defaults for the pending call limits of websocket connections, used when
+// the server's limits are unset.
+const defaultPendingLimit = 50
+const defaultPendingDiscard = 10
+
 type server struct {
 	jsonrpc2.HTTPServer
 	ws       ws.Upgrader
 	debugLog bool
 	header   http.Header
+
+	// pendingLimit and pendingDiscard set the jsonrpc2.Remote limits for
+	// each websocket connection.
+	pendingLimit   int
+	pendingDiscard int
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -34,12 +44,17 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "incorrect vipnode api handshake", http.StatusBadRequest)
 			return
 		}
-		// Assume WebSocket upgrade request
+		// Assume WebSocket upgrade request. If the upgrade fails, then the
+		// upgrader already responded with an HTTP error, or the connection is
+		// gone, so there is nothing left to write.
 		codec, err := s.ws.Upgrade(r, w, nil)
 		if err != nil {
 			logger.Debugf("websocket upgrade error from %s: %s", r.RemoteAddr, err)
 			return
 		}
+		// The connection is hijacked from here on, so errors are sent to
+		// the client as a websocket close frame if the codec supports it.
+		rawCodec := codec
 		if s.debugLog {
 			codec = jsonrpc2.DebugCodec(r.RemoteAddr, codec)
 		}
@@ -48,11 +63,25 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Server: &s.HTTPServer.Server,
 			Client: &jsonrpc2.Client{},
 
-			PendingLimit:   50,
-			PendingDiscard: 10,
+			PendingLimit:   s.pendingLimit,
+			PendingDiscard: s.pendingDiscard,
+		}
+		if remote.PendingLimit == 0 {
+			remote.PendingLimit = defaultPendingLimit
+		}
+		if remote.PendingDiscard == 0 {
+			remote.PendingDiscard = defaultPendingDiscard
+		}
+		err = remote.Serve()
+		if err == nil || err == io.EOF {
+			codec.Close()
+			return
 		}
-		if err := remote.Serve(); err != nil && err != io.EOF {
-			logger.Warningf("jsonrpc2.Remote.Serve() error: %s", err)
+		logger.Warningf("jsonrpc2.Remote.Serve() error: %s", err)
+		if closer, ok := rawCodec.(ws.ErrorCloser); ok {
+			closer.CloseWithError(err)
+		} else {
+			codec.Close()
 		}
 	default:
 		http.Error(w, "unsupported method", http.StatusUnsupportedMediaType)
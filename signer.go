@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/vipnode/vipnode/ethnode"
+	"github.com/vipnode/vipnode/jsonrpc2"
+	"github.com/vipnode/vipnode/pool"
+)
+
+// signer holds the keys that the agent signs its pool requests with.
+type signer struct {
+	NodeID string
+	// NodeKey is the node's p2p private key. It's nil if the agent only has
+	// a signing key.
+	NodeKey *ecdsa.PrivateKey
+	// SigningKey is a separate key that is bound to the node, if any.
+	SigningKey *ecdsa.PrivateKey
+}
+
+// loadSigner loads the keys for signing requests on behalf of the node. If
+// only a signing key is given, then the node key is not loaded and the node
+// ID is taken from the node's enode.
+func loadSigner(node ethnode.EthNode, nodeKeyPath string, signingKeyPath string) (*signer, error) {
+	remoteEnode, err := node.Enode(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &signer{}
+	if signingKeyPath != "" {
+		if s.SigningKey, err = crypto.LoadECDSA(signingKeyPath); err != nil {
+			return nil, ErrExplain{err, "Failed to load the signing key. Check the --signing-key path."}
+		}
+		s.NodeID = remoteEnode
+		if strings.Contains(remoteEnode, "://") {
+			u, err := url.Parse(remoteEnode)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse enode URI: %s", err)
+			}
+			s.NodeID = u.User.Username()
+		}
+		if nodeKeyPath == "" {
+			return s, nil
+		}
+	}
+
+	// Node key is required for signing requests from the NodeID to avoid forgery.
+	if s.NodeKey, err = findNodeKey(nodeKeyPath); err != nil {
+		return nil, ErrExplain{err, "Failed to find node private key. Use --nodekey to specify the correct path."}
+	}
+	// Confirm that nodeID matches the private key
+	s.NodeID = discv5.PubkeyID(&s.NodeKey.PublicKey).String()
+	if err := matchEnode(remoteEnode, s.NodeID); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Remote returns a RemotePool that signs with the signing key if there is
+// one, or the node key otherwise.
+func (s *signer) Remote(client jsonrpc2.Service) *pool.RemotePool {
+	if s.SigningKey != nil {
+		return pool.RemoteSigner(client, s.SigningKey, s.NodeID)
+	}
+	return pool.Remote(client, s.NodeKey)
+}
+
+// Bind binds the signing key to the node with the pool, if both keys are
+// loaded. Once bound, the agent can run with only the signing key.
+func (s *signer) Bind(ctx context.Context, client jsonrpc2.Service) error {
+	if s.NodeKey == nil || s.SigningKey == nil {
+		return nil
+	}
+	signerID := discv5.PubkeyID(&s.SigningKey.PublicKey).String()
+	if err := pool.Remote(client, s.NodeKey).BindSigningKey(ctx, signerID); err != nil {
+		return ErrExplain{err, "Failed to bind the signing key with the pool."}
+	}
+	logger.Infof("Bound signing key to node, --nodekey is no longer needed: %s", signerID)
+	return nil
+}